@@ -0,0 +1,135 @@
+package jwkfetch
+
+import (
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const defaultUnchangedRefreshes = 3
+
+// AdaptiveRefresh adapts the interval of a provider's scheduled refresh to how often its keys change. The interval doubles after
+// consecutive refreshes returning an unchanged key set and drops back to MinInterval once the keys rotated.
+// Failed refreshes don't change it, they are left to RefreshEscalation
+type AdaptiveRefresh struct {
+	// MinInterval is the interval after a rotation. Zero is the provider's RefreshInterval
+	MinInterval time.Duration
+	// MaxInterval bounds the lengthened interval. Zero is the interval of the global refresh, 24 hours unless changed with WithRefreshInterval
+	MaxInterval time.Duration
+	// UnchangedRefreshes is the number of consecutive unchanged refreshes doubling the interval. Zero is 3
+	UnchangedRefreshes int
+}
+
+// adaptiveRefreshState is the adapted refresh interval of a provider
+type adaptiveRefreshState struct {
+	interval time.Duration
+	// unchanged counts the unchanged refreshes since the interval last changed
+	unchanged int
+	// keySet is the key set of the last successful refresh, kept since failed refreshes purge the cached one
+	keySet *jwk.Set
+}
+
+// adaptRefreshInterval compares the key set of a successful refresh of the provider with AdaptiveRefresh with the one of the previous
+// refresh, or the previously cached one, and reschedules the provider when its interval changed
+func (f *Fetcher) adaptRefreshInterval(jwkProvider JWKProvider, previous *keySetEntry, keySet *jwk.Set) {
+	adaptive := jwkProvider.AdaptiveRefresh
+	if adaptive == nil || jwkProvider.RefreshInterval <= 0 {
+		return
+	}
+	minInterval := adaptive.MinInterval
+	if minInterval <= 0 {
+		minInterval = jwkProvider.RefreshInterval
+	}
+	maxInterval := adaptive.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = f.currentOptions().refreshInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	unchangedRefreshes := adaptive.UnchangedRefreshes
+	if unchangedRefreshes <= 0 {
+		unchangedRefreshes = defaultUnchangedRefreshes
+	}
+
+	key := providerKey(jwkProvider)
+	f.providersMu.Lock()
+	state, ok := f.adaptiveRefreshes[key]
+	if !ok {
+		state = &adaptiveRefreshState{interval: jwkProvider.RefreshInterval}
+		f.adaptiveRefreshes[key] = state
+	}
+	last := state.keySet
+	if last == nil && previous != nil {
+		last = previous.keySet
+	}
+	state.keySet = keySet
+	if last == nil {
+		f.providersMu.Unlock()
+		return
+	}
+	previousInterval := state.interval
+	switch {
+	case keySetChanged(last, keySet):
+		state.interval = minInterval
+		state.unchanged = 0
+	default:
+		state.unchanged++
+		if state.unchanged >= unchangedRefreshes {
+			state.interval *= 2
+			state.unchanged = 0
+		}
+	}
+	if state.interval < minInterval {
+		state.interval = minInterval
+	}
+	if state.interval > maxInterval {
+		state.interval = maxInterval
+	}
+	interval := state.interval
+	f.providersMu.Unlock()
+
+	if interval != previousInterval {
+		f.scheduleProviderEvery(jwkProvider, interval)
+	}
+}
+
+// providerRefreshInterval is the interval the provider's refresh is scheduled at, by the caching headers of its key set when it has
+// HeaderRefresh and adapted when it has AdaptiveRefresh
+func (f *Fetcher) providerRefreshInterval(jwkProvider JWKProvider) time.Duration {
+	if withDefaults := f.withDefaults(jwkProvider); withDefaults.HeaderRefresh != nil {
+		return f.headerRefreshInterval(withDefaults, f.cachedProviderEntry(jwkProvider))
+	}
+	f.providersMu.RLock()
+	defer f.providersMu.RUnlock()
+	if state, ok := f.adaptiveRefreshes[providerKey(jwkProvider)]; ok {
+		return state.interval
+	}
+	return jwkProvider.RefreshInterval
+}
+
+// scheduledRefreshInterval is the interval of the provider's running refresh schedule, zero when it has none
+func (f *Fetcher) scheduledRefreshInterval(jwkProvider JWKProvider) time.Duration {
+	f.providersMu.RLock()
+	defer f.providersMu.RUnlock()
+	if s, ok := f.providerSchedulers[providerKey(jwkProvider)]; ok {
+		return s.interval
+	}
+	return 0
+}
+
+// keySetChanged reports whether the key IDs or the number of keys differ between the key sets
+func keySetChanged(previous, current *jwk.Set) bool {
+	if len(previous.Keys) != len(current.Keys) {
+		return true
+	}
+	keyIDs := func(keySet *jwk.Set) map[string]bool {
+		ids := make(map[string]bool, len(keySet.Keys))
+		for _, key := range keySet.Keys {
+			ids[key.KeyID()] = true
+		}
+		return ids
+	}
+	added, removed := diffKeyIDs(keyIDs(previous), keyIDs(current))
+	return len(added) > 0 || len(removed) > 0
+}
@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRefresh(t *testing.T) {
+	_, firstKeySet := newTestKeySet(t, "first-key")
+	_, secondKeySet := newTestKeySet(t, "second-key")
+	var mu sync.Mutex
+	keySet, status := firstKeySet, http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{
+		Issuer:          server.URL,
+		JWKURL:          server.URL + "/jwks",
+		RefreshInterval: time.Hour,
+		AdaptiveRefresh: &AdaptiveRefresh{MaxInterval: 6 * time.Hour, UnchangedRefreshes: 2},
+	}
+	f, err := New([]JWKProvider{jwkProvider})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name         string
+		keySet       string
+		status       int
+		wantInterval time.Duration
+	}{
+		{name: "First unchanged refresh", keySet: firstKeySet, wantInterval: time.Hour},
+		{name: "Unchanged refreshes double the interval", keySet: firstKeySet, wantInterval: 2 * time.Hour},
+		{name: "Counting starts again", keySet: firstKeySet, wantInterval: 2 * time.Hour},
+		{name: "Doubled again", keySet: firstKeySet, wantInterval: 4 * time.Hour},
+		{name: "Failures don't change the interval", keySet: firstKeySet, status: http.StatusInternalServerError, wantInterval: 4 * time.Hour},
+		{name: "Failures aren't unchanged refreshes", keySet: firstKeySet, status: http.StatusInternalServerError, wantInterval: 4 * time.Hour},
+		{name: "Recovered", keySet: firstKeySet, wantInterval: 4 * time.Hour},
+		{name: "Unchanged since the failures", keySet: firstKeySet, wantInterval: 6 * time.Hour},
+		{name: "Bounded by MaxInterval", keySet: firstKeySet, wantInterval: 6 * time.Hour},
+		{name: "Still bounded", keySet: firstKeySet, wantInterval: 6 * time.Hour},
+		{name: "Rotation", keySet: secondKeySet, wantInterval: time.Hour},
+		{name: "Unchanged after rotation", keySet: secondKeySet, wantInterval: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			keySet, status = tt.keySet, tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			mu.Unlock()
+			f.refreshProvider(jwkProvider)
+			if got := f.scheduledRefreshInterval(jwkProvider); got != tt.wantInterval {
+				t.Errorf("Scheduled refresh interval = %v, want %v", got, tt.wantInterval)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// joseAlgorithms are the JWS algorithms of RFC 7518, RFC 8037 and RFC 8812, supported when a jwt-go signing method is registered for them
+var joseAlgorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512", "ES256K",
+	"EdDSA",
+}
+
+// AlgorithmSource is where the algorithm allow-list of a provider comes from
+type AlgorithmSource string
+
+const (
+	// ProviderAlgorithms is the allow-list of JWKProvider.Algorithms
+	ProviderAlgorithms AlgorithmSource = "provider"
+	// DiscoveredAlgorithms is the id_token_signing_alg_values_supported field of the provider's discovery document
+	DiscoveredAlgorithms AlgorithmSource = "discovery"
+	// AnySupportedAlgorithm is used for providers without allow-list, accepting all the supported algorithms
+	AnySupportedAlgorithm AlgorithmSource = "supported"
+)
+
+// AlgorithmPolicy is the algorithm policy in effect for the tokens of a provider
+type AlgorithmPolicy struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer string
+	Source AlgorithmSource
+	// Accepted are the algorithms of the allow-list that are supported, sorted
+	Accepted []string
+	// Unsupported are the algorithms of the allow-list without a verifier, whose tokens are rejected as well
+	Unsupported []string
+}
+
+// SupportedAlgorithms returns the sorted token signing algorithms with a verifier, including the ones of signing methods registered with jwt-go.
+// VerifyJWS additionally rejects the HMAC algorithms
+func SupportedAlgorithms() []string {
+	var supported []string
+	for _, alg := range joseAlgorithms {
+		if supportedAlgorithm(alg) {
+			supported = append(supported, alg)
+		}
+	}
+	sort.Strings(supported)
+	return supported
+}
+
+// EffectiveAlgorithmPolicy is Fetcher.EffectiveAlgorithmPolicy of the default fetcher
+func EffectiveAlgorithmPolicy(ctx context.Context, issuer string) (AlgorithmPolicy, error) {
+	return defaultFetcher.EffectiveAlgorithmPolicy(ctx, issuer)
+}
+
+// EffectiveAlgorithmPolicy returns the algorithms accepted for the tokens of the registered provider, fetching its discovery document if it isn't cached yet.
+// The issuer may be the DiscoverURL or JWKURL of providers without Issuer
+func (f *Fetcher) EffectiveAlgorithmPolicy(ctx context.Context, issuer string) (AlgorithmPolicy, error) {
+	var jwkProvider *JWKProvider
+	for _, registered := range f.Providers() {
+		if providerKey(registered) == issuer {
+			registered := registered
+			jwkProvider = &registered
+			break
+		}
+	}
+	if jwkProvider == nil {
+		return AlgorithmPolicy{}, fmt.Errorf("Provider %s doesn't exist", issuer)
+	}
+	entry, err := f.cacheProviderEntry(ctx, *jwkProvider)
+	if err != nil {
+		return AlgorithmPolicy{}, err
+	}
+
+	return newAlgorithmPolicy(jwkProvider, entry), nil
+}
+
+// newAlgorithmPolicy reads the policy from the provider's cached entry, which holds the allow-list enforced for its tokens
+func newAlgorithmPolicy(jwkProvider *JWKProvider, entry *keySetEntry) AlgorithmPolicy {
+	policy := AlgorithmPolicy{Issuer: providerKey(*jwkProvider), Source: DiscoveredAlgorithms}
+	switch {
+	case entry == nil || len(entry.algorithms) == 0:
+		policy.Source = AnySupportedAlgorithm
+		policy.Accepted = SupportedAlgorithms()
+		return policy
+	case len(jwkProvider.Algorithms) > 0:
+		policy.Source = ProviderAlgorithms
+	}
+	for _, alg := range entry.algorithms {
+		if supportedAlgorithm(alg) {
+			policy.Accepted = append(policy.Accepted, alg)
+		} else {
+			policy.Unsupported = append(policy.Unsupported, alg)
+		}
+	}
+	sort.Strings(policy.Accepted)
+	sort.Strings(policy.Unsupported)
+	return policy
+}
@@ -0,0 +1,74 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSupportedAlgorithms(t *testing.T) {
+	want := []string{"ES256", "ES384", "ES512", "HS256", "HS384", "HS512", "PS256", "PS384", "PS512", "RS256", "RS384", "RS512"}
+	if got := SupportedAlgorithms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SupportedAlgorithms() = %v, want %v", got, want)
+	}
+}
+
+func TestEffectiveAlgorithmPolicy(t *testing.T) {
+	issuer := fmt.Sprintf("http://%s/policy", httptestServerURL)
+	tests := []struct {
+		name       string
+		discovered string
+		algorithms []string
+		want       AlgorithmPolicy
+	}{
+		{
+			name:       "Not advertised",
+			discovered: `null`,
+			want:       AlgorithmPolicy{Issuer: issuer, Source: AnySupportedAlgorithm, Accepted: SupportedAlgorithms()},
+		},
+		{
+			name:       "Advertised",
+			discovered: `["RS256", "EdDSA", "ES256"]`,
+			want:       AlgorithmPolicy{Issuer: issuer, Source: DiscoveredAlgorithms, Accepted: []string{"ES256", "RS256"}, Unsupported: []string{"EdDSA"}},
+		},
+		{
+			name:       "Provider override",
+			discovered: `["ES256"]`,
+			algorithms: []string{"PS256"},
+			want:       AlgorithmPolicy{Issuer: issuer, Source: ProviderAlgorithms, Accepted: []string{"PS256"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/policy/.well-known/openid-configuration" {
+					fmt.Fprintf(w, `{"jwks_uri": "%s/jwks", "id_token_signing_alg_values_supported": %s}`, issuer, tt.discovered)
+					return
+				}
+				io.WriteString(w, jwkResponse)
+			}))
+			defer server.Close()
+
+			jwkProvider := JWKProvider{Issuer: issuer, Algorithms: tt.algorithms}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			got, err := EffectiveAlgorithmPolicy(context.Background(), issuer)
+			if err != nil {
+				t.Fatalf("EffectiveAlgorithmPolicy() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EffectiveAlgorithmPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := EffectiveAlgorithmPolicy(context.Background(), "https://unknown.example.com"); err == nil {
+		t.Errorf("EffectiveAlgorithmPolicy() of unknown provider error = nil, want error")
+	}
+}
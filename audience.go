@@ -0,0 +1,65 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrAudienceNotAllowed is returned for tokens whose aud claim doesn't match the provider's Audiences
+var ErrAudienceNotAllowed = errors.New("Token audience is not allowed")
+
+// AudienceMatch is how the aud claim of tokens is matched with the provider's Audiences
+type AudienceMatch int
+
+const (
+	// AnyAudience accepts tokens with at least one audience matching one of the provider's Audiences
+	AnyAudience AudienceMatch = iota
+	// AllAudiences accepts tokens with audiences matching every one of the provider's Audiences
+	AllAudiences
+)
+
+// checkAudience matches the string or array aud claim with the provider's audience patterns
+func checkAudience(claims jwt.MapClaims, jwkProvider JWKProvider) error {
+	if len(jwkProvider.Audiences) == 0 {
+		return nil
+	}
+	audiences := orderedClaimValues(claims, "aud")
+	matched := 0
+	for _, pattern := range jwkProvider.Audiences {
+		for _, audience := range audiences {
+			if matchAudience(pattern, audience) {
+				matched++
+				break
+			}
+		}
+	}
+	if matched == len(jwkProvider.Audiences) || jwkProvider.AudienceMatch == AnyAudience && matched > 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrAudienceNotAllowed, audiences)
+}
+
+// matchAudience matches the audience with a pattern where * matches the characters of a single DNS label or path segment,
+// none of '/', '.' or ':', e.g. "api://*" or "https://*.example.com", so a wildcard can't stretch into another host
+func matchAudience(pattern string, audience string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == audience
+	}
+	if !strings.HasPrefix(audience, pattern[:i]) {
+		return false
+	}
+	rest := audience[i:]
+	for j := 0; j <= len(rest); j++ {
+		if matchAudience(pattern[i+1:], rest[j:]) {
+			return true
+		}
+		if j < len(rest) && strings.IndexByte("/.:", rest[j]) >= 0 {
+			return false
+		}
+	}
+	return false
+}
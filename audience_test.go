@@ -0,0 +1,80 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func Test_matchAudience(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		audience string
+		want     bool
+	}{
+		{pattern: "api://orders", audience: "api://orders", want: true},
+		{pattern: "api://orders", audience: "api://orders/v2", want: false},
+		{pattern: "api://*", audience: "api://orders", want: true},
+		{pattern: "https://*.example.com", audience: "https://orders.example.com", want: true},
+		{pattern: "https://*.example.com", audience: "https://orders.example.com.evil.org", want: false},
+		{pattern: "https://*.example.com", audience: "https://evil.com/.example.com", want: false},
+		{pattern: "https://*.example.com", audience: "https://evil.org:443.example.com", want: false},
+		{pattern: "https://*.example.com", audience: "https://a.b.example.com", want: false},
+		{pattern: "*", audience: "anything", want: true},
+		{pattern: "a*b*c", audience: "abc", want: true},
+		{pattern: "ab*b", audience: "ab", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.audience, func(t *testing.T) {
+			if got := matchAudience(tt.pattern, tt.audience); got != tt.want {
+				t.Errorf("matchAudience() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderAudiences(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "audience-key")
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name          string
+		audiences     []string
+		audienceMatch AudienceMatch
+		aud           interface{}
+		wantErr       error
+	}{
+		{name: "No audiences", aud: "anything"},
+		{name: "String aud", audiences: []string{"api://orders"}, aud: "api://orders"},
+		{name: "Any of array aud", audiences: []string{"api://orders", "api://billing"}, aud: []string{"api://billing", "https://graph.example.com"}},
+		{name: "None of array aud", audiences: []string{"api://orders"}, aud: []string{"api://billing"}, wantErr: ErrAudienceNotAllowed},
+		{name: "All of array aud", audiences: []string{"api://orders", "api://billing"}, audienceMatch: AllAudiences, aud: []string{"api://billing", "api://orders"}},
+		{name: "Not all of array aud", audiences: []string{"api://orders", "api://billing"}, audienceMatch: AllAudiences, aud: []string{"api://billing"}, wantErr: ErrAudienceNotAllowed},
+		{name: "Wildcard", audiences: []string{"api://*"}, aud: []string{"https://graph.example.com", "api://orders"}},
+		{name: "Missing aud", audiences: []string{"api://*"}, aud: nil, wantErr: ErrAudienceNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwkProvider := JWKProvider{
+				Issuer:        "https://issuer.example.com",
+				InlineJWKS:    []byte(keySet),
+				Audiences:     tt.audiences,
+				AudienceMatch: tt.audienceMatch,
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			claims := jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp}
+			if tt.aud != nil {
+				claims["aud"] = tt.aud
+			}
+			_, err := ParseAndVerify(context.Background(), signTestToken(t, privateKey, "audience-key", claims))
+			if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+				t.Errorf("ParseAndVerify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
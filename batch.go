@@ -0,0 +1,95 @@
+package jwkfetch
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// BatchResult is the outcome of verifying a token of a batch
+type BatchResult struct {
+	Token *jwt.Token
+	Err   error
+}
+
+// WithBatchConcurrency verifies the tokens of VerifyBatch with up to n goroutines. Defaults to 1
+func WithBatchConcurrency(n int) VerifyOption {
+	return func(o *verifyOptions) {
+		o.batchConcurrency = n
+	}
+}
+
+// batchKey is the key resolved once for all tokens of a batch with the same issuer, kid, alg and typ
+type batchKey struct {
+	once sync.Once
+	key  interface{}
+	err  error
+}
+
+// VerifyBatch is Fetcher.VerifyBatch of the default fetcher
+func VerifyBatch(ctx context.Context, tokens []string, opts ...VerifyOption) []BatchResult {
+	return defaultFetcher.VerifyBatch(ctx, tokens, opts...)
+}
+
+// VerifyBatch verifies the tokens like ParseAndVerify, e.g. tokens of queued events, and returns their results in the order of the tokens.
+// The key of each issuer and kid is resolved once for the whole batch, and WithBatchConcurrency verifies the signatures concurrently
+func (f *Fetcher) VerifyBatch(ctx context.Context, tokens []string, opts ...VerifyOption) []BatchResult {
+	var options verifyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var keysMu sync.Mutex
+	keys := make(map[string]*batchKey)
+	// keys are resolved one at a time, while signatures are verified concurrently
+	var resolveMu sync.Mutex
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		issuer, _ := getIssuer(token)
+		keyID, _ := token.Header["kid"].(string)
+		alg, _ := token.Header["alg"].(string)
+		typ, _ := token.Header["typ"].(string)
+		groupKey := strings.Join([]string{issuer, keyID, alg, typ}, "\x00")
+
+		keysMu.Lock()
+		group, ok := keys[groupKey]
+		if !ok {
+			group = &batchKey{}
+			keys[groupKey] = group
+		}
+		keysMu.Unlock()
+
+		group.once.Do(func() {
+			resolveMu.Lock()
+			defer resolveMu.Unlock()
+			resolvedKey, err := f.resolveTrustedKey(ctx, token, options)
+			group.key, group.err = resolvedKey.Key, err
+		})
+		return group.key, group.err
+	}
+
+	results := make([]BatchResult, len(tokens))
+	concurrency := options.batchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency && worker < len(tokens); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				token, err := f.verifyToken(ctx, tokens[i], keyFunc, options)
+				results[i] = BatchResult{Token: token, Err: err}
+			}
+		}()
+	}
+	for i := range tokens {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,84 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyBatch(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "batch-key")
+	var jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&jwksRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/batch", httptestServerURL),
+		JWKURL: fmt.Sprintf("http://%s/batch/jwks", httptestServerURL),
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := signTestToken(t, privateKey, "batch-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp})
+	expired := signTestToken(t, privateKey, "batch-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": time.Now().Add(-time.Hour).Unix()})
+	unknownKid := signTestToken(t, privateKey, "unknown", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp})
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{name: "Sequential", concurrency: 0},
+		{name: "Concurrent", concurrency: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultFetcher.purgeProvider(jwkProvider, nil)
+			atomic.StoreInt32(&jwksRequests, 0)
+
+			tokens := []string{valid, expired, unknownKid, "malformed"}
+			for i := 0; i < 50; i++ {
+				tokens = append(tokens, valid)
+			}
+			results := VerifyBatch(context.Background(), tokens, WithBatchConcurrency(tt.concurrency))
+
+			if len(results) != len(tokens) {
+				t.Fatalf("VerifyBatch() = %d results, want %d", len(results), len(tokens))
+			}
+			if results[0].Err != nil || !results[0].Token.Valid {
+				t.Errorf("VerifyBatch()[0] error = %v, want valid token", results[0].Err)
+			}
+			if results[1].Err == nil {
+				t.Error("VerifyBatch()[1] error = nil, want expired")
+			}
+			if !errors.Is(results[2].Err, ErrKeyNotFound) {
+				t.Errorf("VerifyBatch()[2] error = %v, want %v", results[2].Err, ErrKeyNotFound)
+			}
+			if results[3].Err == nil {
+				t.Error("VerifyBatch()[3] error = nil, want malformed")
+			}
+			for i, result := range results[4:] {
+				if result.Err != nil {
+					t.Errorf("VerifyBatch()[%d] error = %v", i+4, result.Err)
+				}
+			}
+			// the known kid is fetched once and the unknown kid once more
+			if got := atomic.LoadInt32(&jwksRequests); got != 2 {
+				t.Errorf("JWKs requests = %d, want 2", got)
+			}
+		})
+	}
+	defaultFetcher.purgeProvider(jwkProvider, nil)
+}
@@ -0,0 +1,214 @@
+package jwkfetch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Invalidate is Fetcher.Invalidate of the default fetcher
+func Invalidate(issuer string) {
+	defaultFetcher.Invalidate(issuer)
+}
+
+// Invalidate drops all cached keys of the issuer, including the discover and JWKs URL entries it was fetched from, without refetching them.
+// The keys are fetched again on the next token of the issuer
+func (f *Fetcher) Invalidate(issuer string) {
+	jwkProvider, ok := f.findProvider(issuer)
+	if !ok {
+		jwkProvider = JWKProvider{Issuer: issuer}
+	}
+	f.purgeProvider(jwkProvider, nil)
+
+	for _, cache := range []*entryCache{f.vcIssuerCache, f.didCache} {
+		if entry := cache.get(issuer); entry != nil && entry.jwksURL != "" {
+			f.deleteJWKsURL(entry.jwksURL)
+		}
+		cache.delete(issuer)
+	}
+}
+
+// InvalidateAll is Fetcher.InvalidateAll of the default fetcher
+func InvalidateAll() {
+	defaultFetcher.InvalidateAll()
+}
+
+// InvalidateAll drops all cached keys without refetching them
+func (f *Fetcher) InvalidateAll() {
+	for _, cache := range f.allCaches() {
+		cache.clear()
+	}
+	f.validatedCache.clear()
+}
+
+var entryVersion uint64
+
+func nextEntryVersion() uint64 {
+	return atomic.AddUint64(&entryVersion, 1)
+}
+
+// entryCache caches key set entries for the keyfuncs of concurrent requests, the scheduled refreshes and the evictions.
+// Nil entries are placeholders of the keys fetched by refreshCaches
+type entryCache struct {
+	mu      sync.RWMutex
+	entries map[string]*keySetEntry
+	// flights are the fetches of the entries in flight, shared by their concurrent keyfuncs
+	flights flightGroup
+}
+
+func newEntryCache() *entryCache {
+	return &entryCache{entries: make(map[string]*keySetEntry)}
+}
+
+type refreshKey struct{}
+
+// withRefresh returns a context whose key set lookups fetch the entries cached before the refresh again instead of returning them.
+// The fetched entries replace the cached ones once stored, so the cached keys keep verifying tokens when a refresh fails
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, atomic.LoadUint64(&entryVersion))
+}
+
+// isStale reports whether the cached entry is fetched again since the context is of a refresh started after the entry's fetch
+func isStale(ctx context.Context, entry *keySetEntry) bool {
+	since, ok := ctx.Value(refreshKey{}).(uint64)
+	return ok && entry.version <= since
+}
+
+// allCaches returns the caches of all keyfuncs
+func (f *Fetcher) allCaches() []*entryCache {
+	return []*entryCache{f.issuerCache, f.discoverURLsCache, f.jwksCache, f.vcIssuerCache, f.didCache}
+}
+
+// get returns the cached entry, nil if it isn't cached or is a placeholder
+func (c *entryCache) get(cacheKey string) *keySetEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entries[cacheKey]
+}
+
+// set caches the entry regardless of the cached one's version
+func (c *entryCache) set(cacheKey string, entry *keySetEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey] = entry
+}
+
+// store caches the entry unless the cached one has a newer version, and returns the cached entry
+func (c *entryCache) store(cacheKey string, entry *keySetEntry) *keySetEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current := c.entries[cacheKey]; current != nil && current.version > entry.version {
+		return current
+	}
+	c.entries[cacheKey] = entry
+	return entry
+}
+
+func (c *entryCache) delete(cacheKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, cacheKey)
+}
+
+// deleteEntry drops the entry unless it was replaced meanwhile
+func (c *entryCache) deleteEntry(cacheKey string, entry *keySetEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, ok := c.entries[cacheKey]; ok && current == entry {
+		delete(c.entries, cacheKey)
+	}
+}
+
+func (c *entryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*keySetEntry)
+}
+
+// all returns a copy of the cached entries, including placeholders
+func (c *entryCache) all() map[string]*keySetEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make(map[string]*keySetEntry, len(c.entries))
+	for cacheKey, entry := range c.entries {
+		entries[cacheKey] = entry
+	}
+	return entries
+}
+
+func (c *entryCache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// SetCacheIdleTimeout is Fetcher.SetCacheIdleTimeout of the default fetcher
+func SetCacheIdleTimeout(timeout time.Duration) {
+	defaultFetcher.SetCacheIdleTimeout(timeout)
+}
+
+// SetCacheIdleTimeout evicts cached keys that weren't used to verify a token within the timeout, checked every timeout.
+// Keys of registered providers are never evicted, so the ones of issuers seen once, e.g. from spoofed iss claims, don't stay cached forever.
+// Zero (the default) disables eviction
+func (f *Fetcher) SetCacheIdleTimeout(timeout time.Duration) {
+	f.idleEvictionMu.Lock()
+	defer f.idleEvictionMu.Unlock()
+	f.cacheIdleTimeout = timeout
+	if f.idleEvictionScheduler != nil {
+		f.idleEvictionScheduler.Stop()
+		f.idleEvictionScheduler = nil
+	}
+	if timeout <= 0 {
+		return
+	}
+	f.idleEvictionScheduler = f.every(timeout, func() {
+		f.evictIdleEntries(clockNow())
+	})
+}
+
+func (f *Fetcher) currentCacheIdleTimeout() time.Duration {
+	f.idleEvictionMu.Lock()
+	defer f.idleEvictionMu.Unlock()
+	return f.cacheIdleTimeout
+}
+
+func (entry *keySetEntry) touch() {
+	atomic.StoreInt64(&entry.lastAccess, clockNow().UnixNano())
+}
+
+// lastUsed is when a key was last looked up in the entry, or when it was fetched if none was
+func (entry *keySetEntry) lastUsed() time.Time {
+	if lastAccess := atomic.LoadInt64(&entry.lastAccess); lastAccess != 0 {
+		return time.Unix(0, lastAccess)
+	}
+	return entry.fetchedAt
+}
+
+// evictIdleEntries drops the entries unused for longer than the idle timeout, except the ones of registered providers
+func (f *Fetcher) evictIdleEntries(now time.Time) {
+	timeout := f.currentCacheIdleTimeout()
+	if timeout <= 0 || f.InForensicMode() {
+		return
+	}
+
+	providers := f.Providers()
+	kept := f.sharedURLs(providers, "")
+	for _, jwkProvider := range providers {
+		kept[jwkProvider.Issuer] = true
+	}
+	for _, cache := range f.allCaches() {
+		for cacheKey, entry := range cache.all() {
+			if entry != nil && !kept[cacheKey] && now.Round(0).Sub(entry.lastUsed().Round(0)) > timeout {
+				cache.deleteEntry(cacheKey, entry)
+				f.forgetAccess(cacheKey)
+			}
+		}
+	}
+	// the key sets of evicted JWKs URLs aren't revalidated anymore
+	for jwksURL := range f.validatedCache.all() {
+		if !kept[jwksURL] && f.jwksCache.get(jwksURL) == nil {
+			f.validatedCache.delete(jwksURL)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestInvalidate(t *testing.T) {
+	keySet, _ := jwk.ParseString(jwkResponse)
+	issuer := "https://invalidated.example.com"
+	discoverURL := "https://invalidated.example.com/.well-known/openid-configuration"
+	jwksURL := "https://invalidated.example.com/jwks"
+	otherJWKsURL := "https://other.example.com/jwks"
+
+	entry := &keySetEntry{keySet: keySet, jwksURL: jwksURL, discoverURL: discoverURL}
+	defaultFetcher.issuerCache.set(issuer, entry)
+	defaultFetcher.discoverURLsCache.set(discoverURL, entry)
+	defaultFetcher.jwksCache.set(jwksURL, entry)
+	defaultFetcher.validatedCache.set(jwksURL, entry)
+	defaultFetcher.jwksCache.set(otherJWKsURL, &keySetEntry{keySet: keySet, jwksURL: otherJWKsURL})
+	defer defaultFetcher.jwksCache.delete(otherJWKsURL)
+
+	Invalidate(issuer)
+
+	if defaultFetcher.issuerCache.get(issuer) != nil {
+		t.Errorf("Invalidate() didn't drop issuer cache entry")
+	}
+	if defaultFetcher.discoverURLsCache.get(discoverURL) != nil {
+		t.Errorf("Invalidate() didn't drop discover URL cache entry")
+	}
+	if defaultFetcher.jwksCache.get(jwksURL) != nil {
+		t.Errorf("Invalidate() didn't drop JWKs URL cache entry")
+	}
+	if defaultFetcher.validatedCache.get(jwksURL) != nil {
+		t.Errorf("Invalidate() kept the validated entry, which a 304 response would restore")
+	}
+	if defaultFetcher.jwksCache.get(otherJWKsURL) == nil {
+		t.Errorf("Invalidate() dropped cache entry of another issuer")
+	}
+}
+
+func TestInvalidateAll(t *testing.T) {
+	keySet, _ := jwk.ParseString(jwkResponse)
+	entry := &keySetEntry{keySet: keySet}
+	defaultFetcher.issuerCache.set("https://first.example.com", entry)
+	defaultFetcher.discoverURLsCache.set("https://first.example.com/.well-known/openid-configuration", entry)
+	defaultFetcher.jwksCache.set("https://second.example.com/jwks", entry)
+	defaultFetcher.vcIssuerCache.set("https://third.example.com", entry)
+	defaultFetcher.didCache.set("did:web:fourth.example.com", entry)
+
+	InvalidateAll()
+
+	for name, cache := range map[string]*entryCache{
+		"issuer":       defaultFetcher.issuerCache,
+		"discover URL": defaultFetcher.discoverURLsCache,
+		"JWKs URL":     defaultFetcher.jwksCache,
+		"VC issuer":    defaultFetcher.vcIssuerCache,
+		"DID":          defaultFetcher.didCache,
+	} {
+		if cache.len() != 0 {
+			t.Errorf("InvalidateAll() left %d entries in %s cache", cache.len(), name)
+		}
+	}
+}
+
+func TestEvictIdleEntries(t *testing.T) {
+	keySet, _ := jwk.ParseString(jwkResponse)
+	now := time.Now()
+	SetCacheIdleTimeout(time.Hour)
+	defer SetCacheIdleTimeout(0)
+	savedProviders := Providers()
+	defer defaultFetcher.setProviders(savedProviders)
+	defaultFetcher.setProviders([]JWKProvider{{Issuer: "https://configured.example.com", JWKURL: "https://configured.example.com/jwks"}})
+
+	idle := &keySetEntry{keySet: keySet, fetchedAt: now.Add(-2 * time.Hour)}
+	used := &keySetEntry{keySet: keySet, fetchedAt: now.Add(-2 * time.Hour)}
+	used.touch()
+	fresh := &keySetEntry{keySet: keySet, fetchedAt: now}
+
+	tests := []struct {
+		name     string
+		cache    *entryCache
+		cacheKey string
+		entry    *keySetEntry
+		wantKept bool
+	}{
+		{"idle issuer", defaultFetcher.issuerCache, "https://sprayed.example.com", idle, false},
+		{"idle DID", defaultFetcher.didCache, "did:web:sprayed.example.com", idle, false},
+		{"recently used issuer", defaultFetcher.issuerCache, "https://used.example.com", used, true},
+		{"recently fetched JWKs URL", defaultFetcher.jwksCache, "https://fresh.example.com/jwks", fresh, true},
+		{"idle configured issuer", defaultFetcher.issuerCache, "https://configured.example.com", idle, true},
+		{"idle configured JWKs URL", defaultFetcher.jwksCache, "https://configured.example.com/jwks", idle, true},
+		{"placeholder", defaultFetcher.discoverURLsCache, "https://placeholder.example.com", nil, true},
+	}
+	for _, tt := range tests {
+		tt.cache.set(tt.cacheKey, tt.entry)
+		defer tt.cache.delete(tt.cacheKey)
+	}
+
+	defaultFetcher.evictIdleEntries(now)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.cache.all()[tt.cacheKey]; ok != tt.wantKept {
+				t.Errorf("evictIdleEntries() kept %s = %v, want %v", tt.cacheKey, ok, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestEntryCacheStore(t *testing.T) {
+	tests := []struct {
+		name           string
+		currentVersion uint64
+		hasCurrent     bool
+		version        uint64
+		wantStored     bool
+	}{
+		{name: "Not cached", version: 1, wantStored: true},
+		{name: "Older cached", hasCurrent: true, currentVersion: 1, version: 2, wantStored: true},
+		{name: "Same version cached", hasCurrent: true, currentVersion: 2, version: 2, wantStored: true},
+		{name: "Newer cached", hasCurrent: true, currentVersion: 3, version: 2, wantStored: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newEntryCache()
+			current := &keySetEntry{version: tt.currentVersion}
+			if tt.hasCurrent {
+				cache.set("key", current)
+			}
+			entry := &keySetEntry{version: tt.version}
+
+			got := cache.store("key", entry)
+			want := current
+			if tt.wantStored {
+				want = entry
+			}
+			if got != want || cache.get("key") != want {
+				t.Errorf("store() = version %d, cached version %d, want version %d", got.version, cache.get("key").version, want.version)
+			}
+		})
+	}
+}
+
+func TestSlowFetchDoesntReplaceNewerKeySet(t *testing.T) {
+	slowRequested := make(chan struct{})
+	releaseSlow := make(chan struct{})
+	requests := 0
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			close(slowRequested)
+			<-releaseSlow
+			io.WriteString(w, cachedSet)
+			return
+		}
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/versioned/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	slowDone := make(chan *keySetEntry)
+	go func() {
+		entry, _ := defaultFetcher.getKeySetFromJWKCache(context.Background(), jwksURL)
+		slowDone <- entry
+	}()
+	<-slowRequested
+
+	newer, err := defaultFetcher.getKeySetFromJWKCache(context.Background(), jwksURL)
+	if err != nil {
+		t.Fatalf("getKeySetFromJWKCache() error = %v", err)
+	}
+	close(releaseSlow)
+	slow := <-slowDone
+
+	if defaultFetcher.jwksCache.get(jwksURL) != newer {
+		t.Errorf("Slow fetch replaced the newer key set")
+	}
+	if slow != newer {
+		t.Errorf("Slow fetch returned its older key set instead of the cached newer one")
+	}
+}
@@ -0,0 +1,86 @@
+package jwkfetch
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type callerKey struct{}
+
+// WithCaller returns a context identifying the caller, e.g. the tenant of a multi-tenant gateway.
+// Resolving keys with the context attributes the fetches and key misses it causes to the caller in CallerReport and in hook events
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller of the context, empty when it has none
+func CallerFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// CallerStats describes the load caused by a caller
+type CallerStats struct {
+	Caller string
+	// Fetches counts the discovery and key set requests made while resolving keys for the caller
+	Fetches uint64
+	// KeyMisses counts the tokens of the caller whose kid wasn't in the cached key set
+	KeyMisses uint64
+	// ThrottledRefreshes counts the key misses that weren't refreshed since the caller exceeded its RefreshQuota
+	ThrottledRefreshes uint64
+}
+
+var callerStatsMu sync.Mutex
+var callerStats map[string]*CallerStats = make(map[string]*CallerStats)
+
+// CallerReport returns the stats of the callers identified with WithCaller, sorted from the most to the least fetches
+func CallerReport() []CallerStats {
+	callerStatsMu.Lock()
+	report := make([]CallerStats, 0, len(callerStats))
+	for _, stats := range callerStats {
+		report = append(report, *stats)
+	}
+	callerStatsMu.Unlock()
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Fetches != report[j].Fetches {
+			return report[i].Fetches > report[j].Fetches
+		}
+		return report[i].Caller < report[j].Caller
+	})
+	return report
+}
+
+func callerStatsFor(ctx context.Context, record func(*CallerStats)) {
+	caller := CallerFrom(ctx)
+	if caller == "" {
+		return
+	}
+	callerStatsMu.Lock()
+	defer callerStatsMu.Unlock()
+	stats, ok := callerStats[caller]
+	if !ok {
+		if len(callerStats) >= maxEndpoints {
+			return
+		}
+		stats = &CallerStats{Caller: caller}
+		callerStats[caller] = stats
+	}
+	record(stats)
+}
+
+func recordCallerFetch(ctx context.Context) {
+	callerStatsFor(ctx, func(stats *CallerStats) { stats.Fetches++ })
+}
+
+func recordCallerKeyMiss(ctx context.Context) {
+	callerStatsFor(ctx, func(stats *CallerStats) { stats.KeyMisses++ })
+}
+
+func recordCallerThrottled(ctx context.Context) {
+	callerStatsFor(ctx, func(stats *CallerStats) { stats.ThrottledRefreshes++ })
+}
@@ -0,0 +1,81 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestCallerReport(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	previous := callerStats
+	callerStats = make(map[string]*CallerStats)
+	defer func() { callerStats = previous }()
+
+	jwkProvider := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/caller", httptestServerURL),
+		JWKURL: fmt.Sprintf("http://%s/caller/jwks", httptestServerURL),
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	tests := []struct {
+		name   string
+		caller string
+		keyID  string
+	}{
+		{name: "Known kid", caller: "tenant-a", keyID: "512fe2ae0e60bd03084b12885b41423f"},
+		{name: "Unknown kid", caller: "tenant-b", keyID: "unknown"},
+		{name: "Anonymous", caller: "", keyID: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := mockToken()
+			token.Header["kid"] = tt.keyID
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			ResolveKey(WithCaller(context.Background(), tt.caller), token)
+		})
+	}
+
+	want := []CallerStats{
+		{Caller: "tenant-a", Fetches: 1},
+		{Caller: "tenant-b", Fetches: 1, KeyMisses: 1},
+	}
+	got := CallerReport()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("CallerReport() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCallerInHooks(t *testing.T) {
+	var got HostNotAllowedError
+	SetHooks(Hooks{OnHostNotAllowed: func(event HostNotAllowedError) {
+		got = event
+	}})
+	defer SetHooks(Hooks{})
+	if err := SetAllowedHosts("*.example.com"); err != nil {
+		t.Fatalf("SetAllowedHosts() error = %v", err)
+	}
+	defer SetAllowedHosts()
+
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": "https://spoofed.example.org"}
+	if _, err := ResolveKey(WithCaller(context.Background(), "tenant-a"), token); err == nil {
+		t.Fatal("ResolveKey() error = nil, want host not allowed")
+	}
+	defer Invalidate("https://spoofed.example.org")
+	if got.Caller != "tenant-a" {
+		t.Errorf("OnHostNotAllowed() caller = %q, want %q", got.Caller, "tenant-a")
+	}
+}
@@ -0,0 +1,101 @@
+package jwkfetch
+
+import (
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// GitHubActionsClaims are the claims of GitHub Actions OIDC token
+type GitHubActionsClaims struct {
+	jwt.StandardClaims
+	Repository        string `json:"repository"`
+	RepositoryID      string `json:"repository_id"`
+	RepositoryOwner   string `json:"repository_owner"`
+	RepositoryOwnerID string `json:"repository_owner_id"`
+	Ref               string `json:"ref"`
+	RefType           string `json:"ref_type"`
+	SHA               string `json:"sha"`
+	Environment       string `json:"environment"`
+	EventName         string `json:"event_name"`
+	Actor             string `json:"actor"`
+	Workflow          string `json:"workflow"`
+	WorkflowRef       string `json:"workflow_ref"`
+	JobWorkflowRef    string `json:"job_workflow_ref"`
+	RunID             string `json:"run_id"`
+	RunAttempt        string `json:"run_attempt"`
+}
+
+// GitLabCIClaims are the claims of GitLab CI ID token
+type GitLabCIClaims struct {
+	jwt.StandardClaims
+	NamespaceID    string `json:"namespace_id"`
+	NamespacePath  string `json:"namespace_path"`
+	ProjectID      string `json:"project_id"`
+	ProjectPath    string `json:"project_path"`
+	UserLogin      string `json:"user_login"`
+	PipelineID     string `json:"pipeline_id"`
+	PipelineSource string `json:"pipeline_source"`
+	JobID          string `json:"job_id"`
+	Ref            string `json:"ref"`
+	RefType        string `json:"ref_type"`
+	RefProtected   string `json:"ref_protected"`
+	Environment    string `json:"environment"`
+	CIConfigRefURI string `json:"ci_config_ref_uri"`
+	CIConfigSHA    string `json:"ci_config_sha"`
+}
+
+type ciClaims interface {
+	jwt.Claims
+	VerifyAudience(string, bool) bool
+	VerifyIssuer(string, bool) bool
+}
+
+// ParseGitHubActionsToken is Fetcher.ParseGitHubActionsToken of the default fetcher
+func ParseGitHubActionsToken(tokenString string, provider JWKProvider, audience string) (*GitHubActionsClaims, error) {
+	return defaultFetcher.ParseGitHubActionsToken(tokenString, provider, audience)
+}
+
+// ParseGitHubActionsToken validates GitHub Actions OIDC token signature and standard claims (exp, iat, nbf, iss, aud) and returns its claims.
+// Use GitHubActionsProvider to get the provider config
+func (f *Fetcher) ParseGitHubActionsToken(tokenString string, provider JWKProvider, audience string) (*GitHubActionsClaims, error) {
+	claims := &GitHubActionsClaims{}
+	if err := f.parseCIToken(tokenString, provider, audience, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseGitLabCIToken is Fetcher.ParseGitLabCIToken of the default fetcher
+func ParseGitLabCIToken(tokenString string, provider JWKProvider, audience string) (*GitLabCIClaims, error) {
+	return defaultFetcher.ParseGitLabCIToken(tokenString, provider, audience)
+}
+
+// ParseGitLabCIToken validates GitLab CI ID token signature and standard claims (exp, iat, nbf, iss, aud) and returns its claims.
+// Use GitLabProvider to get the provider config
+func (f *Fetcher) ParseGitLabCIToken(tokenString string, provider JWKProvider, audience string) (*GitLabCIClaims, error) {
+	claims := &GitLabCIClaims{}
+	if err := f.parseCIToken(tokenString, provider, audience, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (f *Fetcher) parseCIToken(tokenString string, provider JWKProvider, audience string, claims ciClaims) error {
+	keyFunc := f.FromJWKsURL(provider.JWKURL)
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Check issuer before fetching keys so the token can't make us fetch keys of another provider
+		if !claims.VerifyIssuer(provider.Issuer, true) {
+			return nil, fmt.Errorf("Token issuer is not %s", provider.Issuer)
+		}
+		return keyFunc(token)
+	})
+	if err != nil {
+		return err
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return fmt.Errorf("Token audience is not %s", audience)
+	}
+	return nil
+}
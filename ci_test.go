@@ -0,0 +1,176 @@
+package jwkfetch
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func newTestKeySet(t *testing.T, keyID string) (*rsa.PrivateKey, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, _ := jwk.New(&privateKey.PublicKey)
+	key.Set(jwk.KeyIDKey, keyID)
+	key.Set(jwk.AlgorithmKey, "RS256")
+	buf, err := json.Marshal(jwk.Set{Keys: []jwk.Key{key}})
+	if err != nil {
+		t.Fatalf("failed to marshal key set: %v", err)
+	}
+	return privateKey, string(buf)
+}
+
+func signTestToken(t *testing.T, privateKey *rsa.PrivateKey, keyID string, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	tokenString, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+func TestParseGitHubActionsToken(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "github-actions-key")
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/github/.well-known/jwks" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, keySet)
+			return
+		}
+	}))
+	defer server.Close()
+
+	provider := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/github", httptestServerURL),
+		JWKURL: fmt.Sprintf("http://%s/github/.well-known/jwks", httptestServerURL),
+	}
+	defer defaultFetcher.jwksCache.delete(provider.JWKURL)
+
+	validClaims := func() GitHubActionsClaims {
+		return GitHubActionsClaims{
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    provider.Issuer,
+				Audience:  "sts.amazonaws.com",
+				Subject:   "repo:octo-org/octo-repo:ref:refs/heads/main",
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+			},
+			Repository:     "octo-org/octo-repo",
+			Ref:            "refs/heads/main",
+			JobWorkflowRef: "octo-org/octo-automation/.github/workflows/oidc.yml@refs/heads/main",
+		}
+	}
+
+	type args struct {
+		claims   GitHubActionsClaims
+		audience string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "Happy flow",
+			args: args{
+				claims:   validClaims(),
+				audience: "sts.amazonaws.com",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Wrong audience",
+			args: args{
+				claims:   validClaims(),
+				audience: "https://github.com/octo-org",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Expired token",
+			args: args{
+				claims: func() GitHubActionsClaims {
+					claims := validClaims()
+					claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+					return claims
+				}(),
+				audience: "sts.amazonaws.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Wrong issuer",
+			args: args{
+				claims: func() GitHubActionsClaims {
+					claims := validClaims()
+					claims.Issuer = "https://attacker.example.com"
+					return claims
+				}(),
+				audience: "sts.amazonaws.com",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenString := signTestToken(t, privateKey, "github-actions-key", tt.args.claims)
+			got, err := ParseGitHubActionsToken(tokenString, provider, tt.args.audience)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseGitHubActionsToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(*got, tt.args.claims) {
+				t.Errorf("ParseGitHubActionsToken() = %v, want %v", *got, tt.args.claims)
+			}
+		})
+	}
+}
+
+func TestParseGitLabCIToken(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "gitlab-key")
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gitlab/oauth/discovery/keys" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, keySet)
+			return
+		}
+	}))
+	defer server.Close()
+
+	provider := GitLabProvider(fmt.Sprintf("http://%s/gitlab", httptestServerURL))
+	defer defaultFetcher.jwksCache.delete(provider.JWKURL)
+
+	claims := GitLabCIClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    provider.Issuer,
+			Audience:  "https://vault.example.com",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+		},
+		ProjectPath:  "mygroup/myproject",
+		Ref:          "main",
+		RefProtected: "true",
+	}
+	tokenString := signTestToken(t, privateKey, "gitlab-key", claims)
+
+	got, err := ParseGitLabCIToken(tokenString, provider, "https://vault.example.com")
+	if err != nil {
+		t.Fatalf("ParseGitLabCIToken() error = %v", err)
+	}
+	if !reflect.DeepEqual(*got, claims) {
+		t.Errorf("ParseGitLabCIToken() = %v, want %v", *got, claims)
+	}
+}
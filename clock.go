@@ -0,0 +1,19 @@
+package jwkfetch
+
+import "time"
+
+// clockNow is the time source of the cache TTL, staleness and idle logic, replaced with fake clocks in tests
+var clockNow = time.Now
+
+// elapsedSince returns the longer of the monotonic and the wall clock time elapsed since t.
+// The monotonic clock doesn't advance while the machine is suspended on some platforms, so a cache entry of a resumed laptop or VM would look fresh,
+// while the wall clock may jump backwards after VM migrations or clock corrections, so using the longer one never extends the life of an entry
+func elapsedSince(t time.Time) time.Duration {
+	now := clockNow()
+	monotonic := now.Sub(t)
+	wall := now.Round(0).Sub(t.Round(0))
+	if wall > monotonic {
+		return wall
+	}
+	return monotonic
+}
@@ -0,0 +1,84 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// fakeClock returns a clock whose wall time is shifted by the offset and which has no monotonic reading, like after a suspension or a clock jump
+func fakeClock(offset time.Duration) func() time.Time {
+	return func() time.Time {
+		return time.Now().Round(0).Add(offset)
+	}
+}
+
+func Test_elapsedSince(t *testing.T) {
+	defer func() { clockNow = time.Now }()
+
+	tests := []struct {
+		name    string
+		clock   func() time.Time
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{name: "Monotonic", clock: time.Now, wantMin: 0, wantMax: time.Minute},
+		{name: "Suspended for 3 days", clock: fakeClock(72 * time.Hour), wantMin: 72 * time.Hour, wantMax: 72*time.Hour + time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clockNow = time.Now
+			since := clockNow()
+			clockNow = tt.clock
+			if got := elapsedSince(since); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("elapsedSince() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestLongSuspension(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer func() { clockNow = time.Now }()
+
+	keySet, _ := jwk.ParseString(jwkResponse)
+	jwkProvider := JWKProvider{
+		Issuer:   fmt.Sprintf("http://%s/suspended", httptestServerURL),
+		JWKURL:   fmt.Sprintf("http://%s/suspended/jwks", httptestServerURL),
+		CacheTTL: time.Hour,
+		MaxStale: 24 * time.Hour,
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+	defaultFetcher.issuerCache.set(jwkProvider.Issuer, &keySetEntry{keySet: keySet, index: newKeyIndex(keySet), jwksURL: jwkProvider.JWKURL, fetchedAt: clockNow()})
+	spoofedIssuer := "https://spoofed.example.com"
+	defaultFetcher.issuerCache.set(spoofedIssuer, &keySetEntry{keySet: keySet, fetchedAt: clockNow()})
+	defer defaultFetcher.issuerCache.delete(spoofedIssuer)
+
+	clockNow = fakeClock(72 * time.Hour)
+
+	if got := Stats()[0].MaxStaleRemaining; got > -48*time.Hour {
+		t.Errorf("MaxStaleRemaining = %v after suspension, want exceeded by 48 hours", got)
+	}
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+	if _, err := FromIssuerClaim()(token); !errors.Is(err, ErrKeySetTooStale) {
+		t.Errorf("FromIssuerClaim() error = %v after suspension, want %v", err, ErrKeySetTooStale)
+	}
+
+	SetCacheIdleTimeout(time.Hour)
+	defer SetCacheIdleTimeout(0)
+	defaultFetcher.evictIdleEntries(clockNow())
+	if defaultFetcher.issuerCache.get(spoofedIssuer) != nil {
+		t.Errorf("evictIdleEntries() kept an entry unused during the suspension")
+	}
+}
@@ -0,0 +1,114 @@
+// Command jwkfetch runs checks of jwkfetch providers from the command line.
+//
+// The selftest subcommand discovers and fetches the keys of the providers, checks their algorithms and verifies sample tokens,
+// printing a line per check and exiting with status 1 when any check fails, e.g. to gate a rollout in a deployment pipeline:
+//
+//	jwkfetch selftest -issuer https://login.example.com -token "$SAMPLE_TOKEN"
+//	jwkfetch selftest -providers providers.json -token @sample.jwt
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+)
+
+type values []string
+
+func (v *values) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *values) Set(value string) error {
+	*v = append(*v, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "selftest":
+		os.Exit(selfTest(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: jwkfetch selftest [-issuer issuer]... [-providers file] [-token token|@file]... [-timeout duration]")
+	os.Exit(2)
+}
+
+func selfTest(args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	var issuers, tokens values
+	flags.Var(&issuers, "issuer", "issuer of a provider discovered from its OpenID configuration, may be repeated")
+	providersFile := flags.String("providers", "", "JSON file with an array of providers")
+	flags.Var(&tokens, "token", "sample token, or @ and a file holding it, verified with the keys of its issuer, may be repeated")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the whole self-test")
+	flags.Parse(args)
+
+	providers, err := loadProviders(*providersFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, issuer := range issuers {
+		providers = append(providers, jwkfetch.JWKProvider{Issuer: issuer})
+	}
+	if len(providers) == 0 {
+		fmt.Fprintln(os.Stderr, "No providers to test, set -issuer or -providers")
+		return 2
+	}
+	samples := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "@") {
+			buf, err := os.ReadFile(strings.TrimPrefix(token, "@"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			token = string(buf)
+		}
+		samples = append(samples, strings.TrimSpace(token))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	f, err := jwkfetch.New(providers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	report := f.SelfTest(ctx, samples...)
+	report.WriteText(os.Stdout)
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
+func loadProviders(path string) ([]jwkfetch.JWKProvider, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var providers []jwkfetch.JWKProvider
+	if err := json.Unmarshal(buf, &providers); err != nil {
+		return nil, fmt.Errorf("Error while parsing providers %s: %v", path, err)
+	}
+	return providers, nil
+}
@@ -0,0 +1,207 @@
+// Command soak runs jwkfetch for hours against a rotating test issuer and configured providers, simulating token traffic,
+// and fails when goroutines or file descriptors leak or when tokens of a rotated key aren't verified
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	"github.com/Soluto/fetch-jwk/jwkfetchtest"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type issuers []string
+
+func (i *issuers) String() string {
+	return strings.Join(*i, ",")
+}
+
+func (i *issuers) Set(issuer string) error {
+	*i = append(*i, issuer)
+	return nil
+}
+
+type config struct {
+	duration       time.Duration
+	rotateEvery    time.Duration
+	refreshEvery   time.Duration
+	reportEvery    time.Duration
+	workers        int
+	tokenInterval  time.Duration
+	leakTolerance  int
+	realIssuers    issuers
+	refreshTimeout time.Duration
+}
+
+type counters struct {
+	verified      uint64
+	failed        uint64
+	rotations     uint64
+	refreshErrors uint64
+}
+
+func main() {
+	var cfg config
+	flag.DurationVar(&cfg.duration, "duration", time.Hour, "how long to run")
+	flag.DurationVar(&cfg.rotateEvery, "rotate-every", 5*time.Minute, "how often the test issuer rotates its signing key")
+	flag.DurationVar(&cfg.refreshEvery, "refresh-every", 10*time.Minute, "how often the keys of all providers are refreshed")
+	flag.DurationVar(&cfg.reportEvery, "report-every", time.Minute, "how often progress is logged")
+	flag.IntVar(&cfg.workers, "workers", 8, "goroutines verifying tokens")
+	flag.DurationVar(&cfg.tokenInterval, "token-interval", 10*time.Millisecond, "pause between the tokens of each worker")
+	flag.IntVar(&cfg.leakTolerance, "leak-tolerance", 10, "goroutines and file descriptors allowed above the baseline")
+	flag.DurationVar(&cfg.refreshTimeout, "refresh-timeout", 30*time.Second, "timeout of each refresh")
+	flag.Var(&cfg.realIssuers, "issuer", "issuer of a real provider whose keys are refreshed, may be repeated")
+	flag.Parse()
+
+	if err := run(cfg); err != nil {
+		log.Printf("Soak test failed: %v", err)
+		os.Exit(1)
+	}
+	log.Print("Soak test passed")
+}
+
+func run(cfg config) error {
+	server, err := jwkfetchtest.NewServer()
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	providers := []jwkfetch.JWKProvider{{Issuer: server.Issuer(), RefreshInterval: cfg.refreshEvery}}
+	for _, issuer := range cfg.realIssuers {
+		providers = append(providers, jwkfetch.JWKProvider{Issuer: issuer, RefreshInterval: cfg.refreshEvery})
+	}
+	if err := jwkfetch.Init(providers); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	var c counters
+
+	// the baseline is taken once keys are cached and the schedules are running
+	for _, result := range jwkfetch.WarmUp(ctx) {
+		if result.Err != nil {
+			return fmt.Errorf("Provider %s wasn't fetched: %v", result.Issuer, result.Err)
+		}
+	}
+	time.Sleep(time.Second)
+	baselineGoroutines := runtime.NumGoroutine()
+	baselineFDs := openFDs()
+	log.Printf("Baseline of %d goroutines and %d file descriptors", baselineGoroutines, baselineFDs)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < cfg.workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifyTokens(ctx, cfg, server, &c)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rotateKeys(ctx, cfg, server, &c)
+	}()
+
+	report := time.NewTicker(cfg.reportEvery)
+	defer report.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-report.C:
+			log.Printf("%d tokens verified, %d failed, %d rotations, %d refresh errors, %d goroutines, %d file descriptors",
+				atomic.LoadUint64(&c.verified), atomic.LoadUint64(&c.failed), atomic.LoadUint64(&c.rotations),
+				atomic.LoadUint64(&c.refreshErrors), runtime.NumGoroutine(), openFDs())
+		}
+	}
+	wg.Wait()
+
+	// idle connections are closed by the transport over time, so leaks are checked after they had the chance
+	time.Sleep(2 * time.Second)
+	var failures []string
+	if failed := atomic.LoadUint64(&c.failed); failed > 0 {
+		failures = append(failures, fmt.Sprintf("%d tokens weren't verified, e.g. after a missed rotation", failed))
+	}
+	if goroutines := runtime.NumGoroutine(); goroutines > baselineGoroutines+cfg.leakTolerance {
+		failures = append(failures, fmt.Sprintf("goroutines grew from %d to %d", baselineGoroutines, goroutines))
+	}
+	if fds := openFDs(); baselineFDs >= 0 && fds > baselineFDs+cfg.leakTolerance {
+		failures = append(failures, fmt.Sprintf("file descriptors grew from %d to %d", baselineFDs, fds))
+	}
+	log.Printf("%d tokens verified over %d rotations", atomic.LoadUint64(&c.verified), atomic.LoadUint64(&c.rotations))
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// verifyTokens verifies tokens signed with the current key of the test issuer until the context is done
+func verifyTokens(ctx context.Context, cfg config, server *jwkfetchtest.Server, c *counters) {
+	for ctx.Err() == nil {
+		tokenString, err := server.Sign(jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+		if err != nil {
+			log.Printf("Token wasn't signed: %v", err)
+			atomic.AddUint64(&c.failed, 1)
+			continue
+		}
+		if _, err := jwkfetch.ParseAndVerify(ctx, tokenString); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Token of kid %s wasn't verified: %v", server.KeyID(), err)
+			atomic.AddUint64(&c.failed, 1)
+		} else {
+			atomic.AddUint64(&c.verified, 1)
+		}
+		time.Sleep(cfg.tokenInterval)
+	}
+}
+
+// rotateKeys rotates the test issuer's key and refreshes the keys of all providers until the context is done
+func rotateKeys(ctx context.Context, cfg config, server *jwkfetchtest.Server, c *counters) {
+	rotate := time.NewTicker(cfg.rotateEvery)
+	defer rotate.Stop()
+	refresh := time.NewTicker(cfg.refreshEvery)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rotate.C:
+			if err := server.Rotate(cfg.rotateEvery / 2); err != nil {
+				log.Printf("Key wasn't rotated: %v", err)
+				continue
+			}
+			atomic.AddUint64(&c.rotations, 1)
+		case <-refresh.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, cfg.refreshTimeout)
+			for _, result := range jwkfetch.Refresh(refreshCtx) {
+				if result.Err != nil && ctx.Err() == nil {
+					log.Printf("Provider %s wasn't refreshed: %v", result.Issuer, result.Err)
+					atomic.AddUint64(&c.refreshErrors, 1)
+				}
+			}
+			cancel()
+		}
+	}
+}
+
+// openFDs counts the open file descriptors of the process, -1 where /proc isn't available
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
@@ -0,0 +1,173 @@
+package jwkfetch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ComplianceReport is the trust configuration and the inventory of cached keys in effect when it was generated, e.g. as SOC2 evidence
+type ComplianceReport struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Providers   []ProviderReport `json:"providers"`
+	Policies    PolicyReport     `json:"policies"`
+}
+
+// ProviderReport is the configuration and the cached keys of a provider
+type ProviderReport struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer      string `json:"issuer"`
+	DiscoverURL string `json:"discover_url,omitempty"`
+	JWKURL      string `json:"jwks_url,omitempty"`
+	// Source is the source of the provider's key set, see KeyProvenance
+	Source string `json:"source,omitempty"`
+	// Algorithms are the algorithms accepted for the provider's tokens, from AlgorithmSource
+	Algorithms      []string        `json:"algorithms"`
+	AlgorithmSource AlgorithmSource `json:"algorithm_source"`
+	TokenTypes      []string        `json:"token_types,omitempty"`
+	Audiences       []string        `json:"audiences,omitempty"`
+	SPKIPins        []string        `json:"spki_pins,omitempty"`
+	CrossCheckURL   string          `json:"cross_check_url,omitempty"`
+	// Keys are the provider's cached keys, empty when its key set isn't cached
+	Keys []KeyReport `json:"keys"`
+}
+
+// KeyReport describes a cached key
+type KeyReport struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	// AgeSeconds is the time since the key set was fetched
+	AgeSeconds int64         `json:"age_seconds"`
+	Revoked    bool          `json:"revoked"`
+	Provenance KeyProvenance `json:"provenance"`
+}
+
+// PolicyReport is the package wide policies
+type PolicyReport struct {
+	SupportedAlgorithms  []string      `json:"supported_algorithms"`
+	AllowedHosts         []string      `json:"allowed_hosts,omitempty"`
+	JWKsURIPolicy        JWKsURIPolicy `json:"jwks_uri_policy"`
+	RevokedKeys          []RevokedKey  `json:"revoked_keys,omitempty"`
+	UnsupportedKeyPolicy string        `json:"unsupported_key_policy"`
+	KeySetLimits         KeySetLimits  `json:"key_set_limits"`
+}
+
+var unsupportedKeyPolicyNames = map[UnsupportedKeyPolicy]string{
+	ReportUnsupportedKeys: "report",
+	SkipUnsupportedKeys:   "skip",
+	RejectUnsupportedKeys: "reject",
+}
+
+// NewComplianceReport is Fetcher.NewComplianceReport of the default fetcher
+func NewComplianceReport() ComplianceReport {
+	return defaultFetcher.NewComplianceReport()
+}
+
+// NewComplianceReport generates the report from the registered providers, their cached keys and the current policies.
+// Nothing is fetched, so call WarmUp before to include the keys of providers that weren't used yet
+func (f *Fetcher) NewComplianceReport() ComplianceReport {
+	f.policyMu.RLock()
+	hosts := append([]string(nil), f.allowedHosts...)
+	uriPolicy := f.jwksURIPolicy
+	f.policyMu.RUnlock()
+
+	report := ComplianceReport{
+		GeneratedAt: clockNow(),
+		Providers:   []ProviderReport{},
+		Policies: PolicyReport{
+			SupportedAlgorithms:  SupportedAlgorithms(),
+			AllowedHosts:         hosts,
+			JWKsURIPolicy:        uriPolicy,
+			RevokedKeys:          f.RevokedKeys(),
+			UnsupportedKeyPolicy: unsupportedKeyPolicyNames[f.currentUnsupportedKeyPolicy()],
+			KeySetLimits:         f.currentKeySetLimits(),
+		},
+	}
+	for _, jwkProvider := range f.Providers() {
+		report.Providers = append(report.Providers, f.newProviderReport(jwkProvider))
+	}
+	sort.Slice(report.Providers, func(i, j int) bool { return report.Providers[i].Issuer < report.Providers[j].Issuer })
+	return report
+}
+
+func (f *Fetcher) newProviderReport(jwkProvider JWKProvider) ProviderReport {
+	entry := f.cachedProviderEntry(jwkProvider)
+	algorithmPolicy := newAlgorithmPolicy(&jwkProvider, entry)
+	providerReport := ProviderReport{
+		Issuer:          providerKey(jwkProvider),
+		DiscoverURL:     jwkProvider.DiscoverURL,
+		JWKURL:          jwkProvider.JWKURL,
+		Algorithms:      algorithmPolicy.Accepted,
+		AlgorithmSource: algorithmPolicy.Source,
+		TokenTypes:      jwkProvider.TokenTypes,
+		Audiences:       jwkProvider.Audiences,
+		SPKIPins:        jwkProvider.SPKIPins,
+		CrossCheckURL:   jwkProvider.CrossCheckJWKURL,
+		Keys:            []KeyReport{},
+	}
+	if entry == nil {
+		return providerReport
+	}
+	providerReport.Source = entry.provenance().Source
+	for _, key := range entry.keySet.Keys {
+		provenance := entry.keyProvenance(key.KeyID())
+		providerReport.Keys = append(providerReport.Keys, KeyReport{
+			KeyID:      key.KeyID(),
+			KeyType:    string(key.KeyType()),
+			Algorithm:  key.Algorithm(),
+			Use:        key.KeyUsage(),
+			AgeSeconds: int64(elapsedSince(provenance.FetchedAt) / time.Second),
+			Revoked:    f.isKeyRevoked(jwkProvider.Issuer, key.KeyID()),
+			Provenance: provenance,
+		})
+	}
+	return providerReport
+}
+
+// WriteJSON writes the report as indented JSON
+func (r ComplianceReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// complianceCSVHeader are the columns of WriteCSV
+var complianceCSVHeader = []string{
+	"issuer", "source", "algorithms", "algorithm_source", "kid", "kty", "alg", "use",
+	"fetched_at", "age_seconds", "etag", "peer_spki_hash", "revoked",
+}
+
+// WriteCSV writes a row per cached key, and a row without key for providers whose keys aren't cached. The policies aren't included
+func (r ComplianceReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(complianceCSVHeader); err != nil {
+		return err
+	}
+	for _, provider := range r.Providers {
+		providerColumns := []string{provider.Issuer, provider.Source, strings.Join(provider.Algorithms, " "), string(provider.AlgorithmSource)}
+		if len(provider.Keys) == 0 {
+			if err := writer.Write(append(providerColumns, make([]string, len(complianceCSVHeader)-len(providerColumns))...)); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, key := range provider.Keys {
+			row := append(append([]string(nil), providerColumns...),
+				key.KeyID, key.KeyType, key.Algorithm, key.Use,
+				key.Provenance.FetchedAt.UTC().Format(time.RFC3339), strconv.FormatInt(key.AgeSeconds, 10),
+				key.Provenance.ETag, key.Provenance.PeerSPKIHash, strconv.FormatBool(key.Revoked),
+			)
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,83 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestComplianceReport(t *testing.T) {
+	_, keySet := newTestKeySet(t, "inline-key")
+	cached := JWKProvider{Issuer: "https://cached.example.com", InlineJWKS: []byte(keySet), Algorithms: []string{"RS256", "EdDSA"}, Audiences: []string{"api"}}
+	uncached := JWKProvider{Issuer: "https://uncached.example.com", JWKURL: "https://uncached.example.com/jwks"}
+	defaultFetcher.setProviders([]JWKProvider{uncached, cached})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(cached, nil)
+	if err := defaultFetcher.cacheProvider(context.Background(), cached); err != nil {
+		t.Fatalf("cacheProvider() error = %v", err)
+	}
+	RevokeKey(cached.Issuer, "inline-key")
+	defer UnrevokeKey(cached.Issuer, "inline-key")
+
+	report := NewComplianceReport()
+	if len(report.Providers) != 2 {
+		t.Fatalf("NewComplianceReport() providers = %+v, want 2", report.Providers)
+	}
+	got := report.Providers[0]
+	want := ProviderReport{
+		Issuer:          cached.Issuer,
+		Source:          "inline",
+		Algorithms:      []string{"RS256"},
+		AlgorithmSource: ProviderAlgorithms,
+		Audiences:       []string{"api"},
+	}
+	if len(got.Keys) != 1 {
+		t.Fatalf("NewComplianceReport() keys = %+v, want the inline key", got.Keys)
+	}
+	key := got.Keys[0]
+	if key.KeyID != "inline-key" || key.KeyType != "RSA" || key.Algorithm != "RS256" || !key.Revoked || key.Provenance.Source != "inline" {
+		t.Errorf("NewComplianceReport() key = %+v, want revoked RSA key inline-key", key)
+	}
+	got.Keys = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewComplianceReport() provider = %+v, want %+v", got, want)
+	}
+	if keys := report.Providers[1].Keys; report.Providers[1].Issuer != uncached.Issuer || len(keys) != 0 {
+		t.Errorf("NewComplianceReport() provider = %+v, want %s without keys", report.Providers[1], uncached.Issuer)
+	}
+	if len(report.Policies.RevokedKeys) == 0 || len(report.Policies.SupportedAlgorithms) == 0 {
+		t.Errorf("NewComplianceReport() policies = %+v, want revoked keys and supported algorithms", report.Policies)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var decoded ComplianceReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON() wrote invalid JSON: %v", err)
+	}
+	if len(decoded.Providers) != 2 || decoded.Providers[0].Keys[0].KeyID != "inline-key" {
+		t.Errorf("WriteJSON() = %s, want the providers and keys of the report", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("WriteCSV() wrote invalid CSV: %v", err)
+	}
+	wantRows := [][]string{
+		complianceCSVHeader,
+		{cached.Issuer, "inline", "RS256", "provider", "inline-key", "RSA", "RS256", "", key.Provenance.FetchedAt.UTC().Format("2006-01-02T15:04:05Z07:00"), "0", "", "", "true"},
+		{uncached.Issuer, "", "ES256 ES384 ES512 HS256 HS384 HS512 PS256 PS384 PS512 RS256 RS384 RS512", "supported", "", "", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("WriteCSV() = %v, want %v", rows, wantRows)
+	}
+}
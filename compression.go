@@ -0,0 +1,103 @@
+package jwkfetch
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrResponseTooLarge is returned when a compressed response expands beyond the limit set with SetMaxDecompressedSize
+var ErrResponseTooLarge = errors.New("Decompressed response is too large")
+
+// SetMaxDecompressedSize is Fetcher.SetMaxDecompressedSize of the default fetcher
+func SetMaxDecompressedSize(size int64) {
+	defaultFetcher.SetMaxDecompressedSize(size)
+}
+
+// SetMaxDecompressedSize sets the limit the compressed responses of the fetcher may expand to, 10MB by default
+func (f *Fetcher) SetMaxDecompressedSize(size int64) {
+	f.limitsMu.Lock()
+	defer f.limitsMu.Unlock()
+	f.maxDecompressedSize = size
+}
+
+func (f *Fetcher) currentMaxDecompressedSize() int64 {
+	f.limitsMu.RLock()
+	defer f.limitsMu.RUnlock()
+	return f.maxDecompressedSize
+}
+
+// compressionTransport negotiates gzip and deflate responses and decodes them up to the decompressed size limit
+type compressionTransport struct {
+	base http.RoundTripper
+}
+
+func (t compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		decoded, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		decoded, err = zlib.NewReader(resp.Body)
+	default:
+		return resp, nil
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error while decompressing response: %v", err)
+	}
+
+	resp.Body = &limitedBody{
+		Reader:     decoded,
+		compressed: resp.Body,
+		decoded:    decoded,
+		remaining:  fetcherFrom(req.Context()).currentMaxDecompressedSize(),
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// limitedBody fails reads with ErrResponseTooLarge once more than the remaining bytes are decoded
+type limitedBody struct {
+	io.Reader
+	compressed io.Closer
+	decoded    io.Closer
+	remaining  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var probe [1]byte
+		if n, _ := b.Reader.Read(probe[:]); n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.Reader.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	b.decoded.Close()
+	return b.compressed.Close()
+}
@@ -0,0 +1,82 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, encoding string, body string) []byte {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	if encoding == "gzip" {
+		writer = gzip.NewWriter(&buf)
+	} else {
+		writer = zlib.NewWriter(&buf)
+	}
+	if _, err := io.WriteString(writer, body); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestCompressedKeySet(t *testing.T) {
+	bomb := `{"keys": [], "padding": "` + strings.Repeat("0", 1<<20) + `"}`
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		maxSize  int64
+		wantErr  string
+	}{
+		{name: "Identity", encoding: "", body: jwkResponse, maxSize: 10 << 20},
+		{name: "Gzip", encoding: "gzip", body: jwkResponse, maxSize: 10 << 20},
+		{name: "Deflate", encoding: "deflate", body: jwkResponse, maxSize: 10 << 20},
+		{name: "Exact limit", encoding: "gzip", body: jwkResponse, maxSize: int64(len(jwkResponse))},
+		{name: "Zip bomb", encoding: "gzip", body: bomb, maxSize: 64 << 10, wantErr: ErrResponseTooLarge.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding string
+			server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Type", "application/json")
+				if tt.encoding == "" {
+					w.WriteHeader(http.StatusOK)
+					io.WriteString(w, tt.body)
+					return
+				}
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.WriteHeader(http.StatusOK)
+				w.Write(compress(t, tt.encoding, tt.body))
+			}))
+			defer server.Close()
+			SetMaxDecompressedSize(tt.maxSize)
+			defer SetMaxDecompressedSize(10 << 20)
+
+			keySet, err := defaultFetcher.getKeySet(context.Background(), fmt.Sprintf("http://%s/jwks", httptestServerURL))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("getKeySet() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getKeySet() error = %v", err)
+			}
+			if len(keySet.Keys) != 2 {
+				t.Errorf("getKeySet() returned %d keys, want 2", len(keySet.Keys))
+			}
+			if acceptEncoding != "gzip, deflate" {
+				t.Errorf("Accept-Encoding = %q, want %q", acceptEncoding, "gzip, deflate")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package jwkfetch
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// KeySetDivergence describes the keys found in only one of a provider's cross-checked sources
+type KeySetDivergence struct {
+	Issuer        string
+	JWKsURL       string
+	CrossCheckURL string
+	// KeyIDs are the ids of the keys missing from, or different in, one of the sources
+	KeyIDs []string
+	// Caller is the caller whose token caused the fetch, identified with WithCaller
+	Caller string
+}
+
+// crossCheckEntry narrows the entry to the keys equally present in the provider's cross-check source
+func (f *Fetcher) crossCheckEntry(ctx context.Context, jwkProvider JWKProvider, entry *keySetEntry) (*keySetEntry, error) {
+	crossChecked, err := f.getKeySetFromJWKCache(ctx, jwkProvider.CrossCheckJWKURL)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, divergent := intersectKeySets(entry.keySet, crossChecked.keySet)
+	if len(divergent) > 0 {
+		if onDivergence := f.currentHooks().OnKeySetDivergence; onDivergence != nil {
+			event := KeySetDivergence{
+				Issuer:        jwkProvider.Issuer,
+				JWKsURL:       entry.jwksURL,
+				CrossCheckURL: jwkProvider.CrossCheckJWKURL,
+				KeyIDs:        divergent,
+				Caller:        CallerFrom(ctx),
+			}
+			f.deliverHook(providerKey(jwkProvider), func() { onDivergence(event) })
+		}
+	}
+
+	checked := *entry
+	checked.keySet = &jwk.Set{Keys: keys}
+	checked.index = newKeyIndex(checked.keySet)
+	if crossChecked.fetchedAt.Before(checked.fetchedAt) {
+		checked.fetchedAt = crossChecked.fetchedAt
+	}
+	return &checked, nil
+}
+
+// intersectKeySets returns the keys of primary equally present in secondary and the sorted ids of the other keys of both
+func intersectKeySets(primary, secondary *jwk.Set) ([]jwk.Key, []string) {
+	secondaryKeys := make(map[string]jwk.Key)
+	for _, key := range secondary.Keys {
+		secondaryKeys[key.KeyID()] = key
+	}
+
+	var keys []jwk.Key
+	var divergent []string
+	for _, key := range primary.Keys {
+		other, ok := secondaryKeys[key.KeyID()]
+		delete(secondaryKeys, key.KeyID())
+		if ok && sameKey(key, other) {
+			keys = append(keys, key)
+			continue
+		}
+		divergent = append(divergent, key.KeyID())
+	}
+	for keyID := range secondaryKeys {
+		divergent = append(divergent, keyID)
+	}
+	sort.Strings(divergent)
+	return keys, divergent
+}
+
+func sameKey(a, b jwk.Key) bool {
+	aKey, err := a.Materialize()
+	if err != nil {
+		return false
+	}
+	bKey, err := b.Materialize()
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aKey, bKey)
+}
@@ -0,0 +1,77 @@
+package jwkfetch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestCrossCheckJWKURL(t *testing.T) {
+	const keyID = "512fe2ae0e60bd03084b12885b41423f"
+	const secondKeyID = "84f294c45160088d079fee68138f52133d3e228c"
+	_, replacedKeySet := newTestKeySet(t, keyID)
+	_, otherKeySet := newTestKeySet(t, "other-key")
+
+	tests := []struct {
+		name           string
+		mirrorKeySet   string
+		wantErr        bool
+		wantDivergence []string
+	}{
+		{name: "Sources agree", mirrorKeySet: jwkResponse},
+		{name: "Key replaced in mirror", mirrorKeySet: replacedKeySet, wantErr: true, wantDivergence: []string{keyID, secondKeyID}},
+		{name: "Key missing from mirror", mirrorKeySet: otherKeySet, wantErr: true, wantDivergence: []string{keyID, secondKeyID, "other-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body string
+				switch r.URL.Path {
+				case "/primary/jwks":
+					body = jwkResponse
+				case "/mirror/jwks":
+					body = tt.mirrorKeySet
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, body)
+			}))
+			defer server.Close()
+
+			var divergence []string
+			SetHooks(Hooks{OnKeySetDivergence: func(d KeySetDivergence) {
+				divergence = d.KeyIDs
+			}})
+			defer SetHooks(Hooks{})
+
+			jwkProvider := JWKProvider{
+				Issuer:           fmt.Sprintf("http://%s/primary", httptestServerURL),
+				JWKURL:           fmt.Sprintf("http://%s/primary/jwks", httptestServerURL),
+				CrossCheckJWKURL: fmt.Sprintf("http://%s/mirror/jwks", httptestServerURL),
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			got, err := FromIssuerClaim()(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+			if !reflect.DeepEqual(divergence, tt.wantDivergence) {
+				t.Errorf("OnKeySetDivergence() key ids = %v, want %v", divergence, tt.wantDivergence)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const didWebPrefix = "did:web:"
+
+var didWebScheme = "https"
+
+type didDocument struct {
+	ID                 string               `json:"id"`
+	VerificationMethod []verificationMethod `json:"verificationMethod"`
+}
+
+type verificationMethod struct {
+	ID           string                 `json:"id"`
+	PublicKeyJWK map[string]interface{} `json:"publicKeyJwk"`
+}
+
+// FromDIDIssuerClaim is Fetcher.FromDIDIssuerClaim of the default fetcher
+func FromDIDIssuerClaim() func(*jwt.Token) (interface{}, error) {
+	return defaultFetcher.FromDIDIssuerClaim()
+}
+
+// FromDIDIssuerClaim extracts did:web issuer from JWT token, resolves its DID document and uses the verification methods' publicKeyJwk as JWT keys.
+// Token kid should be the verification method id, either absolute (did:web:example.com#key-1) or relative to the issuer (#key-1)
+func (f *Fetcher) FromDIDIssuerClaim() func(*jwt.Token) (interface{}, error) {
+	return f.safeKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		did, err := getIssuer(token)
+		if err != nil {
+			return nil, err
+		}
+		if keyID, ok := token.Header["kid"].(string); ok && strings.HasPrefix(keyID, "#") {
+			didToken := *token
+			didToken.Header = make(map[string]interface{}, len(token.Header))
+			for name, value := range token.Header {
+				didToken.Header[name] = value
+			}
+			didToken.Header["kid"] = did + keyID
+			token = &didToken
+		}
+		return f.retrieveKey(context.Background(), token, did, f.didCache, f.getKeySetFromDIDCache)
+	})
+}
+
+func (f *Fetcher) getKeySetFromDIDCache(ctx context.Context, did string) (*keySetEntry, error) {
+	if !f.isIssuerAllowed(did) {
+		return nil, ErrIssuerNotAllowed
+	}
+	if entry := f.didCache.get(did); entry != nil && !isStale(ctx, entry) {
+		return entry, nil
+	}
+
+	documentURL, err := getDIDDocumentURL(did)
+	if err != nil {
+		return nil, err
+	}
+
+	version := nextEntryVersion()
+	var document didDocument
+	if err := f.getJSON(ctx, documentURL, &document); err != nil {
+		return nil, fmt.Errorf("Error while getting did document: %w", err)
+	}
+	if document.ID != did {
+		return nil, fmt.Errorf("Did document id %q doesn't match issuer %q", document.ID, did)
+	}
+
+	keySet, err := f.getDIDKeySet(document)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &keySetEntry{
+		keySet:      keySet,
+		index:       newKeyIndex(keySet),
+		discoverURL: documentURL,
+		fetchedAt:   clockNow(),
+		version:     version,
+	}
+	return f.didCache.store(did, entry), nil
+}
+
+// getDIDKeySet converts verification methods with publicKeyJwk into a key set where kid is the absolute verification method id
+func (f *Fetcher) getDIDKeySet(document didDocument) (*jwk.Set, error) {
+	var keys []map[string]interface{}
+	for _, method := range document.VerificationMethod {
+		if method.PublicKeyJWK == nil {
+			continue
+		}
+		keyID := method.ID
+		if strings.HasPrefix(keyID, "#") {
+			keyID = document.ID + keyID
+		}
+		method.PublicKeyJWK["kid"] = keyID
+		keys = append(keys, method.PublicKeyJWK)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Did document %q has no verification methods with publicKeyJwk", document.ID)
+	}
+
+	buf, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		return nil, err
+	}
+	keySet, malformed, err := f.parseKeySet(bytes.NewReader(buf))
+	f.reportMalformedKeys("", malformed)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing did document keys: %w", err)
+	}
+	return keySet, nil
+}
+
+// getDIDDocumentURL transforms did:web identifier to the DID document URL according to the did:web method specification
+func getDIDDocumentURL(did string) (string, error) {
+	if !strings.HasPrefix(did, didWebPrefix) {
+		return "", fmt.Errorf("Issuer %q is not a did:web identifier", did)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(did, didWebPrefix), ":")
+	host, err := url.PathUnescape(parts[0])
+	if err != nil || host == "" {
+		return "", fmt.Errorf("Did %q has invalid domain name", did)
+	}
+
+	path := "/.well-known"
+	if len(parts) > 1 {
+		path = ""
+		for _, part := range parts[1:] {
+			segment, err := url.PathUnescape(part)
+			if err != nil || segment == "" {
+				return "", fmt.Errorf("Did %q has invalid path", did)
+			}
+			path += "/" + segment
+		}
+	}
+
+	documentURL := url.URL{
+		Scheme: didWebScheme,
+		Host:   host,
+		Path:   path + "/did.json",
+	}
+	if _, err := url.Parse(documentURL.String()); err != nil {
+		return "", fmt.Errorf("Did %q has invalid domain name", did)
+	}
+	return documentURL.String(), nil
+}
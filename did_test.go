@@ -0,0 +1,211 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var didDocumentResponse = `{
+	"id": "did:web:localhost%3A8888",
+	"verificationMethod": [
+	  {
+		"id": "#key-1",
+		"type": "JsonWebKey2020",
+		"controller": "did:web:localhost%3A8888",
+		"publicKeyJwk": {
+			"e": "AQAB",
+			"kty": "RSA",
+			"n": "xL3TevYy9F9myjfAJw1dLV3LouuP8m24VlgWTehPypAce34YAprAHNWJhflKFCNQqqXRJEJYfyGn10K0OywIXrmpkq8-Sxmy3WmMT-DprKisP3YIbrW2gEm8BL8mQYyHosGQAFxM1ErhPtItiI56Avs7hj1bQ7SXJGElwqi19NqlN7sfoOUpTCuOp5E2wKRjMHKryi1pvPAXqxS58vDQ2no72d3Uoy1flQfK6pyCBqCMQkiP8ganuZV4oLaXEeS8e71w7HuoJ87o30r4J_WKAVwENwJJWhai1c_TvyWCCBFjEjdIDiQJaG4lGaaPV60mSHTGk2Sr_cf3aIKCbLGk0Q"
+		}
+	  },
+	  {
+		"id": "did:web:localhost%3A8888#key-2",
+		"type": "Ed25519VerificationKey2020",
+		"controller": "did:web:localhost%3A8888",
+		"publicKeyMultibase": "z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+	  }
+	]
+}`
+
+func Test_getDIDDocumentURL(t *testing.T) {
+	type args struct {
+		did string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "did:web:w3c-ccg.github.io",
+			args: args{
+				did: "did:web:w3c-ccg.github.io",
+			},
+			want:    "https://w3c-ccg.github.io/.well-known/did.json",
+			wantErr: false,
+		},
+		{
+			name: "did:web:w3c-ccg.github.io:user:alice",
+			args: args{
+				did: "did:web:w3c-ccg.github.io:user:alice",
+			},
+			want:    "https://w3c-ccg.github.io/user/alice/did.json",
+			wantErr: false,
+		},
+		{
+			name: "did:web:example.com%3A3000",
+			args: args{
+				did: "did:web:example.com%3A3000",
+			},
+			want:    "https://example.com:3000/.well-known/did.json",
+			wantErr: false,
+		},
+		{
+			name: "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK",
+			args: args{
+				did: "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK",
+			},
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name: "did:web:",
+			args: args{
+				did: "did:web:",
+			},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getDIDDocumentURL(tt.args.did)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getDIDDocumentURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getDIDDocumentURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromDIDIssuerClaim(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/did.json" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, didDocumentResponse)
+			return
+		}
+	}))
+	defer server.Close()
+
+	didWebScheme = "http"
+	defer func() { didWebScheme = "https" }()
+
+	didToken := func(kid string) *jwt.Token {
+		tkn := mockToken()
+		tkn.Header["kid"] = kid
+		tkn.Claims = jwt.MapClaims{
+			"iss": "did:web:localhost%3A8888",
+		}
+		return tkn
+	}
+
+	type args struct {
+		token *jwt.Token
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name: "Absolute kid",
+			args: args{
+				token: didToken("did:web:localhost%3A8888#key-1"),
+			},
+			want:    mockKey(),
+			wantErr: false,
+		},
+		{
+			name: "Relative kid",
+			args: args{
+				token: didToken("#key-1"),
+			},
+			want:    mockKey(),
+			wantErr: false,
+		},
+		{
+			name: "Verification method without publicKeyJwk",
+			args: args{
+				token: didToken("#key-2"),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFunc := FromDIDIssuerClaim()
+			got, err := keyFunc(tt.args.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromDIDIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromDIDIssuerClaim() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDIDDocumentLimits(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/malformed/did.json" {
+			document := strings.Replace(didDocumentResponse, `"publicKeyMultibase": "z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"`, `"publicKeyJwk": {"kty": "RSA", "n": "!"}`, 1)
+			io.WriteString(w, strings.Replace(document, `"id": "did:web:localhost%3A8888"`, `"id": "did:web:localhost%3A8888:malformed"`, 1))
+			return
+		}
+		io.WriteString(w, didDocumentResponse)
+	}))
+	defer server.Close()
+
+	didWebScheme = "http"
+	defer func() { didWebScheme = "https" }()
+	defer InvalidateAll()
+
+	tests := []struct {
+		name      string
+		did       string
+		limits    KeySetLimits
+		wantErrIs error
+	}{
+		{name: "Malformed key is skipped", did: "did:web:localhost%3A8888:malformed", limits: defaultFetcher.currentKeySetLimits()},
+		{name: "Document exceeds the key set limits", did: "did:web:localhost%3A8888:large", limits: KeySetLimits{MaxBytes: 64}, wantErrIs: ErrKeySetTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer SetKeySetLimits(defaultFetcher.currentKeySetLimits())
+			SetKeySetLimits(tt.limits)
+
+			_, err := defaultFetcher.getKeySetFromDIDCache(context.Background(), tt.did)
+			if !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("getKeySetFromDIDCache() error = %v, want %v", err, tt.wantErrIs)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package jwkfetch
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const dpopTokenType = "dpop+jwt"
+
+// ErrInvalidDPoPProof is returned for requests without a valid DPoP proof
+var ErrInvalidDPoPProof = errors.New("Invalid DPoP proof")
+
+// ErrDPoPProofReplayed is returned for DPoP proofs whose jti was already used
+var ErrDPoPProofReplayed = errors.New("DPoP proof was already used")
+
+// DPoPProof is a verified DPoP proof
+type DPoPProof struct {
+	// Thumbprint is the base64url encoded RFC 7638 SHA-256 thumbprint of the proof key
+	Thumbprint string
+	Claims     jwt.MapClaims
+	// AccessToken is the DPoP bound access token of the request, nil when the request has no access token
+	AccessToken *jwt.Token
+}
+
+type dpopOptions struct {
+	keyFunc   jwt.Keyfunc
+	window    time.Duration
+	targetURL string
+}
+
+// DPoPOption configures VerifyDPoPProof
+type DPoPOption func(*dpopOptions)
+
+// WithDPoPKeyfunc sets the keyfunc verifying the access token of the request. Defaults to FromIssuerClaim
+func WithDPoPKeyfunc(keyFunc jwt.Keyfunc) DPoPOption {
+	return func(o *dpopOptions) {
+		o.keyFunc = keyFunc
+	}
+}
+
+// WithDPoPWindow sets how far the iat claim of proofs may be from now, and how long jti values are remembered. Defaults to 5 minutes
+func WithDPoPWindow(window time.Duration) DPoPOption {
+	return func(o *dpopOptions) {
+		o.window = window
+	}
+}
+
+// WithDPoPTargetURL sets the htu proofs must have, for servers behind proxies that rewrite the request URL
+func WithDPoPTargetURL(targetURL string) DPoPOption {
+	return func(o *dpopOptions) {
+		o.targetURL = targetURL
+	}
+}
+
+// dpopReplays remembers the jti of proofs until they leave the iat window
+var dpopReplays = NewMemoryReplayCache()
+
+// VerifyDPoPProof verifies the RFC 9449 DPoP proof of the request: its signature by the embedded jwk, htm, htu, iat and jti.
+// When the request has a "DPoP" Authorization header the access token is verified with the keyfunc and must be bound to the proof key by its cnf.jkt claim
+func VerifyDPoPProof(r *http.Request, opts ...DPoPOption) (*DPoPProof, error) {
+	options := dpopOptions{keyFunc: FromIssuerClaim(), window: 5 * time.Minute}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.targetURL == "" {
+		options.targetURL = requestTargetURL(r)
+	}
+
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return nil, fmt.Errorf("%w: request must have exactly one DPoP header", ErrInvalidDPoPProof)
+	}
+
+	var thumbprint string
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(proofs[0], claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := dpopProofKey(token)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		thumbprint = base64.RawURLEncoding.EncodeToString(sum)
+		return key.Materialize()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	if err := checkDPoPClaims(claims, r.Method, options); err != nil {
+		return nil, err
+	}
+
+	proof := &DPoPProof{Thumbprint: thumbprint, Claims: claims}
+	if accessToken, ok := dpopAccessToken(r); ok {
+		if err := bindDPoPAccessToken(proof, accessToken, options.keyFunc); err != nil {
+			return nil, err
+		}
+	}
+
+	jti, _ := claims["jti"].(string)
+	if unused, _ := dpopReplays.Use(r.Context(), jti, 2*options.window); !unused {
+		return nil, ErrDPoPProofReplayed
+	}
+	return proof, nil
+}
+
+// dpopProofKey returns the public key embedded in the jwk header of the proof
+func dpopProofKey(token *jwt.Token) (jwk.Key, error) {
+	if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, dpopTokenType) {
+		return nil, fmt.Errorf("DPoP proof typ is %q, want %q", typ, dpopTokenType)
+	}
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("DPoP proof alg %s is not asymmetric", token.Method.Alg())
+	}
+
+	rawKey, ok := token.Header["jwk"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("DPoP proof doesn't have a jwk header")
+	}
+	buf, err := json.Marshal(map[string]interface{}{"keys": []interface{}{rawKey}})
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := jwk.ParseBytes(buf)
+	if err != nil || len(keySet.Keys) != 1 {
+		return nil, fmt.Errorf("Error while parsing DPoP proof jwk header: %v", err)
+	}
+
+	key := keySet.Keys[0]
+	materialized, err := key.Materialize()
+	if err != nil {
+		return nil, err
+	}
+	switch materialized.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.New("DPoP proof jwk header isn't a public key")
+	}
+}
+
+func checkDPoPClaims(claims jwt.MapClaims, method string, options dpopOptions) error {
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return fmt.Errorf("%w: missing jti", ErrInvalidDPoPProof)
+	}
+	if htm, _ := claims["htm"].(string); htm != method {
+		return fmt.Errorf("%w: htm %q doesn't match method %q", ErrInvalidDPoPProof, htm, method)
+	}
+	if htu, _ := claims["htu"].(string); stripURLQuery(htu) != stripURLQuery(options.targetURL) {
+		return fmt.Errorf("%w: htu %q doesn't match %q", ErrInvalidDPoPProof, htu, options.targetURL)
+	}
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing iat", ErrInvalidDPoPProof)
+	}
+	if age := time.Since(time.Unix(int64(iat), 0)); age > options.window || age < -options.window {
+		return fmt.Errorf("%w: iat is outside of the %v window", ErrInvalidDPoPProof, options.window)
+	}
+	return nil
+}
+
+// bindDPoPAccessToken verifies the access token and checks its ath and cnf.jkt binding to the proof
+func bindDPoPAccessToken(proof *DPoPProof, accessToken string, keyFunc jwt.Keyfunc) error {
+	sum := sha256.Sum256([]byte(accessToken))
+	if ath, _ := proof.Claims["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: ath doesn't match the access token", ErrInvalidDPoPProof)
+	}
+
+	token, err := jwt.Parse(accessToken, keyFunc)
+	if err != nil {
+		return fmt.Errorf("Error while verifying DPoP access token: %v", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	if jkt, _ := cnf["jkt"].(string); jkt != proof.Thumbprint {
+		return fmt.Errorf("%w: access token isn't bound to the proof key", ErrInvalidDPoPProof)
+	}
+	proof.AccessToken = token
+	return nil
+}
+
+func dpopAccessToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 5 || !strings.EqualFold(authorization[:5], "DPoP ") {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[5:]), true
+}
+
+func requestTargetURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func stripURLQuery(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
@@ -0,0 +1,139 @@
+package jwkfetch
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func newDPoPKey(t *testing.T, private bool) (*ecdsa.PrivateKey, map[string]interface{}, string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	var key jwk.Key
+	if private {
+		key, _ = jwk.New(privateKey)
+	} else {
+		key, _ = jwk.New(&privateKey.PublicKey)
+	}
+	sum, _ := key.Thumbprint(crypto.SHA256)
+	buf, _ := json.Marshal(key)
+	var header map[string]interface{}
+	json.Unmarshal(buf, &header)
+	return privateKey, header, base64.RawURLEncoding.EncodeToString(sum)
+}
+
+func signDPoPProof(t *testing.T, privateKey *ecdsa.PrivateKey, header map[string]interface{}, typ string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = typ
+	token.Header["jwk"] = header
+	proof, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign proof: %v", err)
+	}
+	return proof
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	const targetURL = "https://api.example.com/resource"
+	privateKey, header, thumbprint := newDPoPKey(t, false)
+	otherPrivateKey, otherHeader, _ := newDPoPKey(t, false)
+	privateHeaderKey, privateHeader, _ := newDPoPKey(t, true)
+	tokenKey, _ := newTestKeySet(t, "dpop-token-key")
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return &tokenKey.PublicKey, nil
+	}
+
+	accessToken := signTestToken(t, tokenKey, "dpop-token-key", jwt.MapClaims{"cnf": map[string]interface{}{"jkt": thumbprint}})
+	unboundToken := signTestToken(t, tokenKey, "dpop-token-key", jwt.MapClaims{"cnf": map[string]interface{}{"jkt": "other"}})
+	ath := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	proofClaims := func(jti string, changes jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"jti": jti, "htm": "GET", "htu": targetURL, "iat": time.Now().Unix()}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return claims
+	}
+	replayed := signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("replayed", nil))
+
+	tests := []struct {
+		name          string
+		proofs        []string
+		authorization string
+		wantErr       error
+		wantToken     bool
+	}{
+		{name: "Valid proof", proofs: []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("1", nil))}},
+		{name: "Query in htu", proofs: []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("2", jwt.MapClaims{"htu": targetURL + "?a=b"}))}},
+		{
+			name:          "Bound access token",
+			proofs:        []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("3", jwt.MapClaims{"ath": ath(accessToken)}))},
+			authorization: "DPoP " + accessToken,
+			wantToken:     true,
+		},
+		{
+			name:          "Access token bound to other key",
+			proofs:        []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("4", jwt.MapClaims{"ath": ath(unboundToken)}))},
+			authorization: "DPoP " + unboundToken,
+			wantErr:       ErrInvalidDPoPProof,
+		},
+		{
+			name:          "ath of other token",
+			proofs:        []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("5", jwt.MapClaims{"ath": ath(unboundToken)}))},
+			authorization: "DPoP " + accessToken,
+			wantErr:       ErrInvalidDPoPProof,
+		},
+		{name: "No proof", wantErr: ErrInvalidDPoPProof},
+		{name: "Several proofs", proofs: []string{replayed, replayed}, wantErr: ErrInvalidDPoPProof},
+		{name: "Wrong typ", proofs: []string{signDPoPProof(t, privateKey, header, "JWT", proofClaims("6", nil))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Signed by other key", proofs: []string{signDPoPProof(t, otherPrivateKey, header, "dpop+jwt", proofClaims("7", nil))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Private jwk header", proofs: []string{signDPoPProof(t, privateHeaderKey, privateHeader, "dpop+jwt", proofClaims("8", nil))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Wrong htm", proofs: []string{signDPoPProof(t, otherPrivateKey, otherHeader, "dpop+jwt", proofClaims("9", jwt.MapClaims{"htm": "POST"}))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Wrong htu", proofs: []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("10", jwt.MapClaims{"htu": "https://other.example.com/resource"}))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Old iat", proofs: []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("11", jwt.MapClaims{"iat": time.Now().Add(-time.Hour).Unix()}))}, wantErr: ErrInvalidDPoPProof},
+		{name: "Missing jti", proofs: []string{signDPoPProof(t, privateKey, header, "dpop+jwt", proofClaims("", nil))}, wantErr: ErrInvalidDPoPProof},
+		{name: "First use", proofs: []string{replayed}},
+		{name: "Replay", proofs: []string{replayed}, wantErr: ErrDPoPProofReplayed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", fmt.Sprintf("%s?query=1", targetURL), nil)
+			for _, proof := range tt.proofs {
+				r.Header.Add("DPoP", proof)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			got, err := VerifyDPoPProof(r, WithDPoPKeyfunc(keyFunc))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyDPoPProof() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Thumbprint != thumbprint {
+				t.Errorf("VerifyDPoPProof() thumbprint = %v, want %v", got.Thumbprint, thumbprint)
+			}
+			if (got.AccessToken != nil) != tt.wantToken {
+				t.Errorf("VerifyDPoPProof() access token = %v, want token %v", got.AccessToken, tt.wantToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package jwkfetch
+
+import (
+	"time"
+)
+
+// RefreshEscalation degrades a provider after consecutive failures of its scheduled refresh
+type RefreshEscalation struct {
+	// FailureThreshold is the number of consecutive failed refreshes that degrade the provider
+	FailureThreshold int
+	// RetryInterval replaces the provider's RefreshInterval while it is degraded, usually shorter. Zero keeps the RefreshInterval
+	RetryInterval time.Duration
+	// MarkUnhealthy makes Health report the provider as unhealthy while it is degraded
+	MarkUnhealthy bool
+}
+
+// ProviderDegraded is reported to the OnProviderDegraded hook
+type ProviderDegraded struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer              string
+	ConsecutiveFailures int
+	// Err is the error of the last refresh
+	Err error
+}
+
+// recordRefreshResult returns the consecutive failures of the provider's refresh, counting the result, or the failures it recovered from on success
+func (f *Fetcher) recordRefreshResult(key string, err error) int {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	if err == nil {
+		failures := f.refreshFailures[key]
+		delete(f.refreshFailures, key)
+		return failures
+	}
+	f.refreshFailures[key]++
+	return f.refreshFailures[key]
+}
+
+func (f *Fetcher) getRefreshFailures(key string) int {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	return f.refreshFailures[key]
+}
+
+// refreshDegraded returns the consecutive failures of the provider's scheduled refresh and whether they reached its FailureThreshold
+func (f *Fetcher) refreshDegraded(jwkProvider JWKProvider) (int, bool) {
+	failures := f.getRefreshFailures(providerKey(jwkProvider))
+	escalation := jwkProvider.RefreshEscalation
+	return failures, escalation != nil && escalation.FailureThreshold > 0 && failures >= escalation.FailureThreshold
+}
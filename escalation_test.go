@@ -0,0 +1,141 @@
+package jwkfetch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRefreshEscalation(t *testing.T) {
+	failing := true
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	var degraded []ProviderDegraded
+	SetHooks(Hooks{OnProviderDegraded: func(event ProviderDegraded) {
+		degraded = append(degraded, event)
+	}})
+	defer SetHooks(Hooks{})
+
+	jwkProvider := JWKProvider{
+		JWKURL:            fmt.Sprintf("http://%s/escalated/jwks", httptestServerURL),
+		RefreshInterval:   time.Hour,
+		RefreshEscalation: &RefreshEscalation{FailureThreshold: 3, RetryInterval: time.Minute},
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.jwksCache.delete(jwkProvider.JWKURL)
+	if err := defaultFetcher.scheduleProvider(jwkProvider); err != nil {
+		t.Fatalf("scheduleProvider() error = %v", err)
+	}
+	defer defaultFetcher.unscheduleProvider(jwkProvider)
+	defer defaultFetcher.recordRefreshResult(jwkProvider.JWKURL, nil)
+
+	tests := []struct {
+		name         string
+		failing      bool
+		wantFailures int
+		wantDegraded bool
+		wantEvents   int
+		wantInterval time.Duration
+	}{
+		{name: "First failure", failing: true, wantFailures: 1, wantInterval: time.Hour},
+		{name: "Second failure", failing: true, wantFailures: 2, wantInterval: time.Hour},
+		{name: "Threshold reached", failing: true, wantFailures: 3, wantDegraded: true, wantEvents: 1, wantInterval: time.Minute},
+		{name: "Still failing", failing: true, wantFailures: 4, wantDegraded: true, wantEvents: 1, wantInterval: time.Minute},
+		{name: "Recovered", wantEvents: 1, wantInterval: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing = tt.failing
+			defaultFetcher.refreshProvider(jwkProvider)
+
+			stats := Stats()
+			if len(stats) != 1 || stats[0].RefreshFailures != tt.wantFailures || stats[0].Degraded != tt.wantDegraded {
+				t.Errorf("Stats() = %+v, want %d failures, degraded %v", stats, tt.wantFailures, tt.wantDegraded)
+			}
+			if len(degraded) != tt.wantEvents {
+				t.Errorf("OnProviderDegraded called %d times, want %d", len(degraded), tt.wantEvents)
+			}
+			if got := scheduledInterval(t, jwkProvider); got != tt.wantInterval {
+				t.Errorf("Provider refresh is scheduled every %v, want %v", got, tt.wantInterval)
+			}
+		})
+	}
+	if len(degraded) > 0 && (degraded[0].Issuer != jwkProvider.JWKURL || degraded[0].ConsecutiveFailures != 3 || degraded[0].Err == nil) {
+		t.Errorf("OnProviderDegraded event = %+v", degraded[0])
+	}
+}
+
+func scheduledInterval(t *testing.T, jwkProvider JWKProvider) time.Duration {
+	defaultFetcher.providersMu.RLock()
+	s := defaultFetcher.providerSchedulers[providerKey(jwkProvider)]
+	defaultFetcher.providersMu.RUnlock()
+	if s == nil {
+		t.Fatalf("Provider refresh isn't scheduled")
+	}
+	return s.interval
+}
+
+func TestRefreshEscalationOfCachedKeysRefresh(t *testing.T) {
+	failing := true
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	// the provider has no RefreshInterval, so it is refreshed with the cached keys
+	jwkProvider := JWKProvider{
+		JWKURL:            fmt.Sprintf("http://%s/escalated/jwks", httptestServerURL),
+		RefreshEscalation: &RefreshEscalation{FailureThreshold: 2, RetryInterval: time.Minute, MarkUnhealthy: true},
+	}
+	f := newFetcher()
+	defer f.Close()
+	f.setProviders([]JWKProvider{jwkProvider})
+	var degraded int
+	f.SetHooks(Hooks{OnProviderDegraded: func(event ProviderDegraded) {
+		degraded++
+	}})
+
+	tests := []struct {
+		name         string
+		failing      bool
+		wantFailures int
+		wantHealthy  bool
+		wantEvents   int
+		wantInterval time.Duration
+	}{
+		{name: "First failure", failing: true, wantFailures: 1},
+		{name: "Threshold reached", failing: true, wantFailures: 2, wantEvents: 1, wantInterval: time.Minute},
+		{name: "Recovered", wantHealthy: true, wantEvents: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing = tt.failing
+			f.refreshCaches()
+
+			health := f.Health()
+			if len(health) != 1 || health[0].RefreshFailures != tt.wantFailures || health[0].Healthy != tt.wantHealthy {
+				t.Errorf("Health() = %+v, want %d failures, healthy %v", health, tt.wantFailures, tt.wantHealthy)
+			}
+			if degraded != tt.wantEvents {
+				t.Errorf("OnProviderDegraded called %d times, want %d", degraded, tt.wantEvents)
+			}
+			if got := f.scheduledRefreshInterval(jwkProvider); got != tt.wantInterval {
+				t.Errorf("Provider refresh is scheduled every %v, want %v", got, tt.wantInterval)
+			}
+		})
+	}
+}
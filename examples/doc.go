@@ -0,0 +1,9 @@
+// Package examples holds runnable programs wiring jwkfetch into servers through its public API:
+//
+//   - nethttp serves an API behind NewMiddleware with a readiness endpoint on Ready
+//   - grpc serves the gRPC health service behind unary and stream interceptors verifying tokens with ParseAndVerify
+//   - lambda handles API Gateway requests, fetching the keys during the cold start with WarmUp
+//
+// Each program is built and exercised by its tests against a jwkfetchtest server. The examples are a module of their own,
+// so their gRPC and Lambda dependencies don't become dependencies of jwkfetch
+package examples
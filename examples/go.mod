@@ -0,0 +1,23 @@
+module github.com/Soluto/fetch-jwk/examples
+
+go 1.20
+
+require (
+	github.com/Soluto/fetch-jwk v0.0.0
+	github.com/aws/aws-lambda-go v1.41.0
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	google.golang.org/grpc v1.58.3
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/lestrrat-go/jwx v0.9.0 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	golang.org/x/net v0.12.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+replace github.com/Soluto/fetch-jwk => ../
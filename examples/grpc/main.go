@@ -0,0 +1,105 @@
+// Command grpc serves the gRPC health service behind interceptors verifying the bearer tokens of the issuer in the
+// authorization metadata. The service reports SERVING once the issuer's keys are cached:
+//
+//	ISSUER=https://login.example.com go run ./grpc
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	jwt "github.com/dgrijalva/jwt-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func main() {
+	issuer := os.Getenv("ISSUER")
+	if issuer == "" {
+		log.Fatal("ISSUER must be set")
+	}
+	if err := jwkfetch.Init([]jwkfetch.JWKProvider{{Issuer: issuer}}, jwkfetch.WithAllowedIssuers(issuer)); err != nil {
+		log.Printf("Error while fetching keys of %s: %v", issuer, err)
+	}
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(newServer().Serve(listener))
+}
+
+// newServer returns a server verifying the tokens of all calls, with the health service turning SERVING on Ready
+func newServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(unaryInterceptor), grpc.StreamInterceptor(streamInterceptor))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	go func() {
+		<-jwkfetch.Ready()
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}()
+	healthpb.RegisterHealthServer(server, healthServer)
+	return server
+}
+
+type tokenContextKey struct{}
+
+// tokenFromContext returns the token verified by the interceptors
+func tokenFromContext(ctx context.Context) (*jwt.Token, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(*jwt.Token)
+	return token, ok
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream is a stream whose context has the verified token
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate verifies the bearer token of the authorization metadata and returns the context with the token
+func authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var tokenString string
+	if values := md.Get("authorization"); len(values) > 0 && strings.HasPrefix(strings.ToLower(values[0]), "bearer ") {
+		tokenString = values[0][len("bearer "):]
+	}
+	if tokenString == "" {
+		return nil, status.Error(codes.Unauthenticated, jwkfetch.ErrMissingToken.Error())
+	}
+	token, err := jwkfetch.ParseAndVerify(ctx, tokenString)
+	if err != nil {
+		// unreachable issuers aren't the caller's fault, so the call can be retried
+		if jwkfetch.RejectionReasonOf(err) == jwkfetch.RejectionIdPUnreachable {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, tokenContextKey{}, token), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	"github.com/Soluto/fetch-jwk/jwkfetchtest"
+	jwt "github.com/dgrijalva/jwt-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	issuer, err := jwkfetchtest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer issuer.Close()
+	if err := jwkfetch.Init([]jwkfetch.JWKProvider{{Issuer: issuer.Issuer()}}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := newServer()
+	defer server.Stop()
+	go server.Serve(listener)
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := issuer.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+	tests := []struct {
+		name     string
+		token    string
+		wantCode codes.Code
+	}{
+		{name: "Valid token", token: sign(jwt.MapClaims{"sub": "user", "exp": time.Now().Add(time.Hour).Unix()})},
+		{name: "Expired token", token: sign(jwt.MapClaims{"sub": "user", "exp": time.Now().Add(-time.Hour).Unix()}), wantCode: codes.Unauthenticated},
+		{name: "Missing token", wantCode: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tt.token)
+			}
+
+			_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Check() error = %v, want code %v", err, tt.wantCode)
+			}
+
+			// the health service turns SERVING on Ready, which the watch eventually reports
+			stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+			if err != nil {
+				t.Fatalf("Watch() error = %v", err)
+			}
+			for {
+				resp, err := stream.Recv()
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("Watch() error = %v, want code %v", err, tt.wantCode)
+				}
+				if err != nil || resp.Status == healthpb.HealthCheckResponse_SERVING {
+					break
+				}
+			}
+		})
+	}
+}
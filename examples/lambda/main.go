@@ -0,0 +1,76 @@
+// Command lambda handles API Gateway HTTP API requests, verifying their bearer tokens of the issuer. The keys are fetched
+// during the function's init phase, so the first request doesn't wait for them:
+//
+//	GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -o bootstrap ./lambda
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func main() {
+	issuer := os.Getenv("ISSUER")
+	if issuer == "" {
+		log.Fatal("ISSUER must be set")
+	}
+	warmUp(issuer, 5*time.Second)
+	lambda.Start(handle)
+}
+
+// warmUp registers the issuer and fetches its keys within the timeout, leaving failures to be retried by the first requests
+func warmUp(issuer string, timeout time.Duration) {
+	// the background refreshes only run while the execution environment isn't frozen, so the TTL bounds the age of served keys
+	jwkfetch.Init([]jwkfetch.JWKProvider{{Issuer: issuer, CacheTTL: time.Hour}},
+		jwkfetch.WithHTTPClient(&http.Client{Timeout: timeout}),
+		jwkfetch.WithAllowedIssuers(issuer))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, result := range jwkfetch.WarmUp(ctx) {
+		if result.Err != nil {
+			log.Printf("Error while fetching keys of %s: %v", result.Issuer, result.Err)
+		}
+	}
+}
+
+// handle responds with the subject of the request's verified token
+func handle(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	authorization := req.Headers["authorization"]
+	if len(authorization) < len("bearer ") || !strings.EqualFold(authorization[:len("bearer ")], "bearer ") {
+		return errorResponse(http.StatusUnauthorized, jwkfetch.ErrMissingToken), nil
+	}
+	token, err := jwkfetch.ParseAndVerify(ctx, authorization[len("bearer "):])
+	if err != nil {
+		if jwkfetch.RejectionReasonOf(err) == jwkfetch.RejectionIdPUnreachable {
+			return errorResponse(http.StatusServiceUnavailable, err), nil
+		}
+		return errorResponse(http.StatusUnauthorized, err), nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"sub": token.Claims.(jwt.MapClaims)["sub"]})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+func errorResponse(statusCode int, err error) events.APIGatewayV2HTTPResponse {
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"WWW-Authenticate": "Bearer"},
+		Body:       err.Error(),
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Soluto/fetch-jwk/jwkfetchtest"
+	"github.com/aws/aws-lambda-go/events"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestHandle(t *testing.T) {
+	issuer, err := jwkfetchtest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer issuer.Close()
+	warmUp(issuer.Issuer(), 5*time.Second)
+	if requests := issuer.Requests(jwkfetchtest.JWKsPath); requests != 1 {
+		t.Fatalf("warmUp() fetched the keys %d times, want 1", requests)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := issuer.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "Valid token", authorization: "Bearer " + sign(jwt.MapClaims{"sub": "user", "exp": time.Now().Add(time.Hour).Unix()}), wantStatus: http.StatusOK},
+		{name: "Expired token", authorization: "Bearer " + sign(jwt.MapClaims{"sub": "user", "exp": time.Now().Add(-time.Hour).Unix()}), wantStatus: http.StatusUnauthorized},
+		{name: "Missing token", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayV2HTTPRequest{Headers: map[string]string{"authorization": tt.authorization}}
+			resp, err := handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("handle() error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("handle() status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+		})
+	}
+	if requests := issuer.Requests(jwkfetchtest.JWKsPath); requests != 1 {
+		t.Errorf("handle() fetched the keys again, %d requests", requests)
+	}
+}
@@ -0,0 +1,52 @@
+// Command nethttp serves an API verifying bearer tokens of the issuer with jwkfetch's middleware:
+//
+//	ISSUER=https://login.example.com go run ./nethttp
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+)
+
+func main() {
+	issuer := os.Getenv("ISSUER")
+	if issuer == "" {
+		log.Fatal("ISSUER must be set")
+	}
+	err := jwkfetch.Init([]jwkfetch.JWKProvider{{Issuer: issuer}},
+		jwkfetch.WithHTTPClient(&http.Client{Timeout: 5 * time.Second}),
+		jwkfetch.WithAllowedIssuers(issuer),
+		jwkfetch.WithLogger(log.Default()))
+	if err != nil {
+		// Init keeps retrying in the background, /readyz reports when the keys are cached
+		log.Printf("Error while fetching keys of %s: %v", issuer, err)
+	}
+	log.Fatal(http.ListenAndServe(":8080", newHandler()))
+}
+
+// newHandler serves /api/ behind the middleware, requiring the read scope, and the unauthenticated /readyz
+func newHandler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("/api/me", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := jwkfetch.ClaimsFromContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": claims["sub"]})
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", jwkfetch.NewMiddleware(jwkfetch.RequireScopes("read"))(api))
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-jwkfetch.Ready():
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	"github.com/Soluto/fetch-jwk/jwkfetchtest"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestHandler(t *testing.T) {
+	issuer, err := jwkfetchtest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer issuer.Close()
+	if err := jwkfetch.Init([]jwkfetch.JWKProvider{{Issuer: issuer.Issuer()}}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	select {
+	case <-jwkfetch.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ready() didn't fire")
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := issuer.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "Ready", path: "/readyz", wantStatus: http.StatusOK},
+		{name: "Scoped token", path: "/api/me", token: sign(jwt.MapClaims{"sub": "user", "scope": "read", "exp": exp}), wantStatus: http.StatusOK},
+		{name: "Missing scope", path: "/api/me", token: sign(jwt.MapClaims{"sub": "user", "exp": exp}), wantStatus: http.StatusForbidden},
+		{name: "Expired token", path: "/api/me", token: sign(jwt.MapClaims{"sub": "user", "scope": "read", "exp": time.Now().Add(-time.Hour).Unix()}), wantStatus: http.StatusUnauthorized},
+		{name: "Missing token", path: "/api/me", wantStatus: http.StatusUnauthorized},
+	}
+	handler := newHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s status = %d, want %d: %s", tt.path, rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
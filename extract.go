@@ -0,0 +1,53 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor returns the token of the request, empty when the request has none
+type TokenExtractor func(r *http.Request) string
+
+// AuthorizationHeaderExtractor takes the token from a "Bearer" Authorization header
+func AuthorizationHeaderExtractor(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// HeaderExtractor takes the token from a custom header, e.g. X-Id-Token
+func HeaderExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// CookieExtractor takes the token from a cookie, e.g. of SPAs
+func CookieExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// QueryExtractor takes the token from a query parameter, e.g. of WebSocket upgrades from browsers, which can't set headers.
+// Tokens in URLs leak into logs and browser history, so use it only where no other extractor works
+func QueryExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+func extractToken(r *http.Request, extractors []TokenExtractor) string {
+	for _, extract := range extractors {
+		if token := extract(r); token != "" {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_extractToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		extractors []TokenExtractor
+		setup      func(r *http.Request)
+		want       string
+	}{
+		{name: "Authorization header", extractors: []TokenExtractor{AuthorizationHeaderExtractor}, setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer header-token") }, want: "header-token"},
+		{name: "Basic authorization", extractors: []TokenExtractor{AuthorizationHeaderExtractor}, setup: func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") }, want: ""},
+		{name: "Custom header", extractors: []TokenExtractor{HeaderExtractor("X-Id-Token")}, setup: func(r *http.Request) { r.Header.Set("X-Id-Token", "custom-token") }, want: "custom-token"},
+		{name: "Cookie", extractors: []TokenExtractor{CookieExtractor("session")}, setup: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: "cookie-token"}) }, want: "cookie-token"},
+		{name: "Query", extractors: []TokenExtractor{QueryExtractor("access_token")}, setup: func(r *http.Request) { r.URL.RawQuery = "access_token=query-token" }, want: "query-token"},
+		{name: "Query not opted in", extractors: []TokenExtractor{AuthorizationHeaderExtractor}, setup: func(r *http.Request) { r.URL.RawQuery = "access_token=query-token" }, want: ""},
+		{name: "First found", extractors: []TokenExtractor{AuthorizationHeaderExtractor, CookieExtractor("session")}, setup: func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "cookie-token"})
+		}, want: "cookie-token"},
+		{name: "Custom extractor", extractors: []TokenExtractor{func(r *http.Request) string { return r.Header.Get("Sec-WebSocket-Protocol") }}, setup: func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "ws-token") }, want: "ws-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(req)
+			if got := extractToken(req, tt.extractors); got != tt.want {
+				t.Errorf("extractToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
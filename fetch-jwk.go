@@ -1,16 +1,23 @@
 package jwkfetch
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/lestrrat-go/jwx/jwk"
-	"github.com/robfig/cron"
 )
 
 // JWKProvider structure for jwk config
@@ -18,60 +25,267 @@ type JWKProvider struct {
 	Issuer      string
 	DiscoverURL string
 	JWKURL      string
+	// RegionalJWKURLs are JWKs endpoints of the provider keyed by region, used instead of DiscoverURL and JWKURL. The endpoint of the
+	// region of WithRegion is fetched first, falling back to the others when it fails. Requires Issuer
+	RegionalJWKURLs map[string]string
+	// JWKURLResolver resolves the JWKs endpoints of providers whose endpoint moves between hosts, e.g. SRVResolver or ConsulResolver,
+	// used instead of DiscoverURL, JWKURL and RegionalJWKURLs. The endpoints are resolved again whenever the provider is refreshed.
+	// Requires Issuer
+	JWKURLResolver JWKURLResolver
+	// InlineJWKS is a fixed key set of the provider, e.g. partner keys delivered out of band, used instead of fetching DiscoverURL or JWKURL.
+	// Requires Issuer
+	InlineJWKS json.RawMessage
+	// JWKSEnv is the name of an environment variable holding a fixed key set of the provider as JSON or base64 encoded JSON,
+	// e.g. injected into containers. It is read whenever the provider is fetched, so Refresh picks up a changed value. Requires Issuer
+	JWKSEnv string
+	// HTTPClient is used for the provider's discovery and JWKs requests instead of the client of WithHTTPClient, e.g. with its own timeout,
+	// proxy or CA bundle. Clients without Transport use the fetcher's transport
+	HTTPClient *http.Client
+	// Transport is used for the provider's discovery and JWKs requests instead of the default transport, e.g. SigV4Transport.
+	// It replaces the transport of HTTPClient
+	Transport http.RoundTripper
+	// RefreshInterval schedules the provider's own refresh in addition to the global 24 hours refresh. Zero means only the global refresh
+	RefreshInterval time.Duration
+	// RefreshEscalation degrades the provider when its scheduled refresh keeps failing
+	RefreshEscalation *RefreshEscalation
+	// AdaptiveRefresh lengthens the RefreshInterval while the provider's keys don't change and shortens it after rotations
+	AdaptiveRefresh *AdaptiveRefresh
+	// HeaderRefresh schedules the provider's own refresh by the Cache-Control max-age or Expires of its key set response
+	// instead of RefreshInterval and AdaptiveRefresh
+	HeaderRefresh *HeaderRefresh
+	// CacheTTL expires the provider's cached key set once it is older than the TTL, overriding the default of keeping it until the next refresh. Zero means no expiry
+	CacheTTL time.Duration
+	// MinTTL and MaxTTL clamp the lifetime of the provider's cached key set, which is taken from the Cache-Control max-age or no-store,
+	// or the Expires, of the key set response, or is CacheTTL when the response has none. They override misbehaving headers, e.g. no-store on keys
+	// that rotate rarely. Response headers are only used when any of CacheTTL, MinTTL and MaxTTL is set
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MaxStale is a hard bound on the age of the provider's cached key set. Older keys are revalidated and, unlike CacheTTL, never served when revalidation fails. Zero means no bound
+	MaxStale time.Duration
+	// CrossCheckJWKURL is an independent source of the provider's keys, e.g. a mirror or the jwks_uri of its discovery document.
+	// When set only keys equally present in both sources are accepted
+	CrossCheckJWKURL string
+	// SPKIPins are base64 encoded SHA-256 hashes of SubjectPublicKeyInfo, one of which must match the TLS certificate chain of the provider's endpoints.
+	// Several pins allow rotation. Transport, or the transport of HTTPClient, must be nil or an *http.Transport when pins are set
+	SPKIPins []string
+	// Algorithms are the token signing algorithms accepted for the provider, overriding id_token_signing_alg_values_supported of its discovery document
+	Algorithms []string
+	// TokenTypes are the accepted typ headers of the provider's tokens, e.g. "at+jwt" for RFC 9068 access tokens. Empty accepts any typ
+	TokenTypes []string
+	// Audiences are the accepted aud claims of the provider's tokens, checked by ParseAndVerify. * matches a single DNS label or path segment,
+	// e.g. "api://*" or "https://*.example.com". Empty accepts any aud
+	Audiences []string
+	// AudienceMatch is whether tokens must match any (the default) or all of Audiences
+	AudienceMatch AudienceMatch
+	// Migration trusts a previous key source of the issuer together with the provider's source until its cutover
+	Migration *IssuerMigration
 }
 
-var jwkProviders []JWKProvider
-var issuerCache map[string]*jwk.Set = make(map[string]*jwk.Set)
-var jwksCache map[string]*jwk.Set = make(map[string]*jwk.Set)
-var discoverURLsCache map[string]*jwk.Set = make(map[string]*jwk.Set)
+// keySetEntry is a cached key set together with the endpoints it was fetched from
+type keySetEntry struct {
+	keySet      *jwk.Set
+	index       *keyIndex
+	jwksURL     string
+	discoverURL string
+	fetchedAt   time.Time
+	// algorithms are the signing algorithms accepted for the key set, all when empty
+	algorithms []string
+	// tokenTypes are the typ headers accepted for the key set, all when empty
+	tokenTypes []string
+	// previousKeyIDs are the keys merged from the previous source of a migrating issuer until migrationCutover
+	previousKeyIDs   map[string]bool
+	migrationCutover time.Time
+	// header holds the HTTP caching headers of the key set response
+	header http.Header
+	// source names key sets not fetched from jwksURL, "inline" or "env:" and the environment variable
+	source string
+	// peerSPKIHash is the SPKI hash of the certificate of the JWKs endpoint
+	peerSPKIHash string
+	// previousProvenance is the provenance of the keys of previousKeyIDs
+	previousProvenance KeyProvenance
+	// version orders the entries by the start of the fetch of their key set, so a slow fetch doesn't replace a newer key set
+	version uint64
+	// lastAccess is the unix nano time a key was last looked up in the entry, accessed atomically
+	lastAccess int64
+}
 
-var errKeyNotFound = fmt.Errorf("Token key not found in jwks uri")
+// providerClient is the client and transport of a provider's URLs. A nil client is the fetcher's, a nil transport the client's
+type providerClient struct {
+	client    *http.Client
+	transport http.RoundTripper
+}
 
-// FromIssuerClaim extracts issuer from JWT token assuming that OpenID discover URL is <iss>+/.well-known/openid-configuration. Then fetches JWT keys from jwks_url found in configuration
+func (f *Fetcher) clientFor(fetchURL string) (providerClient, bool) {
+	f.clientsMu.RLock()
+	defer f.clientsMu.RUnlock()
+	client, ok := f.clients[fetchURL]
+	return client, ok
+}
+
+func (f *Fetcher) setClient(fetchURL string, client providerClient) {
+	f.clientsMu.Lock()
+	defer f.clientsMu.Unlock()
+	f.clients[fetchURL] = client
+}
+
+func (f *Fetcher) deleteClient(fetchURL string) {
+	f.clientsMu.Lock()
+	defer f.clientsMu.Unlock()
+	delete(f.clients, fetchURL)
+}
+
+// ErrAlgorithmNotAllowed is returned for tokens signed with an algorithm the issuer doesn't use
+var ErrAlgorithmNotAllowed = errors.New("Token signing algorithm is not allowed for issuer")
+
+// ErrTokenTypeNotAllowed is returned for tokens whose typ header isn't accepted by the provider
+var ErrTokenTypeNotAllowed = errors.New("Token type is not allowed for issuer")
+
+// ErrKeyNotFound is returned when the token's key isn't in the key set, even after fetching it again
+var ErrKeyNotFound = fmt.Errorf("Token key not found in jwks uri")
+
+// FromIssuerClaim is Fetcher.FromIssuerClaim of the default fetcher
 func FromIssuerClaim() func(*jwt.Token) (interface{}, error) {
-	return func(token *jwt.Token) (interface{}, error) {
-		claims := token.Claims.(jwt.MapClaims)
-		issuer := claims["iss"].(string)
+	return defaultFetcher.FromIssuerClaim()
+}
 
-		return retrieveKey(token, issuer, issuerCache, getKeySetFromIssuerCache)
-	}
+// FromIssuerClaim extracts issuer from JWT token assuming that OpenID discover URL is <iss>+/.well-known/openid-configuration. Then fetches JWT keys from jwks_url found in configuration
+func (f *Fetcher) FromIssuerClaim() func(*jwt.Token) (interface{}, error) {
+	return f.FromIssuerClaimCtx(context.Background())
 }
 
-// FromDiscoverURL - fetches JWT keys from jwks_url found in configuration from OpenID discover URL.
+// FromIssuerClaimCtx is Fetcher.FromIssuerClaimCtx of the default fetcher
+func FromIssuerClaimCtx(ctx context.Context) func(*jwt.Token) (interface{}, error) {
+	return defaultFetcher.FromIssuerClaimCtx(ctx)
+}
+
+// FromIssuerClaimCtx is FromIssuerClaim with a context bounding the fetches of the discovery document and the key set, e.g. the request's context
+func (f *Fetcher) FromIssuerClaimCtx(ctx context.Context) func(*jwt.Token) (interface{}, error) {
+	return f.safeKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		resolvedKey, err := f.ResolveKey(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return resolvedKey.Key, nil
+	})
+}
+
+// FromDiscoverURL is Fetcher.FromDiscoverURL of the default fetcher
 func FromDiscoverURL(discoverURL string) func(*jwt.Token) (interface{}, error) {
-	return func(token *jwt.Token) (interface{}, error) {
-		return retrieveKey(token, discoverURL, discoverURLsCache, getKeySetFromDiscoverURLCache)
-	}
+	return defaultFetcher.FromDiscoverURL(discoverURL)
 }
 
-// FromJWKsURL fetches JWT keys from jwks_url
+// FromDiscoverURL - fetches JWT keys from jwks_url found in configuration from OpenID discover URL.
+func (f *Fetcher) FromDiscoverURL(discoverURL string) func(*jwt.Token) (interface{}, error) {
+	return f.FromDiscoverURLCtx(context.Background(), discoverURL)
+}
+
+// FromDiscoverURLCtx is Fetcher.FromDiscoverURLCtx of the default fetcher
+func FromDiscoverURLCtx(ctx context.Context, discoverURL string) func(*jwt.Token) (interface{}, error) {
+	return defaultFetcher.FromDiscoverURLCtx(ctx, discoverURL)
+}
+
+// FromDiscoverURLCtx is FromDiscoverURL with a context bounding the fetches of the discovery document and the key set
+func (f *Fetcher) FromDiscoverURLCtx(ctx context.Context, discoverURL string) func(*jwt.Token) (interface{}, error) {
+	return f.safeKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		return f.retrieveKey(ctx, token, discoverURL, f.discoverURLsCache, f.getKeySetFromDiscoverURLCache)
+	})
+}
+
+// FromJWKsURL is Fetcher.FromJWKsURL of the default fetcher
 func FromJWKsURL(jwksURL string) func(*jwt.Token) (interface{}, error) {
-	return func(token *jwt.Token) (interface{}, error) {
-		return retrieveKey(token, jwksURL, jwksCache, getKeySetFromJWKCache)
+	return defaultFetcher.FromJWKsURL(jwksURL)
+}
+
+// FromJWKsURL fetches JWT keys from jwks_url
+func (f *Fetcher) FromJWKsURL(jwksURL string) func(*jwt.Token) (interface{}, error) {
+	return f.FromJWKsURLCtx(context.Background(), jwksURL)
+}
+
+// FromJWKsURLCtx is Fetcher.FromJWKsURLCtx of the default fetcher
+func FromJWKsURLCtx(ctx context.Context, jwksURL string) func(*jwt.Token) (interface{}, error) {
+	return defaultFetcher.FromJWKsURLCtx(ctx, jwksURL)
+}
+
+// FromJWKsURLCtx is FromJWKsURL with a context bounding the fetch of the key set
+func (f *Fetcher) FromJWKsURLCtx(ctx context.Context, jwksURL string) func(*jwt.Token) (interface{}, error) {
+	return f.safeKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		return f.retrieveKey(ctx, token, jwksURL, f.jwksCache, f.getKeySetFromJWKCache)
+	})
+}
+
+func (f *Fetcher) retrieveKey(ctx context.Context, token *jwt.Token, cacheKey string, cache *entryCache, retrieveFn func(context.Context, string) (*keySetEntry, error)) (interface{}, error) {
+	resolvedKey, err := f.resolveKey(ctx, token, cacheKey, cache, retrieveFn)
+	if err != nil {
+		return nil, err
 	}
+	return resolvedKey.Key, nil
 }
 
-func retrieveKey(token *jwt.Token, cacheKey string, cache map[string]*jwk.Set, retrieveFn func(string) (*jwk.Set, error)) (interface{}, error) {
+func (f *Fetcher) resolveKey(ctx context.Context, token *jwt.Token, cacheKey string, cache *entryCache, retrieveFn func(context.Context, string) (*keySetEntry, error)) (ResolvedKey, error) {
+	f.scheduleRefreshJob()
+	if err := f.checkParsedTokenSize(token); err != nil {
+		return ResolvedKey{}, err
+	}
+	if err := f.checkHeader(token); err != nil {
+		return ResolvedKey{}, err
+	}
 	keyID, err := getKeyID(token)
 	if err != nil {
-		return nil, err
+		return ResolvedKey{}, err
 	}
 
-	keySet, err := retrieveFn(cacheKey)
+	entry, err := cache.flights.do(ctx, cacheKey, retrieveFn)
 	if err != nil {
-		return nil, err
+		return ResolvedKey{}, err
+	}
+	if err := checkAlgorithm(token, entry.algorithms); err != nil {
+		return ResolvedKey{}, err
+	}
+	if err := checkTokenType(token, entry.tokenTypes); err != nil {
+		return ResolvedKey{}, err
 	}
 
-	key, err := getKey(keySet, keyID)
-	if err == errKeyNotFound {
-		delete(cache, cacheKey)
-		freshKeySet, err := retrieveFn(cacheKey)
+	entry.touch()
+	key, err := entry.lookupKey(keyID)
+	if err == ErrKeyNotFound && !f.InForensicMode() {
+		entry, err = f.forceRefresh(ctx, cacheKey, cache, entry, retrieveFn)
 		if err != nil {
-			return nil, err
+			return ResolvedKey{}, err
 		}
-		return getKey(freshKeySet, keyID)
+		key, err = entry.lookupKey(keyID)
+	}
+	if err != nil {
+		return ResolvedKey{}, err
+	}
+	if f.isKeyRevokedForIssuers(f.keySetIssuers(cacheKey, cache, entry), keyID) {
+		return ResolvedKey{}, ErrKeyRevoked
+	}
+	issuer, _ := getIssuer(token)
+	f.recordMigrationSource(issuer, entry, keyID)
+	f.recordAccess(cacheKey)
+	return newResolvedKey(token, key, entry)
+}
+
+// forceRefresh fetches the entry of cacheKey again after a key wasn't found in it, since its key set may have been rotated.
+// Forced refreshes are bounded by the RefreshQuota and shared with the concurrent callers missing a key of the same entry
+func (f *Fetcher) forceRefresh(ctx context.Context, cacheKey string, cache *entryCache, entry *keySetEntry, retrieveFn func(context.Context, string) (*keySetEntry, error)) (*keySetEntry, error) {
+	recordCallerKeyMiss(ctx)
+	if !f.allowForcedRefresh(ctx) {
+		recordCallerThrottled(ctx)
+		return nil, errors.Join(ErrKeyNotFound, ErrRefreshQuotaExceeded)
+	}
+	// the discover and JWKs URL entries of the key set are fetched again as well
+	cache.delete(cacheKey)
+	f.discoverURLsCache.delete(entry.discoverURL)
+	f.jwksCache.delete(entry.jwksURL)
+	done := f.beginForcedRefresh(cacheKey)
+	entry, err := cache.flights.do(ctx, cacheKey, retrieveFn)
+	done()
+	if err != nil {
+		return nil, errors.Join(ErrKeyNotFound, err)
 	}
-	return key, err
+	entry.touch()
+	return entry, nil
 }
 
 func getKeyID(token *jwt.Token) (string, error) {
@@ -81,124 +295,392 @@ func getKeyID(token *jwt.Token) (string, error) {
 	return "", fmt.Errorf("Token doesn't have header kid")
 }
 
+func checkAlgorithm(token *jwt.Token, algorithms []string) error {
+	alg, _ := token.Header["alg"].(string)
+	return checkAlgorithmName(alg, algorithms)
+}
+
+func checkAlgorithmName(alg string, algorithms []string) error {
+	if len(algorithms) == 0 {
+		return nil
+	}
+	for _, algorithm := range algorithms {
+		if algorithm == alg {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, alg)
+}
+
+// checkTokenType returns an ErrTokenTypeNotAllowed for tokens whose typ header isn't one of the accepted token types
+func checkTokenType(token *jwt.Token, tokenTypes []string) error {
+	if len(tokenTypes) == 0 {
+		return nil
+	}
+	typ, _ := token.Header["typ"].(string)
+	if containsMediaType(tokenTypes, typ) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrTokenTypeNotAllowed, strings.TrimPrefix(strings.ToLower(typ), "application/"))
+}
+
+// containsMediaType compares typ and cty headers case-insensitively, with the "application/" prefix optional as in RFC 8725
+func containsMediaType(mediaTypes []string, mediaType string) bool {
+	mediaType = strings.TrimPrefix(strings.ToLower(mediaType), "application/")
+	for _, accepted := range mediaTypes {
+		if strings.TrimPrefix(strings.ToLower(accepted), "application/") == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
 func getKey(keySet *jwk.Set, keyID string) (interface{}, error) {
+	key, err := lookupKey(keySet, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return key.Materialize()
+}
+
+func lookupKey(keySet *jwk.Set, keyID string) (jwk.Key, error) {
 	keys := keySet.LookupKeyID(keyID)
 	if keys == nil || len(keys) == 0 {
-		return nil, errKeyNotFound
+		return nil, ErrKeyNotFound
 	}
 	if len(keys) > 1 {
 		return nil, errors.New("Unexpected error. More than one key found in jwks uri")
 	}
-	return keys[0].Materialize()
+	return keys[0], nil
 }
 
-func getKeySet(jwksURL string) (*jwk.Set, error) {
-	keySet, err := jwk.FetchHTTP(jwksURL)
+func (f *Fetcher) getKeySet(ctx context.Context, jwksURL string) (*jwk.Set, error) {
+	entry, err := f.fetchKeySet(ctx, jwksURL)
 	if err != nil {
-		return nil, fmt.Errorf("Error while fetching jwks: %v", err)
+		return nil, err
 	}
-	return keySet, nil
+	return entry.keySet, nil
 }
 
-func getKeySetFromJWKCache(jwksURL string) (*jwk.Set, error) {
-	var keySet *jwk.Set
-	var ok bool
-	var err error
-	if keySet, ok = jwksCache[jwksURL]; !ok {
-		keySet, err = getKeySet(jwksURL)
+func (f *Fetcher) getKeySetFromJWKCache(ctx context.Context, jwksURL string) (*keySetEntry, error) {
+	if entry := f.jwksCache.get(jwksURL); entry != nil && !isStale(ctx, entry) {
+		return entry, nil
+	}
+
+	entry, err := f.fetchKeySet(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	return f.jwksCache.store(jwksURL, entry), nil
+}
+
+// getKeySetFromJWKCaches returns the key set of the first of the URLs that can be fetched, trying them in order
+func (f *Fetcher) getKeySetFromJWKCaches(ctx context.Context, jwksURLs []string) (*keySetEntry, error) {
+	var errs []error
+	for _, jwksURL := range jwksURLs {
+		entry, err := f.getKeySetFromJWKCache(ctx, jwksURL)
+		if err == nil {
+			return entry, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", jwksURL, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, &fetchError{err: fmt.Errorf("Error while fetching jwks from %d endpoints: %w", len(jwksURLs), errors.Join(errs...))}
+}
+
+// getInlineKeySet parses the inline key set of a provider with the limits of fetched key sets
+func (f *Fetcher) getInlineKeySet(inlineJWKS json.RawMessage) (*keySetEntry, error) {
+	version := nextEntryVersion()
+	keySet, malformed, err := f.parseKeySet(bytes.NewReader(inlineJWKS))
+	f.reportMalformedKeys("", malformed)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing configured jwks: %w", err)
+	}
+	return &keySetEntry{
+		keySet:    keySet,
+		index:     newKeyIndex(keySet),
+		fetchedAt: clockNow(),
+		source:    "inline",
+		version:   version,
+	}, nil
+}
+
+// getEnvKeySet parses the key set held by the environment variable as JSON or base64 encoded JSON
+func (f *Fetcher) getEnvKeySet(name string) (*keySetEntry, error) {
+	value, ok := os.LookupEnv(name)
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("Environment variable %s of jwks is not set", name)
+	}
+	if !strings.HasPrefix(value, "{") {
+		decoded, err := base64.StdEncoding.DecodeString(value)
 		if err != nil {
-			return nil, err
+			decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Environment variable %s is neither jwks nor base64 encoded jwks", name)
 		}
-		jwksCache[jwksURL] = keySet
+		value = string(decoded)
 	}
-	return keySet, nil
+	entry, err := f.getInlineKeySet(json.RawMessage(value))
+	if err != nil {
+		return nil, err
+	}
+	entry.source = "env:" + name
+	return entry, nil
 }
 
-func getKeySetFromDiscoverURLCache(discoverURL string) (*jwk.Set, error) {
-	var keySet *jwk.Set
-	var ok bool
-	if keySet, ok = discoverURLsCache[discoverURL]; !ok {
-		jwksURL, err := getJWKsURL(discoverURL)
+func (f *Fetcher) getKeySetFromDiscoverURLCache(ctx context.Context, discoverURL string) (*keySetEntry, error) {
+	if entry := f.discoverURLsCache.get(discoverURL); entry != nil && !isStale(ctx, entry) {
+		return entry, nil
+	}
+
+	document, err := f.getDiscoveryDocument(ctx, discoverURL)
+	if err != nil {
+		return nil, err
+	}
+	jwksURL := document.JWKsURI
+	if err := f.checkJWKsURI(discoverURL, jwksURL); err != nil {
+		return nil, err
+	}
+	if client, ok := f.clientFor(discoverURL); ok {
+		f.setClient(jwksURL, client)
+	}
+
+	jwksEntry, err := f.getKeySetFromJWKCache(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	entry := &keySetEntry{
+		keySet:       jwksEntry.keySet,
+		index:        jwksEntry.index,
+		jwksURL:      jwksEntry.jwksURL,
+		discoverURL:  discoverURL,
+		fetchedAt:    jwksEntry.fetchedAt,
+		algorithms:   document.SigningAlgorithms,
+		header:       jwksEntry.header,
+		version:      jwksEntry.version,
+		peerSPKIHash: jwksEntry.peerSPKIHash,
+	}
+	return f.discoverURLsCache.store(discoverURL, entry), nil
+}
+
+func (f *Fetcher) getKeySetFromIssuerCache(ctx context.Context, issuer string) (*keySetEntry, error) {
+	if f.isIssuerRemoved(issuer) || !f.isIssuerAllowed(issuer) {
+		return nil, ErrIssuerNotAllowed
+	}
+	if entry := f.issuerCache.get(issuer); entry != nil && !isStale(ctx, entry) {
+		return f.revalidateProviderEntry(ctx, issuer, entry)
+	}
+
+	entry, err := f.getKeySetFromProvidedConfig(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		discoverURL, err := getDiscoverURL(issuer)
 		if err != nil {
 			return nil, err
 		}
-
-		keySet, err = getKeySetFromJWKCache(jwksURL)
+		entry, err = f.getKeySetFromDiscoverURLCache(ctx, discoverURL)
 		if err != nil {
 			return nil, err
 		}
-		discoverURLsCache[discoverURL] = keySet
+		entry = f.issuerCache.store(issuer, entry)
 	}
-	return keySet, nil
+	return entry, nil
 }
 
-func getKeySetFromIssuerCache(issuer string) (*jwk.Set, error) {
-	var keySet *jwk.Set
-	var ok bool
+func (f *Fetcher) getKeySetFromProvidedConfig(ctx context.Context, issuer string) (*keySetEntry, error) {
+	jwkProvider, ok := f.findProvider(issuer)
+	if !ok {
+		return nil, nil
+	}
+
+	var entry *keySetEntry
 	var err error
-	if keySet, ok = issuerCache[issuer]; !ok {
-		keySet, err = getKeySetFromProvidedConfig(issuer)
+	switch {
+	case len(jwkProvider.InlineJWKS) > 0:
+		entry, err = f.getInlineKeySet(jwkProvider.InlineJWKS)
+	case jwkProvider.JWKSEnv != "":
+		entry, err = f.getEnvKeySet(jwkProvider.JWKSEnv)
+	case jwkProvider.JWKURLResolver != nil:
+		entry, err = f.getKeySetFromResolvedJWKCache(ctx, jwkProvider)
+	case len(jwkProvider.RegionalJWKURLs) > 0:
+		entry, err = f.getKeySetFromJWKCaches(ctx, f.regionalJWKURLs(jwkProvider))
+	case jwkProvider.JWKURL != "":
+		entry, err = f.getKeySetFromJWKCache(ctx, jwkProvider.JWKURL)
+	case jwkProvider.DiscoverURL != "":
+		entry, err = f.getKeySetFromDiscoverURLCache(ctx, jwkProvider.DiscoverURL)
+	default:
+		var discoverURL string
+		discoverURL, err = getDiscoverURL(issuer)
 		if err != nil {
 			return nil, err
 		}
+		entry, err = f.getKeySetFromDiscoverURLCache(ctx, discoverURL)
+	}
+	if err != nil || entry == nil {
+		return entry, err
+	}
+	f.recordKeySetRotation(issuer, entry.keySet)
 
-		if keySet == nil {
-			discoverURL, err := getDiscoverURL(issuer)
-			if err != nil {
-				return nil, err
-			}
-			keySet, err = getKeySetFromDiscoverURLCache(discoverURL)
-			if err != nil {
-				return nil, err
-			}
-			issuerCache[issuer] = keySet
+	if jwkProvider.CrossCheckJWKURL != "" {
+		entry, err = f.crossCheckEntry(ctx, jwkProvider, entry)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return keySet, nil
-
-}
-
-func getKeySetFromProvidedConfig(issuer string) (*jwk.Set, error) {
-	if jwkProviders != nil {
-		for _, jwkProvider := range jwkProviders {
-			if jwkProvider.Issuer == issuer {
-				if jwkProvider.JWKURL != "" {
-					keySet, err := getKeySetFromJWKCache(jwkProvider.JWKURL)
-					if err == nil && keySet != nil {
-						issuerCache[issuer] = keySet
-					}
-					return keySet, err
-				}
-				if jwkProvider.DiscoverURL != "" {
-					keySet, err := getKeySetFromDiscoverURLCache(jwkProvider.DiscoverURL)
-					if err == nil && keySet != nil {
-						issuerCache[issuer] = keySet
-					}
-					return keySet, err
-				}
-
-				return nil, nil
-			}
+	entry, err = f.mergeMigrationEntry(ctx, jwkProvider, entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(jwkProvider.Algorithms) > 0 || len(jwkProvider.TokenTypes) > 0 {
+		overridden := *entry
+		if len(jwkProvider.Algorithms) > 0 {
+			overridden.algorithms = jwkProvider.Algorithms
 		}
+		overridden.tokenTypes = jwkProvider.TokenTypes
+		entry = &overridden
 	}
-	return nil, nil
+	return f.issuerCache.store(issuer, entry), nil
+}
+
+// discoveryDocument holds the fields used from an OpenID discovery document
+type discoveryDocument struct {
+	Issuer            string   `json:"issuer"`
+	JWKsURI           string   `json:"jwks_uri"`
+	SigningAlgorithms []string `json:"id_token_signing_alg_values_supported"`
 }
 
-func getJWKsURL(discoverURL string) (string, error) {
-	resp, err := http.Get(discoverURL)
+func (f *Fetcher) getDiscoveryDocument(ctx context.Context, discoverURL string) (discoveryDocument, error) {
+	ctx = withFetcher(ctx, f)
+	if err := checkHost(ctx, discoverURL); err != nil {
+		return discoveryDocument{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoverURL, nil)
+	if err != nil {
+		return discoveryDocument{}, fmt.Errorf("Error while getting openid connect configuration: %v", err)
+	}
+	resp, err := httpClientFor(ctx, discoverURL).Do(req)
 	if err != nil {
-		resErr := fmt.Errorf("Error while getting openid connect configuration: %v", err)
-		return "", resErr
+		resErr := fmt.Errorf("Error while getting openid connect configuration: %w", err)
+		return discoveryDocument{}, &fetchError{err: resErr}
 	}
 
-	decoder := json.NewDecoder(resp.Body)
-	var config map[string]interface{}
-	err = decoder.Decode(&config)
+	defer resp.Body.Close()
+
+	document, err := parseDiscoveryDocument(f.limitBody(resp.Body))
+	if err != nil {
+		return discoveryDocument{}, &fetchError{err: err}
+	}
+	return document, nil
+}
+
+func parseDiscoveryDocument(body io.Reader) (discoveryDocument, error) {
+	decoder := json.NewDecoder(body)
+	var document discoveryDocument
+	err := decoder.Decode(&document)
 	if err != nil {
 		resErr := fmt.Errorf("Error while parsing openid connect configuration: %v", err)
-		return "", resErr
+		return discoveryDocument{}, resErr
+	}
+	if document.JWKsURI == "" {
+		return discoveryDocument{}, errors.New("Openid connect configuration doesn't have jwks_uri")
+	}
+	return document, nil
+}
+
+func (f *Fetcher) getJSON(ctx context.Context, jsonURL string, v interface{}) error {
+	body, err := getBody(withFetcher(ctx, f), jsonURL)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func getBody(ctx context.Context, bodyURL string) ([]byte, error) {
+	if err := checkHost(ctx, bodyURL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bodyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClientFor(ctx, bodyURL).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	// the documents fetched with the keys are bounded like key sets
+	return ioutil.ReadAll(fetcherFrom(ctx).limitBody(resp.Body))
+}
+
+// httpClientFor returns the client of the context's fetcher fetching the URL with the provider's client and transport registered for it.
+// Provider clients without transport use the fetcher's
+func httpClientFor(ctx context.Context, fetchURL string) *http.Client {
+	f := fetcherFrom(ctx)
+	client := f.httpClient()
+	if registered, ok := f.clientFor(fetchURL); ok {
+		if registered.client != nil {
+			transport := client.Transport
+			*client = *registered.client
+			if client.Transport == nil {
+				client.Transport = transport
+			}
+		}
+		if registered.transport != nil {
+			client.Transport = registered.transport
+		}
+	}
+	client.Transport = retryTransport{
+		base:   policyTransport{base: statsTransport{base: compressionTransport{base: client.Transport}}},
+		policy: f.currentOptions().retryPolicy,
+	}
+	return client
+}
+
+// newProviderClient returns the client of the provider's URLs, false when it has no HTTPClient, Transport or SPKIPins of its own
+func (f *Fetcher) newProviderClient(jwkProvider JWKProvider) (providerClient, bool) {
+	if jwkProvider.HTTPClient == nil && jwkProvider.Transport == nil && len(jwkProvider.SPKIPins) == 0 {
+		return providerClient{}, false
+	}
+	client := providerClient{client: jwkProvider.HTTPClient, transport: jwkProvider.Transport}
+	if len(jwkProvider.SPKIPins) > 0 {
+		if client.transport == nil && client.client != nil {
+			jwkProvider.Transport = client.client.Transport
+		}
+		client.transport = f.pinnedTransport(jwkProvider)
+	}
+	return client, true
+}
+
+// registerClient registers the client of the provider's URLs when it has its own HTTPClient, Transport or SPKIPins.
+// The client of URLs resolved by a JWKURLResolver is registered on resolution
+func (f *Fetcher) registerClient(jwkProvider JWKProvider) {
+	client, ok := f.newProviderClient(jwkProvider)
+	if !ok {
+		return
+	}
+	for _, jwksURL := range append([]string{jwkProvider.JWKURL, jwkProvider.CrossCheckJWKURL}, regionalURLs(jwkProvider)...) {
+		if jwksURL != "" {
+			f.setClient(jwksURL, client)
+		}
+	}
+	discoverURL := jwkProvider.DiscoverURL
+	if discoverURL == "" && jwkProvider.Issuer != "" {
+		discoverURL, _ = getDiscoverURL(jwkProvider.Issuer)
+	}
+	if discoverURL != "" {
+		f.setClient(discoverURL, client)
 	}
-	return config["jwks_uri"].(string), nil
 }
 
 func getDiscoverURL(issuer string) (string, error) {
@@ -215,61 +697,181 @@ func getDiscoverURL(issuer string) (string, error) {
 	if dcvURL.Scheme == "" {
 		dcvURL.Scheme = "https"
 	}
+	if _, err := url.Parse(dcvURL.String()); err != nil {
+		return "", fmt.Errorf("Error while getting discover url from issuer claim: %v", err)
+	}
 	return dcvURL.String(), nil
 }
 
-func refreshCaches() {
-	for jwksURL := range jwksCache {
-		delete(jwksCache, jwksURL)
-		keySet, err := getKeySet(jwksURL)
-		if err != nil || keySet == nil {
-			// TODO: maybe something else?
+func (f *Fetcher) refreshCaches() {
+	if f.InForensicMode() {
+		return
+	}
+	// the fetched entries replace the cached ones, which are kept when their fetch fails
+	ctx := withRefresh(context.Background())
+	// providers without a schedule of their own are refreshed here, counting their failures for their RefreshEscalation
+	refreshed := make(map[string]bool)
+	for _, jwkProvider := range f.Providers() {
+		if f.hasOwnSchedule(jwkProvider) {
 			continue
 		}
+		refreshed[providerKey(jwkProvider)] = true
+		f.refreshProviderWith(ctx, jwkProvider)
+	}
+
+	for jwksURL := range f.jwksCache.all() {
+		if refreshed[jwksURL] {
+			continue
+		}
+		if _, err := f.getKeySetFromJWKCache(ctx, jwksURL); err != nil {
+			f.logf("Error while refreshing keys of %s: %v", jwksURL, err)
+		}
 	}
 
-	for discoverURL := range discoverURLsCache {
-		delete(discoverURLsCache, discoverURL)
-		keySet, err := getKeySetFromDiscoverURLCache(discoverURL)
-		if err != nil || keySet == nil {
-			// TODO: maybe something else?
+	for discoverURL := range f.discoverURLsCache.all() {
+		if refreshed[discoverURL] {
 			continue
 		}
+		if _, err := f.getKeySetFromDiscoverURLCache(ctx, discoverURL); err != nil {
+			f.logf("Error while refreshing keys of %s: %v", discoverURL, err)
+		}
 	}
 
-	for issuer := range issuerCache {
-		delete(issuerCache, issuer)
-		keySet, err := getKeySetFromIssuerCache(issuer)
-		if err != nil || keySet == nil {
-			// TODO: maybe something else?
+	for issuer := range f.issuerCache.all() {
+		if refreshed[issuer] {
 			continue
 		}
+		if _, err := f.getKeySetFromIssuerCache(ctx, issuer); err != nil {
+			f.logf("Error while refreshing keys of %s: %v", issuer, err)
+		}
+	}
+
+	for did := range f.didCache.all() {
+		if _, err := f.getKeySetFromDIDCache(ctx, did); err != nil {
+			f.logf("Error while refreshing keys of %s: %v", did, err)
+		}
+	}
+
+	for issuer := range f.vcIssuerCache.all() {
+		if _, err := f.getKeySetFromVCIssuerCache(ctx, issuer); err != nil {
+			f.logf("Error while refreshing keys of %s: %v", issuer, err)
+		}
 	}
 }
 
-// Init initializes fetch jwt package
-func Init(providers []JWKProvider) error {
+// Init is Fetcher.Init of the default fetcher
+func Init(providers []JWKProvider, opts ...Option) error {
+	return defaultFetcher.Init(providers, opts...)
+}
+
+// Init initializes the fetcher with the providers. Keyfuncs may also be used without Init, in which case keys are fetched on first use
+// and the first use schedules the refresh of the cached keys every 24 hours. The options replace the options of previous calls
+func (f *Fetcher) Init(providers []JWKProvider, opts ...Option) error {
+	f.setOptions(opts)
+	f.readiness.start(time.Now())
 	if providers != nil {
-		jwkProviders = providers
-		for _, jwkProvider := range jwkProviders {
+		f.setProviders(providers)
+		for _, jwkProvider := range providers {
+			f.registerClient(jwkProvider)
 			if jwkProvider.Issuer != "" {
-				issuerCache[jwkProvider.Issuer] = nil
+				f.issuerCache.set(jwkProvider.Issuer, nil)
 			}
 			if jwkProvider.DiscoverURL != "" {
-				discoverURLsCache[jwkProvider.DiscoverURL] = nil
+				f.discoverURLsCache.set(jwkProvider.DiscoverURL, nil)
 			}
 			if jwkProvider.JWKURL != "" {
-				jwksCache[jwkProvider.JWKURL] = nil
+				f.jwksCache.set(jwkProvider.JWKURL, nil)
 			}
 		}
-		refreshCaches()
+		f.refreshCaches()
+		for _, jwkProvider := range providers {
+			if err := f.scheduleProvider(jwkProvider); err != nil {
+				return err
+			}
+		}
+	}
+	f.startPrewarm(providers)
+	f.scheduleRefreshJob()
+	// the first use of a keyfunc before Init or a previous Init may have scheduled it at another interval
+	f.rescheduleRefreshJob()
+	return nil
+}
+
+// scheduleRefreshJob schedules the refresh of all cached keys once, by Init or by the first use of a keyfunc before Init
+func (f *Fetcher) scheduleRefreshJob() {
+	f.refreshJobOnce.Do(f.rescheduleRefreshJob)
+}
+
+// rescheduleRefreshJob schedules the refresh of all cached keys at the refresh interval, unless it is already scheduled at it
+func (f *Fetcher) rescheduleRefreshJob() {
+	interval := f.currentOptions().refreshInterval
+	f.refreshJobMu.Lock()
+	defer f.refreshJobMu.Unlock()
+	if f.refreshJob != nil && f.refreshJob.interval == interval {
+		return
+	}
+	if f.refreshJob != nil {
+		f.refreshJob.Stop()
+	}
+	f.refreshJob = f.every(interval, f.refreshCaches)
+}
+
+// IssuerResult is the outcome of the discovery of an issuer passed to InitFromIssuers
+type IssuerResult struct {
+	Issuer  string
+	JWKsURL string
+	Err     error
+}
+
+// InitFromIssuers is Fetcher.InitFromIssuers of the default fetcher
+func InitFromIssuers(ctx context.Context, issuers []string, opts ...Option) ([]IssuerResult, error) {
+	return defaultFetcher.InitFromIssuers(ctx, issuers, opts...)
+}
+
+// InitFromIssuers discovers the issuers concurrently and initializes the fetcher with a provider for each discovered one.
+// The discovery document of an issuer must have its jwks_uri and, when set, an issuer matching the issuer. The results are in the order of the issuers
+func (f *Fetcher) InitFromIssuers(ctx context.Context, issuers []string, opts ...Option) ([]IssuerResult, error) {
+	f.setOptions(opts)
+	results := make([]IssuerResult, len(issuers))
+	var wg sync.WaitGroup
+	for i, issuer := range issuers {
+		wg.Add(1)
+		go func(i int, issuer string) {
+			defer wg.Done()
+			results[i] = f.discoverIssuer(ctx, issuer)
+		}(i, issuer)
+	}
+	wg.Wait()
+
+	providers := make([]JWKProvider, 0, len(issuers))
+	for _, result := range results {
+		if result.Err == nil {
+			providers = append(providers, JWKProvider{Issuer: result.Issuer})
+		}
 	}
+	return results, f.Init(providers, opts...)
+}
 
-	c := cron.New()
-	err := c.AddFunc("@every 24h", refreshCaches)
+func (f *Fetcher) discoverIssuer(ctx context.Context, issuer string) IssuerResult {
+	result := IssuerResult{Issuer: issuer}
+	discoverURL, err := getDiscoverURL(issuer)
 	if err != nil {
-		return fmt.Errorf("failed to schedule JWKs refresh job: %v", err)
+		result.Err = err
+		return result
 	}
-	c.Start()
-	return nil
+	document, err := f.getDiscoveryDocument(ctx, discoverURL)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if document.Issuer != "" && document.Issuer != issuer {
+		result.Err = fmt.Errorf("Openid connect configuration issuer %q doesn't match %q", document.Issuer, issuer)
+		return result
+	}
+	if err := f.checkJWKsURI(discoverURL, document.JWKsURI); err != nil {
+		result.Err = err
+		return result
+	}
+	result.JWKsURL = document.JWKsURI
+	return result
 }
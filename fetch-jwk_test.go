@@ -1,17 +1,23 @@
 package jwkfetch
 
 import (
+	"context"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/lestrrat-go/jwx/jwk"
@@ -224,7 +230,7 @@ func Test_getKeySet(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getKeySet(tt.args.jwksURL)
+			got, err := defaultFetcher.getKeySet(context.Background(), tt.args.jwksURL)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getKeySet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -236,7 +242,7 @@ func Test_getKeySet(t *testing.T) {
 	}
 }
 
-func Test_getJWKsURL(t *testing.T) {
+func Test_getDiscoveryDocument(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
@@ -264,13 +270,13 @@ func Test_getJWKsURL(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getJWKsURL(tt.args.discoverURL)
+			got, err := defaultFetcher.getDiscoveryDocument(context.Background(), tt.args.discoverURL)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("getJWKsURL() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("getDiscoveryDocument() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("getJWKsURL() = %v, want %v", got, tt.want)
+			if got.JWKsURI != tt.want {
+				t.Errorf("getDiscoveryDocument() jwks_uri = %v, want %v", got.JWKsURI, tt.want)
 			}
 		})
 	}
@@ -449,7 +455,8 @@ func TestFromJWKsURL(t *testing.T) {
 	defer server.Close()
 
 	jwksURL := fmt.Sprintf("http://%s/jwks", httptestServerURL)
-	jwksCache[jwksURL], _ = jwk.ParseString(cachedSet)
+	cachedKeySet, _ := jwk.ParseString(cachedSet)
+	defaultFetcher.jwksCache.set(jwksURL, &keySetEntry{keySet: cachedKeySet, jwksURL: jwksURL})
 
 	type args struct {
 		jwksURL string
@@ -498,3 +505,380 @@ func TestFromJWKsURL(t *testing.T) {
 		})
 	}
 }
+
+func TestFromJWKsURLRefreshFailure(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/jwks", httptestServerURL)
+	cachedKeySet, _ := jwk.ParseString(cachedSet)
+	defaultFetcher.jwksCache.set(jwksURL, &keySetEntry{keySet: cachedKeySet, jwksURL: jwksURL})
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	token := mockToken()
+	token.Header["kid"] = "84f294c45160088d079fee68138f52133d3e228c"
+	_, err := FromJWKsURL(jwksURL)(token)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("FromJWKsURL() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if err == nil || !strings.Contains(err.Error(), "Error while fetching jwks") {
+		t.Errorf("FromJWKsURL() error = %v, want refresh error", err)
+	}
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	issuer := fmt.Sprintf("http://%s/algs", httptestServerURL)
+	tests := []struct {
+		name       string
+		discovered string
+		algorithms []string
+		wantErr    error
+	}{
+		{name: "Not advertised", discovered: `null`},
+		{name: "Advertised", discovered: `["ES256", "RS256"]`},
+		{name: "Not used by issuer", discovered: `["ES256"]`, wantErr: ErrAlgorithmNotAllowed},
+		{name: "Provider override", discovered: `["ES256"]`, algorithms: []string{"RS256"}},
+		{name: "Provider restriction", discovered: `["RS256"]`, algorithms: []string{"PS256"}, wantErr: ErrAlgorithmNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/algs/.well-known/openid-configuration" {
+					fmt.Fprintf(w, `{"jwks_uri": "%s/jwks", "id_token_signing_alg_values_supported": %s}`, issuer, tt.discovered)
+					return
+				}
+				io.WriteString(w, jwkResponse)
+			}))
+			defer server.Close()
+
+			jwkProvider := JWKProvider{Issuer: issuer, Algorithms: tt.algorithms}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": issuer}
+			got, err := FromIssuerClaim()(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromIssuerClaim() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+		})
+	}
+}
+
+func TestTokenTypes(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		tokenTypes []string
+		typ        interface{}
+		wantErr    error
+	}{
+		{name: "No policy", typ: "JWT"},
+		{name: "Access token", tokenTypes: []string{"at+jwt"}, typ: "at+jwt"},
+		{name: "Media type", tokenTypes: []string{"at+jwt"}, typ: "application/AT+JWT"},
+		{name: "ID token", tokenTypes: []string{"at+jwt"}, typ: "JWT", wantErr: ErrTokenTypeNotAllowed},
+		{name: "Missing typ", tokenTypes: []string{"at+jwt"}, typ: nil, wantErr: ErrTokenTypeNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwkProvider := JWKProvider{
+				Issuer:     fmt.Sprintf("http://%s/typ", httptestServerURL),
+				JWKURL:     fmt.Sprintf("http://%s/typ/jwks", httptestServerURL),
+				TokenTypes: tt.tokenTypes,
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Header["typ"] = tt.typ
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			_, err := FromIssuerClaim()(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FromIssuerClaim() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInlineJWKS(t *testing.T) {
+	tests := []struct {
+		name       string
+		inlineJWKS string
+		wantErr    bool
+	}{
+		{name: "Key set", inlineJWKS: jwkResponse},
+		{name: "Malformed key set", inlineJWKS: `{"keys": {}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwkProvider := JWKProvider{
+				Issuer:     "https://partner.example.com",
+				InlineJWKS: json.RawMessage(tt.inlineJWKS),
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			got, err := FromIssuerClaim()(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+			if stats := Stats(); len(stats) != 1 || stats[0].FetchedAt.IsZero() {
+				t.Errorf("Stats() = %+v, want the inline key set", stats)
+			}
+		})
+	}
+}
+
+func TestJWKSEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		unset   bool
+		wantErr bool
+	}{
+		{name: "JSON", value: jwkResponse},
+		{name: "Base64", value: base64.StdEncoding.EncodeToString([]byte(jwkResponse))},
+		{name: "Unpadded base64url", value: base64.RawURLEncoding.EncodeToString([]byte(jwkResponse))},
+		{name: "Not base64", value: "not jwks", wantErr: true},
+		{name: "Unset", unset: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.unset {
+				t.Setenv("TEST_PARTNER_JWKS", tt.value)
+			}
+			jwkProvider := JWKProvider{
+				Issuer:  "https://partner.example.com",
+				JWKSEnv: "TEST_PARTNER_JWKS",
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			got, err := FromIssuerClaim()(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+		})
+	}
+}
+
+func TestJWKSEnvRefresh(t *testing.T) {
+	t.Setenv("TEST_PARTNER_JWKS", cachedSet)
+	jwkProvider := JWKProvider{
+		Issuer:  "https://partner.example.com",
+		JWKSEnv: "TEST_PARTNER_JWKS",
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	if results := WarmUp(context.Background()); results[0].Err != nil || results[0].KeyCount != 1 {
+		t.Fatalf("WarmUp() = %+v, want 1 key", results)
+	}
+	os.Setenv("TEST_PARTNER_JWKS", jwkResponse)
+	if results := Refresh(context.Background()); results[0].Err != nil || results[0].KeyCount != 2 {
+		t.Errorf("Refresh() = %+v, want 2 keys", results)
+	}
+}
+
+func TestInitFromIssuers(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/first/.well-known/openid-configuration", "/second/.well-known/openid-configuration":
+			io.WriteString(w, fmt.Sprintf(`{"issuer": "http://%s%s", "jwks_uri": "http://%s/jwks"}`, httptestServerURL, strings.TrimSuffix(r.URL.Path, "/.well-known/openid-configuration"), httptestServerURL))
+		case "/mismatch/.well-known/openid-configuration":
+			io.WriteString(w, `{"issuer": "https://other.example.com", "jwks_uri": "http://localhost:8888/jwks"}`)
+		case "/jwks":
+			io.WriteString(w, jwkResponse)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	first := fmt.Sprintf("http://%s/first", httptestServerURL)
+	second := fmt.Sprintf("http://%s/second", httptestServerURL)
+	mismatch := fmt.Sprintf("http://%s/mismatch", httptestServerURL)
+	missing := fmt.Sprintf("http://%s/missing", httptestServerURL)
+	defer defaultFetcher.setProviders(nil)
+	for _, issuer := range []string{first, second, mismatch, missing} {
+		defer defaultFetcher.issuerCache.delete(issuer)
+		defer defaultFetcher.discoverURLsCache.delete(issuer + "/.well-known/openid-configuration")
+	}
+
+	results, err := InitFromIssuers(context.Background(), []string{first, second, mismatch, missing})
+	if err != nil {
+		t.Fatalf("InitFromIssuers() error = %v", err)
+	}
+
+	tests := []struct {
+		issuer      string
+		wantJWKsURL string
+		wantErr     bool
+	}{
+		{issuer: first, wantJWKsURL: fmt.Sprintf("http://%s/jwks", httptestServerURL)},
+		{issuer: second, wantJWKsURL: fmt.Sprintf("http://%s/jwks", httptestServerURL)},
+		{issuer: mismatch, wantErr: true},
+		{issuer: missing, wantErr: true},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("InitFromIssuers() returned %d results, want %d", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.issuer, func(t *testing.T) {
+			result := results[i]
+			if result.Issuer != tt.issuer || result.JWKsURL != tt.wantJWKsURL || (result.Err != nil) != tt.wantErr {
+				t.Errorf("InitFromIssuers() result = %+v, want issuer %s, JWKs URL %q, error %v", result, tt.issuer, tt.wantJWKsURL, tt.wantErr)
+			}
+			if _, ok := ProviderFor(tt.issuer); ok == tt.wantErr {
+				t.Errorf("ProviderFor(%s) = %v, want %v", tt.issuer, ok, !tt.wantErr)
+			}
+		})
+	}
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": second}
+	if _, err := FromIssuerClaim()(token); err != nil {
+		t.Errorf("FromIssuerClaim() error = %v for a discovered issuer", err)
+	}
+}
+
+func TestFetchContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stalled-body/jwks":
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, `{"keys": [`)
+			w.(http.Flusher).Flush()
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// stalledTLS accepts connections without ever completing the TLS handshake
+	stalledTLS, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer stalledTLS.Close()
+	go func() {
+		for {
+			conn, err := stalledTLS.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name  string
+		fetch func(ctx context.Context) error
+	}{
+		{"Stalled JWKs headers", func(ctx context.Context) error {
+			_, err := defaultFetcher.getKeySetFromJWKCache(ctx, server.URL+"/stalled-headers/jwks")
+			return err
+		}},
+		{"Stalled JWKs body", func(ctx context.Context) error {
+			_, err := defaultFetcher.getKeySetFromJWKCache(ctx, server.URL+"/stalled-body/jwks")
+			return err
+		}},
+		{"Stalled TLS handshake", func(ctx context.Context) error {
+			_, err := defaultFetcher.getKeySetFromJWKCache(ctx, fmt.Sprintf("https://%s/jwks", stalledTLS.Addr()))
+			return err
+		}},
+		{"Stalled discovery", func(ctx context.Context) error {
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": server.URL + "/stalled-discovery"}
+			_, err := ResolveKey(ctx, token)
+			return err
+		}},
+		{"FromIssuerClaimCtx", func(ctx context.Context) error {
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": server.URL + "/stalled-keyfunc"}
+			_, err := FromIssuerClaimCtx(ctx)(token)
+			return err
+		}},
+		{"FromDiscoverURLCtx", func(ctx context.Context) error {
+			_, err := FromDiscoverURLCtx(ctx, server.URL+"/stalled-keyfunc/.well-known/openid-configuration")(mockToken())
+			return err
+		}},
+		{"FromJWKsURLCtx", func(ctx context.Context) error {
+			_, err := FromJWKsURLCtx(ctx, server.URL+"/stalled-keyfunc/jwks")(mockToken())
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := tt.fetch(ctx)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("fetch returned after %v, want shortly after the deadline", elapsed)
+			}
+		})
+	}
+}
+
+func TestKeyfuncBeforeInit(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	defaultFetcher.refreshJobOnce = sync.Once{}
+	defaultFetcher.refreshJob = nil
+	jwksURL := fmt.Sprintf("http://%s/before-init/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	if _, err := FromJWKsURL(jwksURL)(mockToken()); err != nil {
+		t.Fatalf("FromJWKsURL() error = %v before Init", err)
+	}
+	scheduled := defaultFetcher.refreshJob
+	if scheduled == nil {
+		t.Fatalf("First use before Init didn't schedule the refresh job")
+	}
+	defer scheduled.Stop()
+
+	if err := Init(nil); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if defaultFetcher.refreshJob != scheduled {
+		t.Errorf("Init() scheduled another refresh job after first use")
+	}
+}
@@ -0,0 +1,212 @@
+package jwkfetch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fetcher fetches and caches the keys of its own providers, with its own HTTP clients and refresh schedules, so independent configurations,
+// e.g. the providers of two tenants, can run in one process. The package functions use a default Fetcher.
+// Each fetcher has its own hooks, policies, limits, revocations, stats and forensic mode, while the stats of fetched endpoints and callers
+// and the DPoP replay cache are shared by all fetchers
+type Fetcher struct {
+	issuerCache       *entryCache
+	jwksCache         *entryCache
+	discoverURLsCache *entryCache
+	didCache          *entryCache
+	vcIssuerCache     *entryCache
+	// validatedCache holds the last entries of the JWKs URLs whose response had an ETag or Last-Modified, fetched again conditionally
+	validatedCache *entryCache
+
+	// clients are the providers' HTTP clients keyed by the URLs fetched for the provider
+	clientsMu sync.RWMutex
+	clients   map[string]providerClient
+
+	providersMu sync.RWMutex
+	providers   []JWKProvider
+	// removedIssuers are the issuers removed with RemoveProvider
+	removedIssuers map[string]bool
+	// providerSchedulers are the refresh schedulers of providers with RefreshInterval keyed by provider key
+	providerSchedulers map[string]*schedule
+	// adaptiveRefreshes are the adapted refresh intervals of providers with AdaptiveRefresh keyed by provider key
+	adaptiveRefreshes map[string]*adaptiveRefreshState
+	// resolvedJWKURLs are the last resolved endpoints of providers with JWKURLResolver keyed by provider key
+	resolvedJWKURLs map[string][]string
+
+	refreshJobOnce sync.Once
+	refreshJobMu   sync.Mutex
+	refreshJob     *schedule
+
+	idleEvictionMu        sync.Mutex
+	cacheIdleTimeout      time.Duration
+	idleEvictionScheduler *schedule
+
+	persistenceMu        sync.Mutex
+	persistence          CachePersistence
+	persistenceScheduler *schedule
+
+	optionsMu sync.RWMutex
+	options   fetcherOptions
+
+	readiness *readinessState
+	// prewarmStop is closed to stop the prewarm of the last Init, which prewarmWG waits for
+	prewarmMu   sync.Mutex
+	prewarmStop chan struct{}
+	prewarmWG   sync.WaitGroup
+
+	// forensicMode is 1 between EnterForensicMode and ExitForensicMode
+	forensicMode int32
+
+	policyMu             sync.RWMutex
+	allowedHosts         []string
+	jwksURIPolicy        JWKsURIPolicy
+	rotationPolicy       RotationAnomalyPolicy
+	unsupportedKeyPolicy UnsupportedKeyPolicy
+
+	// fetchTransport fetches for the providers without Transport, keySetSource fetches all key sets
+	transportMu    sync.RWMutex
+	fetchTransport http.RoundTripper
+	keySetSource   KeySetSource
+
+	limitsMu            sync.RWMutex
+	keySetLimits        KeySetLimits
+	tokenLimits         TokenLimits
+	headerPolicy        HeaderPolicy
+	maxDecompressedSize int64
+
+	// quotaWindows are the forced refreshes counted against the refreshQuota keyed by caller
+	quotaMu      sync.Mutex
+	refreshQuota RefreshQuota
+	quotaWindows map[string]*quotaWindow
+
+	// stampedeRecorders are the forced refreshes recorded while stampedeDebug is set keyed by cache key
+	stampedeMu        sync.Mutex
+	stampedeDebug     bool
+	stampedeRecorders map[string]*stampedeRecorder
+
+	hooks       *hookState
+	revocations *revocationState
+
+	statsMu sync.Mutex
+	// refreshFailures are the consecutive failures of the scheduled refresh keyed by provider key
+	refreshFailures map[string]int
+	// accessStats are the key lookups keyed by cache key
+	accessStats map[string]*AccessStats
+	// migrationStats are the keys resolved from each source keyed by issuer
+	migrationStats map[string]*MigrationStats
+	// rotationTrackers are the key ids last fetched and the times they changed keyed by issuer
+	rotationTrackers map[string]*rotationTracker
+}
+
+var defaultFetcher = newFetcher()
+
+var fetchersMu sync.Mutex
+
+// fetchers are the fetchers created with New and not closed yet
+var fetchers = make(map[*Fetcher]bool)
+
+func newFetcher() *Fetcher {
+	return &Fetcher{
+		issuerCache:         newEntryCache(),
+		jwksCache:           newEntryCache(),
+		discoverURLsCache:   newEntryCache(),
+		didCache:            newEntryCache(),
+		vcIssuerCache:       newEntryCache(),
+		validatedCache:      newEntryCache(),
+		clients:             make(map[string]providerClient),
+		removedIssuers:      make(map[string]bool),
+		providerSchedulers:  make(map[string]*schedule),
+		adaptiveRefreshes:   make(map[string]*adaptiveRefreshState),
+		resolvedJWKURLs:     make(map[string][]string),
+		readiness:           newReadiness(),
+		fetchTransport:      http.DefaultTransport,
+		keySetSource:        HTTPKeySetSource{},
+		rotationPolicy:      RotationAnomalyPolicy{Window: 24 * time.Hour, Factor: 5, MinRotations: 3},
+		keySetLimits:        KeySetLimits{MaxBytes: 5 << 20, MaxKeys: 10000, MaxKeyBytes: 64 << 10},
+		tokenLimits:         TokenLimits{MaxTokenBytes: 64 << 10, MaxHeaderBytes: 8 << 10},
+		maxDecompressedSize: 10 << 20,
+		stampedeRecorders:   make(map[string]*stampedeRecorder),
+		quotaWindows:        make(map[string]*quotaWindow),
+		hooks:               newHookState(),
+		revocations:         newRevocationState(),
+		options:             newFetcherOptions(nil),
+		refreshFailures:     make(map[string]int),
+		accessStats:         make(map[string]*AccessStats),
+		migrationStats:      make(map[string]*MigrationStats),
+		rotationTrackers:    make(map[string]*rotationTracker),
+	}
+}
+
+// New returns a Fetcher of the providers, independent of the package functions and of other fetchers.
+// Like Init, it fetches the providers' keys and schedules their refresh. Close stops the refreshes of fetchers no longer used
+func New(providers []JWKProvider, opts ...Option) (*Fetcher, error) {
+	f := newFetcher()
+	fetchersMu.Lock()
+	fetchers[f] = true
+	fetchersMu.Unlock()
+	if err := f.Init(providers, opts...); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close stops the scheduled refreshes and the prewarm of the fetcher, waiting for the prewarm to return.
+// Its keyfuncs keep working, fetching keys on use
+func (f *Fetcher) Close() {
+	fetchersMu.Lock()
+	delete(fetchers, f)
+	fetchersMu.Unlock()
+	f.stopPrewarm()
+
+	f.providersMu.Lock()
+	for key, s := range f.providerSchedulers {
+		s.Stop()
+		delete(f.providerSchedulers, key)
+	}
+	f.providersMu.Unlock()
+	f.SetCacheIdleTimeout(0)
+	f.closeIdleConnections()
+	f.persistenceMu.Lock()
+	if f.persistenceScheduler != nil {
+		f.persistenceScheduler.Stop()
+	}
+	f.persistenceMu.Unlock()
+	f.refreshJobOnce.Do(func() {})
+	f.refreshJobMu.Lock()
+	defer f.refreshJobMu.Unlock()
+	if f.refreshJob != nil {
+		f.refreshJob.Stop()
+	}
+}
+
+// allFetchers returns the default fetcher and the fetchers created with New
+func allFetchers() []*Fetcher {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	all := []*Fetcher{defaultFetcher}
+	for f := range fetchers {
+		all = append(all, f)
+	}
+	return all
+}
+
+type fetcherKey struct{}
+
+// withFetcher returns a context whose fetches use the HTTP clients of the fetcher
+func withFetcher(ctx context.Context, f *Fetcher) context.Context {
+	if fetcherFrom(ctx) == f {
+		return ctx
+	}
+	return context.WithValue(ctx, fetcherKey{}, f)
+}
+
+// fetcherFrom returns the fetcher of the context, the default fetcher if it has none
+func fetcherFrom(ctx context.Context) *Fetcher {
+	if f, ok := ctx.Value(fetcherKey{}).(*Fetcher); ok {
+		return f
+	}
+	return defaultFetcher
+}
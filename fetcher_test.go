@@ -0,0 +1,287 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// countingTransport counts the requests it sends with the default transport
+type countingTransport struct {
+	requests *int32
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(t.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetcher(t *testing.T) {
+	firstKey, firstKeySet := newTestKeySet(t, "first-key")
+	secondKey, secondKeySet := newTestKeySet(t, "second-key")
+	newServer := func(keySet string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, keySet)
+		}))
+	}
+	firstServer := newServer(firstKeySet)
+	defer firstServer.Close()
+	secondServer := newServer(secondKeySet)
+	defer secondServer.Close()
+	defer InvalidateAll()
+
+	var firstRequests int32
+	first, err := New([]JWKProvider{{Issuer: firstServer.URL, JWKURL: firstServer.URL + "/jwks", Transport: countingTransport{requests: &firstRequests}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer first.Close()
+	second, err := New([]JWKProvider{{Issuer: secondServer.URL, JWKURL: secondServer.URL + "/jwks"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer second.Close()
+
+	claims := func(issuer string) jwt.MapClaims {
+		return jwt.MapClaims{"iss": issuer, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+	firstToken := signTestToken(t, firstKey, "first-key", claims(firstServer.URL))
+	secondToken := signTestToken(t, secondKey, "second-key", claims(secondServer.URL))
+	tests := []struct {
+		name    string
+		fetcher *Fetcher
+		token   string
+		wantErr bool
+	}{
+		{name: "First fetcher's issuer", fetcher: first, token: firstToken},
+		{name: "Second fetcher's issuer", fetcher: second, token: secondToken},
+		{name: "Other fetcher's issuer", fetcher: first, token: secondToken, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwt.Parse(tt.token, tt.fetcher.FromIssuerClaim())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jwt.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if first.Providers()[0].Issuer != firstServer.URL || second.Providers()[0].Issuer != secondServer.URL || len(Providers()) != 0 {
+		t.Errorf("Providers() = %v, %v and %v, want the providers of each fetcher", first.Providers(), second.Providers(), Providers())
+	}
+	if first.issuerCache.get(secondServer.URL) != nil || defaultFetcher.issuerCache.get(firstServer.URL) != nil {
+		t.Errorf("fetchers share cached keys")
+	}
+
+	fetched := atomic.LoadInt32(&firstRequests)
+	if fetched == 0 {
+		t.Fatalf("first fetcher didn't use its provider's transport")
+	}
+	if _, err := jwt.Parse(firstToken, FromJWKsURL(firstServer.URL+"/jwks")); err != nil {
+		t.Fatalf("jwt.Parse() with the package functions error = %v", err)
+	}
+	if got := atomic.LoadInt32(&firstRequests); got != fetched {
+		t.Errorf("package functions used the transport of the first fetcher's provider")
+	}
+}
+
+func TestFetcherClose(t *testing.T) {
+	f, err := New([]JWKProvider{{Issuer: "https://example.com", InlineJWKS: json.RawMessage(jwkResponse), RefreshInterval: time.Hour}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if len(f.providerSchedulers) != 1 || f.refreshJob == nil {
+		t.Fatalf("New() scheduled %d providers, refresh job %v, want both scheduled", len(f.providerSchedulers), f.refreshJob)
+	}
+	f.Close()
+	if len(f.providerSchedulers) != 0 {
+		t.Errorf("Close() kept %d provider schedules", len(f.providerSchedulers))
+	}
+	for _, fetcher := range allFetchers() {
+		if fetcher == f {
+			t.Errorf("Close() kept the fetcher registered")
+		}
+	}
+	refreshJob := f.refreshJob
+	f.scheduleRefreshJob()
+	select {
+	case <-f.refreshJob.stop:
+	default:
+		t.Errorf("Close() didn't stop the refresh job")
+	}
+	if f.refreshJob != refreshJob {
+		t.Errorf("scheduleRefreshJob() after Close() scheduled the refresh job again")
+	}
+}
+
+func TestFetcherVerify(t *testing.T) {
+	key, keySet := newTestKeySet(t, "verify-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+	f, err := New([]JWKProvider{{Issuer: server.URL, JWKURL: server.URL + "/jwks", Audiences: []string{"api"}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	token := func(audience string) string {
+		return signTestToken(t, key, "verify-key", jwt.MapClaims{"iss": server.URL, "aud": audience, "exp": time.Now().Add(time.Hour).Unix()})
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "Audience of the fetcher's provider", token: token("api")},
+		{name: "Other audience", token: token("other"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.ParseAndVerify(context.Background(), tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("ParseAndVerify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if results := f.VerifyBatch(context.Background(), []string{tt.token}); (results[0].Err != nil) != tt.wantErr {
+				t.Errorf("VerifyBatch() error = %v, wantErr %v", results[0].Err, tt.wantErr)
+			}
+			handler := f.NewMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if (rec.Code != http.StatusOK) != tt.wantErr {
+				t.Errorf("NewMiddleware() status = %d, wantErr %v", rec.Code, tt.wantErr)
+			}
+		})
+	}
+
+	if stats := f.AccessReport(); len(stats) != 1 || stats[0].Key != server.URL || stats[0].Accesses == 0 {
+		t.Errorf("AccessReport() = %+v, want the accesses of the fetcher's provider", stats)
+	}
+	for _, access := range AccessReport() {
+		if access.Key == server.URL {
+			t.Errorf("AccessReport() of the default fetcher has %+v of another fetcher", access)
+		}
+	}
+}
+
+func TestFetcherSettings(t *testing.T) {
+	key, keySet := newTestKeySet(t, "settings-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+	f, err := New([]JWKProvider{{Issuer: server.URL, JWKURL: server.URL + "/jwks"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+	token := signTestToken(t, key, "settings-key", jwt.MapClaims{"iss": server.URL, "exp": time.Now().Add(time.Hour).Unix()})
+
+	t.Run("Revoked keys", func(t *testing.T) {
+		f.RevokeKey(server.URL, "settings-key")
+		defer f.SetRevokedKeys(nil)
+		if _, err := f.ParseAndVerify(context.Background(), token); !errors.Is(err, ErrKeyRevoked) {
+			t.Errorf("ParseAndVerify() error = %v, want ErrKeyRevoked", err)
+		}
+		if keys := RevokedKeys(); len(keys) != 0 {
+			t.Errorf("RevokedKeys() of the default fetcher = %v, want the keys of no other fetcher", keys)
+		}
+	})
+
+	t.Run("Allowed hosts and hooks", func(t *testing.T) {
+		f.SetAllowedHosts("*.example.com")
+		defer f.SetAllowedHosts()
+		var blocked []HostNotAllowedError
+		f.SetHooks(Hooks{OnHostNotAllowed: func(e HostNotAllowedError) { blocked = append(blocked, e) }})
+		defer f.SetHooks(Hooks{})
+
+		if err := checkHost(context.Background(), "https://attacker.test/jwks"); err != nil {
+			t.Errorf("checkHost() of the default fetcher error = %v, want nil", err)
+		}
+		var hostErr *HostNotAllowedError
+		if err := checkHost(withFetcher(context.Background(), f), "https://attacker.test/jwks"); !errors.As(err, &hostErr) {
+			t.Errorf("checkHost() error = %v, want *HostNotAllowedError", err)
+		}
+		if len(blocked) != 1 {
+			t.Errorf("OnHostNotAllowed() of the fetcher called %d times, want 1", len(blocked))
+		}
+	})
+
+	t.Run("Token limits", func(t *testing.T) {
+		f.SetTokenLimits(TokenLimits{MaxTokenBytes: 100})
+		defer f.SetTokenLimits(TokenLimits{MaxTokenBytes: 64 << 10, MaxHeaderBytes: 8 << 10})
+		var sizeErr *TokenTooLargeError
+		if _, err := f.ParseAndVerify(context.Background(), token); !errors.As(err, &sizeErr) {
+			t.Errorf("ParseAndVerify() error = %v, want *TokenTooLargeError", err)
+		}
+		if _, err := ParseAndVerify(context.Background(), token); errors.As(err, &sizeErr) {
+			t.Errorf("ParseAndVerify() of the default fetcher error = %v, want the limits of no other fetcher", err)
+		}
+	})
+
+	t.Run("Key set source", func(t *testing.T) {
+		source := &fakeKeySetSource{keySets: map[string]string{"bus://keys/settings": jwkResponse}}
+		f.SetKeySetSource(source)
+		defer f.SetKeySetSource(nil)
+		defer f.jwksCache.delete("bus://keys/settings")
+		if _, err := f.FromJWKsURL("bus://keys/settings")(mockToken()); err != nil {
+			t.Errorf("FromJWKsURL() error = %v", err)
+		}
+		if _, ok := defaultFetcher.currentKeySetSource().(HTTPKeySetSource); !ok {
+			t.Errorf("Key set source of the default fetcher = %T, want HTTPKeySetSource", defaultFetcher.currentKeySetSource())
+		}
+	})
+
+	t.Run("Unsupported key policy", func(t *testing.T) {
+		f.SetUnsupportedKeyPolicy(RejectUnsupportedKeys)
+		defer f.SetUnsupportedKeyPolicy(ReportUnsupportedKeys)
+		document := `{"keys": [{"kty":"OKP","kid":"okp","crv":"Ed448","x":"AA"},{"kty":"oct","kid":"valid","alg":"HS256","k":"c2VjcmV0"}]}`
+		if _, _, err := f.parseKeySet(strings.NewReader(document)); !errors.Is(err, ErrUnsupportedKey) {
+			t.Errorf("parseKeySet() error = %v, want ErrUnsupportedKey", err)
+		}
+		if _, _, err := defaultFetcher.parseKeySet(strings.NewReader(document)); err != nil {
+			t.Errorf("parseKeySet() of the default fetcher error = %v, want the policy of no other fetcher", err)
+		}
+	})
+
+	t.Run("Cache persistence", func(t *testing.T) {
+		if err := f.SetCachePersistence(context.Background(), CachePersistence{Path: filepath.Join(t.TempDir(), "keys.snapshot")}); err != nil {
+			t.Fatalf("SetCachePersistence() error = %v", err)
+		}
+		defer f.SetCachePersistence(context.Background(), CachePersistence{})
+		if err := PersistCache(context.Background()); err == nil {
+			t.Errorf("PersistCache() of the default fetcher error = nil, want error")
+		}
+		if err := f.PersistCache(context.Background()); err != nil {
+			t.Errorf("PersistCache() error = %v", err)
+		}
+
+		var snapshot bytes.Buffer
+		if err := f.WriteSnapshot(context.Background(), &snapshot, nil); err != nil {
+			t.Fatalf("WriteSnapshot() error = %v", err)
+		}
+		if _, err := f.EnterForensicMode(context.Background(), &snapshot, nil); err != nil {
+			t.Fatalf("EnterForensicMode() error = %v", err)
+		}
+		defer f.ExitForensicMode()
+		if err := f.PersistCache(context.Background()); !errors.Is(err, ErrForensicMode) {
+			t.Errorf("PersistCache() in forensic mode error = %v, want ErrForensicMode", err)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// flightGroup shares the fetch of a key set among the concurrent callers of the same cache key, so a cold or expired cache
+// is fetched once instead of once per request
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a fetch in flight, whose entry and error are set once done is closed
+type flightCall struct {
+	done  chan struct{}
+	entry *keySetEntry
+	err   error
+}
+
+// do returns the result of the fetch of the key in flight, or fetches it when none is. Callers stop waiting when their context is done,
+// and when the fetch fails because the context of the caller running it is done, the callers still waiting fetch it again
+func (g *flightGroup) do(ctx context.Context, key string, fetch func(context.Context, string) (*keySetEntry, error)) (*keySetEntry, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*flightCall)
+		}
+		call, inFlight := g.calls[key]
+		if !inFlight {
+			call = &flightCall{done: make(chan struct{})}
+			g.calls[key] = call
+		}
+		g.mu.Unlock()
+
+		if !inFlight {
+			g.run(ctx, key, call, fetch)
+			return call.entry, call.err
+		}
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, &fetchError{err: fmt.Errorf("Error while waiting for the keys of %s: %w", key, ctx.Err())}
+		}
+		if isContextError(call.err) && ctx.Err() == nil {
+			continue
+		}
+		return call.entry, call.err
+	}
+}
+
+// run fetches the key for the call and its waiters. Panics of the fetch fail the waiters and are passed on to the caller
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fetch func(context.Context, string) (*keySetEntry, error)) {
+	fetched := false
+	defer func() {
+		if !fetched {
+			call.err = fmt.Errorf("Fetch of the keys of %s panicked", key)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.entry, call.err = fetch(ctx, key)
+	fetched = true
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
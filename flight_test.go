@@ -0,0 +1,131 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestConcurrentFetchesAreShared(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "shared-key")
+	var discoveryRequests, jwksRequests int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough for all the tokens to arrive while the keys are fetched
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			atomic.AddInt32(&discoveryRequests, 1)
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, server.URL, server.URL+"/jwks")
+		case "/jwks":
+			atomic.AddInt32(&jwksRequests, 1)
+			io.WriteString(w, keySet)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		kid          string
+		wantRequests int32
+	}{
+		{name: "Cold cache", kid: "shared-key", wantRequests: 1},
+		// the tokens looking up the cached keys while the first forced refresh is in flight share it, and then force another one together
+		{name: "Unknown kid", kid: "unknown-key", wantRequests: 2},
+	}
+	f := newFetcher()
+	defer f.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&discoveryRequests, 0)
+			atomic.StoreInt32(&jwksRequests, 0)
+			token := signTestToken(t, privateKey, tt.kid, jwt.MapClaims{"iss": server.URL, "exp": time.Now().Add(time.Hour).Unix()})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					jwt.Parse(token, f.FromIssuerClaim())
+				}()
+			}
+			wg.Wait()
+			if got := atomic.LoadInt32(&discoveryRequests); got > tt.wantRequests {
+				t.Errorf("Discovery requests = %d, want at most %d", got, tt.wantRequests)
+			}
+			if got := atomic.LoadInt32(&jwksRequests); got > tt.wantRequests {
+				t.Errorf("JWKs requests = %d, want at most %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	entry := &keySetEntry{jwksURL: "https://login.example.com/jwks"}
+	tests := []struct {
+		name        string
+		cancelFirst bool
+		wantFetches int32
+	}{
+		{name: "Waiter shares the fetch", wantFetches: 1},
+		{name: "Waiter fetches again when the first caller is cancelled", cancelFirst: true, wantFetches: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g flightGroup
+			var fetches int32
+			started := make(chan struct{})
+			release := make(chan struct{})
+			fetch := func(ctx context.Context, key string) (*keySetEntry, error) {
+				if atomic.AddInt32(&fetches, 1) == 1 {
+					close(started)
+					select {
+					case <-release:
+					case <-ctx.Done():
+						return nil, &fetchError{err: ctx.Err()}
+					}
+				}
+				return entry, nil
+			}
+
+			firstCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			firstDone := make(chan error)
+			go func() {
+				_, err := g.do(firstCtx, "key", fetch)
+				firstDone <- err
+			}()
+			<-started
+			waiterDone := make(chan *keySetEntry)
+			go func() {
+				got, _ := g.do(context.Background(), "key", fetch)
+				waiterDone <- got
+			}()
+			// gives the waiter the time to join the fetch in flight
+			time.Sleep(20 * time.Millisecond)
+			if tt.cancelFirst {
+				cancel()
+			} else {
+				close(release)
+			}
+			<-firstDone
+			if got := <-waiterDone; got != entry {
+				t.Errorf("do() = %v, want %v", got, entry)
+			}
+			if got := atomic.LoadInt32(&fetches); got != tt.wantFetches {
+				t.Errorf("Fetches = %d, want %d", got, tt.wantFetches)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrForensicMode is returned instead of fetching keys in forensic mode
+var ErrForensicMode = errors.New("Fetching is disabled in forensic mode")
+
+// EnterForensicMode is Fetcher.EnterForensicMode of the default fetcher
+func EnterForensicMode(ctx context.Context, r io.Reader, encryption SnapshotEncryption) (time.Time, error) {
+	return defaultFetcher.EnterForensicMode(ctx, r, encryption)
+}
+
+// EnterForensicMode replaces the cached keys of the fetcher with the keys of the snapshot and the fetcher performs no network I/O until ExitForensicMode.
+// Keys are resolved from the snapshot only: keys missing from it aren't fetched, and the snapshot's keys never expire, aren't refreshed
+// or evicted and aren't written back by SetCachePersistence, so past validation decisions are reproduced with the exact keys in effect then.
+// It returns the time the snapshot was saved
+func (f *Fetcher) EnterForensicMode(ctx context.Context, r io.Reader, encryption SnapshotEncryption) (time.Time, error) {
+	s, err := readSnapshot(ctx, r, encryption)
+	if err != nil {
+		return time.Time{}, err
+	}
+	entries, err := f.parseSnapshotEntries(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	atomic.StoreInt32(&f.forensicMode, 1)
+	f.InvalidateAll()
+	f.restoreSnapshotEntries(s, entries)
+	return s.SavedAt, nil
+}
+
+// ExitForensicMode is Fetcher.ExitForensicMode of the default fetcher
+func ExitForensicMode() {
+	defaultFetcher.ExitForensicMode()
+}
+
+// ExitForensicMode enables fetching again. The snapshot's keys stay cached until refreshed as usual
+func (f *Fetcher) ExitForensicMode() {
+	atomic.StoreInt32(&f.forensicMode, 0)
+}
+
+// InForensicMode is Fetcher.InForensicMode of the default fetcher
+func InForensicMode() bool {
+	return defaultFetcher.InForensicMode()
+}
+
+// InForensicMode reports whether EnterForensicMode disabled fetching
+func (f *Fetcher) InForensicMode() bool {
+	return atomic.LoadInt32(&f.forensicMode) == 1
+}
+
+// checkForensicMode fails the fetches of the URL by the fetcher of the context in forensic mode
+func checkForensicMode(ctx context.Context, fetchURL string) error {
+	if fetcherFrom(ctx).InForensicMode() {
+		return fmt.Errorf("%w: %s", ErrForensicMode, fetchURL)
+	}
+	return nil
+}
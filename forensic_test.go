@@ -0,0 +1,76 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestForensicMode(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+	jwkProvider := JWKProvider{Issuer: server.URL, JWKURL: server.URL + "/jwks", CacheTTL: time.Nanosecond}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer InvalidateAll()
+	defer ExitForensicMode()
+
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+	if _, err := FromIssuerClaim()(token); err != nil {
+		t.Fatalf("FromIssuerClaim() error = %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := WriteSnapshot(context.Background(), &snapshot, nil); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+
+	savedAt, err := EnterForensicMode(context.Background(), &snapshot, nil)
+	if err != nil {
+		t.Fatalf("EnterForensicMode() error = %v", err)
+	}
+	if !InForensicMode() || time.Since(savedAt) > time.Minute {
+		t.Errorf("EnterForensicMode() = %v, InForensicMode() = %v, want the snapshot time and forensic mode", savedAt, InForensicMode())
+	}
+	fetched := atomic.LoadInt32(&requests)
+
+	defaultFetcher.refreshProvider(jwkProvider)
+	defaultFetcher.refreshCaches()
+	if _, err := FromIssuerClaim()(token); err != nil {
+		t.Errorf("FromIssuerClaim() of an expired snapshot key error = %v", err)
+	}
+	unknownKey := mockToken()
+	unknownKey.Header["kid"] = "unknown"
+	unknownKey.Claims = token.Claims
+	if _, err := FromIssuerClaim()(unknownKey); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("FromIssuerClaim() of a key missing from the snapshot error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := FromJWKsURL(server.URL + "/other")(token); !errors.Is(err, ErrForensicMode) {
+		t.Errorf("FromJWKsURL() of a URL missing from the snapshot error = %v, want %v", err, ErrForensicMode)
+	}
+	if got := atomic.LoadInt32(&requests); got != fetched {
+		t.Errorf("forensic mode made %d requests, want none", got-fetched)
+	}
+	other := newFetcher()
+	if _, err := other.FromJWKsURL(server.URL + "/other")(token); err != nil || other.InForensicMode() {
+		t.Errorf("FromJWKsURL() of another fetcher in forensic mode error = %v, want the keys fetched", err)
+	}
+
+	ExitForensicMode()
+	if _, err := FromJWKsURL(server.URL + "/other")(token); err != nil {
+		t.Errorf("FromJWKsURL() after ExitForensicMode() error = %v", err)
+	}
+}
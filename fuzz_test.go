@@ -0,0 +1,69 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func FuzzParseDiscoveryDocument(f *testing.F) {
+	f.Add([]byte(discoverResponse))
+	f.Add([]byte(`{"jwks_uri":5}`))
+	f.Add([]byte(`{"jwks_uri":null}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, document []byte) {
+		got, err := parseDiscoveryDocument(bytes.NewReader(document))
+		if err == nil && got.JWKsURI == "" {
+			t.Errorf("parseDiscoveryDocument() = empty jwks_uri without error")
+		}
+	})
+}
+
+func FuzzParseKeySet(f *testing.F) {
+	f.Add([]byte(jwkResponse), "512fe2ae0e60bd03084b12885b41423f")
+	f.Add([]byte(`{"keys":[{"kty":"RSA","kid":"a","n":"","e":""}]}`), "a")
+	f.Add([]byte(`{"keys":[{"kty":"EC","kid":"a","crv":"P-256","x5c":["AA=="]}]}`), "a")
+	f.Add([]byte(`{"keys":[{"kid":"a"},{"kid":"a"}]}`), "a")
+
+	f.Fuzz(func(t *testing.T, document []byte, keyID string) {
+		keySet, _, err := defaultFetcher.parseKeySet(bytes.NewReader(document))
+		if err != nil {
+			return
+		}
+		key, err := lookupKey(keySet, keyID)
+		if err != nil {
+			return
+		}
+		token := &jwt.Token{Header: map[string]interface{}{"kid": keyID}, Claims: jwt.MapClaims{}}
+		newResolvedKey(token, key, &keySetEntry{keySet: keySet})
+		getKeyThumbprint(key)
+	})
+}
+
+func FuzzIssuerNormalization(f *testing.F) {
+	f.Add("https://accounts.google.com")
+	f.Add("https://login.microsoftonline.com/tenant/v2.0/")
+	f.Add("localhost:8888")
+	f.Add("did:web:example.com%3A3000:user:alice")
+	f.Add("https://[::1")
+
+	f.Fuzz(func(t *testing.T, issuer string) {
+		urlFuncs := map[string]func(string) (string, error){
+			"getDiscoverURL":         getDiscoverURL,
+			"getVCIssuerMetadataURL": getVCIssuerMetadataURL,
+			"getDIDDocumentURL":      getDIDDocumentURL,
+		}
+		for name, urlFunc := range urlFuncs {
+			got, err := urlFunc(issuer)
+			if err != nil {
+				continue
+			}
+			if _, err := url.Parse(got); err != nil {
+				t.Errorf("%s(%q) = %q, which isn't a valid url: %v", name, issuer, got, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	defaultMetadataHost  = "metadata.google.internal"
+	idTokenExpiryLeeway  = time.Minute
+	serviceAccountJWTTTL = time.Hour
+)
+
+// IDTokenSource returns a Google-signed identity token for the audience
+type IDTokenSource func(ctx context.Context, audience string) (string, error)
+
+// IDTokenTransport attaches a Google-signed identity token to requests, e.g. for JWKs endpoints protected by IAP or Cloud Run authentication
+type IDTokenTransport struct {
+	// Audience is the token audience: the Cloud Run service URL or the IAP OAuth client id
+	Audience string
+	// TokenSource issues the tokens. MetadataIDTokenSource is used if nil
+	TokenSource IDTokenSource
+	// Base is the transport that sends the request. http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenExchangeKey marks the context of identity token exchanges, which IDTokenTransport sends unchanged
+type tokenExchangeKey struct{}
+
+// RoundTrip sends a copy of the request with the identity token in Authorization header. The token is reused until it's about to expire
+func (t *IDTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// the exchange of the token this transport waits for may be sent with the same client
+	if req.Context().Value(tokenExchangeKey{}) != nil {
+		return base.RoundTrip(req)
+	}
+
+	token, err := t.getToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	authorizedReq := req.Clone(req.Context())
+	authorizedReq.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(authorizedReq)
+}
+
+func (t *IDTokenTransport) getToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Now().Add(idTokenExpiryLeeway).Before(t.expiry) {
+		return t.token, nil
+	}
+
+	tokenSource := t.TokenSource
+	if tokenSource == nil {
+		tokenSource = MetadataIDTokenSource
+	}
+	token, err := tokenSource(ctx, t.Audience)
+	if err != nil {
+		return "", fmt.Errorf("Error while getting identity token: %v", err)
+	}
+
+	var claims jwt.StandardClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err != nil {
+		return "", fmt.Errorf("Error while parsing identity token: %v", err)
+	}
+	t.token = token
+	t.expiry = time.Unix(claims.ExpiresAt, 0)
+	return token, nil
+}
+
+// MetadataIDTokenSource fetches identity token of the default service account from the metadata server. Metadata host may be overridden with GCE_METADATA_HOST environment variable
+func MetadataIDTokenSource(ctx context.Context, audience string) (string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	identityURL := fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/default/identity?audience=%s&format=full",
+		host, url.QueryEscape(audience))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, identityURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := withPolicyTransport(http.DefaultClient).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server responded with status code %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+type serviceAccountCredentials struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// ServiceAccountIDTokenSource returns token source that exchanges a JWT signed with the service account key (from the JSON key file content) for an identity token
+func ServiceAccountIDTokenSource(credentialsJSON []byte) (IDTokenSource, error) {
+	var credentials serviceAccountCredentials
+	if err := json.Unmarshal(credentialsJSON, &credentials); err != nil {
+		return nil, fmt.Errorf("Error while parsing service account credentials: %v", err)
+	}
+	if credentials.ClientEmail == "" || credentials.TokenURI == "" {
+		return nil, fmt.Errorf("Service account credentials must have client_email and token_uri")
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(credentials.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing service account private key: %v", err)
+	}
+
+	return func(ctx context.Context, audience string) (string, error) {
+		return exchangeServiceAccountJWT(ctx, credentials, privateKey, audience)
+	}, nil
+}
+
+func exchangeServiceAccountJWT(ctx context.Context, credentials serviceAccountCredentials, privateKey *rsa.PrivateKey, audience string) (string, error) {
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":             credentials.ClientEmail,
+		"sub":             credentials.ClientEmail,
+		"aud":             credentials.TokenURI,
+		"target_audience": audience,
+		"iat":             now.Unix(),
+		"exp":             now.Add(serviceAccountJWTTTL).Unix(),
+	})
+	assertion.Header["kid"] = credentials.PrivateKeyID
+	signedAssertion, err := assertion.SignedString(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signedAssertion},
+	}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, tokenExchangeKey{}, true), http.MethodPost, credentials.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := withPolicyTransport(fetcherFrom(ctx).httpClient()).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded with status code %d", resp.StatusCode)
+	}
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if tokenResponse.IDToken == "" {
+		return "", fmt.Errorf("token endpoint response doesn't have id_token")
+	}
+	return tokenResponse.IDToken, nil
+}
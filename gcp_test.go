@@ -0,0 +1,175 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestMetadataIDTokenSource(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/identity" &&
+			r.URL.Query().Get("audience") == "https://jwks.example.com" {
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "identity-token")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	os.Setenv("GCE_METADATA_HOST", httptestServerURL)
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	got, err := MetadataIDTokenSource(context.Background(), "https://jwks.example.com")
+	if err != nil {
+		t.Fatalf("MetadataIDTokenSource() error = %v", err)
+	}
+	if got != "identity-token" {
+		t.Errorf("MetadataIDTokenSource() = %v, want %v", got, "identity-token")
+	}
+}
+
+func TestIDTokenTransport(t *testing.T) {
+	privateKey, _ := newTestKeySet(t, "id-token-key")
+	identityToken := signTestToken(t, privateKey, "id-token-key", jwt.StandardClaims{
+		Audience:  "https://jwks.example.com",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+identityToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tokenSourceCalls := 0
+	client := &http.Client{
+		Transport: &IDTokenTransport{
+			Audience: "https://jwks.example.com",
+			TokenSource: func(ctx context.Context, audience string) (string, error) {
+				tokenSourceCalls++
+				return identityToken, nil
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(fmt.Sprintf("http://%s/jwks", httptestServerURL))
+		if err != nil {
+			t.Fatalf("IDTokenTransport.RoundTrip() error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("IDTokenTransport.RoundTrip() status = %v, want %v", resp.StatusCode, http.StatusOK)
+		}
+	}
+	if tokenSourceCalls != 1 {
+		t.Errorf("IDTokenTransport token source calls = %v, want 1", tokenSourceCalls)
+	}
+}
+
+func TestServiceAccountIDTokenSource(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	tokenURI := fmt.Sprintf("http://%s/token", httptestServerURL)
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("failed to sign identity token: %v", err)
+	}
+
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			if r.Header.Get("Authorization") != "Bearer "+idToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(r.FormValue("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil || claims["target_audience"] != "https://jwks.example.com" || claims["aud"] != tokenURI {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"id_token": %q}`, idToken)
+	}))
+	defer server.Close()
+
+	credentialsJSON, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "jwks-reader@project.iam.gserviceaccount.com",
+		"private_key_id": "service-account-key",
+		"private_key":    string(privateKeyPEM),
+		"token_uri":      tokenURI,
+	})
+	tokenSource, err := ServiceAccountIDTokenSource(credentialsJSON)
+	if err != nil {
+		t.Fatalf("ServiceAccountIDTokenSource() error = %v", err)
+	}
+
+	got, err := tokenSource(context.Background(), "https://jwks.example.com")
+	if err != nil {
+		t.Fatalf("ServiceAccountIDTokenSource() token source error = %v", err)
+	}
+	if got != idToken {
+		t.Errorf("ServiceAccountIDTokenSource() token source = %v, want %v", got, idToken)
+	}
+
+	// the exchange is sent with the fetcher's client, whose IDTokenTransport passes it to its base transport instead of waiting for the exchanged token
+	var requests int32
+	f := newFetcher()
+	f.setOptions([]Option{WithHTTPClient(&http.Client{Transport: &IDTokenTransport{
+		Audience:    "https://jwks.example.com",
+		TokenSource: tokenSource,
+		Base:        countingTransport{requests: &requests},
+	}})})
+	done := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(withFetcher(context.Background(), f), http.MethodGet, fmt.Sprintf("http://%s/jwks", httptestServerURL), nil)
+		resp, err := f.httpClient().Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			}
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("IDTokenTransport with ServiceAccountIDTokenSource error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("IDTokenTransport with ServiceAccountIDTokenSource didn't return")
+	}
+	if requests != 2 {
+		t.Errorf("IDTokenTransport sent %d requests with its base transport, want 2", requests)
+	}
+}
@@ -5,7 +5,9 @@ go 1.12
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/lestrrat-go/jwx v0.9.0
+)
+
+require (
 	github.com/pkg/errors v0.8.1 // indirect
-	github.com/robfig/cron v1.2.0
 	github.com/stretchr/testify v1.3.0 // indirect
 )
@@ -0,0 +1,104 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrHeaderNotAllowed is returned for tokens whose header is rejected by the HeaderPolicy
+var ErrHeaderNotAllowed = errors.New("Token header is not allowed")
+
+// HeaderPolicy rejects tokens by their header before their keys are resolved, so malformed and hostile tokens are rejected
+// without fetching anything. The zero policy accepts any header
+type HeaderPolicy struct {
+	// Strict rejects tokens with a crit that isn't a list of CriticalParameters present in the header, as RFC 7515 requires,
+	// tokens with a cty that isn't one of ContentTypes, and tokens whose typ, cty or kid isn't a string
+	Strict bool `json:"strict"`
+	// CriticalParameters are the extension header parameters the application processes and therefore accepts in crit
+	CriticalParameters []string `json:"critical_parameters"`
+	// ContentTypes are the accepted cty headers, compared like the provider's TokenTypes. Empty rejects any cty,
+	// e.g. of nested tokens whose inner token wouldn't be verified
+	ContentTypes []string `json:"content_types"`
+	// MaxParameters bounds the number of header parameters, also when not Strict. Zero means no bound
+	MaxParameters int `json:"max_parameters"`
+}
+
+// registeredHeaderParameters are the header parameters of RFC 7515, which must not be listed in crit
+var registeredHeaderParameters = map[string]bool{
+	"alg": true, "jku": true, "jwk": true, "kid": true, "x5u": true, "x5c": true, "x5t": true, "x5t#S256": true, "typ": true, "cty": true, "crit": true,
+}
+
+// SetHeaderPolicy is Fetcher.SetHeaderPolicy of the default fetcher
+func SetHeaderPolicy(policy HeaderPolicy) {
+	defaultFetcher.SetHeaderPolicy(policy)
+}
+
+// SetHeaderPolicy sets the policy the headers of all tokens verified by the fetcher must follow. Tokens the policy rejects fail with ErrHeaderNotAllowed
+func (f *Fetcher) SetHeaderPolicy(policy HeaderPolicy) {
+	f.limitsMu.Lock()
+	defer f.limitsMu.Unlock()
+	f.headerPolicy = policy
+}
+
+func (f *Fetcher) currentHeaderPolicy() HeaderPolicy {
+	f.limitsMu.RLock()
+	defer f.limitsMu.RUnlock()
+	return f.headerPolicy
+}
+
+// checkHeader returns an ErrHeaderNotAllowed for tokens whose header is rejected by the HeaderPolicy
+func (f *Fetcher) checkHeader(token *jwt.Token) error {
+	return checkHeaderParameters(token.Header, f.currentHeaderPolicy())
+}
+
+func checkHeaderParameters(header map[string]interface{}, policy HeaderPolicy) error {
+	if policy.MaxParameters > 0 && len(header) > policy.MaxParameters {
+		return fmt.Errorf("%w: %d parameters exceed %d", ErrHeaderNotAllowed, len(header), policy.MaxParameters)
+	}
+	if !policy.Strict {
+		return nil
+	}
+	for _, name := range []string{"typ", "cty", "kid"} {
+		if value, ok := header[name]; ok {
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("%w: %s isn't a string", ErrHeaderNotAllowed, name)
+			}
+		}
+	}
+	if cty, ok := header["cty"].(string); ok && !containsMediaType(policy.ContentTypes, cty) {
+		return fmt.Errorf("%w: cty %q", ErrHeaderNotAllowed, cty)
+	}
+	if crit, ok := header["crit"]; ok {
+		return checkCritical(header, crit, policy.CriticalParameters)
+	}
+	return nil
+}
+
+// checkCritical checks that crit is a non-empty list of understood extension parameters, each present in the header
+func checkCritical(header map[string]interface{}, crit interface{}, understood []string) error {
+	names, ok := crit.([]interface{})
+	if !ok || len(names) == 0 {
+		return fmt.Errorf("%w: crit isn't a non-empty list", ErrHeaderNotAllowed)
+	}
+	for _, value := range names {
+		name, ok := value.(string)
+		isUnderstood := false
+		for _, parameter := range understood {
+			isUnderstood = isUnderstood || parameter == name
+		}
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: crit isn't a list of strings", ErrHeaderNotAllowed)
+		case registeredHeaderParameters[name]:
+			return fmt.Errorf("%w: crit lists registered parameter %q", ErrHeaderNotAllowed, name)
+		case !isUnderstood:
+			return fmt.Errorf("%w: crit parameter %q isn't understood", ErrHeaderNotAllowed, name)
+		}
+		if _, ok := header[name]; !ok {
+			return fmt.Errorf("%w: crit parameter %q is missing", ErrHeaderNotAllowed, name)
+		}
+	}
+	return nil
+}
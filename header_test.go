@@ -0,0 +1,60 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	var requests int32
+	f := newFetcher()
+	defer f.Close()
+	f.setOptions([]Option{WithHTTPClient(&http.Client{Transport: countingTransport{requests: &requests}})})
+
+	strict := HeaderPolicy{Strict: true, CriticalParameters: []string{"exp"}, ContentTypes: []string{"JWT"}}
+	tests := []struct {
+		name     string
+		policy   HeaderPolicy
+		header   map[string]interface{}
+		rejected bool
+	}{
+		{name: "Zero policy", header: map[string]interface{}{"cty": "JWT", "crit": []interface{}{"b64"}, "kid": "key"}, rejected: false},
+		{name: "Plain header", policy: strict, header: map[string]interface{}{"typ": "JWT", "kid": "key"}, rejected: false},
+		{name: "Understood crit", policy: strict, header: map[string]interface{}{"kid": "key", "exp": 1, "crit": []interface{}{"exp"}}, rejected: false},
+		{name: "Unknown crit", policy: strict, header: map[string]interface{}{"kid": "key", "b64": false, "crit": []interface{}{"b64"}}, rejected: true},
+		{name: "Crit parameter missing", policy: strict, header: map[string]interface{}{"kid": "key", "crit": []interface{}{"exp"}}, rejected: true},
+		{name: "Crit of registered parameter", policy: strict, header: map[string]interface{}{"kid": "key", "crit": []interface{}{"kid"}}, rejected: true},
+		{name: "Empty crit", policy: strict, header: map[string]interface{}{"kid": "key", "crit": []interface{}{}}, rejected: true},
+		{name: "Crit isn't a list", policy: strict, header: map[string]interface{}{"kid": "key", "crit": "exp"}, rejected: true},
+		{name: "Accepted cty", policy: strict, header: map[string]interface{}{"kid": "key", "cty": "application/jwt"}, rejected: false},
+		{name: "Unexpected cty", policy: strict, header: map[string]interface{}{"kid": "key", "cty": "json"}, rejected: true},
+		{name: "Cty without content types", policy: HeaderPolicy{Strict: true}, header: map[string]interface{}{"kid": "key", "cty": "JWT"}, rejected: true},
+		{name: "Typ isn't a string", policy: strict, header: map[string]interface{}{"kid": "key", "typ": []interface{}{"JWT"}}, rejected: true},
+		{name: "Kid isn't a string", policy: strict, header: map[string]interface{}{"kid": 1}, rejected: true},
+		{name: "Too many parameters", policy: HeaderPolicy{MaxParameters: 2}, header: map[string]interface{}{"kid": "key", "typ": "JWT", "x": 1}, rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.SetHeaderPolicy(tt.policy)
+			defer f.SetHeaderPolicy(HeaderPolicy{})
+			atomic.StoreInt32(&requests, 0)
+
+			token := &jwt.Token{Header: tt.header, Claims: jwt.MapClaims{"iss": server.URL}}
+			_, err := f.ResolveKey(context.Background(), token)
+			if got := errors.Is(err, ErrHeaderNotAllowed); got != tt.rejected {
+				t.Fatalf("ResolveKey() error = %v, want rejected %v", err, tt.rejected)
+			}
+			if tt.rejected && atomic.LoadInt32(&requests) != 0 {
+				t.Errorf("ResolveKey() sent %d requests for a rejected header", requests)
+			}
+		})
+	}
+}
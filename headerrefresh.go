@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"time"
+)
+
+const defaultHeaderRefreshMinInterval = time.Minute
+
+// HeaderRefresh schedules a provider's refresh by the caching headers of its key set response, refreshing the keys when the
+// Cache-Control max-age, or else the Expires, of the response lapses, as providers like Google and Auth0 signal their rotation cadence
+type HeaderRefresh struct {
+	// DefaultInterval is the refresh interval when the response has no caching headers, e.g. inline or failed key sets.
+	// Zero is the provider's RefreshInterval, or the interval of the global refresh when it has none
+	DefaultInterval time.Duration
+	// MinInterval bounds the interval of short lifetimes and no-store or no-cache responses. Zero is one minute
+	MinInterval time.Duration
+	// MaxInterval bounds the interval of long lifetimes. Zero is the interval of the global refresh, 24 hours unless changed with WithRefreshInterval
+	MaxInterval time.Duration
+}
+
+// WithHeaderRefresh is the HeaderRefresh of providers without their own
+func WithHeaderRefresh(headerRefresh HeaderRefresh) Option {
+	return func(options *fetcherOptions) {
+		options.headerRefresh = &headerRefresh
+	}
+}
+
+// headerRefreshInterval is the refresh interval of the provider with HeaderRefresh by the caching headers of the cached entry
+func (f *Fetcher) headerRefreshInterval(jwkProvider JWKProvider, entry *keySetEntry) time.Duration {
+	headerRefresh := jwkProvider.HeaderRefresh
+	globalInterval := f.currentOptions().refreshInterval
+	interval := headerRefresh.DefaultInterval
+	if interval <= 0 {
+		interval = jwkProvider.RefreshInterval
+	}
+	if interval <= 0 {
+		interval = globalInterval
+	}
+	if entry != nil {
+		if ttl, ok := headerTTL(entry.header); ok {
+			interval = ttl
+		}
+	}
+
+	minInterval := headerRefresh.MinInterval
+	if minInterval <= 0 {
+		minInterval = defaultHeaderRefreshMinInterval
+	}
+	maxInterval := headerRefresh.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = globalInterval
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	if interval < minInterval {
+		interval = minInterval
+	}
+	return interval
+}
+
+// rescheduleHeaderRefresh reschedules the refresh of a provider with HeaderRefresh by the caching headers of its cached key set
+func (f *Fetcher) rescheduleHeaderRefresh(jwkProvider JWKProvider) {
+	if f.withDefaults(jwkProvider).HeaderRefresh == nil {
+		return
+	}
+	interval := f.providerRefreshInterval(jwkProvider)
+	if interval != f.scheduledRefreshInterval(jwkProvider) {
+		f.scheduleProviderEvery(jwkProvider, interval)
+	}
+}
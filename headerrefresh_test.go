@@ -0,0 +1,104 @@
+package jwkfetch
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeaderRefresh(t *testing.T) {
+	_, keySet := newTestKeySet(t, "header-refresh-key")
+	var mu sync.Mutex
+	var cacheControl string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{
+		Issuer:        server.URL,
+		JWKURL:        server.URL + "/jwks",
+		HeaderRefresh: &HeaderRefresh{DefaultInterval: time.Hour, MaxInterval: 12 * time.Hour},
+	}
+	f, err := New([]JWKProvider{jwkProvider})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+	if got := f.scheduledRefreshInterval(jwkProvider); got != time.Hour {
+		t.Errorf("Scheduled refresh interval = %v, want %v", got, time.Hour)
+	}
+
+	tests := []struct {
+		name         string
+		cacheControl string
+		status       int
+		wantInterval time.Duration
+	}{
+		{name: "Max age", cacheControl: "public, max-age=7200", wantInterval: 2 * time.Hour},
+		{name: "Changed max age", cacheControl: "public, max-age=1800", wantInterval: 30 * time.Minute},
+		{name: "Failures don't change the interval", status: http.StatusInternalServerError, wantInterval: 30 * time.Minute},
+		{name: "No caching headers", wantInterval: time.Hour},
+		{name: "Bounded by MaxInterval", cacheControl: "max-age=604800", wantInterval: 12 * time.Hour},
+		{name: "No store bounded by MinInterval", cacheControl: "no-store", wantInterval: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			cacheControl, status = tt.cacheControl, tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			mu.Unlock()
+			f.refreshProvider(jwkProvider)
+			if got := f.scheduledRefreshInterval(jwkProvider); got != tt.wantInterval {
+				t.Errorf("Scheduled refresh interval = %v, want %v", got, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestWithHeaderRefresh(t *testing.T) {
+	_, keySet := newTestKeySet(t, "header-refresh-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=600")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	withHeaders := JWKProvider{Issuer: server.URL, JWKURL: server.URL + "/jwks"}
+	inline := JWKProvider{Issuer: server.URL + "/inline", InlineJWKS: []byte(keySet)}
+	f, err := New([]JWKProvider{withHeaders, inline}, WithHeaderRefresh(HeaderRefresh{DefaultInterval: 3 * time.Hour}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name         string
+		jwkProvider  JWKProvider
+		wantInterval time.Duration
+	}{
+		{name: "Max age", jwkProvider: withHeaders, wantInterval: 10 * time.Minute},
+		{name: "No response headers", jwkProvider: inline, wantInterval: 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.scheduledRefreshInterval(tt.jwkProvider); got != tt.wantInterval {
+				t.Errorf("Scheduled refresh interval = %v, want %v", got, tt.wantInterval)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package jwkfetch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ProviderHealth describes whether the keys of a provider can be relied on
+type ProviderHealth struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer  string `json:"issuer"`
+	Healthy bool   `json:"healthy"`
+	// Reason explains why the provider is unhealthy, empty when it is healthy
+	Reason string `json:"reason,omitempty"`
+	// RefreshFailures counts the consecutive failures of the provider's scheduled refresh
+	RefreshFailures int  `json:"refresh_failures"`
+	Degraded        bool `json:"degraded"`
+}
+
+// Health is Fetcher.Health of the default fetcher
+func Health() []ProviderHealth {
+	return defaultFetcher.Health()
+}
+
+// Health returns the health of the registered providers. A provider is unhealthy when its keys aren't cached, when its cached keys
+// exceed its MaxStale or while it is degraded by a RefreshEscalation with MarkUnhealthy
+func (f *Fetcher) Health() []ProviderHealth {
+	providers := f.Providers()
+	health := make([]ProviderHealth, 0, len(providers))
+	for _, jwkProvider := range providers {
+		providerHealth := ProviderHealth{Issuer: providerKey(jwkProvider)}
+		providerHealth.RefreshFailures, providerHealth.Degraded = f.refreshDegraded(jwkProvider)
+		entry := f.cachedProviderEntry(jwkProvider)
+		switch {
+		case entry == nil:
+			providerHealth.Reason = "keys aren't cached"
+		case jwkProvider.MaxStale > 0 && elapsedSince(entry.fetchedAt) > jwkProvider.MaxStale:
+			providerHealth.Reason = fmt.Sprintf("keys are older than MaxStale %v", jwkProvider.MaxStale)
+		case providerHealth.Degraded && jwkProvider.RefreshEscalation.MarkUnhealthy:
+			providerHealth.Reason = fmt.Sprintf("refresh failed %d times in a row", providerHealth.RefreshFailures)
+		default:
+			providerHealth.Healthy = true
+		}
+		health = append(health, providerHealth)
+	}
+	return health
+}
+
+// HealthHandler is Fetcher.HealthHandler of the default fetcher
+func HealthHandler() http.Handler {
+	return defaultFetcher.HealthHandler()
+}
+
+// HealthHandler serves the Health of the providers as JSON, with status 503 when any of them is unhealthy, e.g. for readiness probes
+func (f *Fetcher) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := f.Health()
+		status := http.StatusOK
+		for _, providerHealth := range health {
+			if !providerHealth.Healthy {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(health)
+	})
+}
@@ -0,0 +1,65 @@
+package jwkfetch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestHealth(t *testing.T) {
+	keySet, _ := jwk.ParseString(jwkResponse)
+	escalation := &RefreshEscalation{FailureThreshold: 2, MarkUnhealthy: true}
+	healthy := JWKProvider{JWKURL: "https://healthy.example.com/jwks"}
+	uncached := JWKProvider{JWKURL: "https://uncached.example.com/jwks"}
+	stale := JWKProvider{JWKURL: "https://stale.example.com/jwks", MaxStale: time.Hour}
+	degraded := JWKProvider{JWKURL: "https://degraded.example.com/jwks", RefreshEscalation: escalation}
+	reported := JWKProvider{JWKURL: "https://reported.example.com/jwks", RefreshEscalation: &RefreshEscalation{FailureThreshold: 2}}
+
+	f := newFetcher()
+	f.setProviders([]JWKProvider{healthy, uncached, stale, degraded, reported})
+	for _, jwkProvider := range []JWKProvider{healthy, degraded, reported} {
+		f.jwksCache.set(jwkProvider.JWKURL, &keySetEntry{keySet: keySet, fetchedAt: time.Now()})
+		f.recordRefreshResult(jwkProvider.JWKURL, http.ErrHandlerTimeout)
+		f.recordRefreshResult(jwkProvider.JWKURL, http.ErrHandlerTimeout)
+	}
+	f.jwksCache.set(stale.JWKURL, &keySetEntry{keySet: keySet, fetchedAt: time.Now().Add(-2 * time.Hour)})
+
+	want := []ProviderHealth{
+		{Issuer: healthy.JWKURL, Healthy: true, RefreshFailures: 2},
+		{Issuer: uncached.JWKURL, Reason: "keys aren't cached"},
+		{Issuer: stale.JWKURL, Reason: "keys are older than MaxStale 1h0m0s"},
+		{Issuer: degraded.JWKURL, Reason: "refresh failed 2 times in a row", RefreshFailures: 2, Degraded: true},
+		{Issuer: reported.JWKURL, Healthy: true, RefreshFailures: 2, Degraded: true},
+	}
+	if got := f.Health(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Health() = %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		name       string
+		providers  []JWKProvider
+		wantStatus int
+	}{
+		{name: "Healthy providers", providers: []JWKProvider{healthy, reported}, wantStatus: http.StatusOK},
+		{name: "Unhealthy provider", providers: []JWKProvider{healthy, degraded}, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.setProviders(tt.providers)
+			recorder := httptest.NewRecorder()
+			f.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("HealthHandler() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			var got []ProviderHealth
+			if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil || len(got) != len(tt.providers) {
+				t.Errorf("HealthHandler() body = %+v, error = %v", got, err)
+			}
+		})
+	}
+}
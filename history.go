@@ -0,0 +1,222 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrSnapshotNotFound is returned by VerifyAt when no snapshot was retained at or before the time
+var ErrSnapshotNotFound = errors.New("No snapshot was retained before the time")
+
+var errSnapshotHistoryDisabled = errors.New("Snapshot history is not enabled")
+
+const historySnapshotLayout = "20060102T150405.000000000Z"
+
+const historySnapshotExt = ".snapshot"
+
+// historySnapshotName is the name of the snapshot saved at the time in the history directory, sorting in the order of the times
+func historySnapshotName(savedAt time.Time) string {
+	return savedAt.UTC().Format(historySnapshotLayout) + historySnapshotExt
+}
+
+// historySnapshotTime is the time a snapshot of the history directory was saved. The second value is false for other files
+func historySnapshotTime(name string) (time.Time, bool) {
+	if !strings.HasSuffix(name, historySnapshotExt) {
+		return time.Time{}, false
+	}
+	savedAt, err := time.Parse(historySnapshotLayout, strings.TrimSuffix(name, historySnapshotExt))
+	return savedAt, err == nil
+}
+
+// historySnapshot is a snapshot file of the history directory
+type historySnapshot struct {
+	path    string
+	savedAt time.Time
+	size    int64
+}
+
+// listHistorySnapshots returns the snapshots of the history directory, oldest first. Other files are ignored
+func listHistorySnapshots(dir string) ([]historySnapshot, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []historySnapshot
+	for _, file := range files {
+		savedAt, ok := historySnapshotTime(file.Name())
+		if !ok || !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// removed since listed, e.g. by a concurrent pruning
+			continue
+		}
+		snapshots = append(snapshots, historySnapshot{path: filepath.Join(dir, file.Name()), savedAt: savedAt, size: info.Size()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].savedAt.Before(snapshots[j].savedAt) })
+	return snapshots, nil
+}
+
+// findHistorySnapshot returns the path of the latest snapshot of the history directory saved at or before the time
+func findHistorySnapshot(dir string, at time.Time) (string, error) {
+	snapshots, err := listHistorySnapshots(dir)
+	if err != nil {
+		return "", err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].savedAt.After(at) {
+			return snapshots[i].path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrSnapshotNotFound, at.Format(time.RFC3339))
+}
+
+// pruneSnapshotHistory removes the oldest snapshots of the history directory beyond the retention of the persistence.
+// The latest snapshot is always kept
+func pruneSnapshotHistory(p CachePersistence) error {
+	if p.HistoryMaxCount <= 0 && p.HistoryMaxAge <= 0 && p.HistoryMaxBytes <= 0 {
+		return nil
+	}
+	snapshots, err := listHistorySnapshots(p.HistoryDir)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, snapshot := range snapshots {
+		size += snapshot.size
+	}
+
+	now := clockNow()
+	var errs []error
+	for i := 0; i < len(snapshots)-1; i++ {
+		snapshot, remaining := snapshots[i], len(snapshots)-i
+		expired := p.HistoryMaxAge > 0 && now.Sub(snapshot.savedAt) > p.HistoryMaxAge
+		tooMany := p.HistoryMaxCount > 0 && remaining > p.HistoryMaxCount
+		tooLarge := p.HistoryMaxBytes > 0 && size > p.HistoryMaxBytes
+		if !expired && !tooMany && !tooLarge {
+			break
+		}
+		if err := os.Remove(snapshot.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		size -= snapshot.size
+	}
+	return errors.Join(errs...)
+}
+
+// HistoryStats is the disk usage of the snapshot history
+type HistoryStats struct {
+	// Snapshots is the number of retained snapshots
+	Snapshots int
+	// Bytes is the size of the retained snapshots
+	Bytes int64
+	// Oldest and Newest are the times the oldest and the newest retained snapshots were saved. Zero without snapshots
+	Oldest time.Time
+	Newest time.Time
+}
+
+// SnapshotHistoryStats is Fetcher.SnapshotHistoryStats of the default fetcher
+func SnapshotHistoryStats() (HistoryStats, error) {
+	return defaultFetcher.SnapshotHistoryStats()
+}
+
+// SnapshotHistoryStats reports the snapshots retained in the HistoryDir of the fetcher's SetCachePersistence
+func (f *Fetcher) SnapshotHistoryStats() (HistoryStats, error) {
+	p := f.currentPersistence()
+	if p.HistoryDir == "" {
+		return HistoryStats{}, errSnapshotHistoryDisabled
+	}
+	snapshots, err := listHistorySnapshots(p.HistoryDir)
+	if err != nil {
+		return HistoryStats{}, err
+	}
+	var stats HistoryStats
+	for _, snapshot := range snapshots {
+		stats.Snapshots++
+		stats.Bytes += snapshot.size
+	}
+	if len(snapshots) > 0 {
+		stats.Oldest, stats.Newest = snapshots[0].savedAt, snapshots[len(snapshots)-1].savedAt
+	}
+	return stats, nil
+}
+
+// VerifyAt is Fetcher.VerifyAt of the default fetcher
+func VerifyAt(ctx context.Context, tokenString string, at time.Time) (*jwt.Token, error) {
+	return defaultFetcher.VerifyAt(ctx, tokenString, at)
+}
+
+// VerifyAt verifies the token the way ParseAndVerify would have at the time, with the keys of the latest snapshot retained in the HistoryDir
+// of the fetcher's SetCachePersistence at or before it, e.g. to re-validate archived audit events long after their keys were rotated.
+// The exp, nbf and iat claims are checked at the time and no keys are fetched: keys missing from the snapshot fail with ErrKeyNotFound
+func (f *Fetcher) VerifyAt(ctx context.Context, tokenString string, at time.Time) (*jwt.Token, error) {
+	p := f.currentPersistence()
+	if p.HistoryDir == "" {
+		return nil, errSnapshotHistoryDisabled
+	}
+
+	path, err := findHistorySnapshot(p.HistoryDir, at)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readSnapshot(ctx, file, p.Encryption)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading snapshot %s: %w", path, err)
+	}
+	entries, err := f.parseSnapshotEntries(s)
+	if err != nil {
+		return nil, err
+	}
+	issuerEntries := make(map[string]*keySetEntry)
+	for i, saved := range s.Entries {
+		if saved.Cache == "issuer" {
+			issuerEntries[saved.Key] = entries[i]
+		}
+	}
+
+	return f.verifyToken(ctx, tokenString, func(token *jwt.Token) (interface{}, error) {
+		return snapshotKey(token, issuerEntries)
+	}, verifyOptions{at: at})
+}
+
+// snapshotKey looks up the token key in the snapshot entries of its issuer, with the algorithms and token types in effect then
+func snapshotKey(token *jwt.Token, issuerEntries map[string]*keySetEntry) (interface{}, error) {
+	issuer, err := getIssuer(token)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := getKeyID(token)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := issuerEntries[issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: the snapshot has no keys of issuer %s", ErrKeyNotFound, issuer)
+	}
+	if err := checkAlgorithm(token, entry.algorithms); err != nil {
+		return nil, err
+	}
+	if err := checkTokenType(token, entry.tokenTypes); err != nil {
+		return nil, err
+	}
+	key, err := entry.lookupKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return key.Materialize()
+}
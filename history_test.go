@@ -0,0 +1,136 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyAt(t *testing.T) {
+	const issuer = "https://history.example.com"
+	defer InvalidateAll()
+	defer SetCachePersistence(context.Background(), CachePersistence{})
+	defer func() { clockNow = time.Now }()
+	dir := t.TempDir()
+	encryption := AEADEncryption(testAEAD(t, 5))
+	if _, err := VerifyAt(context.Background(), "", time.Now()); err == nil {
+		t.Errorf("VerifyAt() without snapshot history error = nil, want error")
+	}
+	if err := SetCachePersistence(context.Background(), CachePersistence{Path: filepath.Join(dir, "keys.snapshot"), HistoryDir: filepath.Join(dir, "history"), Encryption: encryption}); err != nil {
+		t.Fatalf("SetCachePersistence() error = %v", err)
+	}
+
+	persistKeys := func(offset time.Duration, keySetJSON string) {
+		keySet, _, err := defaultFetcher.parseKeySet(strings.NewReader(keySetJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clockNow = fakeClock(offset)
+		defaultFetcher.issuerCache.set(issuer, &keySetEntry{keySet: keySet, index: newKeyIndex(keySet)})
+		if err := PersistCache(context.Background()); err != nil {
+			t.Fatalf("PersistCache() error = %v", err)
+		}
+		clockNow = time.Now
+	}
+	oldKey, oldKeySet := newTestKeySet(t, "old-key")
+	newKey, newKeySet := newTestKeySet(t, "new-key")
+	persistKeys(-48*time.Hour, oldKeySet)
+	persistKeys(-2*time.Hour, newKeySet)
+	InvalidateAll()
+
+	now := time.Now()
+	oldToken := signTestToken(t, oldKey, "old-key", jwt.MapClaims{"iss": issuer, "iat": now.Add(-47 * time.Hour).Unix(), "exp": now.Add(-46 * time.Hour).Unix()})
+	newToken := signTestToken(t, newKey, "new-key", jwt.MapClaims{"iss": issuer, "iat": now.Add(-time.Hour).Unix(), "exp": now.Add(-30 * time.Minute).Unix()})
+	otherIssuer := signTestToken(t, newKey, "new-key", jwt.MapClaims{"iss": "https://other.example.com"})
+	tests := []struct {
+		name        string
+		token       string
+		at          time.Time
+		wantErr     error
+		wantInvalid bool
+	}{
+		{name: "Key of the snapshot before the time", token: oldToken, at: now.Add(-47 * time.Hour)},
+		{name: "Rotated key", token: newToken, at: now.Add(-45 * time.Minute)},
+		{name: "Key rotated out since", token: oldToken, at: now.Add(-90 * time.Minute), wantErr: ErrKeyNotFound},
+		{name: "Expired at the time", token: newToken, at: now, wantInvalid: true},
+		{name: "Before any snapshot", token: oldToken, at: now.Add(-72 * time.Hour), wantErr: ErrSnapshotNotFound},
+		{name: "Issuer missing from the snapshot", token: otherIssuer, at: now, wantErr: ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAt(context.Background(), tt.token, tt.at)
+			var validationErr *jwt.ValidationError
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("VerifyAt() error = %v, want %v", err, tt.wantErr)
+			case tt.wantInvalid && !errors.As(err, &validationErr):
+				t.Errorf("VerifyAt() error = %v, want a validation error", err)
+			case tt.wantErr == nil && !tt.wantInvalid && err != nil:
+				t.Errorf("VerifyAt() error = %v", err)
+			}
+		})
+	}
+	if entry := defaultFetcher.issuerCache.get(issuer); entry != nil {
+		t.Errorf("VerifyAt() cached the snapshot keys")
+	}
+}
+
+func TestSnapshotHistoryRetention(t *testing.T) {
+	offsets := []time.Duration{-3 * time.Hour, -2 * time.Hour, -time.Hour, 0}
+	tests := []struct {
+		name        string
+		persistence CachePersistence
+		wantKept    int
+	}{
+		{name: "Unbounded", wantKept: 4},
+		{name: "Max count", persistence: CachePersistence{HistoryMaxCount: 2}, wantKept: 2},
+		{name: "Max age", persistence: CachePersistence{HistoryMaxAge: 90 * time.Minute}, wantKept: 2},
+		{name: "Max bytes", persistence: CachePersistence{HistoryMaxBytes: 1}, wantKept: 1},
+		{name: "Tightest bound", persistence: CachePersistence{HistoryMaxCount: 3, HistoryMaxAge: 150 * time.Minute, HistoryMaxBytes: 1 << 30}, wantKept: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer InvalidateAll()
+			defer SetCachePersistence(context.Background(), CachePersistence{})
+			defer func() { clockNow = time.Now }()
+			dir := t.TempDir()
+			p := tt.persistence
+			p.Path, p.HistoryDir = filepath.Join(dir, "keys.snapshot"), filepath.Join(dir, "history")
+			if err := SetCachePersistence(context.Background(), p); err != nil {
+				t.Fatalf("SetCachePersistence() error = %v", err)
+			}
+			keySet, _, err := defaultFetcher.parseKeySet(strings.NewReader(jwkResponse))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defaultFetcher.issuerCache.set("https://example.com", &keySetEntry{keySet: keySet, index: newKeyIndex(keySet)})
+
+			for _, offset := range offsets {
+				clockNow = fakeClock(offset)
+				if err := PersistCache(context.Background()); err != nil {
+					t.Fatalf("PersistCache() error = %v", err)
+				}
+			}
+			clockNow = time.Now
+
+			stats, err := SnapshotHistoryStats()
+			if err != nil {
+				t.Fatalf("SnapshotHistoryStats() error = %v", err)
+			}
+			if stats.Snapshots != tt.wantKept || stats.Bytes <= 0 {
+				t.Errorf("SnapshotHistoryStats() = %+v, want %d snapshots", stats, tt.wantKept)
+			}
+			if age := time.Since(stats.Newest); age > time.Minute {
+				t.Errorf("SnapshotHistoryStats() newest snapshot is %v old, want the latest kept", age)
+			}
+			if age, want := time.Since(stats.Oldest), -offsets[len(offsets)-tt.wantKept]; age < want || age > want+time.Minute {
+				t.Errorf("SnapshotHistoryStats() oldest snapshot is %v old, want %v", age, want)
+			}
+		})
+	}
+}
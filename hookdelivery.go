@@ -0,0 +1,238 @@
+package jwkfetch
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultHookQueueSize = 100
+
+// HookDelivery configures how hooks are called. Whatever the delivery, every event is delivered at most once, to the hooks set when it was raised,
+// and panics of hooks are recovered and reported to OnPanic instead of crashing the fetch that raised the event
+type HookDelivery struct {
+	// Async calls hooks on background goroutines instead of the goroutine raising the event, so slow hooks don't delay key resolution.
+	// Events of the same provider are delivered in the order they were raised, one at a time
+	Async bool
+	// QueueSize bounds the events of a provider waiting for delivery when Async. Defaults to 100
+	QueueSize int
+	// Block makes events wait for room in a full queue, slowing down the fetches raising them, instead of being dropped
+	Block bool
+}
+
+// HookDeliveryStats counts the events passed to hooks
+type HookDeliveryStats struct {
+	Delivered uint64
+	// Dropped counts the events of full async queues
+	Dropped uint64
+	// Panics counts the hooks that panicked
+	Panics uint64
+}
+
+// hookState holds the hooks of a fetcher and delivers their events
+type hookState struct {
+	// stats is accessed atomically, first for its alignment
+	stats HookDeliveryStats
+
+	mu    sync.RWMutex
+	hooks Hooks
+
+	deliveryMu sync.Mutex
+	delivery   HookDelivery
+	queues     map[string]*hookQueue
+
+	pendingMu   sync.Mutex
+	pendingCond *sync.Cond
+	pending     int
+}
+
+// hookQueue delivers the async events of a provider in order until it is closed
+type hookQueue struct {
+	state  *hookState
+	mu     sync.RWMutex
+	closed bool
+	events chan func()
+}
+
+func newHookState() *hookState {
+	s := &hookState{queues: make(map[string]*hookQueue)}
+	s.pendingCond = sync.NewCond(&s.pendingMu)
+	return s
+}
+
+func (s *hookState) current() Hooks {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hooks
+}
+
+// SetHookDelivery is Fetcher.SetHookDelivery of the default fetcher
+func SetHookDelivery(delivery HookDelivery) {
+	defaultFetcher.SetHookDelivery(delivery)
+}
+
+// SetHookDelivery replaces how the fetcher's hooks are called. Events already queued are still delivered.
+// Hooks are called synchronously, on the goroutine raising the event, by default
+func (f *Fetcher) SetHookDelivery(delivery HookDelivery) {
+	if delivery.QueueSize <= 0 {
+		delivery.QueueSize = defaultHookQueueSize
+	}
+	s := f.hooks
+	s.deliveryMu.Lock()
+	defer s.deliveryMu.Unlock()
+	for _, queue := range s.queues {
+		queue.close()
+	}
+	s.queues = make(map[string]*hookQueue)
+	s.delivery = delivery
+}
+
+// HookStats is Fetcher.HookStats of the default fetcher
+func HookStats() HookDeliveryStats {
+	return defaultFetcher.HookStats()
+}
+
+// HookStats returns the counts of the events passed to the fetcher's hooks
+func (f *Fetcher) HookStats() HookDeliveryStats {
+	s := f.hooks
+	return HookDeliveryStats{
+		Delivered: atomic.LoadUint64(&s.stats.Delivered),
+		Dropped:   atomic.LoadUint64(&s.stats.Dropped),
+		Panics:    atomic.LoadUint64(&s.stats.Panics),
+	}
+}
+
+// FlushHooks is Fetcher.FlushHooks of the default fetcher
+func FlushHooks(ctx context.Context) error {
+	return defaultFetcher.FlushHooks(ctx)
+}
+
+// FlushHooks waits until the queued events of the fetcher are delivered, e.g. before shutting down
+func (f *Fetcher) FlushHooks(ctx context.Context) error {
+	s := f.hooks
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.pendingMu.Lock()
+			defer s.pendingMu.Unlock()
+			s.pendingCond.Broadcast()
+		case <-done:
+		}
+	}()
+
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for s.pending > 0 && ctx.Err() == nil {
+		s.pendingCond.Wait()
+	}
+	return ctx.Err()
+}
+
+// deliverHook calls the hook according to the hook delivery. Events with the same key, the provider's key, are delivered in order
+func (f *Fetcher) deliverHook(key string, call func()) {
+	s := f.hooks
+	s.dispatch(key, func() { s.call(call, false) })
+}
+
+// deliverPanicHook reports the panic to the OnPanic hook
+func (f *Fetcher) deliverPanicHook(event PanicEvent) {
+	s := f.hooks
+	if onPanic := s.current().OnPanic; onPanic != nil {
+		s.dispatch("", func() { s.call(func() { onPanic(event) }, true) })
+	}
+}
+
+func (s *hookState) dispatch(key string, call func()) {
+	s.deliveryMu.Lock()
+	delivery := s.delivery
+	if !delivery.Async {
+		s.deliveryMu.Unlock()
+		call()
+		return
+	}
+	queue := s.queueFor(key, delivery.QueueSize)
+	s.deliveryMu.Unlock()
+
+	queue.send(call, delivery.Block)
+}
+
+// queueFor returns the queue of the key, starting its worker. Keys beyond maxEndpoints share a queue. It must be called with deliveryMu held
+func (s *hookState) queueFor(key string, size int) *hookQueue {
+	if _, ok := s.queues[key]; !ok && len(s.queues) >= maxEndpoints {
+		key = ""
+	}
+	queue, ok := s.queues[key]
+	if !ok {
+		queue = &hookQueue{state: s, events: make(chan func(), size)}
+		s.queues[key] = queue
+		go queue.run()
+	}
+	return queue
+}
+
+func (q *hookQueue) run() {
+	for call := range q.events {
+		call()
+		q.state.addPending(-1)
+	}
+}
+
+// send queues the call, waiting for room when block, or drops it when the queue is full. Calls of a closed queue run synchronously
+func (q *hookQueue) send(call func(), block bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		call()
+		return
+	}
+	q.state.addPending(1)
+	if block {
+		q.events <- call
+		return
+	}
+	select {
+	case q.events <- call:
+	default:
+		atomic.AddUint64(&q.state.stats.Dropped, 1)
+		q.state.addPending(-1)
+	}
+}
+
+// close stops the queue once the senders blocked on it are done. The worker delivers the queued events before it exits
+func (q *hookQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	close(q.events)
+}
+
+// call calls the hook, recovering its panic. Panics of hooks other than OnPanic are reported to OnPanic
+func (s *hookState) call(call func(), panicHook bool) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		atomic.AddUint64(&s.stats.Panics, 1)
+		if panicHook {
+			return
+		}
+		if onPanic := s.current().OnPanic; onPanic != nil {
+			s.call(func() { onPanic(PanicEvent{Value: value, Stack: debug.Stack()}) }, true)
+		}
+	}()
+	call()
+	atomic.AddUint64(&s.stats.Delivered, 1)
+}
+
+func (s *hookState) addPending(delta int) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	s.pending += delta
+	if s.pending == 0 {
+		s.pendingCond.Broadcast()
+	}
+}
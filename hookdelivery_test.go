@@ -0,0 +1,98 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHookDelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		delivery    HookDelivery
+		events      int
+		blockFirst  bool
+		wantDropped uint64
+	}{
+		{name: "Synchronous", events: 20},
+		{name: "Async", delivery: HookDelivery{Async: true}, events: 20},
+		{name: "Async with full queue", delivery: HookDelivery{Async: true, QueueSize: 2}, events: 10, blockFirst: true, wantDropped: 7},
+		{name: "Async blocking on full queue", delivery: HookDelivery{Async: true, QueueSize: 2, Block: true}, events: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetHookDelivery(tt.delivery)
+			defer SetHookDelivery(HookDelivery{})
+			before := HookStats()
+
+			var mu sync.Mutex
+			var delivered []int
+			release := make(chan struct{})
+			started := make(chan struct{}, 1)
+			for i := 0; i < tt.events; i++ {
+				i := i
+				defaultFetcher.deliverHook("issuer", func() {
+					if tt.blockFirst && i == 0 {
+						started <- struct{}{}
+						<-release
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					delivered = append(delivered, i)
+				})
+				if tt.blockFirst && i == 0 {
+					<-started
+				}
+			}
+			close(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := FlushHooks(ctx); err != nil {
+				t.Fatalf("FlushHooks() error = %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if got := uint64(len(delivered)); got != uint64(tt.events)-tt.wantDropped {
+				t.Errorf("delivered %d events, want %d", got, uint64(tt.events)-tt.wantDropped)
+			}
+			for i := 1; i < len(delivered); i++ {
+				if delivered[i] < delivered[i-1] {
+					t.Fatalf("delivered events %v, want them in order", delivered)
+				}
+			}
+			if dropped := HookStats().Dropped - before.Dropped; dropped != tt.wantDropped {
+				t.Errorf("HookStats().Dropped grew by %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestHookPanic(t *testing.T) {
+	SetAllowedHosts("*.example.com")
+	defer SetAllowedHosts()
+	var panics []PanicEvent
+	SetHooks(Hooks{
+		OnHostNotAllowed: func(HostNotAllowedError) { panic("broken hook") },
+		OnPanic: func(event PanicEvent) {
+			panics = append(panics, event)
+			panic("broken panic hook")
+		},
+	})
+	defer SetHooks(Hooks{})
+	before := HookStats()
+
+	var hostErr *HostNotAllowedError
+	if err := checkHost(context.Background(), "https://attacker.test/jwks"); !errors.As(err, &hostErr) {
+		t.Fatalf("checkHost() error = %v, want *HostNotAllowedError", err)
+	}
+	if len(panics) != 1 || fmt.Sprint(panics[0].Value) != "broken hook" {
+		t.Errorf("OnPanic() events = %v, want the panic of OnHostNotAllowed", panics)
+	}
+	if got := HookStats().Panics - before.Panics; got != 2 {
+		t.Errorf("HookStats().Panics grew by %d, want 2", got)
+	}
+}
@@ -0,0 +1,39 @@
+package jwkfetch
+
+// Hooks are callbacks notified about security relevant events, called as configured by SetHookDelivery. Nil hooks are skipped
+type Hooks struct {
+	// OnKeySetDivergence is called when the cross-checked sources of a provider disagree
+	OnKeySetDivergence func(KeySetDivergence)
+	// OnPinFailure is called when a JWKS endpoint fails the provider's SPKI pins
+	OnPinFailure func(PinFailure)
+	// OnHostNotAllowed is called when a fetch is blocked by SetAllowedHosts
+	OnHostNotAllowed func(HostNotAllowedError)
+	// OnPanic is called with the stack of a panic recovered while resolving a key
+	OnPanic func(PanicEvent)
+	// OnProviderDegraded is called when the scheduled refresh of a provider failed RefreshEscalation.FailureThreshold times in a row
+	OnProviderDegraded func(ProviderDegraded)
+	// OnReload is called after a reload triggered by ReloadOnSignal
+	OnReload func(ReloadEvent)
+	// OnRotationAnomaly is called when the keys of a provider change far more often than they used to, see SetRotationAnomalyPolicy
+	OnRotationAnomaly func(RotationAnomaly)
+	// OnMalformedKey is called for every key skipped from a fetched key set because it couldn't be parsed
+	OnMalformedKey func(MalformedKey)
+	// OnRevocationListFailure is called when a poll of a revocation list fails, while the keys of the last accepted list stay revoked
+	OnRevocationListFailure func(RevocationListFailure)
+}
+
+// SetHooks is Fetcher.SetHooks of the default fetcher
+func SetHooks(h Hooks) {
+	defaultFetcher.SetHooks(h)
+}
+
+// SetHooks replaces the hooks notified by the fetcher
+func (f *Fetcher) SetHooks(h Hooks) {
+	f.hooks.mu.Lock()
+	defer f.hooks.mu.Unlock()
+	f.hooks.hooks = h
+}
+
+func (f *Fetcher) currentHooks() Hooks {
+	return f.hooks.current()
+}
@@ -0,0 +1,74 @@
+package jwkfetch
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrNonceMismatch is returned for ID tokens whose nonce claim isn't the nonce of WithNonce
+var ErrNonceMismatch = errors.New("Token nonce doesn't match")
+
+// ErrTokenHashMismatch is returned for ID tokens whose at_hash or c_hash claim doesn't match the access token or code
+var ErrTokenHashMismatch = errors.New("Token hash doesn't match")
+
+func checkIDTokenClaims(token *jwt.Token, options verifyOptions) error {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if options.nonce != "" {
+		nonce, _ := claims["nonce"].(string)
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(options.nonce)) != 1 {
+			return ErrNonceMismatch
+		}
+	}
+	if options.accessToken != "" {
+		if err := checkTokenHash(token, claims, "at_hash", options.accessToken); err != nil {
+			return err
+		}
+	}
+	if options.code != "" {
+		if err := checkTokenHash(token, claims, "c_hash", options.code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTokenHash compares the hash claim with the base64url encoded left half of the value's hash by the hash function of the token's alg
+func checkTokenHash(token *jwt.Token, claims jwt.MapClaims, claim string, value string) error {
+	hashClaim, _ := claims[claim].(string)
+	if hashClaim == "" {
+		return fmt.Errorf("%w: missing %s", ErrTokenHashMismatch, claim)
+	}
+	newHash, err := tokenHashFunction(token.Method.Alg())
+	if err != nil {
+		return err
+	}
+	h := newHash()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	want := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	if subtle.ConstantTimeCompare([]byte(hashClaim), []byte(want)) != 1 {
+		return fmt.Errorf("%w: %s", ErrTokenHashMismatch, claim)
+	}
+	return nil
+}
+
+// tokenHashFunction is the hash function of the alg, e.g. SHA-256 for RS256, ES256 and PS256
+func tokenHashFunction(alg string) (func() hash.Hash, error) {
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		return sha256.New, nil
+	case strings.HasSuffix(alg, "384"):
+		return sha512.New384, nil
+	case strings.HasSuffix(alg, "512"):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("Token alg %s has no hash function", alg)
+}
@@ -0,0 +1,83 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func leftHalfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func TestIDTokenClaims(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "id-token-key")
+	jwkProvider := JWKProvider{Issuer: "https://issuer.example.com", InlineJWKS: []byte(keySet)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	claims := jwt.MapClaims{
+		"iss":     jwkProvider.Issuer,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"nonce":   "n-0S6_WzA2Mj",
+		"at_hash": leftHalfHash("access-token"),
+		"c_hash":  leftHalfHash("code"),
+	}
+	tokenString := signTestToken(t, privateKey, "id-token-key", claims)
+	withoutHashes := signTestToken(t, privateKey, "id-token-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": claims["exp"]})
+
+	tests := []struct {
+		name        string
+		tokenString string
+		opts        []VerifyOption
+		wantErr     error
+	}{
+		{name: "No checks", tokenString: tokenString},
+		{name: "Nonce", tokenString: tokenString, opts: []VerifyOption{WithNonce("n-0S6_WzA2Mj")}},
+		{name: "Wrong nonce", tokenString: tokenString, opts: []VerifyOption{WithNonce("other")}, wantErr: ErrNonceMismatch},
+		{name: "Missing nonce", tokenString: withoutHashes, opts: []VerifyOption{WithNonce("n-0S6_WzA2Mj")}, wantErr: ErrNonceMismatch},
+		{name: "Hashes", tokenString: tokenString, opts: []VerifyOption{WithAccessTokenHash("access-token"), WithCodeHash("code")}},
+		{name: "Wrong access token", tokenString: tokenString, opts: []VerifyOption{WithAccessTokenHash("other")}, wantErr: ErrTokenHashMismatch},
+		{name: "Wrong code", tokenString: tokenString, opts: []VerifyOption{WithCodeHash("other")}, wantErr: ErrTokenHashMismatch},
+		{name: "Missing at_hash", tokenString: withoutHashes, opts: []VerifyOption{WithAccessTokenHash("access-token")}, wantErr: ErrTokenHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAndVerify(context.Background(), tt.tokenString, tt.opts...)
+			if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+				t.Errorf("ParseAndVerify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_tokenHashFunction(t *testing.T) {
+	tests := []struct {
+		alg      string
+		wantSize int
+		wantErr  bool
+	}{
+		{alg: "RS256", wantSize: 32},
+		{alg: "ES384", wantSize: 48},
+		{alg: "PS512", wantSize: 64},
+		{alg: "none", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			newHash, err := tokenHashFunction(tt.alg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tokenHashFunction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && newHash().Size() != tt.wantSize {
+				t.Errorf("tokenHashFunction() size = %d, want %d", newHash().Size(), tt.wantSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package jwkfetch
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// keyIndex indexes the keys of a key set by kid and thumbprint, so lookups don't scan key sets with many keys
+type keyIndex struct {
+	byID         map[string][]jwk.Key
+	byThumbprint map[string]jwk.Key
+	// approxBytes approximates the memory used by the keys with the size of their JSON
+	approxBytes uint64
+}
+
+func newKeyIndex(keySet *jwk.Set) *keyIndex {
+	index := &keyIndex{
+		byID:         make(map[string][]jwk.Key, len(keySet.Keys)),
+		byThumbprint: make(map[string]jwk.Key),
+	}
+	for _, key := range keySet.Keys {
+		if buf, err := json.Marshal(key); err == nil {
+			index.approxBytes += uint64(len(buf))
+		}
+		index.byID[key.KeyID()] = append(index.byID[key.KeyID()], key)
+		if thumbprint := getKeyThumbprint(key); thumbprint != "" {
+			if _, ok := index.byThumbprint[thumbprint]; !ok {
+				index.byThumbprint[thumbprint] = key
+			}
+		}
+	}
+	return index
+}
+
+func (index *keyIndex) lookupKey(keyID string) (jwk.Key, error) {
+	keys := index.byID[keyID]
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if len(keys) > 1 {
+		return nil, errors.New("Unexpected error. More than one key found in jwks uri")
+	}
+	return keys[0], nil
+}
+
+func (index *keyIndex) lookupThumbprint(thumbprint string) (jwk.Key, error) {
+	if key, ok := index.byThumbprint[thumbprint]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// lookupKey looks up the key in the entry's index, which entries created before indexing don't have
+func (entry *keySetEntry) lookupKey(keyID string) (jwk.Key, error) {
+	if entry.index == nil {
+		return lookupKey(entry.keySet, keyID)
+	}
+	return entry.index.lookupKey(keyID)
+}
+
+func (entry *keySetEntry) lookupThumbprint(thumbprint string) (jwk.Key, error) {
+	if entry.index == nil {
+		for _, key := range entry.keySet.Keys {
+			if getKeyThumbprint(key) == thumbprint {
+				return key, nil
+			}
+		}
+		return nil, ErrKeyNotFound
+	}
+	return entry.index.lookupThumbprint(thumbprint)
+}
+
+func (entry *keySetEntry) approxBytes() uint64 {
+	if entry.keySet == nil {
+		return 0
+	}
+	if entry.index == nil {
+		return newKeyIndex(entry.keySet).approxBytes
+	}
+	return entry.index.approxBytes
+}
@@ -0,0 +1,83 @@
+package jwkfetch
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func newLargeKeySet(tb testing.TB, size int) *jwk.Set {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatalf("failed to generate key: %v", err)
+	}
+	keySet := &jwk.Set{}
+	for i := 0; i < size; i++ {
+		key, _ := jwk.New(&privateKey.PublicKey)
+		key.Set(jwk.KeyIDKey, fmt.Sprintf("tenant-%d", i))
+		key.Set(jwk.X509CertThumbprintKey, fmt.Sprintf("thumbprint-%d", i))
+		keySet.Keys = append(keySet.Keys, key)
+	}
+	return keySet
+}
+
+func TestKeyIndex(t *testing.T) {
+	keySet := newLargeKeySet(t, 3)
+	duplicate, _ := jwk.New([]byte("secret"))
+	duplicate.Set(jwk.KeyIDKey, "tenant-2")
+	keySet.Keys = append(keySet.Keys, duplicate)
+	index := newKeyIndex(keySet)
+
+	tests := []struct {
+		name       string
+		keyID      string
+		thumbprint string
+		want       jwk.Key
+		wantErr    bool
+	}{
+		{name: "By kid", keyID: "tenant-1", want: keySet.Keys[1]},
+		{name: "Unknown kid", keyID: "tenant-9", wantErr: true},
+		{name: "Duplicate kid", keyID: "tenant-2", wantErr: true},
+		{name: "By thumbprint", thumbprint: "thumbprint-0", want: keySet.Keys[0]},
+		{name: "Unknown thumbprint", thumbprint: "thumbprint-9", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got jwk.Key
+			var err error
+			if tt.keyID != "" {
+				got, err = index.lookupKey(tt.keyID)
+			} else {
+				got, err = index.lookupThumbprint(tt.thumbprint)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookup error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lookup = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkLookupKey(b *testing.B) {
+	for _, size := range []int{10, 1000, 5000} {
+		keySet := newLargeKeySet(b, size)
+		index := newKeyIndex(keySet)
+		keyID := fmt.Sprintf("tenant-%d", size-1)
+
+		b.Run(fmt.Sprintf("Linear/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lookupKey(keySet, keyID)
+			}
+		})
+		b.Run(fmt.Sprintf("Indexed/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				index.lookupKey(keyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package jwkfetchmsg verifies the JWTs of queue messages, e.g. of Kafka or SQS consumers, with the key management of jwkfetch
+package jwkfetchmsg
+
+import (
+	"context"
+	"strings"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultHeader is the message header holding the token
+const DefaultHeader = "authorization"
+
+// VerifyMessage verifies the token of the message's authorization header with jwkfetch.ParseAndVerify.
+// Header names are matched case-insensitively and the token may have a "Bearer " prefix
+func VerifyMessage(ctx context.Context, headers map[string][]byte, opts ...jwkfetch.VerifyOption) (*jwt.Token, error) {
+	return VerifyMessageHeader(ctx, headers, DefaultHeader, opts...)
+}
+
+// VerifyMessageHeader verifies the token of the named message header with jwkfetch.ParseAndVerify
+func VerifyMessageHeader(ctx context.Context, headers map[string][]byte, name string, opts ...jwkfetch.VerifyOption) (*jwt.Token, error) {
+	tokenString := messageToken(headers, name)
+	if tokenString == "" {
+		return nil, jwkfetch.ErrMissingToken
+	}
+	return jwkfetch.ParseAndVerify(ctx, tokenString, opts...)
+}
+
+// StringHeaders converts string headers, e.g. the string message attributes of SQS, to headers
+func StringHeaders(attributes map[string]string) map[string][]byte {
+	headers := make(map[string][]byte, len(attributes))
+	for name, value := range attributes {
+		headers[name] = []byte(value)
+	}
+	return headers
+}
+
+func messageToken(headers map[string][]byte, name string) string {
+	for headerName, value := range headers {
+		if !strings.EqualFold(headerName, name) {
+			continue
+		}
+		token := strings.TrimSpace(string(value))
+		if scheme, rest, ok := strings.Cut(token, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(rest)
+		}
+		return token
+	}
+	return ""
+}
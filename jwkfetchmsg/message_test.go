@@ -0,0 +1,56 @@
+package jwkfetchmsg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	"github.com/Soluto/fetch-jwk/jwkfetchtest"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	server, err := jwkfetchtest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	jwkfetch.AddProvider(jwkfetch.JWKProvider{Issuer: server.Issuer(), JWKURL: server.URL + jwkfetchtest.JWKsPath})
+	defer jwkfetch.RemoveProvider(server.Issuer())
+
+	tokenString, err := server.Sign(jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string][]byte
+		wantErr error
+	}{
+		{name: "Kafka header", headers: map[string][]byte{"authorization": []byte(tokenString)}},
+		{name: "Bearer prefix", headers: map[string][]byte{"Authorization": []byte("Bearer " + tokenString)}},
+		{name: "SQS attribute", headers: StringHeaders(map[string]string{"Authorization": tokenString})},
+		{name: "Missing header", headers: map[string][]byte{"content-type": []byte("application/json")}, wantErr: jwkfetch.ErrMissingToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := VerifyMessage(context.Background(), tt.headers)
+			if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+				t.Fatalf("VerifyMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !token.Valid {
+				t.Error("VerifyMessage() token isn't valid")
+			}
+		})
+	}
+}
+
+func TestVerifyMessageHeader(t *testing.T) {
+	headers := map[string][]byte{"x-event-token": []byte("not a token")}
+	if _, err := VerifyMessageHeader(context.Background(), headers, "X-Event-Token"); err == nil || errors.Is(err, jwkfetch.ErrMissingToken) {
+		t.Errorf("VerifyMessageHeader() error = %v, want a parse error", err)
+	}
+}
@@ -0,0 +1,104 @@
+package jwkfetchtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fixture is a recorded response
+type fixture struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// FixturePath returns the path of the fixture file of the URL in the directory
+func FixturePath(dir string, fixtureURL string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(fixtureURL, "https://"), "http://")
+	return filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(name, "_")+".json")
+}
+
+// Recorder is a transport saving the responses of its base transport to fixture files, e.g. as a JWKProvider Transport
+// to record the discovery document and key set of a real issuer
+type Recorder struct {
+	// Dir is the directory of the fixture files
+	Dir string
+	// Base is the transport fetching the responses, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip fetches the response with the base transport and saves it to the fixture file of the request URL
+func (r Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// let the base transport decompress responses so fixtures are readable
+	req = req.Clone(req.Context())
+	req.Header.Del("Accept-Encoding")
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	buf, err := json.MarshalIndent(fixture{URL: req.URL.String(), StatusCode: resp.StatusCode, Header: header, Body: string(body)}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(FixturePath(r.Dir, req.URL.String()), buf, 0644); err != nil {
+		return nil, fmt.Errorf("Error while saving fixture: %v", err)
+	}
+	return resp, nil
+}
+
+// ReplayTransport serves the responses saved by a Recorder without network access. Requests without fixture fail
+type ReplayTransport struct {
+	// Dir is the directory of the fixture files
+	Dir string
+}
+
+// RoundTrip serves the fixture of the request URL
+func (t ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	buf, err := os.ReadFile(FixturePath(t.Dir, req.URL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("No fixture for %s: %v", req.URL, err)
+	}
+	var recorded fixture
+	if err := json.Unmarshal(buf, &recorded); err != nil {
+		return nil, fmt.Errorf("Error while parsing fixture of %s: %v", req.URL, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
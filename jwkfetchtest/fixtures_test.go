@@ -0,0 +1,45 @@
+package jwkfetchtest
+
+import (
+	"context"
+	"testing"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	dir := t.TempDir()
+	token, err := server.Sign(jwt.MapClaims{"sub": "recorded"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	parsed, _ := jwt.Parse(token, nil)
+
+	if err := jwkfetch.AddProvider(jwkfetch.JWKProvider{Issuer: server.Issuer(), Transport: Recorder{Dir: dir}}); err != nil {
+		t.Fatalf("AddProvider() error = %v while recording", err)
+	}
+	if err := jwkfetch.RemoveProvider(server.Issuer()); err != nil {
+		t.Fatalf("RemoveProvider() error = %v", err)
+	}
+	// the server is gone, so the provider can only be fetched from the fixtures
+	server.Close()
+
+	if err := jwkfetch.AddProvider(jwkfetch.JWKProvider{Issuer: server.Issuer(), Transport: ReplayTransport{Dir: dir}}); err != nil {
+		t.Fatalf("AddProvider() error = %v while replaying", err)
+	}
+	defer jwkfetch.RemoveProvider(server.Issuer())
+	if _, err := jwkfetch.ResolveKey(context.Background(), parsed); err != nil {
+		t.Errorf("ResolveKey() error = %v with replayed fixtures", err)
+	}
+
+	missing := ReplayTransport{Dir: t.TempDir()}
+	if err := jwkfetch.AddProvider(jwkfetch.JWKProvider{Issuer: "https://missing.example.com", Transport: missing}); err == nil {
+		t.Errorf("AddProvider() succeeded without fixtures")
+	}
+	jwkfetch.RemoveProvider("https://missing.example.com")
+}
@@ -0,0 +1,210 @@
+// Package jwkfetchtest provides an OpenID Connect test server for testing jwkfetch settings against key rotation and outages
+package jwkfetchtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	// DiscoverPath is the path of the server's discovery document
+	DiscoverPath = "/.well-known/openid-configuration"
+	// JWKsPath is the path of the server's key set
+	JWKsPath = "/jwks"
+)
+
+type signingKey struct {
+	keyID      string
+	privateKey *rsa.PrivateKey
+	// retireAt is when the key disappears from the key set, zero while it is the current key
+	retireAt time.Time
+}
+
+// Server is an issuer serving its discovery document and key set. Its issuer is the server URL
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	keys      []*signingKey
+	generated int
+	latency   time.Duration
+	failures  int
+	status    int
+	malformed bool
+	requests  map[string]int
+}
+
+// NewServer starts a server with a single RS256 signing key. The server should be closed when done
+func NewServer() (*Server, error) {
+	s := &Server{requests: make(map[string]int)}
+	if err := s.addKey(); err != nil {
+		return nil, err
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// Issuer returns the iss of the server's tokens
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// KeyID returns the kid of the current signing key
+func (s *Server) KeyID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentKey().keyID
+}
+
+// Sign signs the claims with the current signing key. The iss claim is set to the server's issuer unless the claims have one
+func (s *Server) Sign(claims jwt.MapClaims) (string, error) {
+	s.mu.Lock()
+	key := s.currentKey()
+	s.mu.Unlock()
+
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = s.Issuer()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.keyID
+	return token.SignedString(key.privateKey)
+}
+
+// Rotate adds a new current signing key with a new kid. The previous key stays in the key set for the overlap, zero removes it immediately
+func (s *Server) Rotate(overlap time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentKey().retireAt = time.Now().Add(overlap)
+	return s.addKeyLocked()
+}
+
+// SetLatency delays every response by the latency
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// FailNext fails the next count requests with the status, e.g. http.StatusServiceUnavailable for a 5xx burst
+func (s *Server) FailNext(count int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = count
+	s.status = status
+}
+
+// SetMalformed serves truncated JSON instead of the discovery document and key set while malformed is true
+func (s *Server) SetMalformed(malformed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.malformed = malformed
+}
+
+// Requests returns the number of requests of the path, e.g. JWKsPath
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) addKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addKeyLocked()
+}
+
+func (s *Server) addKeyLocked() error {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	s.generated++
+	s.keys = append(s.keys, &signingKey{keyID: fmt.Sprintf("key-%d", s.generated), privateKey: privateKey})
+	return nil
+}
+
+func (s *Server) currentKey() *signingKey {
+	return s.keys[len(s.keys)-1]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	latency := s.latency
+	failing := s.failures > 0
+	status := s.status
+	if failing {
+		s.failures--
+	}
+	malformed := s.malformed
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if failing {
+		w.WriteHeader(status)
+		return
+	}
+
+	var body interface{}
+	switch r.URL.Path {
+	case DiscoverPath:
+		body = map[string]interface{}{"issuer": s.Issuer(), "jwks_uri": s.URL + JWKsPath}
+	case JWKsPath:
+		keySet, err := s.keySet()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body = keySet
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if malformed {
+		buf = buf[:len(buf)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
+
+// keySet returns the public keys that aren't retired yet
+func (s *Server) keySet() (jwk.Set, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var keySet jwk.Set
+	for _, key := range s.keys {
+		if !key.retireAt.IsZero() && !now.Before(key.retireAt) {
+			continue
+		}
+		publicKey, err := jwk.New(&key.privateKey.PublicKey)
+		if err != nil {
+			return jwk.Set{}, err
+		}
+		publicKey.Set(jwk.KeyIDKey, key.keyID)
+		publicKey.Set(jwk.AlgorithmKey, "RS256")
+		keySet.Keys = append(keySet.Keys, publicKey)
+	}
+	return keySet, nil
+}
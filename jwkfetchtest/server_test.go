@@ -0,0 +1,154 @@
+package jwkfetchtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	jwkfetch "github.com/Soluto/fetch-jwk"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestServerRotation(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+
+	oldToken, err := server.Sign(jwt.MapClaims{"sub": "old"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if _, err := jwt.Parse(oldToken, jwkfetch.FromIssuerClaim()); err != nil {
+		t.Fatalf("jwt.Parse() error = %v before rotation", err)
+	}
+
+	oldKeyID := server.KeyID()
+	if err := server.Rotate(time.Hour); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if server.KeyID() == oldKeyID {
+		t.Fatalf("Rotate() kept kid %s", oldKeyID)
+	}
+	newToken, err := server.Sign(jwt.MapClaims{"sub": "new"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if _, err := jwt.Parse(newToken, jwkfetch.FromIssuerClaim()); err != nil {
+		t.Errorf("jwt.Parse() error = %v after rotation", err)
+	}
+	if got := keyIDs(t, server); len(got) != 2 {
+		t.Errorf("Key set during overlap = %v, want old and new kid", got)
+	}
+
+	retiredKeyID := server.KeyID()
+	if err := server.Rotate(0); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	want := []string{oldKeyID, server.KeyID()}
+	if got := keyIDs(t, server); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Key set after rotation without overlap = %v, want %v without %s", got, want, retiredKeyID)
+	}
+}
+
+func TestServerOutages(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(*Server)
+		timeout    time.Duration
+		wantStatus []int
+		wantErr    bool
+	}{
+		{
+			name:       "5xx burst",
+			setup:      func(s *Server) { s.FailNext(2, http.StatusServiceUnavailable) },
+			wantStatus: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+		},
+		{
+			name:    "Slow responses",
+			setup:   func(s *Server) { s.SetLatency(time.Second) },
+			timeout: 50 * time.Millisecond,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewServer()
+			if err != nil {
+				t.Fatalf("NewServer() error = %v", err)
+			}
+			defer server.Close()
+			tt.setup(server)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			for i := 0; i < len(tt.wantStatus) || (tt.wantErr && i == 0); i++ {
+				req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+JWKsPath, nil)
+				resp, err := http.DefaultClient.Do(req)
+				if tt.wantErr {
+					if err == nil {
+						resp.Body.Close()
+						t.Errorf("Request %d succeeded, want error", i)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Request %d error = %v", i, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus[i] {
+					t.Errorf("Request %d status = %d, want %d", i, resp.StatusCode, tt.wantStatus[i])
+				}
+			}
+			if got := server.Requests(JWKsPath); got == 0 {
+				t.Errorf("Requests(%s) = 0", JWKsPath)
+			}
+		})
+	}
+}
+
+func TestServerMalformed(t *testing.T) {
+	server, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	server.SetMalformed(true)
+
+	token, err := server.Sign(jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if _, err := jwt.Parse(token, jwkfetch.FromIssuerClaim()); err == nil {
+		t.Errorf("jwt.Parse() succeeded with malformed discovery document")
+	}
+}
+
+func keyIDs(t *testing.T, server *Server) []string {
+	t.Helper()
+	resp, err := http.Get(server.URL + JWKsPath)
+	if err != nil {
+		t.Fatalf("Error while fetching key set: %v", err)
+	}
+	defer resp.Body.Close()
+	var keySet struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		t.Fatalf("Error while parsing key set: %v", err)
+	}
+	var keyIDs []string
+	for _, key := range keySet.Keys {
+		keyIDs = append(keyIDs, key.KeyID)
+	}
+	return keyIDs
+}
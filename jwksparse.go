@@ -0,0 +1,225 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// ErrKeySetTooLarge is returned for key sets exceeding the KeySetLimits
+var ErrKeySetTooLarge = errors.New("Key set exceeds limits")
+
+// ErrNoUsableKeys is returned for key sets whose keys are all malformed
+var ErrNoUsableKeys = errors.New("Key set has no usable keys")
+
+// MalformedKey is a key that couldn't be parsed and was skipped, so the other keys of its key set stay usable
+type MalformedKey struct {
+	// JWKsURL is the URL of the key set, empty for inline and environment key sets
+	JWKsURL string
+	// Index is the position of the key in the key set
+	Index int
+	// KeyID is the kid of the key, empty when it isn't readable
+	KeyID string
+	// Unsupported is true for keys of a key type or algorithm the package doesn't support, as opposed to keys with invalid parameters
+	Unsupported bool
+	Err         error
+}
+
+// KeySetLimits bound the fetched key sets
+type KeySetLimits struct {
+	// MaxBytes is the maximal size of a key set document, and of the discovery, metadata and DID documents the keys are fetched with
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxKeys is the maximal number of keys in a key set
+	MaxKeys int `json:"max_keys"`
+	// MaxKeyBytes is the maximal size of a single key
+	MaxKeyBytes int `json:"max_key_bytes"`
+}
+
+// SetKeySetLimits is Fetcher.SetKeySetLimits of the default fetcher
+func SetKeySetLimits(limits KeySetLimits) {
+	defaultFetcher.SetKeySetLimits(limits)
+}
+
+// SetKeySetLimits sets the limits of the key sets the fetcher fetches. Defaults to 5MB documents of at most 10000 keys of 64KB each
+func (f *Fetcher) SetKeySetLimits(limits KeySetLimits) {
+	f.limitsMu.Lock()
+	defer f.limitsMu.Unlock()
+	f.keySetLimits = limits
+}
+
+func (f *Fetcher) currentKeySetLimits() KeySetLimits {
+	f.limitsMu.RLock()
+	defer f.limitsMu.RUnlock()
+	return f.keySetLimits
+}
+
+// limitReader fails with ErrKeySetTooLarge instead of EOF once more than its limit is read
+type limitReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+// limitBody bounds a fetched document by the MaxBytes of the fetcher's KeySetLimits
+func (f *Fetcher) limitBody(r io.Reader) *limitReader {
+	limit := f.currentKeySetLimits().MaxBytes
+	return &limitReader{r: r, limit: limit, remaining: limit}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: document is larger than %d bytes", ErrKeySetTooLarge, l.limit)
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("%w: document is larger than %d bytes", ErrKeySetTooLarge, l.limit)
+	}
+	return n, err
+}
+
+// parseKeySet decodes a key set one key at a time, so malformed or oversized documents fail without being buffered whole.
+// Keys that can't be parsed are skipped and returned as malformed, failing the key set only when none of its keys is usable
+func (f *Fetcher) parseKeySet(r io.Reader) (*jwk.Set, []MalformedKey, error) {
+	limits := f.currentKeySetLimits()
+	decoder := json.NewDecoder(f.limitBody(r))
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, nil, err
+	}
+	policy := f.currentUnsupportedKeyPolicy()
+	keySet := &jwk.Set{}
+	var malformed []MalformedKey
+	// skipped are the errors of the unsupported keys skipped silently
+	var skipped []error
+	foundKeys := false
+	for decoder.More() {
+		name, err := decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error while parsing jwks: %w", err)
+		}
+		if name != "keys" {
+			var ignored json.RawMessage
+			if err := decoder.Decode(&ignored); err != nil {
+				return nil, nil, fmt.Errorf("Error while parsing jwks: %w", err)
+			}
+			continue
+		}
+
+		foundKeys = true
+		if err := expectDelim(decoder, '['); err != nil {
+			return nil, nil, err
+		}
+		for index := 0; decoder.More(); index++ {
+			if index >= limits.MaxKeys {
+				return nil, nil, fmt.Errorf("%w: more than %d keys", ErrKeySetTooLarge, limits.MaxKeys)
+			}
+			raw, err := decodeRawKey(decoder, limits.MaxKeyBytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			header, ok := parseRawKeyHeader(raw)
+			if ok {
+				if err := checkKeySupport(header); err != nil {
+					switch policy {
+					case RejectUnsupportedKeys:
+						return nil, nil, fmt.Errorf("Error while parsing jwks: %w", err)
+					case SkipUnsupportedKeys:
+						skipped = append(skipped, err)
+					default:
+						malformed = append(malformed, MalformedKey{Index: index, KeyID: header.KeyID, Unsupported: true, Err: err})
+					}
+					continue
+				}
+			}
+			key, err := parseKey(raw)
+			if err != nil {
+				malformed = append(malformed, MalformedKey{Index: index, KeyID: header.KeyID, Err: err})
+				continue
+			}
+			keySet.Keys = append(keySet.Keys, key)
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, nil, err
+	}
+	if !foundKeys {
+		return nil, nil, errors.New("Error while parsing jwks: missing 'keys' parameter")
+	}
+	if len(keySet.Keys) == 0 {
+		for _, key := range malformed {
+			skipped = append(skipped, key.Err)
+		}
+		if len(skipped) > 0 {
+			return nil, malformed, fmt.Errorf("%w: %v", ErrNoUsableKeys, skipped[0])
+		}
+	}
+	return keySet, malformed, nil
+}
+
+// decodeRawKey decodes the next key of the key set without parsing it
+func decodeRawKey(decoder *json.Decoder, maxKeyBytes int) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("Error while parsing jwks: %w", err)
+	}
+	if len(raw) > maxKeyBytes {
+		return nil, fmt.Errorf("%w: key is larger than %d bytes", ErrKeySetTooLarge, maxKeyBytes)
+	}
+	return raw, nil
+}
+
+func parseKey(raw json.RawMessage) (jwk.Key, error) {
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, errors.New("Error while parsing jwks: invalid element in 'keys'")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"keys":[`)
+	buf.Write(raw)
+	buf.WriteString(`]}`)
+	parsed, err := jwk.ParseBytes(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing jwks key: %v", err)
+	}
+	return parsed.Keys[0], nil
+}
+
+// reportMalformedKeys reports the skipped keys of the key set of the URL to the OnMalformedKey hook and to FetchStats
+func (f *Fetcher) reportMalformedKeys(jwksURL string, malformed []MalformedKey) {
+	if len(malformed) == 0 {
+		return
+	}
+	if jwksURL != "" {
+		recordMalformedKeys(jwksURL, uint64(len(malformed)))
+	}
+	onMalformedKey := f.currentHooks().OnMalformedKey
+	if onMalformedKey == nil {
+		return
+	}
+	for _, key := range malformed {
+		key.JWKsURL = jwksURL
+		f.deliverHook(jwksURL, func() { onMalformedKey(key) })
+	}
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("Error while parsing jwks: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("Error while parsing jwks: expected %v, got %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func Test_parseKeySet(t *testing.T) {
+	keys := strings.Repeat(`{"kty":"oct","k":"c2VjcmV0"},`, 4) + `{"kty":"oct","k":"c2VjcmV0"}`
+	tests := []struct {
+		name          string
+		document      string
+		limits        KeySetLimits
+		wantKeys      int
+		wantErr       bool
+		wantTooLarge  bool
+		wantNoUsable  bool
+		wantMalformed []string
+	}{
+		{name: "Key set", document: jwkResponse, wantKeys: 2},
+		{name: "Other fields", document: `{"issuer": {"nested": [1, 2]}, "keys": [` + keys + `], "extra": "x"}`, wantKeys: 5},
+		{name: "Empty keys", document: `{"keys": []}`, wantKeys: 0},
+		{name: "Missing keys", document: `{"kty": "oct", "k": "c2VjcmV0"}`, wantErr: true},
+		{name: "Malformed tail", document: `{"keys": [` + keys + `], "extra": }`, wantErr: true},
+		{name: "Truncated", document: `{"keys": [` + keys, wantErr: true},
+		{name: "Not a key object", document: `{"keys": ["key"]}`, wantErr: true, wantNoUsable: true},
+		{name: "Malformed key among valid ones", document: `{"keys": [{"kty":"EC","kid":"bad","crv":"P-999","x":"AA","y":"AA"},` + keys + `]}`, wantKeys: 5, wantMalformed: []string{"bad"}},
+		{name: "Only malformed keys", document: `{"keys": [{"kty":"EC","kid":"bad","crv":"P-999"},{"kty":"unknown","kid":"other"}]}`, wantErr: true, wantNoUsable: true, wantMalformed: []string{"bad", "other"}},
+		{name: "Too many keys", document: `{"keys": [` + keys + `]}`, limits: KeySetLimits{MaxBytes: 1 << 20, MaxKeys: 4, MaxKeyBytes: 1024}, wantErr: true, wantTooLarge: true},
+		{name: "Key too large", document: `{"keys": [{"kty":"oct","k":"` + strings.Repeat("A", 2048) + `"}]}`, limits: KeySetLimits{MaxBytes: 1 << 20, MaxKeys: 10, MaxKeyBytes: 1024}, wantErr: true, wantTooLarge: true},
+		{name: "Document too large", document: `{"keys": [` + keys + `]}`, limits: KeySetLimits{MaxBytes: 64, MaxKeys: 10, MaxKeyBytes: 1024}, wantErr: true, wantTooLarge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limits != (KeySetLimits{}) {
+				SetKeySetLimits(tt.limits)
+				defer SetKeySetLimits(KeySetLimits{MaxBytes: 5 << 20, MaxKeys: 10000, MaxKeyBytes: 64 << 10})
+			}
+
+			got, malformed, err := defaultFetcher.parseKeySet(strings.NewReader(tt.document))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeySet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantNoUsable && !errors.Is(err, ErrNoUsableKeys) {
+				t.Errorf("parseKeySet() error = %v, want %v", err, ErrNoUsableKeys)
+			}
+			var malformedKeyIDs []string
+			for _, key := range malformed {
+				malformedKeyIDs = append(malformedKeyIDs, key.KeyID)
+			}
+			if len(tt.wantMalformed) > 0 && !reflect.DeepEqual(malformedKeyIDs, tt.wantMalformed) {
+				t.Errorf("parseKeySet() malformed keys = %v, want %v", malformedKeyIDs, tt.wantMalformed)
+			}
+			if tt.wantTooLarge && !errors.Is(err, ErrKeySetTooLarge) {
+				t.Errorf("parseKeySet() error = %v, want %v", err, ErrKeySetTooLarge)
+			}
+			if err == nil && len(got.Keys) != tt.wantKeys {
+				t.Errorf("parseKeySet() returned %d keys, want %d", len(got.Keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestMalformedKeysReported(t *testing.T) {
+	document := strings.Replace(jwkResponse, `"keys": [`, `"keys": [{"kty":"EC","kid":"bad","crv":"P-999"},`, 1)
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, document)
+	}))
+	defer server.Close()
+
+	var reported []MalformedKey
+	SetHooks(Hooks{OnMalformedKey: func(key MalformedKey) {
+		reported = append(reported, key)
+	}})
+	defer SetHooks(Hooks{})
+
+	jwksURL := fmt.Sprintf("http://%s/malformed/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+	if _, err := defaultFetcher.resolveKey(context.Background(), mockToken(), jwksURL, defaultFetcher.jwksCache, defaultFetcher.getKeySetFromJWKCache); err != nil {
+		t.Fatalf("resolveKey() error = %v, want the valid key of the key set", err)
+	}
+	if len(reported) != 1 || reported[0].KeyID != "bad" || reported[0].JWKsURL != jwksURL || reported[0].Index != 0 {
+		t.Errorf("OnMalformedKey() keys = %+v, want key bad of %s", reported, jwksURL)
+	}
+	for _, stats := range FetchStats() {
+		if stats.URL == jwksURL {
+			if stats.MalformedKeys != 1 {
+				t.Errorf("FetchStats() MalformedKeys = %d, want 1", stats.MalformedKeys)
+			}
+			return
+		}
+	}
+	t.Errorf("FetchStats() doesn't have %s", jwksURL)
+}
+
+func BenchmarkParseKeySet(b *testing.B) {
+	keySet := newLargeKeySet(b, 1000)
+	buf, err := json.Marshal(keySet)
+	if err != nil {
+		b.Fatalf("failed to marshal key set: %v", err)
+	}
+	document := string(buf)
+	b.Run("Streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := defaultFetcher.parseKeySet(strings.NewReader(document)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := jwk.ParseString(document); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
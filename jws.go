@@ -0,0 +1,256 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws/verify"
+)
+
+type jwsSignature struct {
+	Protected string                 `json:"protected"`
+	Header    map[string]interface{} `json:"header"`
+	Signature string                 `json:"signature"`
+}
+
+type jwsJSON struct {
+	Payload    string         `json:"payload"`
+	Signatures []jwsSignature `json:"signatures"`
+	jwsSignature
+}
+
+type jwsHeader struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"kid"`
+	Thumbprint string `json:"x5t"`
+	// protected are the integrity protected header parameters, the only ones which may include crit
+	protected map[string]interface{}
+	// parameters are the protected and unprotected header parameters
+	parameters map[string]interface{}
+}
+
+// VerifyJWS is Fetcher.VerifyJWS of the default fetcher
+func VerifyJWS(ctx context.Context, issuer string, compactOrDetachedJWS []byte, payload []byte) ([]byte, error) {
+	return defaultFetcher.VerifyJWS(ctx, issuer, compactOrDetachedJWS, payload)
+}
+
+// VerifyJWS verifies a JWS in compact, flattened JSON or general JSON serialization signed by issuer and returns its payload.
+// For detached signatures (empty payload part) pass the detached payload, otherwise payload must be empty: a JWS with both payloads is rejected.
+// The key is looked up by the kid or x5t header only in the key set of issuer, resolved like the iss claim of tokens, and the JWS
+// is checked against the TokenLimits and HeaderPolicy. A crit listing parameters that aren't HeaderPolicy.CriticalParameters
+// is rejected even when the policy isn't Strict, as RFC 7515 requires
+func (f *Fetcher) VerifyJWS(ctx context.Context, issuer string, compactOrDetachedJWS []byte, payload []byte) (verifiedPayload []byte, err error) {
+	defer f.recoverPanic(&err)
+	if issuer == "" {
+		return nil, errors.New("JWS issuer is empty")
+	}
+	if err := f.checkTokenLength(len(compactOrDetachedJWS)); err != nil {
+		return nil, err
+	}
+	signatures, encodedPayload, err := parseJWS(compactOrDetachedJWS, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	f.scheduleRefreshJob()
+	entry, err := f.issuerCache.flights.do(ctx, issuer, f.getKeySetFromIssuerCache)
+	if err != nil {
+		return nil, err
+	}
+	err = f.verifyJWSSignatures(issuer, entry, signatures, encodedPayload)
+	if err == ErrKeyNotFound && !f.InForensicMode() {
+		entry, err = f.forceRefresh(ctx, issuer, f.issuerCache, entry, f.getKeySetFromIssuerCache)
+		if err != nil {
+			return nil, err
+		}
+		err = f.verifyJWSSignatures(issuer, entry, signatures, encodedPayload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decodedPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("Error while decoding JWS payload: %v", err)
+	}
+	return decodedPayload, nil
+}
+
+func parseJWS(buf []byte, detachedPayload []byte) ([]jwsSignature, string, error) {
+	buf = bytes.TrimSpace(buf)
+	if len(buf) == 0 {
+		return nil, "", errors.New("JWS is empty")
+	}
+
+	var signatures []jwsSignature
+	var encodedPayload string
+	if buf[0] == '{' {
+		var message jwsJSON
+		if err := json.Unmarshal(buf, &message); err != nil {
+			return nil, "", fmt.Errorf("Error while parsing JWS: %v", err)
+		}
+		signatures = message.Signatures
+		if message.Signature != "" {
+			signatures = append(signatures, message.jwsSignature)
+		}
+		encodedPayload = message.Payload
+	} else {
+		parts := strings.Split(string(buf), ".")
+		if len(parts) != 3 {
+			return nil, "", errors.New("JWS compact serialization must have 3 parts")
+		}
+		signatures = []jwsSignature{{Protected: parts[0], Signature: parts[2]}}
+		encodedPayload = parts[1]
+	}
+
+	if len(signatures) == 0 {
+		return nil, "", errors.New("JWS doesn't have signatures")
+	}
+	// a detached payload isn't the payload the signatures of an attached one cover
+	if encodedPayload != "" && len(detachedPayload) > 0 {
+		return nil, "", errors.New("JWS has both an attached and a detached payload")
+	}
+	if encodedPayload == "" {
+		encodedPayload = base64.RawURLEncoding.EncodeToString(detachedPayload)
+	}
+	return signatures, encodedPayload, nil
+}
+
+// verifyJWSSignatures returns nil when any of the signatures is verified by a key of entry, ErrKeyNotFound when none is
+// but the key of one of them wasn't found, and the error of the first signature otherwise
+func (f *Fetcher) verifyJWSSignatures(issuer string, entry *keySetEntry, signatures []jwsSignature, encodedPayload string) error {
+	var result error
+	for _, signature := range signatures {
+		err := f.verifyJWSSignature(issuer, entry, signature, encodedPayload)
+		if err == nil {
+			return nil
+		}
+		if result == nil || err == ErrKeyNotFound {
+			result = err
+		}
+	}
+	return result
+}
+
+// verifyJWSSignature verifies the signature with a key of the entry of the issuer the caller expects, whose revocations apply
+func (f *Fetcher) verifyJWSSignature(issuer string, entry *keySetEntry, signature jwsSignature, encodedPayload string) error {
+	header, err := f.parseJWSHeader(signature)
+	if err != nil {
+		return err
+	}
+	if err := f.checkJWSHeader(header); err != nil {
+		return err
+	}
+	if header.KeyID == "" && header.Thumbprint == "" {
+		return errors.New("JWS doesn't have header kid or x5t")
+	}
+	if err := checkAlgorithmName(header.Algorithm, entry.algorithms); err != nil {
+		return err
+	}
+
+	var key jwk.Key
+	if header.KeyID != "" {
+		key, err = entry.lookupKey(header.KeyID)
+	} else {
+		key, err = entry.lookupThumbprint(header.Thumbprint)
+	}
+	if err != nil {
+		return err
+	}
+	entry.touch()
+	if f.isKeyRevoked(issuer, key.KeyID()) {
+		return ErrKeyRevoked
+	}
+	if key.Algorithm() != "" && key.Algorithm() != header.Algorithm {
+		return fmt.Errorf("JWS alg %s doesn't match key alg %s", header.Algorithm, key.Algorithm())
+	}
+
+	publicKey, err := key.Materialize()
+	if err != nil {
+		return err
+	}
+	return verifyJWSSignatureWithKey(signature, header, encodedPayload, publicKey)
+}
+
+// checkJWSHeader applies the HeaderPolicy to the header and rejects a crit which isn't protected or lists parameters the
+// application doesn't understand
+func (f *Fetcher) checkJWSHeader(header jwsHeader) error {
+	policy := f.currentHeaderPolicy()
+	if err := checkHeaderParameters(header.parameters, policy); err != nil {
+		return err
+	}
+	crit, ok := header.protected["crit"]
+	if !ok {
+		if _, ok := header.parameters["crit"]; ok {
+			return fmt.Errorf("%w: crit isn't protected", ErrHeaderNotAllowed)
+		}
+		return nil
+	}
+	return checkCritical(header.protected, crit, policy.CriticalParameters)
+}
+
+func verifyJWSSignatureWithKey(signature jwsSignature, header jwsHeader, encodedPayload string, publicKey interface{}) error {
+	alg := jwa.SignatureAlgorithm(header.Algorithm)
+	switch alg {
+	case "", jwa.NoSignature, jwa.HS256, jwa.HS384, jwa.HS512:
+		return fmt.Errorf("JWS alg %q is not allowed", header.Algorithm)
+	}
+
+	verifier, err := verify.New(alg)
+	if err != nil {
+		return err
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("Error while decoding JWS signature: %v", err)
+	}
+	return verifier.Verify([]byte(signature.Protected+"."+encodedPayload), decodedSignature, publicKey)
+}
+
+func (f *Fetcher) parseJWSHeader(signature jwsSignature) (jwsHeader, error) {
+	header := jwsHeader{protected: map[string]interface{}{}, parameters: map[string]interface{}{}}
+	if signature.Protected != "" {
+		if err := f.checkHeaderSize(signature.Protected); err != nil {
+			return header, err
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(signature.Protected)
+		if err != nil {
+			return header, fmt.Errorf("Error while decoding JWS protected header: %v", err)
+		}
+		if err := json.Unmarshal(decoded, &header.protected); err != nil {
+			return header, fmt.Errorf("Error while parsing JWS protected header: %v", err)
+		}
+	}
+	for name, value := range signature.Header {
+		header.parameters[name] = value
+	}
+	for name, value := range header.protected {
+		header.parameters[name] = value
+	}
+	header.Algorithm, _ = header.protected["alg"].(string)
+	header.KeyID, _ = header.parameters["kid"].(string)
+	header.Thumbprint, _ = header.parameters["x5t"].(string)
+	return header, nil
+}
+
+func getKeyThumbprint(key jwk.Key) string {
+	if thumbprint := key.X509CertThumbprint(); thumbprint != "" {
+		return thumbprint
+	}
+	if chain, ok := key.Get(jwk.X509CertChainKey); ok {
+		if certs, ok := chain.([]*x509.Certificate); ok && len(certs) > 0 {
+			sum := sha1.Sum(certs[0].Raw)
+			return base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+	}
+	return ""
+}
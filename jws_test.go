@@ -0,0 +1,222 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func signJWS(t *testing.T, privateKey *rsa.PrivateKey, protected string, payload []byte) (string, string) {
+	encodedProtected := base64.RawURLEncoding.EncodeToString([]byte(protected))
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(encodedProtected + "." + encodedPayload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign JWS: %v", err)
+	}
+	return encodedProtected, base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWS(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, _ := jwk.New(&privateKey.PublicKey)
+	key.Set(jwk.KeyIDKey, "jws-key")
+	key.Set(jwk.AlgorithmKey, "RS256")
+	otherPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	otherKey, _ := jwk.New(&otherPrivateKey.PublicKey)
+	otherKey.Set(jwk.KeyIDKey, "other-key")
+
+	// the issuer's discovery fails, so the key set is only the cached one
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	issuer := server.URL
+	otherIssuer := server.URL + "/other"
+	defer InvalidateAll()
+	defer SetRevokedKeys(nil)
+
+	payload := []byte(`{"event":"user.created"}`)
+	protected, signature := signJWS(t, privateKey, `{"alg":"RS256","kid":"jws-key"}`, payload)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	unknownProtected, unknownSignature := signJWS(t, privateKey, `{"alg":"RS256","kid":"unknown-key"}`, payload)
+	otherProtected, otherSignature := signJWS(t, otherPrivateKey, `{"alg":"RS256","kid":"other-key"}`, payload)
+	critProtected, critSignature := signJWS(t, privateKey, `{"alg":"RS256","kid":"jws-key","crit":["exp"],"exp":1}`, payload)
+
+	type args struct {
+		issuer  string
+		jws     string
+		payload []byte
+	}
+	tests := []struct {
+		name      string
+		args      args
+		revoked   bool
+		want      []byte
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "Compact serialization",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf("%s.%s.%s", protected, encodedPayload, signature),
+			},
+			want:    payload,
+			wantErr: false,
+		},
+		{
+			name: "Detached payload",
+			args: args{
+				issuer:  issuer,
+				jws:     fmt.Sprintf("%s..%s", protected, signature),
+				payload: payload,
+			},
+			want:    payload,
+			wantErr: false,
+		},
+		{
+			name: "Flattened JSON serialization",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf(`{"payload":"%s","protected":"%s","signature":"%s"}`, encodedPayload, protected, signature),
+			},
+			want:    payload,
+			wantErr: false,
+		},
+		{
+			name: "General JSON serialization",
+			args: args{
+				issuer: issuer,
+				jws: fmt.Sprintf(`{"payload":"%s","signatures":[{"protected":"%s","signature":"%s"},{"protected":"%s","signature":"%s"}]}`,
+					encodedPayload, unknownProtected, unknownSignature, protected, signature),
+			},
+			want:    payload,
+			wantErr: false,
+		},
+		{
+			name: "Detached payload was tampered",
+			args: args{
+				issuer:  issuer,
+				jws:     fmt.Sprintf("%s..%s", protected, signature),
+				payload: []byte(`{"event":"user.deleted"}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Attached and detached payloads",
+			args: args{
+				issuer:  issuer,
+				jws:     fmt.Sprintf("%s.%s.%s", protected, encodedPayload, signature),
+				payload: []byte(`{"event":"user.deleted"}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown kid",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf("%s.%s.%s", unknownProtected, encodedPayload, unknownSignature),
+			},
+			wantErr:   true,
+			wantErrIs: ErrKeyNotFound,
+		},
+		{
+			name: "Key of another issuer",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf("%s.%s.%s", otherProtected, encodedPayload, otherSignature),
+			},
+			wantErr:   true,
+			wantErrIs: ErrKeyNotFound,
+		},
+		{
+			name: "Other issuer",
+			args: args{
+				issuer: otherIssuer,
+				jws:    fmt.Sprintf("%s.%s.%s", otherProtected, encodedPayload, otherSignature),
+			},
+			want:    payload,
+			wantErr: false,
+		},
+		{
+			name: "Revoked kid",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf("%s.%s.%s", protected, encodedPayload, signature),
+			},
+			revoked:   true,
+			wantErr:   true,
+			wantErrIs: ErrKeyRevoked,
+		},
+		{
+			name: "Crit isn't understood",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf("%s.%s.%s", critProtected, encodedPayload, critSignature),
+			},
+			wantErr:   true,
+			wantErrIs: ErrHeaderNotAllowed,
+		},
+		{
+			name: "Crit isn't protected",
+			args: args{
+				issuer: issuer,
+				jws:    fmt.Sprintf(`{"payload":"%s","protected":"%s","header":{"crit":["b64"]},"signature":"%s"}`, encodedPayload, protected, signature),
+			},
+			wantErr:   true,
+			wantErrIs: ErrHeaderNotAllowed,
+		},
+		{
+			name: "Empty issuer",
+			args: args{
+				jws: fmt.Sprintf("%s.%s.%s", protected, encodedPayload, signature),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Malformed JWS",
+			args: args{
+				issuer: issuer,
+				jws:    "not-a-jws",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultFetcher.issuerCache.set(issuer, &keySetEntry{keySet: &jwk.Set{Keys: []jwk.Key{key}}})
+			defaultFetcher.issuerCache.set(otherIssuer, &keySetEntry{keySet: &jwk.Set{Keys: []jwk.Key{otherKey}}})
+			SetRevokedKeys(nil)
+			if tt.revoked {
+				RevokeKey(issuer, "jws-key")
+			}
+
+			got, err := VerifyJWS(context.Background(), tt.args.issuer, []byte(tt.args.jws), tt.args.payload)
+			if (err != nil) != tt.wantErr || (tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs)) {
+				t.Errorf("VerifyJWS() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VerifyJWS() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrMissingToken is returned for requests without a token
+var ErrMissingToken = errors.New("Request doesn't have a token")
+
+// ErrInsufficientScope is returned for tokens missing a scope required by RequireScopes
+var ErrInsufficientScope = errors.New("Token doesn't have the required scopes")
+
+// ErrInsufficientRole is returned for tokens having none of the roles of RequireRoles
+var ErrInsufficientRole = errors.New("Token doesn't have a required role")
+
+// ErrOpaqueToken is returned for bearer tokens that aren't JWS when the middleware has no OpaqueTokenFallback
+var ErrOpaqueToken = errors.New("Token is not a JWS")
+
+// OpaqueTokenFallback validates bearer tokens that aren't JWS, e.g. by introspection or a session lookup, and returns their claims
+type OpaqueTokenFallback func(r *http.Request, token string) (jwt.MapClaims, error)
+
+// Policy authorizes the request of a verified token. Its errors are returned to the client with status 403
+type Policy func(r *http.Request, claims jwt.MapClaims) error
+
+// ClaimsTransformer changes the claims of a verified token in place, e.g. to map IdP group ids to internal roles.
+// Its errors are returned to the client with status 500
+type ClaimsTransformer func(ctx context.Context, claims jwt.MapClaims) error
+
+type middlewareOptions struct {
+	verifyOptions []VerifyOption
+	transformers  []ClaimsTransformer
+	opaqueToken   OpaqueTokenFallback
+	extractors    []TokenExtractor
+	scopes        []string
+	roles         []string
+	policies      []Policy
+}
+
+// MiddlewareOption configures NewMiddleware
+type MiddlewareOption func(*middlewareOptions)
+
+// WithVerifyOptions sets the options of ParseAndVerify used by the middleware
+func WithVerifyOptions(opts ...VerifyOption) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.verifyOptions = append(o.verifyOptions, opts...)
+	}
+}
+
+// WithClaimsTransformers adds transformers run in order after verification, before the requirements are checked and the token is stored in the request context
+func WithClaimsTransformers(transformers ...ClaimsTransformer) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.transformers = append(o.transformers, transformers...)
+	}
+}
+
+// WithTokenExtractors sets where the middleware takes the token from, trying the extractors in order. Defaults to AuthorizationHeaderExtractor
+func WithTokenExtractors(extractors ...TokenExtractor) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.extractors = append(o.extractors, extractors...)
+	}
+}
+
+// WithOpaqueTokenFallback validates bearer tokens that aren't JWS with the fallback instead of rejecting them with ErrOpaqueToken.
+// The claims it returns go through the transformers and requirements like the claims of verified tokens
+func WithOpaqueTokenFallback(fallback OpaqueTokenFallback) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.opaqueToken = fallback
+	}
+}
+
+// RequireScopes requires the token to have all the scopes in its space separated scope claim, or in its scp claim
+func RequireScopes(scopes ...string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// RequireRoles requires the token to have one of the roles in its roles claim
+func RequireRoles(roles ...string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.roles = append(o.roles, roles...)
+	}
+}
+
+// WithPolicy adds a policy evaluated after the scopes and roles
+func WithPolicy(policy Policy) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.policies = append(o.policies, policy)
+	}
+}
+
+type tokenContextKey struct{}
+
+// TokenFromContext returns the verified token stored in the request context by the middleware
+func TokenFromContext(ctx context.Context) (*jwt.Token, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(*jwt.Token)
+	return token, ok
+}
+
+// ClaimsFromContext returns the claims of the verified token stored in the request context by the middleware, after their transformation
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	token, ok := TokenFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, ok
+}
+
+// NewMiddleware is Fetcher.NewMiddleware of the default fetcher
+func NewMiddleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return defaultFetcher.NewMiddleware(opts...)
+}
+
+// NewMiddleware returns a middleware verifying the bearer token of requests with ParseAndVerify, so tokens of issuers that aren't
+// registered or allowed are rejected unless WithVerifyOptions has WithDiscoveredIssuers.
+// Requests without a valid token are rejected with status 401 and tokens failing the scopes, roles or policies with status 403,
+// with RFC 6750 error details. The verified token is stored in the request context
+func (f *Fetcher) NewMiddleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	var options middlewareOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if len(options.extractors) == 0 {
+		options.extractors = []TokenExtractor{AuthorizationHeaderExtractor}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := f.authenticate(r, extractToken(r, options.extractors), options)
+			if err != nil {
+				writeAuthError(w, err, options.scopes)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+		})
+	}
+}
+
+// AuthError is a rejected token with the status and the RFC 6750 error code of its response
+type AuthError struct {
+	StatusCode int
+	// Code is the RFC 6750 error code, e.g. invalid_token or insufficient_scope
+	Code string
+	Err  error
+}
+
+func (e *AuthError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// authenticate verifies the token, transforms its claims and checks the requirements
+func (f *Fetcher) authenticate(r *http.Request, tokenString string, options middlewareOptions) (*jwt.Token, *AuthError) {
+	if tokenString == "" {
+		return nil, &AuthError{StatusCode: http.StatusUnauthorized, Code: "invalid_request", Err: ErrMissingToken}
+	}
+	if err := f.checkTokenSize(tokenString); err != nil {
+		return nil, &AuthError{StatusCode: http.StatusUnauthorized, Code: "invalid_request", Err: err}
+	}
+	token, err := f.verifyRequestToken(r, tokenString, options)
+	if err != nil {
+		return nil, &AuthError{StatusCode: http.StatusUnauthorized, Code: "invalid_token", Err: err}
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	for _, transform := range options.transformers {
+		if err := transform(r.Context(), claims); err != nil {
+			return nil, &AuthError{StatusCode: http.StatusInternalServerError, Code: "server_error", Err: err}
+		}
+	}
+	if err := authorize(r, claims, options); err != nil {
+		code := "insufficient_scope"
+		if !errors.Is(err, ErrInsufficientScope) {
+			code = "access_denied"
+		}
+		return nil, &AuthError{StatusCode: http.StatusForbidden, Code: code, Err: err}
+	}
+	return token, nil
+}
+
+// verifyRequestToken verifies JWS tokens and passes the other tokens to the opaque token fallback
+func (f *Fetcher) verifyRequestToken(r *http.Request, tokenString string, options middlewareOptions) (*jwt.Token, error) {
+	if isJWS(tokenString) {
+		return f.ParseAndVerify(r.Context(), tokenString, options.verifyOptions...)
+	}
+	if options.opaqueToken == nil {
+		return nil, ErrOpaqueToken
+	}
+	claims, err := options.opaqueToken(r, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &jwt.Token{Raw: tokenString, Claims: claims, Valid: true}, nil
+}
+
+// isJWS checks the token has the three segments of a compact JWS and that its header is a JSON object
+func isJWS(tokenString string) bool {
+	segments := strings.Split(tokenString, ".")
+	if len(segments) != 3 {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[0], "="))
+	if err != nil {
+		return false
+	}
+	var fields map[string]interface{}
+	return json.Unmarshal(header, &fields) == nil
+}
+
+func authorize(r *http.Request, claims jwt.MapClaims, options middlewareOptions) error {
+	granted := claimValues(claims, "scope")
+	for scope := range claimValues(claims, "scp") {
+		granted[scope] = true
+	}
+	for _, scope := range options.scopes {
+		if !granted[scope] {
+			return fmt.Errorf("%w: missing %s", ErrInsufficientScope, scope)
+		}
+	}
+	if len(options.roles) > 0 {
+		roles := claimValues(claims, "roles")
+		hasRole := false
+		for _, role := range options.roles {
+			hasRole = hasRole || roles[role]
+		}
+		if !hasRole {
+			return fmt.Errorf("%w: one of %s", ErrInsufficientRole, strings.Join(options.roles, ", "))
+		}
+	}
+	for _, policy := range options.policies {
+		if err := policy(r, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimValues returns the set of values of a space separated string or string array claim
+func claimValues(claims jwt.MapClaims, name string) map[string]bool {
+	values := make(map[string]bool)
+	for _, value := range orderedClaimValues(claims, name) {
+		values[value] = true
+	}
+	return values
+}
+
+// orderedClaimValues returns the values of a space separated string or string array claim in order
+func orderedClaimValues(claims jwt.MapClaims, name string) []string {
+	switch claim := claims[name].(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		values := make([]string, 0, len(claim))
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// authErrorBody is the body of rejected requests
+type authErrorBody struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func writeAuthError(w http.ResponseWriter, authErr *AuthError, scopes []string) {
+	challenge := fmt.Sprintf("Bearer error=%q", authErr.Code)
+	if errors.Is(authErr.Err, ErrMissingToken) {
+		challenge = "Bearer"
+	}
+	if authErr.Code == "insufficient_scope" && len(scopes) > 0 {
+		challenge += fmt.Sprintf(", scope=%q", strings.Join(scopes, " "))
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(authErr.StatusCode)
+	json.NewEncoder(w).Encode(authErrorBody{Error: authErr.Code, ErrorDescription: authErr.Err.Error()})
+}
@@ -0,0 +1,89 @@
+package jwkfetch
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// IssuerMigration is the previous key source of an issuer, trusted together with the provider's source until the cutover
+type IssuerMigration struct {
+	JWKURL      string
+	DiscoverURL string
+	Cutover     time.Time
+}
+
+// MigrationStats counts the keys resolved from each source of a migrating issuer
+type MigrationStats struct {
+	Current  uint64
+	Previous uint64
+}
+
+// mergeMigrationEntry adds the keys of the provider's previous source to the entry until the cutover
+func (f *Fetcher) mergeMigrationEntry(ctx context.Context, jwkProvider JWKProvider, entry *keySetEntry) (*keySetEntry, error) {
+	migration := jwkProvider.Migration
+	if migration == nil || !clockNow().Before(migration.Cutover) {
+		return entry, nil
+	}
+
+	var previous *keySetEntry
+	var err error
+	if migration.JWKURL != "" {
+		previous, err = f.getKeySetFromJWKCache(ctx, migration.JWKURL)
+	} else {
+		previous, err = f.getKeySetFromDiscoverURLCache(ctx, migration.DiscoverURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := append([]jwk.Key(nil), entry.keySet.Keys...)
+	currentKeyIDs := make(map[string]bool)
+	for _, key := range entry.keySet.Keys {
+		currentKeyIDs[key.KeyID()] = true
+	}
+	previousKeyIDs := make(map[string]bool)
+	for _, key := range previous.keySet.Keys {
+		if !currentKeyIDs[key.KeyID()] {
+			keys = append(keys, key)
+			previousKeyIDs[key.KeyID()] = true
+		}
+	}
+
+	merged := *entry
+	merged.keySet = &jwk.Set{Keys: keys}
+	merged.index = newKeyIndex(merged.keySet)
+	merged.previousKeyIDs = previousKeyIDs
+	merged.previousProvenance = previous.provenance()
+	merged.migrationCutover = migration.Cutover
+	return &merged, nil
+}
+
+func (f *Fetcher) recordMigrationSource(issuer string, entry *keySetEntry, keyID string) {
+	if entry.previousKeyIDs == nil {
+		return
+	}
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	stats, ok := f.migrationStats[issuer]
+	if !ok {
+		stats = &MigrationStats{}
+		f.migrationStats[issuer] = stats
+	}
+	if entry.previousKeyIDs[keyID] {
+		stats.Previous++
+	} else {
+		stats.Current++
+	}
+}
+
+func (f *Fetcher) getMigrationStats(issuer string) *MigrationStats {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	copied := MigrationStats{}
+	if stats, ok := f.migrationStats[issuer]; ok {
+		copied = *stats
+	}
+	return &copied
+}
@@ -0,0 +1,74 @@
+package jwkfetch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestIssuerMigration(t *testing.T) {
+	_, currentKeySet := newTestKeySet(t, "current-key")
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/migrating/jwks":
+			body = currentKeySet
+		case "/previous/jwks":
+			body = jwkResponse
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/migrating", httptestServerURL),
+		JWKURL: fmt.Sprintf("http://%s/migrating/jwks", httptestServerURL),
+		Migration: &IssuerMigration{
+			JWKURL:  fmt.Sprintf("http://%s/previous/jwks", httptestServerURL),
+			Cutover: time.Now().Add(500 * time.Millisecond),
+		},
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+	defer delete(defaultFetcher.migrationStats, jwkProvider.Issuer)
+
+	resolve := func(keyID string) (interface{}, error) {
+		token := mockToken()
+		token.Header["kid"] = keyID
+		token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+		return FromIssuerClaim()(token)
+	}
+
+	if _, err := resolve("current-key"); err != nil {
+		t.Errorf("FromIssuerClaim() of current key before cutover error = %v", err)
+	}
+	got, err := resolve("512fe2ae0e60bd03084b12885b41423f")
+	if err != nil {
+		t.Fatalf("FromIssuerClaim() of previous key before cutover error = %v", err)
+	}
+	if !reflect.DeepEqual(got, mockKey()) {
+		t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+	}
+	if stats := Stats()[0].Migration; !reflect.DeepEqual(stats, &MigrationStats{Current: 1, Previous: 1}) {
+		t.Errorf("Stats() migration = %v, want %v", stats, &MigrationStats{Current: 1, Previous: 1})
+	}
+
+	time.Sleep(time.Until(jwkProvider.Migration.Cutover))
+	if _, err := resolve("512fe2ae0e60bd03084b12885b41423f"); err == nil {
+		t.Errorf("FromIssuerClaim() of previous key after cutover error = nil, want error")
+	}
+	if _, err := resolve("current-key"); err != nil {
+		t.Errorf("FromIssuerClaim() of current key after cutover error = %v", err)
+	}
+}
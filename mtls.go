@@ -0,0 +1,36 @@
+package jwkfetch
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrCertificateNotBound is returned when an access token isn't bound to the client certificate of the request
+var ErrCertificateNotBound = errors.New("Token isn't bound to the client certificate")
+
+// VerifyCertificateBinding checks that the cnf.x5t#S256 claim of a verified RFC 8705 certificate-bound access token matches the client certificate of the request's TLS connection
+func VerifyCertificateBinding(r *http.Request, token *jwt.Token) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: request has no client certificate", ErrCertificateNotBound)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("%w: token claims aren't jwt.MapClaims", ErrCertificateNotBound)
+	}
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	thumbprint, _ := cnf["x5t#S256"].(string)
+	if thumbprint == "" {
+		return fmt.Errorf("%w: token doesn't have cnf.x5t#S256 claim", ErrCertificateNotBound)
+	}
+
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	if thumbprint != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		return ErrCertificateNotBound
+	}
+	return nil
+}
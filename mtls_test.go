@@ -0,0 +1,75 @@
+package jwkfetch
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	clientCert := &x509.Certificate{Raw: []byte("client certificate")}
+	otherCert := &x509.Certificate{Raw: []byte("other certificate")}
+	sum := sha256.Sum256(clientCert.Raw)
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		state   *tls.ConnectionState
+		claims  jwt.Claims
+		wantErr bool
+	}{
+		{
+			name:   "Bound token",
+			state:  &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}},
+			claims: jwt.MapClaims{"cnf": map[string]interface{}{"x5t#S256": thumbprint}},
+		},
+		{
+			name:    "Other certificate",
+			state:   &tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherCert}},
+			claims:  jwt.MapClaims{"cnf": map[string]interface{}{"x5t#S256": thumbprint}},
+			wantErr: true,
+		},
+		{
+			name:    "No client certificate",
+			state:   &tls.ConnectionState{},
+			claims:  jwt.MapClaims{"cnf": map[string]interface{}{"x5t#S256": thumbprint}},
+			wantErr: true,
+		},
+		{
+			name:    "No TLS",
+			claims:  jwt.MapClaims{"cnf": map[string]interface{}{"x5t#S256": thumbprint}},
+			wantErr: true,
+		},
+		{
+			name:    "Unbound token",
+			state:   &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}},
+			claims:  jwt.MapClaims{},
+			wantErr: true,
+		},
+		{
+			name:    "Standard claims",
+			state:   &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}},
+			claims:  &jwt.StandardClaims{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://api.example.com/resource", nil)
+			r.TLS = tt.state
+			err := VerifyCertificateBinding(r, &jwt.Token{Claims: tt.claims})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyCertificateBinding() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrCertificateNotBound) {
+				t.Errorf("VerifyCertificateBinding() error = %v, want %v", err, ErrCertificateNotBound)
+			}
+		})
+	}
+}
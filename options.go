@@ -0,0 +1,128 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures a fetcher with Init or New
+type Option func(*fetcherOptions)
+
+// Logger logs the errors of background refreshes, e.g. *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type fetcherOptions struct {
+	httpClient      *http.Client
+	refreshInterval time.Duration
+	cacheTTL        time.Duration
+	logger          Logger
+	// allowedIssuers are the only issuers whose keys are resolved. Nil allows all issuers
+	allowedIssuers map[string]bool
+	retryPolicy    RetryPolicy
+	region         string
+	headerRefresh  *HeaderRefresh
+}
+
+const defaultRefreshInterval = 24 * time.Hour
+
+func newFetcherOptions(opts []Option) fetcherOptions {
+	options := fetcherOptions{refreshInterval: defaultRefreshInterval, retryPolicy: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// WithHTTPClient fetches keys with the client, e.g. with its timeout, proxy or TLS configuration, and makes the identity token exchange
+// of ServiceAccountIDTokenSource with it. Providers with their own HTTPClient use it instead, and providers with their own Transport
+// use it with the client's other settings
+func WithHTTPClient(client *http.Client) Option {
+	return func(options *fetcherOptions) {
+		options.httpClient = client
+	}
+}
+
+// WithRefreshInterval refreshes all cached keys every interval instead of every 24 hours
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(options *fetcherOptions) {
+		if interval > 0 {
+			options.refreshInterval = interval
+		}
+	}
+}
+
+// WithCacheTTL is the CacheTTL of providers without their own
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(options *fetcherOptions) {
+		options.cacheTTL = ttl
+	}
+}
+
+// WithLogger logs the errors of background refreshes, which otherwise only surface as stale keys or through the hooks
+func WithLogger(logger Logger) Option {
+	return func(options *fetcherOptions) {
+		options.logger = logger
+	}
+}
+
+// WithAllowedIssuers resolves keys only for tokens of the issuers, failing others with ErrIssuerNotAllowed before any fetch,
+// so tokens can't make the fetcher discover arbitrary issuers
+func WithAllowedIssuers(issuers ...string) Option {
+	return func(options *fetcherOptions) {
+		options.allowedIssuers = make(map[string]bool, len(issuers))
+		for _, issuer := range issuers {
+			options.allowedIssuers[issuer] = true
+		}
+	}
+}
+
+// setOptions replaces the options of the fetcher, defaulting the ones not given
+func (f *Fetcher) setOptions(opts []Option) {
+	options := newFetcherOptions(opts)
+	f.optionsMu.Lock()
+	defer f.optionsMu.Unlock()
+	f.options = options
+}
+
+func (f *Fetcher) currentOptions() fetcherOptions {
+	f.optionsMu.RLock()
+	defer f.optionsMu.RUnlock()
+	return f.options
+}
+
+// httpClient returns a copy of the client of WithHTTPClient, with the fetcher's transport when the client has none
+func (f *Fetcher) httpClient() *http.Client {
+	var client http.Client
+	if httpClient := f.currentOptions().httpClient; httpClient != nil {
+		client = *httpClient
+	}
+	if client.Transport == nil {
+		client.Transport = f.currentFetchTransport()
+	}
+	return &client
+}
+
+func (f *Fetcher) isIssuerAllowed(issuer string) bool {
+	allowedIssuers := f.currentOptions().allowedIssuers
+	return allowedIssuers == nil || allowedIssuers[issuer]
+}
+
+// withDefaults returns the provider with the fetcher's CacheTTL and HeaderRefresh unless it has its own
+func (f *Fetcher) withDefaults(jwkProvider JWKProvider) JWKProvider {
+	options := f.currentOptions()
+	if jwkProvider.CacheTTL <= 0 {
+		jwkProvider.CacheTTL = options.cacheTTL
+	}
+	if jwkProvider.HeaderRefresh == nil {
+		jwkProvider.HeaderRefresh = options.headerRefresh
+	}
+	return jwkProvider
+}
+
+func (f *Fetcher) logf(format string, v ...interface{}) {
+	if logger := f.currentOptions().logger; logger != nil {
+		logger.Printf(format, v...)
+	}
+}
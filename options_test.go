@@ -0,0 +1,106 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// recordingLogger records the logged lines
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestOptions(t *testing.T) {
+	var requests int32
+	var failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	var clientRequests int32
+	logger := &recordingLogger{}
+	jwkProvider := JWKProvider{Issuer: server.URL, JWKURL: server.URL + "/jwks"}
+	f, err := New([]JWKProvider{jwkProvider},
+		WithHTTPClient(&http.Client{Transport: countingTransport{requests: &clientRequests}, Timeout: time.Minute}),
+		WithRefreshInterval(time.Hour),
+		WithCacheTTL(time.Nanosecond),
+		WithLogger(logger),
+		WithAllowedIssuers(server.URL))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	if f.refreshJob.interval != time.Hour {
+		t.Errorf("WithRefreshInterval() scheduled the refresh every %v, want %v", f.refreshJob.interval, time.Hour)
+	}
+
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": server.URL}
+	fetched := atomic.LoadInt32(&requests)
+	if _, err := f.FromIssuerClaim()(token); err != nil {
+		t.Fatalf("FromIssuerClaim() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got == fetched {
+		t.Errorf("WithCacheTTL() didn't expire the provider's keys")
+	}
+	if got, want := atomic.LoadInt32(&clientRequests), atomic.LoadInt32(&requests); got != want {
+		t.Errorf("WithHTTPClient() client sent %d requests, want %d", got, want)
+	}
+
+	other := mockToken()
+	other.Claims = jwt.MapClaims{"iss": "https://other.example.com"}
+	fetched = atomic.LoadInt32(&requests)
+	if _, err := f.FromIssuerClaim()(other); !errors.Is(err, ErrIssuerNotAllowed) {
+		t.Errorf("FromIssuerClaim() of an issuer not allowed error = %v, want %v", err, ErrIssuerNotAllowed)
+	}
+	if got := atomic.LoadInt32(&requests); got != fetched {
+		t.Errorf("FromIssuerClaim() of an issuer not allowed made %d requests", got-fetched)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	f.refreshCaches()
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.lines) == 0 || !strings.Contains(logger.lines[0], server.URL) {
+		t.Errorf("WithLogger() logged %q, want the refresh errors", logger.lines)
+	}
+}
+
+func TestInitResetsOptions(t *testing.T) {
+	f, err := New(nil, WithRefreshInterval(time.Hour), WithAllowedIssuers("https://example.com"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+	if err := f.Init(nil); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if f.refreshJob.interval != defaultRefreshInterval || !f.isIssuerAllowed("https://other.example.com") {
+		t.Errorf("Init() without options kept the options of the previous call")
+	}
+}
@@ -0,0 +1,35 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrPanic is returned instead of crashing when resolving a key panics, e.g. on a malformed token
+var ErrPanic = errors.New("Recovered from panic while resolving key")
+
+// PanicEvent describes a recovered panic
+type PanicEvent struct {
+	Value interface{}
+	Stack []byte
+}
+
+// recoverPanic converts a panic into ErrPanic and reports it to the OnPanic hook. It must be deferred directly
+func (f *Fetcher) recoverPanic(err *error) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	f.deliverPanicHook(PanicEvent{Value: value, Stack: debug.Stack()})
+	*err = fmt.Errorf("%w: %v", ErrPanic, value)
+}
+
+func (f *Fetcher) safeKeyFunc(keyFunc jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (key interface{}, err error) {
+		defer f.recoverPanic(&err)
+		return keyFunc(token)
+	}
+}
@@ -0,0 +1,70 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyFuncPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyFunc jwt.Keyfunc
+	}{
+		{name: "FromIssuerClaim", keyFunc: FromIssuerClaim()},
+		{name: "FromDiscoverURL", keyFunc: FromDiscoverURL("http://localhost:8888/.well-known/openid-configuration")},
+		{name: "FromJWKsURL", keyFunc: FromJWKsURL("http://localhost:8888/jwks")},
+		{name: "FromVCIssuerClaim", keyFunc: FromVCIssuerClaim()},
+		{name: "FromDIDIssuerClaim", keyFunc: FromDIDIssuerClaim()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event *PanicEvent
+			SetHooks(Hooks{OnPanic: func(e PanicEvent) {
+				event = &e
+			}})
+			defer SetHooks(Hooks{})
+
+			_, err := tt.keyFunc(nil)
+			if !errors.Is(err, ErrPanic) {
+				t.Errorf("keyFunc() error = %v, want %v", err, ErrPanic)
+			}
+			if event == nil || len(event.Stack) == 0 {
+				t.Errorf("OnPanic() event = %v, want event with stack", event)
+			}
+		})
+	}
+}
+
+func FuzzKeyFuncs(f *testing.F) {
+	f.Add([]byte(`{"alg":"RS256","kid":"512fe2ae0e60bd03084b12885b41423f"}`), []byte(`{"iss":"http://localhost:8888"}`))
+	f.Add([]byte(`{"kid":5}`), []byte(`{"iss":{"nested":null}}`))
+	f.Add([]byte(`{"kid":null,"x5t":[]}`), []byte(`{"iss":"did:web:%zz"}`))
+	f.Add([]byte(`null`), []byte(`{"iss":"https://[::1"}`))
+
+	if err := SetAllowedHosts("fuzz.invalid"); err != nil {
+		f.Fatalf("SetAllowedHosts() error = %v", err)
+	}
+	defer SetAllowedHosts()
+	keyFuncs := []jwt.Keyfunc{FromIssuerClaim(), FromVCIssuerClaim(), FromDIDIssuerClaim()}
+
+	f.Fuzz(func(t *testing.T, header, claims []byte) {
+		token := &jwt.Token{Method: jwt.SigningMethodRS256}
+		json.Unmarshal(header, &token.Header)
+		var mapClaims jwt.MapClaims
+		json.Unmarshal(claims, &mapClaims)
+		token.Claims = mapClaims
+
+		for _, keyFunc := range keyFuncs {
+			if _, err := keyFunc(token); errors.Is(err, ErrPanic) {
+				t.Errorf("keyFunc() panicked: %v", err)
+			}
+		}
+		if _, err := ResolveKey(context.Background(), token); errors.Is(err, ErrPanic) {
+			t.Errorf("ResolveKey() panicked: %v", err)
+		}
+	})
+}
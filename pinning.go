@@ -0,0 +1,89 @@
+package jwkfetch
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+// ErrSPKIPinMismatch is returned when the certificate chain of a JWKS endpoint doesn't match any of the provider's SPKI pins
+var ErrSPKIPinMismatch = errors.New("Certificate chain doesn't match any SPKI pin")
+
+var errPinnedTransportUnsupported = errors.New("SPKI pins require the provider's Transport to be an *http.Transport")
+
+// PinFailure describes a TLS handshake rejected by a provider's SPKI pins
+type PinFailure struct {
+	Issuer string
+	Host   string
+	// Caller is the caller of the failed fetch identified with WithCaller
+	Caller string
+}
+
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// pinnedTransport returns the provider's transport verifying its SPKI pins during the TLS handshake
+func (f *Fetcher) pinnedTransport(jwkProvider JWKProvider) http.RoundTripper {
+	var transport *http.Transport
+	base := jwkProvider.Transport
+	if base == nil {
+		base = f.currentFetchTransport()
+	}
+	switch base := base.(type) {
+	case *http.Transport:
+		transport = base.Clone()
+	default:
+		return failingTransport{err: errPinnedTransportUnsupported}
+	}
+
+	pins := make(map[string]bool)
+	for _, pin := range jwkProvider.SPKIPins {
+		pins[pin] = true
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if pins[spkiHash(cert)] {
+					return nil
+				}
+			}
+		}
+		return ErrSPKIPinMismatch
+	}
+	return &pinningTransport{issuer: jwkProvider.Issuer, base: transport}
+}
+
+// pinningTransport reports the pin failures of its base transport to the OnPinFailure hook
+type pinningTransport struct {
+	issuer string
+	base   *http.Transport
+}
+
+func (t *pinningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if errors.Is(err, ErrSPKIPinMismatch) {
+		f := fetcherFrom(req.Context())
+		if onPinFailure := f.currentHooks().OnPinFailure; onPinFailure != nil {
+			event := PinFailure{Issuer: t.issuer, Host: req.URL.Host, Caller: CallerFrom(req.Context())}
+			f.deliverHook(t.issuer, func() { onPinFailure(event) })
+		}
+	}
+	return resp, err
+}
+
+// spkiHash is the base64 encoded SHA-256 hash of the certificate's SubjectPublicKeyInfo
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestSPKIPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+	serverPin := spkiHash(server.Certificate())
+	serverURL, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		name        string
+		pins        []string
+		transport   http.RoundTripper
+		wantErr     bool
+		wantFailure *PinFailure
+	}{
+		{name: "Matching pin", pins: []string{serverPin}, transport: server.Client().Transport},
+		{name: "Rotated pin", pins: []string{"bmV4dC1waW4=", serverPin}, transport: server.Client().Transport},
+		{name: "Mismatching pin", pins: []string{"b3RoZXItcGlu"}, transport: server.Client().Transport, wantErr: true, wantFailure: &PinFailure{Issuer: server.URL, Host: serverURL.Host}},
+		{name: "Unsupported transport", pins: []string{serverPin}, transport: failingTransport{err: errors.New("unused")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failure *PinFailure
+			SetHooks(Hooks{OnPinFailure: func(f PinFailure) {
+				failure = &f
+			}})
+			defer SetHooks(Hooks{})
+
+			jwkProvider := JWKProvider{
+				Issuer:    server.URL,
+				JWKURL:    server.URL + "/jwks",
+				Transport: tt.transport,
+				SPKIPins:  tt.pins,
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defaultFetcher.registerClient(jwkProvider)
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.deleteClient(jwkProvider.JWKURL)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			got, err := FromIssuerClaim()(token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+			if !reflect.DeepEqual(failure, tt.wantFailure) {
+				t.Errorf("OnPinFailure() = %v, want %v", failure, tt.wantFailure)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// HostNotAllowedError is returned for fetches of hosts that don't match the allowed hosts
+type HostNotAllowedError struct {
+	URL  string
+	Host string
+	// Caller is the caller of the blocked fetch identified with WithCaller
+	Caller string
+}
+
+func (e *HostNotAllowedError) Error() string {
+	return fmt.Sprintf("Host %s is not allowed", e.Host)
+}
+
+// ErrJWKsURINotAllowed is returned for discovery documents whose jwks_uri is rejected by the JWKsURIPolicy
+var ErrJWKsURINotAllowed = errors.New("Discovery document jwks_uri is not allowed")
+
+// JWKsURIPolicy restricts the jwks_uri of discovery documents
+type JWKsURIPolicy struct {
+	// RequireHTTPS rejects jwks_uri that doesn't use https
+	RequireHTTPS bool `json:"require_https"`
+	// RequireSameHost rejects jwks_uri that isn't on the host of the discovery document, which is the issuer's host unless DiscoverURL is configured, or on one of AllowedHosts
+	RequireSameHost bool `json:"require_same_host"`
+	// AllowedHosts are additional jwks_uri host patterns matched with path.Match
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+}
+
+// SetJWKsURIPolicy is Fetcher.SetJWKsURIPolicy of the default fetcher
+func SetJWKsURIPolicy(policy JWKsURIPolicy) error {
+	return defaultFetcher.SetJWKsURIPolicy(policy)
+}
+
+// SetJWKsURIPolicy sets the policy the jwks_uri of discovery documents must follow. The zero policy allows any jwks_uri
+func (f *Fetcher) SetJWKsURIPolicy(policy JWKsURIPolicy) error {
+	for _, pattern := range policy.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Error while parsing host pattern %q: %v", pattern, err)
+		}
+	}
+	f.policyMu.Lock()
+	defer f.policyMu.Unlock()
+	f.jwksURIPolicy = policy
+	return nil
+}
+
+func (f *Fetcher) checkJWKsURI(discoverURL, jwksURL string) error {
+	f.policyMu.RLock()
+	policy := f.jwksURIPolicy
+	f.policyMu.RUnlock()
+
+	parsedJWKsURL, err := url.Parse(jwksURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKsURINotAllowed, err)
+	}
+	if policy.RequireHTTPS && parsedJWKsURL.Scheme != "https" {
+		return fmt.Errorf("%w: %s doesn't use https", ErrJWKsURINotAllowed, jwksURL)
+	}
+	if !policy.RequireSameHost {
+		return nil
+	}
+
+	host := strings.ToLower(parsedJWKsURL.Hostname())
+	parsedDiscoverURL, err := url.Parse(discoverURL)
+	if err == nil && strings.ToLower(parsedDiscoverURL.Hostname()) == host {
+		return nil
+	}
+	for _, pattern := range policy.AllowedHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s isn't on the host of %s", ErrJWKsURINotAllowed, jwksURL, discoverURL)
+}
+
+// SetAllowedHosts is Fetcher.SetAllowedHosts of the default fetcher
+func SetAllowedHosts(patterns ...string) error {
+	return defaultFetcher.SetAllowedHosts(patterns...)
+}
+
+// SetAllowedHosts restricts all fetches of the fetcher, including URLs derived from token claims, to hostnames matching one of the patterns.
+// Patterns are matched with path.Match, e.g. "*.example.com". No patterns allows all hosts
+func (f *Fetcher) SetAllowedHosts(patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Error while parsing host pattern %q: %v", pattern, err)
+		}
+	}
+	f.policyMu.Lock()
+	defer f.policyMu.Unlock()
+	f.allowedHosts = append([]string(nil), patterns...)
+	return nil
+}
+
+// checkHost returns a *HostNotAllowedError and notifies the OnHostNotAllowed hook when the URL's host isn't allowed
+// for the fetcher of the context. In forensic mode no host is allowed
+func checkHost(ctx context.Context, fetchURL string) error {
+	if err := checkForensicMode(ctx, fetchURL); err != nil {
+		return err
+	}
+	f := fetcherFrom(ctx)
+	f.policyMu.RLock()
+	patterns := f.allowedHosts
+	f.policyMu.RUnlock()
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(fetchURL)
+	if err != nil {
+		return fmt.Errorf("Error while parsing url: %v", err)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return nil
+		}
+	}
+
+	hostErr := &HostNotAllowedError{URL: fetchURL, Host: host, Caller: CallerFrom(ctx)}
+	if onHostNotAllowed := f.currentHooks().OnHostNotAllowed; onHostNotAllowed != nil {
+		event := *hostErr
+		f.deliverHook(host, func() { onHostNotAllowed(event) })
+	}
+	return hostErr
+}
+
+// policyTransport checks every request, including redirects, against the allowed hosts
+type policyTransport struct {
+	base http.RoundTripper
+}
+
+func (t policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkHost(req.Context(), req.URL.String()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withPolicyTransport returns a copy of the client checking its requests against the allowed hosts, for fetches outside the key fetches
+func withPolicyTransport(client *http.Client) *http.Client {
+	copied := *client
+	base := copied.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	copied.Transport = policyTransport{base: base}
+	return &copied
+}
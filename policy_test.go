@@ -0,0 +1,231 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSetAllowedHosts(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, discoverResponse)
+		case "/jwks":
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+		case "/redirect":
+			http.Redirect(w, r, "http://127.0.0.1:8888/jwks", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		patterns    []string
+		keyFunc     func(*testing.T) (interface{}, error)
+		wantBlocked *HostNotAllowedError
+		wantHostErr bool
+	}{
+		{
+			name:     "No patterns",
+			patterns: nil,
+			keyFunc: func(t *testing.T) (interface{}, error) {
+				return FromIssuerClaim()(mockToken())
+			},
+		},
+		{
+			name:     "Allowed host",
+			patterns: []string{"*.example.com", "localhost"},
+			keyFunc: func(t *testing.T) (interface{}, error) {
+				return FromIssuerClaim()(mockToken())
+			},
+		},
+		{
+			name:     "Host derived from iss",
+			patterns: []string{"*.example.com"},
+			keyFunc: func(t *testing.T) (interface{}, error) {
+				return FromIssuerClaim()(mockToken())
+			},
+			wantBlocked: &HostNotAllowedError{URL: fmt.Sprintf("http://%s/.well-known/openid-configuration", httptestServerURL), Host: "localhost"},
+			wantHostErr: true,
+		},
+		{
+			name:     "Redirect",
+			patterns: []string{"localhost"},
+			keyFunc: func(t *testing.T) (interface{}, error) {
+				jwksURL := fmt.Sprintf("http://%s/redirect", httptestServerURL)
+				defer defaultFetcher.jwksCache.delete(jwksURL)
+				return FromJWKsURL(jwksURL)(mockToken())
+			},
+			wantBlocked: &HostNotAllowedError{URL: "http://127.0.0.1:8888/jwks", Host: "127.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var blocked *HostNotAllowedError
+			SetHooks(Hooks{OnHostNotAllowed: func(e HostNotAllowedError) {
+				blocked = &e
+			}})
+			defer SetHooks(Hooks{})
+			if err := SetAllowedHosts(tt.patterns...); err != nil {
+				t.Fatalf("SetAllowedHosts() error = %v", err)
+			}
+			defer SetAllowedHosts()
+			defer InvalidateAll()
+
+			got, err := tt.keyFunc(t)
+			if tt.wantBlocked == nil {
+				if err != nil {
+					t.Fatalf("keyFunc() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, mockKey()) {
+					t.Errorf("keyFunc() = %v, want %v", got, mockKey())
+				}
+			} else if err == nil {
+				t.Fatalf("keyFunc() error = nil, want error")
+			}
+			if !reflect.DeepEqual(blocked, tt.wantBlocked) {
+				t.Errorf("OnHostNotAllowed() = %v, want %v", blocked, tt.wantBlocked)
+			}
+			if tt.wantHostErr {
+				var hostErr *HostNotAllowedError
+				if !errors.As(err, &hostErr) {
+					t.Errorf("keyFunc() error = %v, want *HostNotAllowedError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetAllowedHostsOtherRequests(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	credentialsJSON, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "jwks-reader@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+		"token_uri":    "http://127.0.0.1:8888/token",
+	})
+	tokenSource, err := ServiceAccountIDTokenSource(credentialsJSON)
+	if err != nil {
+		t.Fatalf("ServiceAccountIDTokenSource() error = %v", err)
+	}
+	t.Setenv("GCE_METADATA_HOST", "127.0.0.1:8888")
+
+	tests := []struct {
+		name    string
+		request func() error
+	}{
+		{
+			name: "Consul catalog",
+			request: func() error {
+				_, err := ConsulResolver{Address: "http://127.0.0.1:8888", Service: "auth"}.ResolveJWKURLs(context.Background())
+				return err
+			},
+		},
+		{
+			name: "Metadata server",
+			request: func() error {
+				_, err := MetadataIDTokenSource(context.Background(), "https://jwks.example.com")
+				return err
+			},
+		},
+		{
+			name: "Token exchange",
+			request: func() error {
+				_, err := tokenSource(context.Background(), "https://jwks.example.com")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetAllowedHosts("localhost"); err != nil {
+				t.Fatalf("SetAllowedHosts() error = %v", err)
+			}
+			defer SetAllowedHosts()
+
+			var hostErr *HostNotAllowedError
+			if err := tt.request(); !errors.As(err, &hostErr) || hostErr.Host != "127.0.0.1" {
+				t.Errorf("request error = %v, want *HostNotAllowedError of 127.0.0.1", err)
+			}
+		})
+	}
+}
+
+func TestSetAllowedHostsInvalidPattern(t *testing.T) {
+	if err := SetAllowedHosts("[invalid"); err == nil {
+		t.Errorf("SetAllowedHosts() error = nil, want error")
+	}
+}
+
+func TestCheckJWKsURI(t *testing.T) {
+	const discoverURL = "https://login.example.com/.well-known/openid-configuration"
+	tests := []struct {
+		name    string
+		policy  JWKsURIPolicy
+		jwksURL string
+		wantErr bool
+	}{
+		{name: "Zero policy", jwksURL: "http://keys.other.com/jwks"},
+		{name: "HTTPS", policy: JWKsURIPolicy{RequireHTTPS: true}, jwksURL: "https://keys.other.com/jwks"},
+		{name: "Not HTTPS", policy: JWKsURIPolicy{RequireHTTPS: true}, jwksURL: "http://login.example.com/jwks", wantErr: true},
+		{name: "Same host", policy: JWKsURIPolicy{RequireSameHost: true}, jwksURL: "https://LOGIN.example.com/jwks"},
+		{name: "Other host", policy: JWKsURIPolicy{RequireSameHost: true}, jwksURL: "https://keys.other.com/jwks", wantErr: true},
+		{name: "Allowed host", policy: JWKsURIPolicy{RequireSameHost: true, AllowedHosts: []string{"*.other.com"}}, jwksURL: "https://keys.other.com/jwks"},
+		{name: "Lookalike host", policy: JWKsURIPolicy{RequireSameHost: true}, jwksURL: "https://login.example.com.evil.com/jwks", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetJWKsURIPolicy(tt.policy); err != nil {
+				t.Fatalf("SetJWKsURIPolicy() error = %v", err)
+			}
+			defer SetJWKsURIPolicy(JWKsURIPolicy{})
+
+			err := defaultFetcher.checkJWKsURI(discoverURL, tt.jwksURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("defaultFetcher.checkJWKsURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrJWKsURINotAllowed) {
+				t.Errorf("defaultFetcher.checkJWKsURI() error = %v, want %v", err, ErrJWKsURINotAllowed)
+			}
+		})
+	}
+}
+
+func TestJWKsURIPolicyFromDiscoverURL(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jwks_uri": "http://keys.example.com/jwks"}`)
+	}))
+	defer server.Close()
+
+	if err := SetJWKsURIPolicy(JWKsURIPolicy{RequireSameHost: true}); err != nil {
+		t.Fatalf("SetJWKsURIPolicy() error = %v", err)
+	}
+	defer SetJWKsURIPolicy(JWKsURIPolicy{})
+
+	discoverURL := fmt.Sprintf("http://%s/.well-known/openid-configuration", httptestServerURL)
+	defer defaultFetcher.discoverURLsCache.delete(discoverURL)
+	if _, err := FromDiscoverURL(discoverURL)(mockToken()); !errors.Is(err, ErrJWKsURINotAllowed) {
+		t.Errorf("FromDiscoverURL() error = %v, want %v", err, ErrJWKsURINotAllowed)
+	}
+}
@@ -0,0 +1,70 @@
+package jwkfetch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Well-known issuers and their JWKs URLs
+const (
+	AppleIssuer  = "https://appleid.apple.com"
+	AppleJWKsURL = "https://appleid.apple.com/auth/keys"
+
+	GoogleIssuer  = "https://accounts.google.com"
+	GoogleJWKsURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+	GitHubActionsIssuer  = "https://token.actions.githubusercontent.com"
+	GitHubActionsJWKsURL = "https://token.actions.githubusercontent.com/.well-known/jwks"
+
+	GitLabIssuer  = "https://gitlab.com"
+	GitLabJWKsURL = "https://gitlab.com/oauth/discovery/keys"
+
+	PayPalIssuer  = "https://www.paypal.com"
+	PayPalJWKsURL = "https://api.paypal.com/v1/oauth2/certs"
+)
+
+const microsoftLoginURL = "https://login.microsoftonline.com"
+
+// AppleProvider returns provider config for Sign in with Apple
+func AppleProvider() JWKProvider {
+	return JWKProvider{Issuer: AppleIssuer, JWKURL: AppleJWKsURL}
+}
+
+// GoogleProvider returns provider config for Google. Note that Google ID tokens may also have iss claim accounts.google.com (without scheme)
+func GoogleProvider() JWKProvider {
+	return JWKProvider{Issuer: GoogleIssuer, JWKURL: GoogleJWKsURL}
+}
+
+// MicrosoftProvider returns provider config for Microsoft identity platform v2.0 tokens of the given tenant id
+func MicrosoftProvider(tenantID string) JWKProvider {
+	return JWKProvider{
+		Issuer:      fmt.Sprintf("%s/%s/v2.0", microsoftLoginURL, tenantID),
+		DiscoverURL: fmt.Sprintf("%s/%s/v2.0/.well-known/openid-configuration", microsoftLoginURL, tenantID),
+		JWKURL:      fmt.Sprintf("%s/%s/discovery/v2.0/keys", microsoftLoginURL, tenantID),
+	}
+}
+
+// GitHubActionsProvider returns provider config for GitHub Actions OIDC tokens.
+// Pass enterprise slug for enterprises that use a unique issuer URL, or empty string for the default issuer
+func GitHubActionsProvider(enterprise string) JWKProvider {
+	if enterprise == "" {
+		return JWKProvider{Issuer: GitHubActionsIssuer, JWKURL: GitHubActionsJWKsURL}
+	}
+	issuer := fmt.Sprintf("%s/%s", GitHubActionsIssuer, enterprise)
+	return JWKProvider{Issuer: issuer, JWKURL: issuer + "/.well-known/jwks"}
+}
+
+// GitLabProvider returns provider config for GitLab CI OIDC tokens.
+// Pass the URL of a self-managed GitLab instance, or empty string for gitlab.com
+func GitLabProvider(gitlabURL string) JWKProvider {
+	if gitlabURL == "" {
+		return JWKProvider{Issuer: GitLabIssuer, JWKURL: GitLabJWKsURL}
+	}
+	issuer := strings.TrimSuffix(gitlabURL, "/")
+	return JWKProvider{Issuer: issuer, JWKURL: issuer + "/oauth/discovery/keys"}
+}
+
+// PayPalProvider returns provider config for Log in with PayPal
+func PayPalProvider() JWKProvider {
+	return JWKProvider{Issuer: PayPalIssuer, JWKURL: PayPalJWKsURL}
+}
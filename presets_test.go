@@ -0,0 +1,87 @@
+package jwkfetch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		name string
+		got  JWKProvider
+		want JWKProvider
+	}{
+		{
+			name: "Apple",
+			got:  AppleProvider(),
+			want: JWKProvider{
+				Issuer: "https://appleid.apple.com",
+				JWKURL: "https://appleid.apple.com/auth/keys",
+			},
+		},
+		{
+			name: "Google",
+			got:  GoogleProvider(),
+			want: JWKProvider{
+				Issuer: "https://accounts.google.com",
+				JWKURL: "https://www.googleapis.com/oauth2/v3/certs",
+			},
+		},
+		{
+			name: "Microsoft",
+			got:  MicrosoftProvider("9188040d-6c67-4c5b-b112-36a304b66dad"),
+			want: JWKProvider{
+				Issuer:      "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0",
+				DiscoverURL: "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0/.well-known/openid-configuration",
+				JWKURL:      "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/discovery/v2.0/keys",
+			},
+		},
+		{
+			name: "GitHub Actions",
+			got:  GitHubActionsProvider(""),
+			want: JWKProvider{
+				Issuer: "https://token.actions.githubusercontent.com",
+				JWKURL: "https://token.actions.githubusercontent.com/.well-known/jwks",
+			},
+		},
+		{
+			name: "GitHub Actions enterprise",
+			got:  GitHubActionsProvider("octocat-inc"),
+			want: JWKProvider{
+				Issuer: "https://token.actions.githubusercontent.com/octocat-inc",
+				JWKURL: "https://token.actions.githubusercontent.com/octocat-inc/.well-known/jwks",
+			},
+		},
+		{
+			name: "GitLab",
+			got:  GitLabProvider(""),
+			want: JWKProvider{
+				Issuer: "https://gitlab.com",
+				JWKURL: "https://gitlab.com/oauth/discovery/keys",
+			},
+		},
+		{
+			name: "GitLab self-managed",
+			got:  GitLabProvider("https://gitlab.example.com/"),
+			want: JWKProvider{
+				Issuer: "https://gitlab.example.com",
+				JWKURL: "https://gitlab.example.com/oauth/discovery/keys",
+			},
+		},
+		{
+			name: "PayPal",
+			got:  PayPalProvider(),
+			want: JWKProvider{
+				Issuer: "https://www.paypal.com",
+				JWKURL: "https://api.paypal.com/v1/oauth2/certs",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("%s provider = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
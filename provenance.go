@@ -0,0 +1,46 @@
+package jwkfetch
+
+import (
+	"time"
+)
+
+// KeyProvenance describes where and when a cached key was fetched, so audits can prove where each trusted key came from
+type KeyProvenance struct {
+	// Source is the URL the key set was fetched from, the URL of the document embedding it, e.g. of DID documents,
+	// or "inline" and "env:" and the variable name for key sets of JWKProvider.InlineJWKS and JWKProvider.JWKSEnv
+	Source string `json:"source"`
+	// DiscoverURL is the OpenID discover URL the jwks_uri was taken from. Empty if the key set was fetched from a known jwks_url
+	DiscoverURL string    `json:"discover_url,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	// ETag is the ETag of the key set response. Empty if the response had none
+	ETag string `json:"etag,omitempty"`
+	// PeerSPKIHash is the base64 encoded SHA-256 hash of the SubjectPublicKeyInfo of the certificate presented by the endpoint, as in JWKProvider.SPKIPins.
+	// Empty for key sets not fetched over TLS
+	PeerSPKIHash string `json:"peer_spki_hash,omitempty"`
+}
+
+// provenance returns the provenance of the entry's key set
+func (entry *keySetEntry) provenance() KeyProvenance {
+	provenance := KeyProvenance{
+		Source:       entry.jwksURL,
+		DiscoverURL:  entry.discoverURL,
+		FetchedAt:    entry.fetchedAt,
+		ETag:         entry.header.Get("ETag"),
+		PeerSPKIHash: entry.peerSPKIHash,
+	}
+	switch {
+	case provenance.Source == "" && entry.source != "":
+		provenance.Source = entry.source
+	case provenance.Source == "":
+		provenance.Source = entry.discoverURL
+	}
+	return provenance
+}
+
+// keyProvenance returns the provenance of the key, which is the previous source's for keys merged from an issuer migration
+func (entry *keySetEntry) keyProvenance(keyID string) KeyProvenance {
+	if entry.previousKeyIDs[keyID] {
+		return entry.previousProvenance
+	}
+	return entry.provenance()
+}
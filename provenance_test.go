@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyProvenance(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+	_, keySet := newTestKeySet(t, "inline-key")
+
+	tests := []struct {
+		name        string
+		jwkProvider JWKProvider
+		keyID       string
+		want        KeyProvenance
+	}{
+		{
+			name:        "Fetched over TLS",
+			jwkProvider: JWKProvider{Issuer: server.URL, JWKURL: server.URL + "/jwks", Transport: server.Client().Transport},
+			keyID:       "512fe2ae0e60bd03084b12885b41423f",
+			want:        KeyProvenance{Source: server.URL + "/jwks", ETag: `"v1"`, PeerSPKIHash: spkiHash(server.Certificate())},
+		},
+		{
+			name:        "Inline",
+			jwkProvider: JWKProvider{Issuer: "https://inline.example.com", InlineJWKS: []byte(keySet)},
+			keyID:       "inline-key",
+			want:        KeyProvenance{Source: "inline"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultFetcher.setProviders([]JWKProvider{tt.jwkProvider})
+			defaultFetcher.registerClient(tt.jwkProvider)
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.deleteClient(tt.jwkProvider.JWKURL)
+			defer defaultFetcher.purgeProvider(tt.jwkProvider, nil)
+
+			token := mockToken()
+			token.Header["kid"] = tt.keyID
+			token.Claims = jwt.MapClaims{"iss": tt.jwkProvider.Issuer}
+			got, err := ResolveKey(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ResolveKey() error = %v", err)
+			}
+			if time.Since(got.Provenance.FetchedAt) > time.Minute {
+				t.Errorf("ResolveKey() Provenance.FetchedAt = %v, want the time of the fetch", got.Provenance.FetchedAt)
+			}
+			got.Provenance.FetchedAt = time.Time{}
+			if got.Provenance != tt.want {
+				t.Errorf("ResolveKey() Provenance = %+v, want %+v", got.Provenance, tt.want)
+			}
+			for _, stats := range Stats() {
+				if stats.Issuer == tt.jwkProvider.Issuer && stats.Provenance.Source != tt.want.Source {
+					t.Errorf("Stats() Provenance = %+v, want %+v", stats.Provenance, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIssuerNotAllowed is returned for tokens of issuers that were removed with RemoveProvider or aren't allowed by WithAllowedIssuers
+var ErrIssuerNotAllowed = errors.New("Token issuer is not allowed")
+
+// ErrKeySetTooStale is returned when a provider's cached key set is older than its MaxStale and couldn't be fetched again
+var ErrKeySetTooStale = errors.New("Key set is older than the provider's MaxStale")
+
+// AddProvider is Fetcher.AddProvider of the default fetcher
+func AddProvider(jwkProvider JWKProvider) error {
+	return defaultFetcher.AddProvider(jwkProvider)
+}
+
+// AddProvider adds a provider at runtime. The provider keys are fetched immediately and scheduled for refresh at the provider's RefreshInterval.
+// The provider stays registered even if the immediate fetch fails, in which case keys are fetched on first use
+func (f *Fetcher) AddProvider(jwkProvider JWKProvider) error {
+	key := providerKey(jwkProvider)
+	if key == "" {
+		return fmt.Errorf("Provider must have Issuer, DiscoverURL or JWKURL")
+	}
+
+	f.providersMu.Lock()
+	for _, existing := range f.providers {
+		if providerKey(existing) == key {
+			f.providersMu.Unlock()
+			return fmt.Errorf("Provider %s already exists", key)
+		}
+	}
+	f.providers = append(f.providers, jwkProvider)
+	delete(f.removedIssuers, jwkProvider.Issuer)
+	f.registerClient(jwkProvider)
+	f.providersMu.Unlock()
+
+	if err := f.scheduleProvider(jwkProvider); err != nil {
+		return err
+	}
+
+	// the keys are fetched again rather than purged first, so keys cached for other providers stay until replaced
+	if err := f.cacheProvider(withRefresh(context.Background()), jwkProvider); err != nil {
+		return fmt.Errorf("Provider %s was added but its keys couldn't be fetched: %v", key, err)
+	}
+	f.rescheduleHeaderRefresh(jwkProvider)
+	return nil
+}
+
+// RemoveProvider is Fetcher.RemoveProvider of the default fetcher
+func RemoveProvider(issuer string) error {
+	return defaultFetcher.RemoveProvider(issuer)
+}
+
+// RemoveProvider removes the provider of the issuer, unschedules its refresh and purges its keys from all cache layers.
+// Discover and JWKs URL entries shared with other providers are kept. Subsequent tokens of the issuer fail with ErrIssuerNotAllowed
+func (f *Fetcher) RemoveProvider(issuer string) error {
+	f.providersMu.Lock()
+	var removed *JWKProvider
+	remaining := make([]JWKProvider, 0, len(f.providers))
+	for i := range f.providers {
+		if f.providers[i].Issuer == issuer && removed == nil {
+			removed = &f.providers[i]
+			continue
+		}
+		remaining = append(remaining, f.providers[i])
+	}
+	if removed == nil {
+		f.providersMu.Unlock()
+		return fmt.Errorf("Provider %s doesn't exist", issuer)
+	}
+	f.providers = remaining
+	f.removedIssuers[issuer] = true
+	f.providersMu.Unlock()
+
+	f.dropProvider(*removed, remaining)
+	f.forgetRotations(issuer)
+	return nil
+}
+
+// UpdateProvider is Fetcher.UpdateProvider of the default fetcher
+func UpdateProvider(jwkProvider JWKProvider) error {
+	return defaultFetcher.UpdateProvider(jwkProvider)
+}
+
+// UpdateProvider replaces the provider with the same Issuer, or DiscoverURL or JWKURL when it has no Issuer.
+// The keys of the replaced provider are purged and the provider's keys are fetched immediately
+func (f *Fetcher) UpdateProvider(jwkProvider JWKProvider) error {
+	key := providerKey(jwkProvider)
+	if key == "" {
+		return fmt.Errorf("Provider must have Issuer, DiscoverURL or JWKURL")
+	}
+
+	f.providersMu.Lock()
+	var replaced *JWKProvider
+	others := make([]JWKProvider, 0, len(f.providers))
+	for i := range f.providers {
+		if providerKey(f.providers[i]) == key && replaced == nil {
+			existing := f.providers[i]
+			replaced = &existing
+			f.providers[i] = jwkProvider
+			continue
+		}
+		others = append(others, f.providers[i])
+	}
+	f.providersMu.Unlock()
+	if replaced == nil {
+		return fmt.Errorf("Provider %s doesn't exist", key)
+	}
+
+	f.dropProvider(*replaced, others)
+	f.providersMu.Lock()
+	f.registerClient(jwkProvider)
+	f.providersMu.Unlock()
+
+	if err := f.scheduleProvider(jwkProvider); err != nil {
+		return err
+	}
+	if err := f.cacheProvider(withRefresh(context.Background()), jwkProvider); err != nil {
+		return fmt.Errorf("Provider %s was updated but its keys couldn't be fetched: %v", key, err)
+	}
+	f.rescheduleHeaderRefresh(jwkProvider)
+	return nil
+}
+
+// Providers is Fetcher.Providers of the default fetcher
+func Providers() []JWKProvider {
+	return defaultFetcher.Providers()
+}
+
+// Providers returns a copy of the registered providers
+func (f *Fetcher) Providers() []JWKProvider {
+	f.providersMu.RLock()
+	defer f.providersMu.RUnlock()
+	return append([]JWKProvider(nil), f.providers...)
+}
+
+// ProviderFor is Fetcher.ProviderFor of the default fetcher
+func ProviderFor(issuer string) (JWKProvider, bool) {
+	return defaultFetcher.ProviderFor(issuer)
+}
+
+// ProviderFor returns the provider registered for the issuer
+func (f *Fetcher) ProviderFor(issuer string) (JWKProvider, bool) {
+	return f.findProvider(issuer)
+}
+
+// dropProvider unschedules the provider and purges its keys and transports, keeping the ones shared with the other providers
+func (f *Fetcher) dropProvider(jwkProvider JWKProvider, others []JWKProvider) {
+	f.unscheduleProvider(jwkProvider)
+	f.recordRefreshResult(providerKey(jwkProvider), nil)
+
+	shared := f.sharedURLs(others, jwkProvider.Issuer)
+	f.purgeProvider(jwkProvider, shared)
+	fetchURLs := append([]string{jwkProvider.JWKURL, jwkProvider.DiscoverURL, jwkProvider.CrossCheckJWKURL}, regionalURLs(jwkProvider)...)
+	for _, fetchURL := range append(fetchURLs, f.forgetResolvedJWKURLs(jwkProvider)...) {
+		if !shared[fetchURL] {
+			f.deleteClient(fetchURL)
+			f.validatedCache.delete(fetchURL)
+		}
+	}
+}
+
+func (f *Fetcher) isIssuerRemoved(issuer string) bool {
+	f.providersMu.RLock()
+	defer f.providersMu.RUnlock()
+	return f.removedIssuers[issuer]
+}
+
+// sharedURLs returns the discover and JWKs URLs used by the providers and by the cached issuers other than the given one
+func (f *Fetcher) sharedURLs(providers []JWKProvider, issuer string) map[string]bool {
+	shared := make(map[string]bool)
+	for _, jwkProvider := range providers {
+		shared[jwkProvider.DiscoverURL] = true
+		shared[jwkProvider.JWKURL] = true
+		shared[jwkProvider.CrossCheckJWKURL] = true
+		for _, jwksURL := range regionalURLs(jwkProvider) {
+			shared[jwksURL] = true
+		}
+		if jwkProvider.Migration != nil {
+			shared[jwkProvider.Migration.JWKURL] = true
+			shared[jwkProvider.Migration.DiscoverURL] = true
+		}
+	}
+	for cachedIssuer, entry := range f.issuerCache.all() {
+		if cachedIssuer != issuer && entry != nil {
+			shared[entry.discoverURL] = true
+			shared[entry.jwksURL] = true
+		}
+	}
+	delete(shared, "")
+	return shared
+}
+
+// providerKey identifies provider by its issuer or, for providers without issuer, by its URL
+func providerKey(jwkProvider JWKProvider) string {
+	switch {
+	case jwkProvider.Issuer != "":
+		return jwkProvider.Issuer
+	case jwkProvider.DiscoverURL != "":
+		return jwkProvider.DiscoverURL
+	}
+	return jwkProvider.JWKURL
+}
+
+// setProviders registers a copy of the providers, which UpdateProvider and AddProvider may then modify
+func (f *Fetcher) setProviders(providers []JWKProvider) {
+	f.providersMu.Lock()
+	defer f.providersMu.Unlock()
+	f.providers = append([]JWKProvider(nil), providers...)
+}
+
+func (f *Fetcher) findProvider(issuer string) (JWKProvider, bool) {
+	f.providersMu.RLock()
+	defer f.providersMu.RUnlock()
+	for _, jwkProvider := range f.providers {
+		if jwkProvider.Issuer == issuer {
+			return jwkProvider, true
+		}
+	}
+	return JWKProvider{}, false
+}
+
+// revalidateProviderEntry fetches the issuer's key set again once the cached one is older than its TTL or the provider's MaxStale.
+// When the fetch fails the cached key set keeps being served, unless it is older than MaxStale
+func (f *Fetcher) revalidateProviderEntry(ctx context.Context, issuer string, entry *keySetEntry) (*keySetEntry, error) {
+	jwkProvider, ok := f.findProvider(issuer)
+	if !ok || f.InForensicMode() {
+		return entry, nil
+	}
+	age := elapsedSince(entry.fetchedAt)
+	ttl, expires := f.withDefaults(jwkProvider).entryTTL(entry)
+	expired := expires && age > ttl
+	tooStale := jwkProvider.MaxStale > 0 && age > jwkProvider.MaxStale
+	cutOver := !entry.migrationCutover.IsZero() && !clockNow().Before(entry.migrationCutover)
+	if !expired && !tooStale && !cutOver {
+		return entry, nil
+	}
+
+	// the cached entries are replaced only once fetched, so they are still served when the fetch fails
+	fetched, err := f.getKeySetFromIssuerCache(withRefresh(ctx), issuer)
+	if err == nil {
+		return fetched, nil
+	}
+	if tooStale {
+		return nil, fmt.Errorf("%w: %v", ErrKeySetTooStale, err)
+	}
+	if cutOver {
+		return nil, err
+	}
+	return f.issuerCache.store(issuer, entry), nil
+}
+
+// hasOwnSchedule reports whether the provider is refreshed on a schedule of its own rather than with the cached keys
+func (f *Fetcher) hasOwnSchedule(jwkProvider JWKProvider) bool {
+	return jwkProvider.RefreshInterval > 0 || f.withDefaults(jwkProvider).HeaderRefresh != nil
+}
+
+func (f *Fetcher) scheduleProvider(jwkProvider JWKProvider) error {
+	if !f.hasOwnSchedule(jwkProvider) {
+		return nil
+	}
+	return f.scheduleProviderEvery(jwkProvider, f.providerRefreshInterval(jwkProvider))
+}
+
+func (f *Fetcher) scheduleProviderEvery(jwkProvider JWKProvider, interval time.Duration) error {
+	s := f.every(interval, func() {
+		f.refreshProvider(jwkProvider)
+	})
+
+	f.providersMu.Lock()
+	if previous, ok := f.providerSchedulers[providerKey(jwkProvider)]; ok {
+		previous.Stop()
+	}
+	f.providerSchedulers[providerKey(jwkProvider)] = s
+	f.providersMu.Unlock()
+	return nil
+}
+
+func (f *Fetcher) unscheduleProvider(jwkProvider JWKProvider) {
+	f.providersMu.Lock()
+	defer f.providersMu.Unlock()
+	if c, ok := f.providerSchedulers[providerKey(jwkProvider)]; ok {
+		c.Stop()
+		delete(f.providerSchedulers, providerKey(jwkProvider))
+	}
+	delete(f.adaptiveRefreshes, providerKey(jwkProvider))
+}
+
+func (f *Fetcher) refreshProvider(jwkProvider JWKProvider) {
+	f.refreshProviderWith(withRefresh(context.Background()), jwkProvider)
+}
+
+// refreshProviderWith refreshes the provider with the context of a refresh, counting its failures for its RefreshEscalation
+func (f *Fetcher) refreshProviderWith(ctx context.Context, jwkProvider JWKProvider) {
+	if f.InForensicMode() {
+		return
+	}
+	previous := f.cachedProviderEntry(jwkProvider)
+	entry, err := f.cacheProviderEntry(ctx, jwkProvider)
+	if err != nil {
+		f.logf("Error while refreshing keys of %s: %v", providerKey(jwkProvider), err)
+	}
+	failures := f.recordRefreshResult(providerKey(jwkProvider), err)
+	switch {
+	case err != nil || entry == nil || entry.keySet == nil:
+	case f.withDefaults(jwkProvider).HeaderRefresh != nil:
+		f.rescheduleHeaderRefresh(jwkProvider)
+	default:
+		f.adaptRefreshInterval(jwkProvider, previous, entry.keySet)
+	}
+	escalation := jwkProvider.RefreshEscalation
+	if escalation == nil || escalation.FailureThreshold <= 0 {
+		return
+	}
+
+	switch {
+	case err == nil && failures >= escalation.FailureThreshold:
+		// recovered, back to the provider's RefreshInterval, or to the refresh of the cached keys when it has none
+		if escalation.RetryInterval > 0 && f.hasOwnSchedule(jwkProvider) {
+			f.scheduleProvider(jwkProvider)
+		} else if escalation.RetryInterval > 0 {
+			f.unscheduleProvider(jwkProvider)
+		}
+	case err != nil && failures == escalation.FailureThreshold:
+		if onProviderDegraded := f.currentHooks().OnProviderDegraded; onProviderDegraded != nil {
+			event := ProviderDegraded{Issuer: providerKey(jwkProvider), ConsecutiveFailures: failures, Err: err}
+			f.deliverHook(providerKey(jwkProvider), func() { onProviderDegraded(event) })
+		}
+		if escalation.RetryInterval > 0 {
+			f.scheduleProviderEvery(jwkProvider, escalation.RetryInterval)
+		}
+	}
+}
+
+// purgeProvider removes the provider's entries from all cache layers except the entries of the kept URLs
+func (f *Fetcher) purgeProvider(jwkProvider JWKProvider, keep map[string]bool) {
+	discoverURL := jwkProvider.DiscoverURL
+	if discoverURL == "" && jwkProvider.Issuer != "" && jwkProvider.JWKURL == "" {
+		discoverURL, _ = getDiscoverURL(jwkProvider.Issuer)
+	}
+
+	for _, entry := range []*keySetEntry{f.issuerCache.get(jwkProvider.Issuer), f.discoverURLsCache.get(discoverURL)} {
+		if entry != nil && entry.jwksURL != "" && !keep[entry.jwksURL] {
+			f.deleteJWKsURL(entry.jwksURL)
+		}
+	}
+	if jwkProvider.Issuer != "" {
+		f.issuerCache.delete(jwkProvider.Issuer)
+	}
+	if discoverURL != "" && !keep[discoverURL] {
+		f.discoverURLsCache.delete(discoverURL)
+	}
+	for _, jwksURL := range append([]string{jwkProvider.JWKURL, jwkProvider.CrossCheckJWKURL}, regionalURLs(jwkProvider)...) {
+		if jwksURL != "" && !keep[jwksURL] {
+			f.deleteJWKsURL(jwksURL)
+		}
+	}
+	if migration := jwkProvider.Migration; migration != nil {
+		f.purgeProvider(JWKProvider{JWKURL: migration.JWKURL, DiscoverURL: migration.DiscoverURL}, keep)
+	}
+}
+
+// deleteJWKsURL drops the key set of the JWKs URL together with its validated entry, so the next fetch isn't conditional and a
+// 304 response can't restore the dropped keys
+func (f *Fetcher) deleteJWKsURL(jwksURL string) {
+	f.jwksCache.delete(jwksURL)
+	f.validatedCache.delete(jwksURL)
+}
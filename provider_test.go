@@ -0,0 +1,491 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestAddProvider(t *testing.T) {
+	var jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/added/jwks" {
+			atomic.AddInt32(&jwksRequests, 1)
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{
+		Issuer:          fmt.Sprintf("http://%s/added", httptestServerURL),
+		JWKURL:          fmt.Sprintf("http://%s/added/jwks", httptestServerURL),
+		RefreshInterval: time.Second,
+	}
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.unscheduleProvider(jwkProvider)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+	keyFunc := FromIssuerClaim()
+
+	if _, err := keyFunc(token); err == nil {
+		t.Fatalf("FromIssuerClaim() resolved key of provider that wasn't added yet")
+	}
+
+	if err := AddProvider(jwkProvider); err != nil {
+		t.Fatalf("AddProvider() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&jwksRequests); got != 1 {
+		t.Errorf("AddProvider() fetched jwks %d times, want 1", got)
+	}
+
+	got, err := keyFunc(token)
+	if err != nil {
+		t.Fatalf("FromIssuerClaim() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, mockKey()) {
+		t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+	}
+
+	if err := AddProvider(jwkProvider); err == nil {
+		t.Errorf("AddProvider() of existing provider error = nil, want error")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&jwksRequests) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&jwksRequests); got < 2 {
+		t.Errorf("Provider wasn't refreshed at its RefreshInterval, jwks fetched %d times", got)
+	}
+}
+
+func TestAddProviderKeepsSharedKeys(t *testing.T) {
+	var failing int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+	jwksURL := fmt.Sprintf("http://%s/shared/jwks", httptestServerURL)
+	existing := JWKProvider{Issuer: fmt.Sprintf("http://%s/existing", httptestServerURL), JWKURL: jwksURL}
+	defaultFetcher.setProviders([]JWKProvider{existing})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(existing, nil)
+	if err := defaultFetcher.cacheProvider(context.Background(), existing); err != nil {
+		t.Fatalf("cacheProvider() error = %v", err)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	added := JWKProvider{Issuer: fmt.Sprintf("http://%s/added", httptestServerURL), JWKURL: jwksURL}
+	if err := AddProvider(added); err == nil {
+		t.Errorf("AddProvider() while the JWKs URL fails error = nil, want error")
+	}
+	if defaultFetcher.jwksCache.get(jwksURL) == nil || defaultFetcher.issuerCache.get(existing.Issuer) == nil {
+		t.Errorf("AddProvider() dropped the keys cached for the existing provider before fetching its own")
+	}
+}
+
+func TestRemoveProvider(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/shared/jwks" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/shared/jwks", httptestServerURL)
+	removed := JWKProvider{
+		Issuer:          fmt.Sprintf("http://%s/offboarded", httptestServerURL),
+		JWKURL:          jwksURL,
+		RefreshInterval: time.Hour,
+	}
+	kept := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/kept", httptestServerURL),
+		JWKURL: jwksURL,
+	}
+	defer defaultFetcher.setProviders(nil)
+	defer delete(defaultFetcher.removedIssuers, removed.Issuer)
+	defer defaultFetcher.purgeProvider(kept, nil)
+
+	for _, jwkProvider := range []JWKProvider{removed, kept} {
+		if err := AddProvider(jwkProvider); err != nil {
+			t.Fatalf("AddProvider() error = %v", err)
+		}
+	}
+
+	if err := RemoveProvider(removed.Issuer); err != nil {
+		t.Fatalf("RemoveProvider() error = %v", err)
+	}
+	if err := RemoveProvider(removed.Issuer); err == nil {
+		t.Errorf("RemoveProvider() of removed provider error = nil, want error")
+	}
+
+	if defaultFetcher.issuerCache.get(removed.Issuer) != nil {
+		t.Errorf("RemoveProvider() didn't purge issuer cache")
+	}
+	if defaultFetcher.jwksCache.get(jwksURL) == nil {
+		t.Errorf("RemoveProvider() purged JWKs URL shared with another provider")
+	}
+	if _, ok := defaultFetcher.providerSchedulers[providerKey(removed)]; ok {
+		t.Errorf("RemoveProvider() didn't unschedule provider refresh")
+	}
+
+	keyFunc := FromIssuerClaim()
+	removedToken := mockToken()
+	removedToken.Claims = jwt.MapClaims{"iss": removed.Issuer}
+	if _, err := keyFunc(removedToken); err != ErrIssuerNotAllowed {
+		t.Errorf("FromIssuerClaim() of removed issuer error = %v, want %v", err, ErrIssuerNotAllowed)
+	}
+
+	keptToken := mockToken()
+	keptToken.Claims = jwt.MapClaims{"iss": kept.Issuer}
+	if _, err := keyFunc(keptToken); err != nil {
+		t.Errorf("FromIssuerClaim() of kept issuer error = %v", err)
+	}
+}
+
+func TestProviderCacheTTL(t *testing.T) {
+	var jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ttl/jwks" {
+			atomic.AddInt32(&jwksRequests, 1)
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		cacheTTL time.Duration
+		age      time.Duration
+		want     int32
+	}{
+		{name: "No TTL", cacheTTL: 0, age: 48 * time.Hour, want: 1},
+		{name: "Fresh entry", cacheTTL: time.Hour, age: time.Minute, want: 1},
+		{name: "Expired entry", cacheTTL: time.Hour, age: 2 * time.Hour, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&jwksRequests, 0)
+			jwkProvider := JWKProvider{
+				Issuer:   fmt.Sprintf("http://%s/ttl", httptestServerURL),
+				JWKURL:   fmt.Sprintf("http://%s/ttl/jwks", httptestServerURL),
+				CacheTTL: tt.cacheTTL,
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			keyFunc := FromIssuerClaim()
+			if _, err := keyFunc(token); err != nil {
+				t.Fatalf("FromIssuerClaim() error = %v", err)
+			}
+			defaultFetcher.issuerCache.get(jwkProvider.Issuer).fetchedAt = time.Now().Add(-tt.age)
+
+			got, err := keyFunc(token)
+			if err != nil {
+				t.Fatalf("FromIssuerClaim() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+			if got := atomic.LoadInt32(&jwksRequests); got != tt.want {
+				t.Errorf("jwks fetched %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderMaxStale(t *testing.T) {
+	var failing int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stale/jwks" && atomic.LoadInt32(&failing) == 0 {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		failing bool
+		wantErr error
+	}{
+		{name: "Stale entry is revalidated", age: 10 * time.Hour},
+		{name: "Stale entry is served on error within MaxStale", age: 2 * time.Hour, failing: true},
+		{name: "Entry older than MaxStale fails closed", age: 10 * time.Hour, failing: true, wantErr: ErrKeySetTooStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&failing, 0)
+			jwkProvider := JWKProvider{
+				Issuer:   fmt.Sprintf("http://%s/stale", httptestServerURL),
+				JWKURL:   fmt.Sprintf("http://%s/stale/jwks", httptestServerURL),
+				CacheTTL: time.Hour,
+				MaxStale: 7 * time.Hour,
+			}
+			// another provider shares the JWKs URL, whose key set a failed revalidation mustn't drop
+			sharing := JWKProvider{Issuer: fmt.Sprintf("http://%s/sharing", httptestServerURL), JWKURL: jwkProvider.JWKURL}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider, sharing})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			keyFunc := FromIssuerClaim()
+			if _, err := keyFunc(token); err != nil {
+				t.Fatalf("FromIssuerClaim() error = %v", err)
+			}
+			defaultFetcher.issuerCache.get(jwkProvider.Issuer).fetchedAt = time.Now().Add(-tt.age)
+			if tt.failing {
+				atomic.StoreInt32(&failing, 1)
+			}
+
+			got, err := keyFunc(token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromIssuerClaim() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+			if defaultFetcher.jwksCache.get(jwkProvider.JWKURL) == nil {
+				t.Errorf("revalidation dropped the key set of the JWKs URL shared with another provider")
+			}
+		})
+	}
+}
+
+func TestProviderRefresh(t *testing.T) {
+	var failing, jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/refreshed/jwks" {
+			atomic.AddInt32(&jwksRequests, 1)
+		}
+		if r.URL.Path == "/refreshed/jwks" && atomic.LoadInt32(&failing) == 0 {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		failing bool
+		wantNew bool
+	}{
+		{name: "Refreshed entry replaces the cached one", wantNew: true},
+		{name: "Cached entry is kept on error", failing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&failing, 0)
+			jwkProvider := JWKProvider{
+				Issuer: fmt.Sprintf("http://%s/refreshed", httptestServerURL),
+				JWKURL: fmt.Sprintf("http://%s/refreshed/jwks", httptestServerURL),
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+			defer defaultFetcher.recordRefreshResult(providerKey(jwkProvider), nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			keyFunc := FromIssuerClaim()
+			if _, err := keyFunc(token); err != nil {
+				t.Fatalf("FromIssuerClaim() error = %v", err)
+			}
+			cached := defaultFetcher.cachedProviderEntry(jwkProvider)
+			if tt.failing {
+				atomic.StoreInt32(&failing, 1)
+			}
+			atomic.StoreInt32(&jwksRequests, 0)
+
+			defaultFetcher.refreshProvider(jwkProvider)
+			if atomic.LoadInt32(&jwksRequests) == 0 {
+				t.Errorf("refreshProvider() didn't fetch the jwks")
+			}
+			if got := defaultFetcher.cachedProviderEntry(jwkProvider); (got != cached) != tt.wantNew {
+				t.Errorf("refreshProvider() replaced the cached entry = %v, want %v", got != cached, tt.wantNew)
+			}
+			got, err := keyFunc(token)
+			if err != nil {
+				t.Fatalf("FromIssuerClaim() after refresh error = %v", err)
+			}
+			if !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+		})
+	}
+}
+
+func TestUpdateProvider(t *testing.T) {
+	_, updatedKeySet := newTestKeySet(t, "updated-key")
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/updated/jwks":
+			body = jwkResponse
+		case "/updated/jwks-v2":
+			body = updatedKeySet
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	issuer := fmt.Sprintf("http://%s/updated", httptestServerURL)
+	original := JWKProvider{Issuer: issuer, JWKURL: issuer + "/jwks"}
+	updated := JWKProvider{Issuer: issuer, JWKURL: issuer + "/jwks-v2"}
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(updated, nil)
+
+	if err := UpdateProvider(original); err == nil {
+		t.Errorf("UpdateProvider() of missing provider error = nil, want error")
+	}
+	if err := AddProvider(original); err != nil {
+		t.Fatalf("AddProvider() error = %v", err)
+	}
+	if err := UpdateProvider(updated); err != nil {
+		t.Fatalf("UpdateProvider() error = %v", err)
+	}
+
+	if got := Providers(); !reflect.DeepEqual(got, []JWKProvider{updated}) {
+		t.Errorf("Providers() = %v, want %v", got, []JWKProvider{updated})
+	}
+	if got, ok := ProviderFor(issuer); !ok || !reflect.DeepEqual(got, updated) {
+		t.Errorf("ProviderFor() = %v, %v, want %v, true", got, ok, updated)
+	}
+	if _, ok := ProviderFor("http://unknown"); ok {
+		t.Errorf("ProviderFor() of unknown issuer found provider")
+	}
+	if defaultFetcher.jwksCache.get(original.JWKURL) != nil {
+		t.Errorf("UpdateProvider() kept the keys of the replaced provider")
+	}
+
+	token := mockToken()
+	token.Header["kid"] = "updated-key"
+	token.Claims = jwt.MapClaims{"iss": issuer}
+	if _, err := FromIssuerClaim()(token); err != nil {
+		t.Errorf("FromIssuerClaim() of updated provider key error = %v", err)
+	}
+}
+
+func TestProviderHTTPClient(t *testing.T) {
+	_, keySet := newTestKeySet(t, "client-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow/jwks" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	var providerRequests, fetcherRequests int32
+	providerTransport := countingTransport{requests: &providerRequests}
+	fetcherTransport := countingTransport{requests: &fetcherRequests}
+	tests := []struct {
+		name                 string
+		provider             JWKProvider
+		opts                 []Option
+		wantErr              bool
+		wantProviderRequests int32
+		wantFetcherRequests  int32
+	}{
+		{
+			name:                 "Provider client",
+			provider:             JWKProvider{HTTPClient: &http.Client{Transport: providerTransport}},
+			opts:                 []Option{WithHTTPClient(&http.Client{Transport: fetcherTransport})},
+			wantProviderRequests: 1,
+		},
+		{
+			name:                "Provider client without transport",
+			provider:            JWKProvider{HTTPClient: &http.Client{Timeout: time.Second}},
+			opts:                []Option{WithHTTPClient(&http.Client{Transport: fetcherTransport})},
+			wantFetcherRequests: 1,
+		},
+		{
+			name:                 "Transport replaces the provider client's",
+			provider:             JWKProvider{HTTPClient: &http.Client{Transport: fetcherTransport}, Transport: providerTransport},
+			wantProviderRequests: 1,
+		},
+		{
+			name:     "Provider client timeout",
+			provider: JWKProvider{JWKURL: server.URL + "/slow/jwks", HTTPClient: &http.Client{Timeout: 50 * time.Millisecond}},
+			wantErr:  true,
+		},
+		{
+			name:     "Provider transport keeps the fetcher client's timeout",
+			provider: JWKProvider{JWKURL: server.URL + "/slow/jwks", Transport: http.DefaultTransport},
+			opts:     []Option{WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond})},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&providerRequests, 0)
+			atomic.StoreInt32(&fetcherRequests, 0)
+			jwkProvider := tt.provider
+			jwkProvider.Issuer = server.URL
+			if jwkProvider.JWKURL == "" {
+				jwkProvider.JWKURL = server.URL + "/jwks"
+			}
+			f, err := New([]JWKProvider{jwkProvider}, tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer f.Close()
+
+			_, err = f.cacheProviderEntry(context.Background(), jwkProvider)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cacheProviderEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&providerRequests); got != tt.wantProviderRequests {
+				t.Errorf("Provider transport requests = %d, want %d", got, tt.wantProviderRequests)
+			}
+			if got := atomic.LoadInt32(&fetcherRequests); got != tt.wantFetcherRequests {
+				t.Errorf("Fetcher transport requests = %d, want %d", got, tt.wantFetcherRequests)
+			}
+		})
+	}
+}
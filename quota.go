@@ -0,0 +1,76 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRefreshQuotaExceeded is returned for unknown kids of a caller that exhausted its RefreshQuota, without fetching the key set again
+var ErrRefreshQuotaExceeded = errors.New("Caller exceeded its quota of refreshes for unknown kids")
+
+// RefreshQuota bounds the key set refreshes forced by unknown kids per caller identified with WithCaller.
+// Callers without identity share one quota
+type RefreshQuota struct {
+	// Refreshes is the number of forced refreshes allowed per caller within Interval
+	Refreshes int
+	Interval  time.Duration
+}
+
+type quotaWindow struct {
+	start     time.Time
+	refreshes int
+}
+
+// SetRefreshQuota is Fetcher.SetRefreshQuota of the default fetcher
+func SetRefreshQuota(quota RefreshQuota) {
+	defaultFetcher.SetRefreshQuota(quota)
+}
+
+// SetRefreshQuota limits the refreshes forced by the unknown kids of each caller, so one tenant's misconfigured client
+// can't make the keys of every tenant's issuers be fetched over and over. The zero RefreshQuota (the default) disables the limit
+func (f *Fetcher) SetRefreshQuota(quota RefreshQuota) {
+	f.quotaMu.Lock()
+	defer f.quotaMu.Unlock()
+	f.refreshQuota = quota
+	f.quotaWindows = make(map[string]*quotaWindow)
+}
+
+// allowForcedRefresh counts a refresh forced by an unknown kid against the quota of the context's caller
+func (f *Fetcher) allowForcedRefresh(ctx context.Context) bool {
+	f.quotaMu.Lock()
+	defer f.quotaMu.Unlock()
+	if f.refreshQuota.Refreshes <= 0 || f.refreshQuota.Interval <= 0 {
+		return true
+	}
+
+	now := clockNow()
+	caller := CallerFrom(ctx)
+	window, ok := f.quotaWindows[caller]
+	if !ok {
+		if len(f.quotaWindows) >= maxEndpoints {
+			f.dropExpiredQuotaWindows(now)
+		}
+		if len(f.quotaWindows) >= maxEndpoints {
+			caller = ""
+			window = f.quotaWindows[caller]
+		}
+	}
+	if window == nil || now.Round(0).Sub(window.start.Round(0)) >= f.refreshQuota.Interval {
+		window = &quotaWindow{start: now}
+		f.quotaWindows[caller] = window
+	}
+	if window.refreshes >= f.refreshQuota.Refreshes {
+		return false
+	}
+	window.refreshes++
+	return true
+}
+
+func (f *Fetcher) dropExpiredQuotaWindows(now time.Time) {
+	for caller, window := range f.quotaWindows {
+		if now.Round(0).Sub(window.start.Round(0)) >= f.refreshQuota.Interval {
+			delete(f.quotaWindows, caller)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestRefreshQuota(t *testing.T) {
+	var jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&jwksRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+	defer func() { clockNow = time.Now }()
+
+	SetRefreshQuota(RefreshQuota{Refreshes: 2, Interval: time.Minute})
+	defer SetRefreshQuota(RefreshQuota{})
+
+	jwkProvider := JWKProvider{
+		Issuer: fmt.Sprintf("http://%s/quota", httptestServerURL),
+		JWKURL: fmt.Sprintf("http://%s/quota/jwks", httptestServerURL),
+	}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+	if _, err := defaultFetcher.cacheProviderEntry(context.Background(), jwkProvider); err != nil {
+		t.Fatalf("cacheProviderEntry() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		caller    string
+		clock     func() time.Time
+		wantErr   error
+		wantFetch bool
+	}{
+		{name: "First miss", caller: "tenant-a", clock: time.Now, wantErr: ErrKeyNotFound, wantFetch: true},
+		{name: "Second miss", caller: "tenant-a", clock: time.Now, wantErr: ErrKeyNotFound, wantFetch: true},
+		{name: "Quota exceeded", caller: "tenant-a", clock: time.Now, wantErr: ErrRefreshQuotaExceeded, wantFetch: false},
+		{name: "Other tenant", caller: "tenant-b", clock: time.Now, wantErr: ErrKeyNotFound, wantFetch: true},
+		{name: "Next interval", caller: "tenant-a", clock: fakeClock(2 * time.Minute), wantErr: ErrKeyNotFound, wantFetch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clockNow = tt.clock
+			before := atomic.LoadInt32(&jwksRequests)
+
+			token := mockToken()
+			token.Header["kid"] = "unknown"
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			_, err := ResolveKey(WithCaller(context.Background(), tt.caller), token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ResolveKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if fetched := atomic.LoadInt32(&jwksRequests) > before; fetched != tt.wantFetch {
+				t.Errorf("ResolveKey() fetched = %v, want %v", fetched, tt.wantFetch)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxPrewarmRetryInterval = time.Minute
+
+// prewarmRetryInterval is the first interval between the prewarm retries, accessed atomically
+var prewarmRetryInterval = int64(time.Second)
+
+func setPrewarmRetryInterval(interval time.Duration) {
+	atomic.StoreInt64(&prewarmRetryInterval, int64(interval))
+}
+
+type readinessState struct {
+	mu        sync.Mutex
+	ready     chan struct{}
+	once      sync.Once
+	deadline  time.Duration
+	startedAt time.Time
+	latency   time.Duration
+	fired     bool
+}
+
+func newReadiness() *readinessState {
+	return &readinessState{ready: make(chan struct{})}
+}
+
+// SetPrewarmDeadline is Fetcher.SetPrewarmDeadline of the default fetcher
+func SetPrewarmDeadline(deadline time.Duration) {
+	defaultFetcher.SetPrewarmDeadline(deadline)
+}
+
+// SetPrewarmDeadline sets the cold-start latency budget: Ready fires when keys of all providers are cached or when the deadline passes since Init, whichever comes first.
+// Zero (the default) means Ready waits until keys of all providers are cached. Should be called before Init, or right after New since the deadline counts from Init
+func (f *Fetcher) SetPrewarmDeadline(deadline time.Duration) {
+	f.readiness.mu.Lock()
+	defer f.readiness.mu.Unlock()
+	f.readiness.deadline = deadline
+}
+
+// Ready is Fetcher.Ready of the default fetcher
+func Ready() <-chan struct{} {
+	return defaultFetcher.Ready()
+}
+
+// Ready returns a channel that is closed when keys of all providers passed to Init are cached, so servers can delay marking themselves ready until token validation will succeed
+func (f *Fetcher) Ready() <-chan struct{} {
+	return f.readiness.ready
+}
+
+// ColdStartLatency is Fetcher.ColdStartLatency of the default fetcher
+func ColdStartLatency() (time.Duration, bool) {
+	return defaultFetcher.ColdStartLatency()
+}
+
+// ColdStartLatency returns how long it took from Init until Ready fired. The second value is false if Ready hasn't fired yet
+func (f *Fetcher) ColdStartLatency() (time.Duration, bool) {
+	f.readiness.mu.Lock()
+	defer f.readiness.mu.Unlock()
+	return f.readiness.latency, f.readiness.fired
+}
+
+func (r *readinessState) start(startedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startedAt = startedAt
+}
+
+func (r *readinessState) fire() {
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.latency = time.Since(r.startedAt)
+		r.fired = true
+		r.mu.Unlock()
+		close(r.ready)
+	})
+}
+
+func (r *readinessState) deadlineAt() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.startedAt.Add(r.deadline), r.deadline > 0
+}
+
+// startPrewarm stops the prewarm of a previous Init and prewarms the providers until stopPrewarm
+func (f *Fetcher) startPrewarm(providers []JWKProvider) {
+	f.prewarmMu.Lock()
+	defer f.prewarmMu.Unlock()
+	f.stopPrewarmLocked()
+	f.prewarmStop = make(chan struct{})
+	f.prewarmWG.Add(1)
+	go func(stop <-chan struct{}) {
+		defer f.prewarmWG.Done()
+		f.prewarm(providers, stop)
+	}(f.prewarmStop)
+}
+
+// stopPrewarm stops the prewarm of the last Init and waits for it to return
+func (f *Fetcher) stopPrewarm() {
+	f.prewarmMu.Lock()
+	defer f.prewarmMu.Unlock()
+	f.stopPrewarmLocked()
+}
+
+func (f *Fetcher) stopPrewarmLocked() {
+	if f.prewarmStop != nil {
+		close(f.prewarmStop)
+		f.prewarmStop = nil
+	}
+	f.prewarmWG.Wait()
+}
+
+// prewarm retries caching the providers that failed during Init until all of them are cached, the prewarm deadline passes
+// or stop is closed. Providers removed meanwhile aren't retried
+func (f *Fetcher) prewarm(providers []JWKProvider, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	retryInterval := time.Duration(atomic.LoadInt64(&prewarmRetryInterval))
+	for {
+		providers = f.registeredProviders(providers)
+		if f.providersCached(providers) {
+			f.readiness.fire()
+			return
+		}
+
+		deadline, hasDeadline := f.readiness.deadlineAt()
+		wait := retryInterval
+		if hasDeadline {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				f.readiness.fire()
+				return
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+
+		retryInterval *= 2
+		if retryInterval > maxPrewarmRetryInterval {
+			retryInterval = maxPrewarmRetryInterval
+		}
+		for _, jwkProvider := range providers {
+			if ctx.Err() != nil {
+				return
+			}
+			if !f.providerCached(jwkProvider) {
+				f.cacheProvider(ctx, jwkProvider)
+			}
+		}
+	}
+}
+
+// registeredProviders returns the providers that are still registered, as they are currently registered
+func (f *Fetcher) registeredProviders(providers []JWKProvider) []JWKProvider {
+	registered := make(map[string]JWKProvider)
+	for _, jwkProvider := range f.Providers() {
+		registered[providerKey(jwkProvider)] = jwkProvider
+	}
+	remaining := make([]JWKProvider, 0, len(providers))
+	for _, jwkProvider := range providers {
+		if current, ok := registered[providerKey(jwkProvider)]; ok {
+			remaining = append(remaining, current)
+		}
+	}
+	return remaining
+}
+
+func (f *Fetcher) providersCached(providers []JWKProvider) bool {
+	for _, jwkProvider := range providers {
+		if !f.providerCached(jwkProvider) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fetcher) providerCached(jwkProvider JWKProvider) bool {
+	return providerKey(jwkProvider) == "" || f.cachedProviderEntry(jwkProvider) != nil
+}
+
+// cachedProviderEntry returns the cached key set of the provider, nil if it isn't cached
+func (f *Fetcher) cachedProviderEntry(jwkProvider JWKProvider) *keySetEntry {
+	switch {
+	case jwkProvider.Issuer != "":
+		return f.issuerCache.get(jwkProvider.Issuer)
+	case jwkProvider.DiscoverURL != "":
+		return f.discoverURLsCache.get(jwkProvider.DiscoverURL)
+	case jwkProvider.JWKURL != "":
+		return f.jwksCache.get(jwkProvider.JWKURL)
+	}
+	return nil
+}
+
+func (f *Fetcher) cacheProvider(ctx context.Context, jwkProvider JWKProvider) error {
+	_, err := f.cacheProviderEntry(ctx, jwkProvider)
+	return err
+}
+
+// cacheProviderEntry returns the key set of the provider, fetching it when it isn't cached or is stale
+func (f *Fetcher) cacheProviderEntry(ctx context.Context, jwkProvider JWKProvider) (*keySetEntry, error) {
+	previous := f.cachedProviderEntry(jwkProvider)
+	entry, err := f.getProviderEntry(ctx, jwkProvider)
+	if err == nil && previous != nil && entry != nil && previous.jwksURL != "" && previous.jwksURL != entry.jwksURL &&
+		!f.sharedURLs(f.Providers(), jwkProvider.Issuer)[previous.jwksURL] {
+		// the provider moved to another JWKs URL, so the key set of the previous one isn't used anymore
+		f.deleteJWKsURL(previous.jwksURL)
+	}
+	return entry, err
+}
+
+func (f *Fetcher) getProviderEntry(ctx context.Context, jwkProvider JWKProvider) (*keySetEntry, error) {
+	switch {
+	case jwkProvider.Issuer != "":
+		return f.getKeySetFromIssuerCache(ctx, jwkProvider.Issuer)
+	case jwkProvider.DiscoverURL != "":
+		return f.getKeySetFromDiscoverURLCache(ctx, jwkProvider.DiscoverURL)
+	case jwkProvider.JWKURL != "":
+		return f.getKeySetFromJWKCache(ctx, jwkProvider.JWKURL)
+	}
+	return nil, nil
+}
+
+// ProviderResult is the outcome of fetching the keys of a provider
+type ProviderResult struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer   string
+	Duration time.Duration
+	KeyCount int
+	Err      error
+}
+
+// WarmUp is Fetcher.WarmUp of the default fetcher
+func WarmUp(ctx context.Context) []ProviderResult {
+	return defaultFetcher.WarmUp(ctx)
+}
+
+// WarmUp fetches the keys of the registered providers that aren't cached yet and returns the outcome of each fetch
+func (f *Fetcher) WarmUp(ctx context.Context) []ProviderResult {
+	var results []ProviderResult
+	for _, jwkProvider := range f.Providers() {
+		if !f.providerCached(jwkProvider) {
+			results = append(results, f.fetchProvider(ctx, jwkProvider))
+		}
+	}
+	return results
+}
+
+// Refresh is Fetcher.Refresh of the default fetcher
+func Refresh(ctx context.Context) []ProviderResult {
+	return defaultFetcher.Refresh(ctx)
+}
+
+// Refresh fetches the keys of all registered providers again and returns the outcome of each fetch.
+// The cached keys of a provider are replaced once its keys are fetched, so they keep verifying tokens when its fetch fails
+func (f *Fetcher) Refresh(ctx context.Context) []ProviderResult {
+	providers := f.Providers()
+	results := make([]ProviderResult, 0, len(providers))
+	for _, jwkProvider := range providers {
+		results = append(results, f.fetchProvider(withRefresh(ctx), jwkProvider))
+	}
+	return results
+}
+
+func (f *Fetcher) fetchProvider(ctx context.Context, jwkProvider JWKProvider) ProviderResult {
+	result := ProviderResult{Issuer: providerKey(jwkProvider)}
+	start := time.Now()
+	entry, err := f.cacheProviderEntry(ctx, jwkProvider)
+	result.Duration = time.Since(start)
+	result.Err = err
+	if err == nil && entry == nil {
+		result.Err = fmt.Errorf("Provider must have Issuer, DiscoverURL or JWKURL")
+	}
+	if entry != nil && entry.keySet != nil {
+		result.KeyCount = len(entry.keySet.Keys)
+	}
+	return result
+}
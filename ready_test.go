@@ -0,0 +1,163 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	failures := 2
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready/jwks" {
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+	}))
+	defer server.Close()
+
+	defaultFetcher.readiness = newReadiness()
+	setPrewarmRetryInterval(10 * time.Millisecond)
+	defer setPrewarmRetryInterval(time.Second)
+
+	jwksURL := fmt.Sprintf("http://%s/ready/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	if err := Init([]JWKProvider{{JWKURL: jwksURL}}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	select {
+	case <-Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ready() didn't fire after the provider recovered")
+	}
+	if _, ok := ColdStartLatency(); !ok {
+		t.Errorf("ColdStartLatency() is not set after Ready() fired")
+	}
+	if defaultFetcher.jwksCache.get(jwksURL) == nil {
+		t.Errorf("Ready() fired before the provider was cached")
+	}
+}
+
+func TestSetPrewarmDeadline(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	defaultFetcher.readiness = newReadiness()
+	SetPrewarmDeadline(50 * time.Millisecond)
+	setPrewarmRetryInterval(10 * time.Millisecond)
+	defer setPrewarmRetryInterval(time.Second)
+
+	jwksURL := fmt.Sprintf("http://%s/unavailable/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	if err := Init([]JWKProvider{{JWKURL: jwksURL}}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	select {
+	case <-Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ready() didn't fire after the prewarm deadline")
+	}
+	if latency, _ := ColdStartLatency(); latency < 50*time.Millisecond {
+		t.Errorf("ColdStartLatency() = %v, want at least the prewarm deadline", latency)
+	}
+}
+
+func TestWarmUpAndRefresh(t *testing.T) {
+	fetches := 0
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/refreshed/jwks" {
+			fetches++
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/refreshed/jwks", httptestServerURL)
+	failingURL := fmt.Sprintf("http://%s/failing/jwks", httptestServerURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+	defaultFetcher.setProviders([]JWKProvider{{JWKURL: jwksURL}, {JWKURL: failingURL}})
+	defer defaultFetcher.setProviders(nil)
+
+	check := func(name string, results []ProviderResult, wantFetches int) {
+		t.Helper()
+		if len(results) != 2 {
+			t.Fatalf("%s() returned %d results, want 2", name, len(results))
+		}
+		if results[0].Issuer != jwksURL || results[0].Err != nil || results[0].KeyCount != 2 || results[0].Duration <= 0 {
+			t.Errorf("%s() result = %+v, want 2 keys of %s", name, results[0], jwksURL)
+		}
+		if results[1].Issuer != failingURL || results[1].Err == nil || results[1].KeyCount != 0 {
+			t.Errorf("%s() result = %+v, want error of %s", name, results[1], failingURL)
+		}
+		if fetches != wantFetches {
+			t.Errorf("%s() fetched %s %d times, want %d", name, jwksURL, fetches, wantFetches)
+		}
+	}
+
+	check("WarmUp", WarmUp(context.Background()), 1)
+	if results := WarmUp(context.Background()); len(results) != 1 || results[0].Issuer != failingURL {
+		t.Errorf("WarmUp() = %+v, want only the uncached provider", results)
+	}
+	check("Refresh", Refresh(context.Background()), 2)
+}
+
+func TestPrewarmStops(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	setPrewarmRetryInterval(10 * time.Millisecond)
+	defer setPrewarmRetryInterval(time.Second)
+
+	jwkProvider := JWKProvider{Issuer: server.URL, JWKURL: server.URL + "/jwks"}
+	tests := []struct {
+		name string
+		stop func(f *Fetcher) error
+	}{
+		{name: "Removed provider", stop: func(f *Fetcher) error { return f.RemoveProvider(jwkProvider.Issuer) }},
+		{name: "Later Init", stop: func(f *Fetcher) error { return f.Init(nil) }},
+		{name: "Closed", stop: func(f *Fetcher) error { f.Close(); return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFetcher()
+			defer f.Close()
+			if err := f.Init([]JWKProvider{jwkProvider}, WithRetryPolicy(RetryPolicy{Attempts: 1})); err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if err := tt.stop(f); err != nil {
+				t.Fatalf("stop error = %v", err)
+			}
+			// a retry in flight may still finish
+			time.Sleep(50 * time.Millisecond)
+			stopped := atomic.LoadInt32(&requests)
+			time.Sleep(200 * time.Millisecond)
+			if got := atomic.LoadInt32(&requests); got != stopped {
+				t.Errorf("Prewarm sent %d requests after it was stopped", got-stopped)
+			}
+		})
+	}
+}
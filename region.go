@@ -0,0 +1,61 @@
+package jwkfetch
+
+import (
+	"sort"
+	"time"
+)
+
+// WithRegion fetches the keys of providers with RegionalJWKURLs from the endpoint of the region first, e.g. the region of the deployment
+func WithRegion(region string) Option {
+	return func(options *fetcherOptions) {
+		options.region = region
+	}
+}
+
+// regionalJWKURLs orders the provider's regional endpoints: the one of the fetcher's region first, then the ones that weren't
+// fetched yet, so refreshes probe each of them, then the others by their observed median latency
+func (f *Fetcher) regionalJWKURLs(jwkProvider JWKProvider) []string {
+	region := f.currentOptions().region
+	type candidate struct {
+		region  string
+		jwksURL string
+		latency time.Duration
+		probed  bool
+	}
+	candidates := make([]candidate, 0, len(jwkProvider.RegionalJWKURLs))
+	for candidateRegion, jwksURL := range jwkProvider.RegionalJWKURLs {
+		latency, probed := endpointLatency(jwksURL)
+		candidates = append(candidates, candidate{region: candidateRegion, jwksURL: jwksURL, latency: latency, probed: probed})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case (a.region == region) != (b.region == region):
+			return a.region == region
+		case a.probed != b.probed:
+			return !a.probed
+		case a.latency != b.latency:
+			return a.latency < b.latency
+		}
+		return a.region < b.region
+	})
+	jwksURLs := make([]string, len(candidates))
+	for i, c := range candidates {
+		jwksURLs[i] = c.jwksURL
+	}
+	return jwksURLs
+}
+
+// regionalURLs are the provider's regional endpoints sorted by region
+func regionalURLs(jwkProvider JWKProvider) []string {
+	regions := make([]string, 0, len(jwkProvider.RegionalJWKURLs))
+	for region := range jwkProvider.RegionalJWKURLs {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	jwksURLs := make([]string, len(regions))
+	for i, region := range regions {
+		jwksURLs[i] = jwkProvider.RegionalJWKURLs[region]
+	}
+	return jwksURLs
+}
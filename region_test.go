@@ -0,0 +1,107 @@
+package jwkfetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegionalJWKURLs(t *testing.T) {
+	_, keySet := newTestKeySet(t, "regional-key")
+	newRegion := func(available bool, requests *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(requests, 1)
+			if !available {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, keySet)
+		}))
+	}
+	tests := []struct {
+		name       string
+		region     string
+		euUp, usUp bool
+		wantErr    bool
+		wantEU     int32
+		wantUS     int32
+	}{
+		{name: "Region's endpoint", region: "us", euUp: true, usUp: true, wantUS: 1},
+		{name: "Other region's endpoint", region: "eu", euUp: true, usUp: true, wantEU: 1},
+		{name: "Falls back to another region", region: "eu", usUp: true, wantEU: 1, wantUS: 1},
+		{name: "All regions down", region: "eu", wantErr: true, wantEU: 1, wantUS: 1},
+		{name: "Unknown region", region: "ap", euUp: true, usUp: true, wantEU: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var euRequests, usRequests int32
+			eu := newRegion(tt.euUp, &euRequests)
+			defer eu.Close()
+			us := newRegion(tt.usUp, &usRequests)
+			defer us.Close()
+
+			jwkProvider := JWKProvider{
+				Issuer:          "https://regional.example.com",
+				RegionalJWKURLs: map[string]string{"eu": eu.URL + "/jwks", "us": us.URL + "/jwks"},
+			}
+			f := newFetcher()
+			defer f.Close()
+			f.setOptions([]Option{WithRegion(tt.region), WithRetryPolicy(RetryPolicy{Attempts: 1})})
+			f.setProviders([]JWKProvider{jwkProvider})
+
+			entry, err := f.getKeySetFromIssuerCache(context.Background(), jwkProvider.Issuer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getKeySetFromIssuerCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(entry.keySet.Keys) != 1 {
+				t.Errorf("getKeySetFromIssuerCache() = %d keys, want 1", len(entry.keySet.Keys))
+			}
+			if err != nil && RejectionReasonOf(err) != RejectionIdPUnreachable {
+				t.Errorf("RejectionReasonOf(%v) = %q, want %q", err, RejectionReasonOf(err), RejectionIdPUnreachable)
+			}
+			if euRequests != tt.wantEU || usRequests != tt.wantUS {
+				t.Errorf("Requests eu = %d, us = %d, want eu = %d, us = %d", euRequests, usRequests, tt.wantEU, tt.wantUS)
+			}
+		})
+	}
+}
+
+func TestRegionalJWKURLsOrder(t *testing.T) {
+	jwkProvider := JWKProvider{RegionalJWKURLs: map[string]string{
+		"ap": "https://ap.order.example.com/jwks",
+		"eu": "https://eu.order.example.com/jwks",
+		"us": "https://us.order.example.com/jwks",
+		"sa": "https://sa.order.example.com/jwks",
+	}}
+	recordFetch("https://eu.order.example.com/jwks", 300*time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	recordFetch("https://us.order.example.com/jwks", 100*time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	recordFetch("https://sa.order.example.com/jwks", 200*time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	tests := []struct {
+		name   string
+		region string
+		want   []string
+	}{
+		{name: "Unprobed endpoints first, then by latency", want: []string{"ap", "us", "sa", "eu"}},
+		{name: "Region first", region: "eu", want: []string{"eu", "ap", "us", "sa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFetcher()
+			defer f.Close()
+			f.setOptions([]Option{WithRegion(tt.region)})
+			want := make([]string, len(tt.want))
+			for i, region := range tt.want {
+				want[i] = jwkProvider.RegionalJWKURLs[region]
+			}
+			if got := f.regionalJWKURLs(jwkProvider); !reflect.DeepEqual(got, want) {
+				t.Errorf("regionalJWKURLs() = %v, want %v", got, want)
+			}
+		})
+	}
+}
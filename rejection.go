@@ -0,0 +1,61 @@
+package jwkfetch
+
+import (
+	"errors"
+)
+
+// RejectionReason is a machine-readable category of a key resolution error, e.g. for WAFs and rate limiters that treat
+// repeated unknown kids of one client as abuse
+type RejectionReason string
+
+const (
+	// RejectionUnknownIssuer is the reason of tokens of removed or not allowed issuers
+	RejectionUnknownIssuer RejectionReason = "unknown_issuer"
+	// RejectionUnknownKid is the reason of tokens whose kid isn't in the issuer's key set
+	RejectionUnknownKid RejectionReason = "unknown_kid"
+	// RejectionAlgMismatch is the reason of tokens signed with an algorithm the issuer doesn't use
+	RejectionAlgMismatch RejectionReason = "alg_mismatch"
+	// RejectionMalformedHeader is the reason of tokens whose header is rejected by the HeaderPolicy
+	RejectionMalformedHeader RejectionReason = "malformed_header"
+	// RejectionTokenTooLarge is the reason of tokens exceeding the TokenLimits
+	RejectionTokenTooLarge RejectionReason = "token_too_large"
+	// RejectionIdPUnreachable is the reason of tokens whose keys couldn't be fetched, which isn't the client's fault
+	RejectionIdPUnreachable RejectionReason = "idp_unreachable"
+)
+
+// RejectionReasonOf returns the category of an error returned by the keyfuncs and ResolveKey, empty for uncategorized errors
+func RejectionReasonOf(err error) RejectionReason {
+	var hostErr *HostNotAllowedError
+	var fetchErr *fetchError
+	var tooLargeErr *TokenTooLargeError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrIssuerNotAllowed), errors.As(err, &hostErr):
+		return RejectionUnknownIssuer
+	case errors.As(err, &fetchErr), errors.Is(err, ErrKeySetTooStale):
+		return RejectionIdPUnreachable
+	case errors.Is(err, ErrKeyNotFound):
+		return RejectionUnknownKid
+	case errors.Is(err, ErrAlgorithmNotAllowed):
+		return RejectionAlgMismatch
+	case errors.Is(err, ErrHeaderNotAllowed):
+		return RejectionMalformedHeader
+	case errors.As(err, &tooLargeErr):
+		return RejectionTokenTooLarge
+	}
+	return ""
+}
+
+// fetchError marks errors of fetching discovery documents and key sets
+type fetchError struct {
+	err error
+}
+
+func (e *fetchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
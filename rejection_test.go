@@ -0,0 +1,79 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestRejectionReasonOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want RejectionReason
+	}{
+		{name: "No error", err: nil, want: ""},
+		{name: "Removed issuer", err: ErrIssuerNotAllowed, want: RejectionUnknownIssuer},
+		{name: "Host not allowed", err: fmt.Errorf("Error while fetching jwks: %w", &HostNotAllowedError{Host: "evil.example.com"}), want: RejectionUnknownIssuer},
+		{name: "Unknown kid", err: ErrKeyNotFound, want: RejectionUnknownKid},
+		{name: "Refresh quota exceeded", err: errors.Join(ErrKeyNotFound, ErrRefreshQuotaExceeded), want: RejectionUnknownKid},
+		{name: "Unknown kid while IdP is down", err: errors.Join(ErrKeyNotFound, &fetchError{err: errors.New("connection refused")}), want: RejectionIdPUnreachable},
+		{name: "Too stale", err: fmt.Errorf("%w: connection refused", ErrKeySetTooStale), want: RejectionIdPUnreachable},
+		{name: "Algorithm", err: fmt.Errorf("%w: HS256", ErrAlgorithmNotAllowed), want: RejectionAlgMismatch},
+		{name: "Token too large", err: &TokenTooLargeError{Part: "token", Size: 70000, Limit: 65536}, want: RejectionTokenTooLarge},
+		{name: "Header", err: fmt.Errorf("%w: cty \"JWT\"", ErrHeaderNotAllowed), want: RejectionMalformedHeader},
+		{name: "Uncategorized", err: errors.New("Token doesn't have header kid"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RejectionReasonOf(tt.err); got != tt.want {
+				t.Errorf("RejectionReasonOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectionReasonOfResolvedKeys(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down/jwks" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	up := JWKProvider{Issuer: fmt.Sprintf("http://%s/up", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/up/jwks", httptestServerURL)}
+	down := JWKProvider{Issuer: fmt.Sprintf("http://%s/down", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/down/jwks", httptestServerURL)}
+	defaultFetcher.setProviders([]JWKProvider{up, down})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(up, nil)
+	defer defaultFetcher.purgeProvider(down, nil)
+
+	tests := []struct {
+		name   string
+		issuer string
+		keyID  string
+		want   RejectionReason
+	}{
+		{name: "Unknown kid", issuer: up.Issuer, keyID: "unknown", want: RejectionUnknownKid},
+		{name: "IdP unreachable", issuer: down.Issuer, keyID: "512fe2ae0e60bd03084b12885b41423f", want: RejectionIdPUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := mockToken()
+			token.Header["kid"] = tt.keyID
+			token.Claims = jwt.MapClaims{"iss": tt.issuer}
+			_, err := FromIssuerClaim()(token)
+			if got := RejectionReasonOf(err); got != tt.want {
+				t.Errorf("RejectionReasonOf(%v) = %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
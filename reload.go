@@ -0,0 +1,109 @@
+package jwkfetch
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+)
+
+// LoadProviders loads the provider configuration again, e.g. from a file or the environment
+type LoadProviders func() ([]JWKProvider, error)
+
+// ReloadEvent is the outcome of a reload triggered by a signal
+type ReloadEvent struct {
+	Signal  os.Signal
+	Results []ProviderResult
+	Err     error
+}
+
+// Reload is Fetcher.Reload of the default fetcher
+func Reload(ctx context.Context, load LoadProviders) ([]ProviderResult, error) {
+	return defaultFetcher.Reload(ctx, load)
+}
+
+// Reload replaces the registered providers with the ones returned by load, unless load is nil, and fetches the keys of all providers again.
+// Providers missing from the loaded configuration are removed like with RemoveProvider. The providers are kept when load fails.
+// Providers loaded unchanged keep their cached keys when fetching them again fails
+func (f *Fetcher) Reload(ctx context.Context, load LoadProviders) ([]ProviderResult, error) {
+	if load != nil {
+		providers, err := load()
+		if err != nil {
+			return nil, err
+		}
+		f.replaceProviders(providers)
+	}
+	return f.Refresh(ctx), nil
+}
+
+// ReloadOnSignal is Fetcher.ReloadOnSignal of the default fetcher
+func ReloadOnSignal(ctx context.Context, load LoadProviders, signals ...os.Signal) {
+	defaultFetcher.ReloadOnSignal(ctx, load, signals...)
+}
+
+// ReloadOnSignal calls Reload whenever the process receives one of the signals, SIGHUP when none are given, until the context is done.
+// The outcome of each reload is reported to the OnReload hook
+func (f *Fetcher) ReloadOnSignal(ctx context.Context, load LoadProviders, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = reloadSignals
+	}
+	if len(signals) == 0 {
+		return
+	}
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	go func() {
+		defer signal.Stop(received)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-received:
+				results, err := f.Reload(ctx, load)
+				if onReload := f.currentHooks().OnReload; onReload != nil {
+					event := ReloadEvent{Signal: sig, Results: results, Err: err}
+					f.deliverHook("", func() { onReload(event) })
+				}
+			}
+		}
+	}()
+}
+
+// replaceProviders registers the providers instead of the current ones, dropping the keys, transports and schedules of the
+// current ones unless they are loaded unchanged
+func (f *Fetcher) replaceProviders(providers []JWKProvider) {
+	loaded := make(map[string]bool, len(providers))
+	unchanged := make(map[string]JWKProvider, len(providers))
+	for _, jwkProvider := range providers {
+		loaded[providerKey(jwkProvider)] = true
+		unchanged[providerKey(jwkProvider)] = jwkProvider
+	}
+
+	f.providersMu.Lock()
+	previous := f.providers
+	f.providers = append([]JWKProvider(nil), providers...)
+	for _, jwkProvider := range providers {
+		delete(f.removedIssuers, jwkProvider.Issuer)
+	}
+	for _, jwkProvider := range previous {
+		if jwkProvider.Issuer != "" && !loaded[jwkProvider.Issuer] {
+			f.removedIssuers[jwkProvider.Issuer] = true
+		}
+	}
+	f.providersMu.Unlock()
+
+	for _, jwkProvider := range previous {
+		if reloaded, ok := unchanged[providerKey(jwkProvider)]; ok && reflect.DeepEqual(reloaded, jwkProvider) {
+			continue
+		}
+		f.dropProvider(jwkProvider, providers)
+	}
+	f.providersMu.Lock()
+	for _, jwkProvider := range providers {
+		f.registerClient(jwkProvider)
+	}
+	f.providersMu.Unlock()
+	for _, jwkProvider := range providers {
+		f.scheduleProvider(jwkProvider)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !js
+
+package jwkfetch
+
+import (
+	"os"
+	"syscall"
+)
+
+var reloadSignals = []os.Signal{syscall.SIGHUP}
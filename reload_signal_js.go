@@ -0,0 +1,6 @@
+package jwkfetch
+
+import "os"
+
+// reloadSignals is empty since js has no signals
+var reloadSignals []os.Signal
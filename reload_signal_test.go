@@ -0,0 +1,39 @@
+//go:build unix
+
+package jwkfetch
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	events := make(chan ReloadEvent, 1)
+	SetHooks(Hooks{OnReload: func(event ReloadEvent) {
+		events <- event
+	}})
+	defer SetHooks(Hooks{})
+	defaultFetcher.setProviders([]JWKProvider{{Issuer: "https://partner.example.com", InlineJWKS: []byte(jwkResponse)}})
+	defer defaultFetcher.setProviders(nil)
+	defer Invalidate("https://partner.example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ReloadOnSignal(ctx, nil, syscall.SIGUSR1)
+
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatalf("Signal() error = %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Signal != syscall.SIGUSR1 || event.Err != nil || len(event.Results) != 1 || event.Results[0].KeyCount != 2 {
+			t.Errorf("OnReload() event = %+v, want 2 keys of the provider", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReload() wasn't called")
+	}
+}
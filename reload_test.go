@@ -0,0 +1,126 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestReload(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	kept := JWKProvider{Issuer: fmt.Sprintf("http://%s/kept", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/kept/jwks", httptestServerURL)}
+	removed := JWKProvider{Issuer: fmt.Sprintf("http://%s/removed", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/removed/jwks", httptestServerURL)}
+	added := JWKProvider{Issuer: fmt.Sprintf("http://%s/added", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/added/jwks", httptestServerURL)}
+	defaultFetcher.setProviders([]JWKProvider{kept, removed})
+	defer defaultFetcher.setProviders(nil)
+	defer func() {
+		for _, jwkProvider := range []JWKProvider{kept, removed, added} {
+			defaultFetcher.purgeProvider(jwkProvider, nil)
+			delete(defaultFetcher.removedIssuers, jwkProvider.Issuer)
+		}
+	}()
+
+	if _, err := Reload(context.Background(), func() ([]JWKProvider, error) {
+		return nil, errors.New("Invalid configuration")
+	}); err == nil {
+		t.Fatalf("Reload() error = nil, want the load error")
+	}
+	if got := len(Providers()); got != 2 {
+		t.Fatalf("Providers() after failed reload = %d providers, want 2", got)
+	}
+
+	results, err := Reload(context.Background(), func() ([]JWKProvider, error) {
+		return []JWKProvider{kept, added}, nil
+	})
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil || results[1].Issuer != added.Issuer {
+		t.Errorf("Reload() = %+v, want kept and added fetched", results)
+	}
+
+	tests := []struct {
+		name    string
+		issuer  string
+		wantErr error
+	}{
+		{name: "Kept provider", issuer: kept.Issuer},
+		{name: "Added provider", issuer: added.Issuer},
+		{name: "Removed provider", issuer: removed.Issuer, wantErr: ErrIssuerNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": tt.issuer}
+			if _, err := FromIssuerClaim()(token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("FromIssuerClaim() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsKeysOnError(t *testing.T) {
+	var failing int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{Issuer: fmt.Sprintf("http://%s/reloaded", httptestServerURL), JWKURL: fmt.Sprintf("http://%s/reloaded/jwks", httptestServerURL)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	tests := []struct {
+		name string
+		load LoadProviders
+	}{
+		{name: "Refresh only"},
+		{name: "Unchanged configuration", load: func() ([]JWKProvider, error) { return []JWKProvider{jwkProvider}, nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&failing, 0)
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			if _, err := FromIssuerClaim()(token); err != nil {
+				t.Fatalf("FromIssuerClaim() error = %v", err)
+			}
+
+			atomic.StoreInt32(&failing, 1)
+			results, err := Reload(context.Background(), tt.load)
+			if err != nil {
+				t.Fatalf("Reload() error = %v", err)
+			}
+			if len(results) != 1 || results[0].Err == nil {
+				t.Errorf("Reload() = %+v, want the fetch error", results)
+			}
+			got, err := FromIssuerClaim()(token)
+			if err != nil {
+				t.Fatalf("FromIssuerClaim() after failed reload error = %v", err)
+			}
+			if !reflect.DeepEqual(got, mockKey()) {
+				t.Errorf("FromIssuerClaim() = %v, want %v", got, mockKey())
+			}
+		})
+	}
+}
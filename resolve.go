@@ -0,0 +1,91 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// ResolvedKey is the public key that validates a JWT together with the metadata of the key and the endpoints it was fetched from
+type ResolvedKey struct {
+	// Key is the materialized public key, e.g. *rsa.PublicKey or *ecdsa.PublicKey
+	Key interface{}
+	// Issuer is the token issuer the key was resolved for
+	Issuer string
+	// KeyID is the kid of the key
+	KeyID string
+	// Algorithm is the alg of the key as published in the JWKs. Empty if the JWK has no alg
+	Algorithm string
+	// Use is the use of the key as published in the JWKs. Empty if the JWK has no use
+	Use string
+	// Certificate is the leaf certificate of the key's x5c chain. Nil if the JWK has no x5c
+	Certificate *x509.Certificate
+	// JWKsURL is the URL the key set was fetched from
+	JWKsURL string
+	// DiscoverURL is the OpenID discover URL the jwks_uri was taken from. Empty if the key set was fetched from a known jwks_url
+	DiscoverURL string
+	// FetchedAt is the time the key set was fetched
+	FetchedAt time.Time
+	// Provenance describes where the key came from, including the ETag and the TLS certificate of the response
+	Provenance KeyProvenance
+}
+
+// ResolveKey is Fetcher.ResolveKey of the default fetcher
+func ResolveKey(ctx context.Context, token *jwt.Token) (resolvedKey ResolvedKey, err error) {
+	return defaultFetcher.ResolveKey(ctx, token)
+}
+
+// ResolveKey extracts issuer from JWT token and resolves the token key the same way FromIssuerClaim does, returning the key along with its metadata
+func (f *Fetcher) ResolveKey(ctx context.Context, token *jwt.Token) (resolvedKey ResolvedKey, err error) {
+	defer f.recoverPanic(&err)
+	issuer, err := getIssuer(token)
+	if err != nil {
+		return ResolvedKey{}, err
+	}
+	return f.resolveKey(ctx, token, issuer, f.issuerCache, f.getKeySetFromIssuerCache)
+}
+
+func getIssuer(token *jwt.Token) (string, error) {
+	switch claims := token.Claims.(type) {
+	case jwt.MapClaims:
+		if issuer, ok := claims["iss"].(string); ok {
+			return issuer, nil
+		}
+	case *jwt.StandardClaims:
+		if claims.Issuer != "" {
+			return claims.Issuer, nil
+		}
+	}
+	return "", fmt.Errorf("Token doesn't have claim iss")
+}
+
+func newResolvedKey(token *jwt.Token, key jwk.Key, entry *keySetEntry) (ResolvedKey, error) {
+	materializedKey, err := key.Materialize()
+	if err != nil {
+		return ResolvedKey{}, err
+	}
+
+	resolvedKey := ResolvedKey{
+		Key:         materializedKey,
+		KeyID:       key.KeyID(),
+		Algorithm:   key.Algorithm(),
+		Use:         key.KeyUsage(),
+		JWKsURL:     entry.jwksURL,
+		DiscoverURL: entry.discoverURL,
+		FetchedAt:   entry.fetchedAt,
+		Provenance:  entry.keyProvenance(key.KeyID()),
+	}
+	if issuer, err := getIssuer(token); err == nil {
+		resolvedKey.Issuer = issuer
+	}
+	if chain, ok := key.Get(jwk.X509CertChainKey); ok {
+		if certs, ok := chain.([]*x509.Certificate); ok && len(certs) > 0 {
+			resolvedKey.Certificate = certs[0]
+		}
+	}
+	return resolvedKey, nil
+}
@@ -0,0 +1,97 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestResolveKey(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration") {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, discoverResponse)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/jwks") {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+	}))
+	defer server.Close()
+
+	issuer := fmt.Sprintf("http://%s", httptestServerURL)
+	defaultFetcher.issuerCache.delete(issuer)
+
+	type args struct {
+		token *jwt.Token
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    ResolvedKey
+		wantErr bool
+	}{
+		{
+			name: "Happy flow",
+			args: args{
+				token: mockToken(),
+			},
+			want: ResolvedKey{
+				Key:         mockKey(),
+				Issuer:      issuer,
+				KeyID:       "512fe2ae0e60bd03084b12885b41423f",
+				Algorithm:   "RS256",
+				Use:         "sig",
+				JWKsURL:     fmt.Sprintf("http://%s/jwks", httptestServerURL),
+				DiscoverURL: fmt.Sprintf("http://%s/.well-known/openid-configuration", httptestServerURL),
+				Provenance: KeyProvenance{
+					Source:      fmt.Sprintf("http://%s/jwks", httptestServerURL),
+					DiscoverURL: fmt.Sprintf("http://%s/.well-known/openid-configuration", httptestServerURL),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Token without iss claim",
+			args: args{
+				token: func() *jwt.Token {
+					tkn := mockToken()
+					tkn.Claims = jwt.MapClaims{}
+					return tkn
+				}(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveKey(context.Background(), tt.args.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.FetchedAt.IsZero() {
+				t.Errorf("ResolveKey() FetchedAt is not set")
+			}
+			got.FetchedAt = tt.want.FetchedAt
+			got.Provenance.FetchedAt = tt.want.Provenance.FetchedAt
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
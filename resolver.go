@@ -0,0 +1,182 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNoEndpoints is returned when a JWKURLResolver resolves no endpoint
+var ErrNoEndpoints = errors.New("No JWKs endpoints were resolved")
+
+// JWKURLResolver resolves the JWKs endpoints of a provider, e.g. from DNS SRV records or a service catalog
+type JWKURLResolver interface {
+	// ResolveJWKURLs returns the JWKs URLs of the provider in the order they are tried
+	ResolveJWKURLs(ctx context.Context) ([]string, error)
+}
+
+// JWKURLResolverFunc is a function resolving JWKs endpoints, e.g. with the client of another service catalog
+type JWKURLResolverFunc func(ctx context.Context) ([]string, error)
+
+// ResolveJWKURLs calls the function
+func (fn JWKURLResolverFunc) ResolveJWKURLs(ctx context.Context) ([]string, error) {
+	return fn(ctx)
+}
+
+// SRVResolver resolves the JWKs endpoints from the DNS SRV records of the service, ordered by priority and randomized by weight
+type SRVResolver struct {
+	// Service, Proto and Name are looked up as _Service._Proto.Name, e.g. jwks, tcp and auth.internal
+	Service string
+	Proto   string
+	Name    string
+	// Scheme of the endpoints, https when empty
+	Scheme string
+	// Path of the key set on the endpoints, e.g. /jwks
+	Path string
+	// Resolver looks up the records, net.DefaultResolver when nil
+	Resolver *net.Resolver
+}
+
+// ResolveJWKURLs looks up the SRV records
+func (r SRVResolver) ResolveJWKURLs(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Error while resolving SRV records of %s: %w", r.Name, err)
+	}
+	jwksURLs := make([]string, 0, len(records))
+	for _, record := range records {
+		jwksURLs = append(jwksURLs, endpointURL(r.Scheme, strings.TrimSuffix(record.Target, "."), int(record.Port), r.Path))
+	}
+	return jwksURLs, nil
+}
+
+// ConsulResolver resolves the JWKs endpoints from the passing instances of the service in the Consul catalog
+type ConsulResolver struct {
+	// Address of the Consul HTTP API, e.g. http://127.0.0.1:8500
+	Address string
+	Service string
+	// Token is the ACL token of the requests, if any
+	Token string
+	// Scheme of the endpoints, https when empty
+	Scheme string
+	// Path of the key set on the endpoints, e.g. /jwks
+	Path string
+	// Client queries the catalog, http.DefaultClient when nil
+	Client *http.Client
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// ResolveJWKURLs queries the health endpoint of the Consul HTTP API
+func (r ConsulResolver) ResolveJWKURLs(ctx context.Context) ([]string, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	client = withPolicyTransport(client)
+	healthURL := fmt.Sprintf("%s/v1/health/service/%s?passing=true", strings.TrimSuffix(r.Address, "/"), url.PathEscape(r.Service))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error while resolving Consul service %s: %v", r.Service, err)
+	}
+	if r.Token != "" {
+		req.Header.Set("X-Consul-Token", r.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error while resolving Consul service %s: %w", r.Service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error while resolving Consul service %s: unexpected status code %d", r.Service, resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Error while parsing Consul service %s: %v", r.Service, err)
+	}
+	jwksURLs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		jwksURLs = append(jwksURLs, endpointURL(r.Scheme, host, entry.Service.Port, r.Path))
+	}
+	return jwksURLs, nil
+}
+
+func endpointURL(scheme string, host string, port int, path string) string {
+	if scheme == "" {
+		scheme = "https"
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)), path)
+}
+
+// getKeySetFromResolvedJWKCache resolves the provider's JWKs endpoints and fetches the key set from the first one that can be fetched.
+// The provider's client is registered for the resolved endpoints, replacing the ones of its previous resolution
+func (f *Fetcher) getKeySetFromResolvedJWKCache(ctx context.Context, jwkProvider JWKProvider) (*keySetEntry, error) {
+	jwksURLs, err := jwkProvider.JWKURLResolver.ResolveJWKURLs(ctx)
+	if err == nil && len(jwksURLs) == 0 {
+		err = ErrNoEndpoints
+	}
+	if err != nil {
+		return nil, &fetchError{err: err}
+	}
+	f.setResolvedJWKURLs(jwkProvider, jwksURLs)
+	return f.getKeySetFromJWKCaches(ctx, jwksURLs)
+}
+
+// setResolvedJWKURLs records the provider's resolved endpoints and registers its client for them
+func (f *Fetcher) setResolvedJWKURLs(jwkProvider JWKProvider, jwksURLs []string) {
+	key := providerKey(jwkProvider)
+	f.providersMu.Lock()
+	previous := f.resolvedJWKURLs[key]
+	f.resolvedJWKURLs[key] = jwksURLs
+	f.providersMu.Unlock()
+
+	client, ok := f.newProviderClient(jwkProvider)
+	if !ok {
+		return
+	}
+	resolved := make(map[string]bool, len(jwksURLs))
+	for _, jwksURL := range jwksURLs {
+		resolved[jwksURL] = true
+		f.setClient(jwksURL, client)
+	}
+	for _, jwksURL := range previous {
+		if !resolved[jwksURL] {
+			f.deleteClient(jwksURL)
+		}
+	}
+}
+
+// forgetResolvedJWKURLs returns the provider's resolved endpoints and forgets them
+func (f *Fetcher) forgetResolvedJWKURLs(jwkProvider JWKProvider) []string {
+	f.providersMu.Lock()
+	defer f.providersMu.Unlock()
+	jwksURLs := f.resolvedJWKURLs[providerKey(jwkProvider)]
+	delete(f.resolvedJWKURLs, providerKey(jwkProvider))
+	return jwksURLs
+}
@@ -0,0 +1,198 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// serveSRV answers the DNS queries of the returned resolver with SRV records of the targets on port 443, weighted equally by priority
+func serveSRV(t *testing.T, targets ...string) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			// the question is the name's labels, its terminating zero, its type and class, without the query's additional records
+			end := 12
+			for end < n && query[end] != 0 {
+				end += int(query[end]) + 1
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			// header with the query's ID, QR, AA and RD set, one question and an answer per target
+			resp := append([]byte{query[0], query[1], 0x85, 0x00, 0, 1, 0, byte(len(targets)), 0, 0, 0, 0}, query[12:end]...)
+			for i, target := range targets {
+				var rdata []byte
+				rdata = binary.BigEndian.AppendUint16(rdata, uint16(i))
+				rdata = binary.BigEndian.AppendUint16(rdata, 0)
+				rdata = binary.BigEndian.AppendUint16(rdata, 443)
+				for _, label := range strings.Split(strings.TrimSuffix(target, "."), ".") {
+					rdata = append(append(rdata, byte(len(label))), label...)
+				}
+				rdata = append(rdata, 0)
+				// name compressed to the question's, type SRV, class IN, TTL of a minute
+				resp = append(resp, 0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60)
+				resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+				resp = append(resp, rdata...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "udp", conn.LocalAddr().String())
+	}}
+}
+
+func TestSRVResolver(t *testing.T) {
+	resolver := SRVResolver{Service: "jwks", Proto: "tcp", Name: "auth.internal", Path: "jwks", Resolver: serveSRV(t, "a.auth.internal.", "b.auth.internal.")}
+	got, err := resolver.ResolveJWKURLs(context.Background())
+	if err != nil {
+		t.Fatalf("ResolveJWKURLs() error = %v", err)
+	}
+	want := []string{"https://a.auth.internal:443/jwks", "https://b.auth.internal:443/jwks"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveJWKURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestConsulResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/jwks" || r.URL.Query().Get("passing") != "true" || r.Header.Get("X-Consul-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8443}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "jwks-2.internal", "Port": 9443}}
+		]`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		resolver ConsulResolver
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "Passing instances",
+			resolver: ConsulResolver{Address: server.URL, Service: "jwks", Token: "token", Path: "/jwks"},
+			want:     []string{"https://10.0.0.1:8443/jwks", "https://jwks-2.internal:9443/jwks"},
+		},
+		{name: "Rejected token", resolver: ConsulResolver{Address: server.URL, Service: "jwks", Token: "other"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.ResolveJWKURLs(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveJWKURLs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveJWKURLs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKURLResolver(t *testing.T) {
+	_, keySet := newTestKeySet(t, "resolved-key")
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer down.Close()
+
+	var mu sync.Mutex
+	var resolved []string
+	var resolveErr error
+	var resolutions int32
+	var transportRequests int32
+	jwkProvider := JWKProvider{
+		Issuer: "https://resolved.example.com",
+		JWKURLResolver: JWKURLResolverFunc(func(ctx context.Context) ([]string, error) {
+			atomic.AddInt32(&resolutions, 1)
+			mu.Lock()
+			defer mu.Unlock()
+			return resolved, resolveErr
+		}),
+		Transport: countingTransport{requests: &transportRequests},
+	}
+	f := newFetcher()
+	defer f.Close()
+	f.setOptions([]Option{WithRetryPolicy(RetryPolicy{Attempts: 1})})
+	f.setProviders([]JWKProvider{jwkProvider})
+
+	tests := []struct {
+		name         string
+		resolved     []string
+		resolveErr   error
+		wantErr      error
+		wantClients  []string
+		wantRequests int32
+	}{
+		{name: "Falls back to the next endpoint", resolved: []string{down.URL + "/jwks", up.URL + "/jwks"}, wantClients: []string{down.URL + "/jwks", up.URL + "/jwks"}, wantRequests: 2},
+		{name: "Resolved again on refresh", resolved: []string{up.URL + "/keys"}, wantClients: []string{up.URL + "/keys"}, wantRequests: 1},
+		{name: "No endpoints", resolved: []string{}, wantErr: ErrNoEndpoints, wantClients: []string{up.URL + "/keys"}},
+		{name: "Resolution failure", resolveErr: errors.New("catalog down"), wantErr: errors.New("catalog down"), wantClients: []string{up.URL + "/keys"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			resolved, resolveErr = tt.resolved, tt.resolveErr
+			mu.Unlock()
+			atomic.StoreInt32(&transportRequests, 0)
+
+			f.purgeProvider(jwkProvider, nil)
+			_, err := f.cacheProviderEntry(context.Background(), jwkProvider)
+			if fmt.Sprint(errors.Unwrap(err)) != fmt.Sprint(tt.wantErr) {
+				t.Errorf("cacheProviderEntry() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && RejectionReasonOf(err) != RejectionIdPUnreachable {
+				t.Errorf("RejectionReasonOf(%v) = %q, want %q", err, RejectionReasonOf(err), RejectionIdPUnreachable)
+			}
+			if got := atomic.LoadInt32(&resolutions); got != int32(i+1) {
+				t.Errorf("Resolutions = %d, want %d", got, i+1)
+			}
+			if got := atomic.LoadInt32(&transportRequests); got != tt.wantRequests {
+				t.Errorf("Requests with the provider's transport = %d, want %d", got, tt.wantRequests)
+			}
+			f.clientsMu.RLock()
+			var clients []string
+			for fetchURL := range f.clients {
+				if strings.HasPrefix(fetchURL, "http://127.0.0.1") {
+					clients = append(clients, fetchURL)
+				}
+			}
+			f.clientsMu.RUnlock()
+			if len(clients) != len(tt.wantClients) {
+				t.Errorf("Registered clients = %v, want %v", clients, tt.wantClients)
+			}
+		})
+	}
+}
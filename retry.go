@@ -0,0 +1,96 @@
+package jwkfetch
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy retries the fetches of discovery documents and key sets failing with a network error or a 408, 429 or 5xx response,
+// so transient failures of an identity provider don't fail token verification
+type RetryPolicy struct {
+	// Attempts is the maximum number of requests of a fetch, including the first. 1 disables retries
+	Attempts int
+	// InitialBackoff is the wait before the first retry, doubled before every further retry
+	InitialBackoff time.Duration
+	// MaxBackoff bounds the wait before a retry
+	MaxBackoff time.Duration
+	// Jitter randomizes every wait by up to the fraction of it, e.g. 0.2 for up to 20% shorter or longer waits
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy of fetchers without WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.2}
+
+// WithRetryPolicy replaces DefaultRetryPolicy. The wait between retries counts towards the timeout of the fetch
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(options *fetcherOptions) {
+		options.retryPolicy = policy
+	}
+}
+
+// backoff is the wait before the retry following the attempt, counted from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// retryTransport retries the requests of its base transport by its policy
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.policy.Attempts || req.Body != nil && req.GetBody == nil || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		timer := time.NewTimer(t.policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryable reports whether the outcome of a request is a transient failure
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
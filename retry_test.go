@@ -0,0 +1,113 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	_, keySet := newTestKeySet(t, "retry-key")
+	fastRetries := RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Jitter: 0.2}
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		failures int32
+		status   int
+		discover bool
+		timeout  time.Duration
+		wantErr  bool
+		// wantRequests counts the requests of the key set, or of the discovery document with discover
+		wantRequests int32
+	}{
+		{name: "Recovers from 5xx", policy: fastRetries, failures: 2, status: http.StatusServiceUnavailable, wantRequests: 3},
+		{name: "Recovers from 429", policy: fastRetries, failures: 1, status: http.StatusTooManyRequests, wantRequests: 2},
+		{name: "Discovery recovers from 5xx", policy: fastRetries, failures: 1, status: http.StatusBadGateway, discover: true, wantRequests: 2},
+		{name: "Attempts exhausted", policy: fastRetries, failures: 3, status: http.StatusInternalServerError, wantErr: true, wantRequests: 3},
+		{name: "Client errors aren't retried", policy: fastRetries, failures: 1, status: http.StatusNotFound, wantErr: true, wantRequests: 1},
+		{name: "Retries disabled", policy: RetryPolicy{Attempts: 1}, failures: 1, status: http.StatusServiceUnavailable, wantErr: true, wantRequests: 1},
+		{
+			name:         "Backoff bounded by the context",
+			policy:       RetryPolicy{Attempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+			failures:     1,
+			status:       http.StatusServiceUnavailable,
+			timeout:      50 * time.Millisecond,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests, failures int32
+			failingPath := "/jwks"
+			if tt.discover {
+				failingPath = "/.well-known/openid-configuration"
+			}
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == failingPath {
+					atomic.AddInt32(&requests, 1)
+					if atomic.AddInt32(&failures, 1) <= tt.failures {
+						w.WriteHeader(tt.status)
+						return
+					}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/jwks" {
+					io.WriteString(w, keySet)
+					return
+				}
+				fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, server.URL, server.URL+"/jwks")
+			}))
+			defer server.Close()
+
+			jwkProvider := JWKProvider{Issuer: server.URL}
+			if !tt.discover {
+				jwkProvider.JWKURL = server.URL + "/jwks"
+			}
+			f := newFetcher()
+			defer f.Close()
+			f.setOptions([]Option{WithRetryPolicy(tt.policy)})
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			_, err := f.cacheProviderEntry(ctx, jwkProvider)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cacheProviderEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("Requests = %d, want %d", got, tt.wantRequests)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("cacheProviderEntry() took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, wantBackoff := range want {
+		if got := policy.backoff(i + 1); got != wantBackoff {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, wantBackoff)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff(1) with jitter = %v, want within 50%% of 100ms", got)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrKeyRevoked is returned when the token key is in the revocation list
+var ErrKeyRevoked = errors.New("Token key is revoked")
+
+// RevokedKey identifies a revoked signing key. Empty Issuer revokes the kid for all issuers
+type RevokedKey struct {
+	Issuer string `json:"iss"`
+	KeyID  string `json:"kid"`
+}
+
+// revocationList is the remote revocation list document. IssuedAt is required in signed documents to reject rollback to older documents
+type revocationList struct {
+	IssuedAt int64        `json:"iat"`
+	Revoked  []RevokedKey `json:"revoked"`
+}
+
+// revocationState holds the revoked keys of a fetcher
+type revocationState struct {
+	mu   sync.RWMutex
+	keys map[RevokedKey]bool
+	// remote are the keys of each polled revocation list by its URL, so lists polled together don't replace each other's keys
+	remote map[string]map[RevokedKey]bool
+}
+
+func newRevocationState() *revocationState {
+	return &revocationState{keys: make(map[RevokedKey]bool), remote: make(map[string]map[RevokedKey]bool)}
+}
+
+// RevokeKey is Fetcher.RevokeKey of the default fetcher
+func RevokeKey(issuer, keyID string) {
+	defaultFetcher.RevokeKey(issuer, keyID)
+}
+
+// RevokeKey adds the key to the revocation list. Tokens signed with the key fail with ErrKeyRevoked even if the key is still published by the issuer
+func (f *Fetcher) RevokeKey(issuer, keyID string) {
+	r := f.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[RevokedKey{Issuer: issuer, KeyID: keyID}] = true
+}
+
+// UnrevokeKey is Fetcher.UnrevokeKey of the default fetcher
+func UnrevokeKey(issuer, keyID string) {
+	defaultFetcher.UnrevokeKey(issuer, keyID)
+}
+
+// UnrevokeKey removes the key from the revocation list. Keys revoked by the remote revocation list are not affected
+func (f *Fetcher) UnrevokeKey(issuer, keyID string) {
+	r := f.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, RevokedKey{Issuer: issuer, KeyID: keyID})
+}
+
+// SetRevokedKeys is Fetcher.SetRevokedKeys of the default fetcher
+func SetRevokedKeys(keys []RevokedKey) {
+	defaultFetcher.SetRevokedKeys(keys)
+}
+
+// SetRevokedKeys replaces the revocation list. Keys revoked by the remote revocation list are not affected
+func (f *Fetcher) SetRevokedKeys(keys []RevokedKey) {
+	r := f.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = make(map[RevokedKey]bool, len(keys))
+	for _, key := range keys {
+		r.keys[key] = true
+	}
+}
+
+// RevokedKeys is Fetcher.RevokedKeys of the default fetcher
+func RevokedKeys() []RevokedKey {
+	return defaultFetcher.RevokedKeys()
+}
+
+// RevokedKeys returns the revoked keys, including the keys revoked by the remote revocation list
+func (f *Fetcher) RevokedKeys() []RevokedKey {
+	r := f.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]RevokedKey, 0, len(r.keys))
+	for key := range r.keys {
+		keys = append(keys, key)
+	}
+	listed := make(map[RevokedKey]bool)
+	for _, remote := range r.remote {
+		for key := range remote {
+			if !r.keys[key] && !listed[key] {
+				listed[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Issuer != keys[j].Issuer {
+			return keys[i].Issuer < keys[j].Issuer
+		}
+		return keys[i].KeyID < keys[j].KeyID
+	})
+	return keys
+}
+
+// RevocationListFailure is reported to the OnRevocationListFailure hook
+type RevocationListFailure struct {
+	ListURL             string
+	ConsecutiveFailures int
+	// Err is the error of the last poll
+	Err error
+}
+
+// PollRevocationList is Fetcher.PollRevocationList of the default fetcher
+func PollRevocationList(listURL string, interval time.Duration) (stop func(), err error) {
+	return defaultFetcher.PollRevocationList(listURL, interval)
+}
+
+// PollRevocationList fetches the revocation list document ({"revoked": [{"iss": "...", "kid": "..."}]}) from the URL and then polls it at the interval.
+// The keys of the document replace the keys previously polled from the URL, and add to the keys of other polled lists. Returns error without polling if the first fetch fails.
+// When a poll fails the keys of the last fetched document stay revoked and the failure is reported to the OnRevocationListFailure hook. Call stop to stop polling
+func (f *Fetcher) PollRevocationList(listURL string, interval time.Duration) (stop func(), err error) {
+	if err := f.fetchRevocationList(listURL); err != nil {
+		return nil, err
+	}
+	return f.pollRevocationList(listURL, interval, func() error {
+		return f.fetchRevocationList(listURL)
+	}), nil
+}
+
+// PollSignedRevocationList is Fetcher.PollSignedRevocationList of the default fetcher
+func PollSignedRevocationList(listURL string, verificationKey interface{}, interval time.Duration) (stop func(), err error) {
+	return defaultFetcher.PollSignedRevocationList(listURL, verificationKey, interval)
+}
+
+// PollSignedRevocationList is like PollRevocationList but the document is a compact JWS signed with the security team key, verified with verificationKey (*rsa.PublicKey or *ecdsa.PublicKey).
+// The JWS payload is the revocation list document with iat claim: {"iat": 1700000000, "revoked": [...]}. Documents older than the last accepted one are rejected.
+// When a poll fails, including on a document that isn't verified or is older, the keys of the last accepted document stay revoked and the failure is reported
+// to the OnRevocationListFailure hook
+func (f *Fetcher) PollSignedRevocationList(listURL string, verificationKey interface{}, interval time.Duration) (stop func(), err error) {
+	poller := &signedRevocationListPoller{fetcher: f, listURL: listURL, verificationKey: verificationKey}
+	if err := poller.fetch(); err != nil {
+		return nil, err
+	}
+	return f.pollRevocationList(listURL, interval, poller.fetch), nil
+}
+
+// pollRevocationList calls fetch at the interval and reports its failures to the OnRevocationListFailure hook
+func (f *Fetcher) pollRevocationList(listURL string, interval time.Duration, fetch func() error) (stop func()) {
+	failures := 0
+	s := f.every(interval, func() {
+		err := fetch()
+		if err == nil {
+			failures = 0
+			return
+		}
+		failures++
+		if onRevocationListFailure := f.currentHooks().OnRevocationListFailure; onRevocationListFailure != nil {
+			event := RevocationListFailure{ListURL: listURL, ConsecutiveFailures: failures, Err: err}
+			f.deliverHook(listURL, func() { onRevocationListFailure(event) })
+		}
+	})
+	return s.Stop
+}
+
+type signedRevocationListPoller struct {
+	fetcher         *Fetcher
+	listURL         string
+	verificationKey interface{}
+	lastIssuedAt    int64
+}
+
+func (p *signedRevocationListPoller) fetch() error {
+	body, err := getBody(withFetcher(context.Background(), p.fetcher), p.listURL)
+	if err != nil {
+		return fmt.Errorf("Error while fetching revocation list: %v", err)
+	}
+
+	list, err := p.fetcher.verifyRevocationList(body, p.verificationKey)
+	if err != nil {
+		return err
+	}
+	if list.IssuedAt == 0 {
+		return errors.New("Signed revocation list doesn't have iat")
+	}
+	if list.IssuedAt < p.lastIssuedAt {
+		return fmt.Errorf("Signed revocation list issued at %d is older than the last accepted list issued at %d", list.IssuedAt, p.lastIssuedAt)
+	}
+	p.lastIssuedAt = list.IssuedAt
+	p.fetcher.setRemoteRevokedKeys(p.listURL, list.Revoked)
+	return nil
+}
+
+func (f *Fetcher) verifyRevocationList(body []byte, verificationKey interface{}) (revocationList, error) {
+	var list revocationList
+	signatures, encodedPayload, err := parseJWS(body, nil)
+	if err != nil {
+		return list, err
+	}
+	if len(signatures) != 1 {
+		return list, errors.New("Signed revocation list must have exactly one signature")
+	}
+	header, err := f.parseJWSHeader(signatures[0])
+	if err != nil {
+		return list, err
+	}
+	if err := verifyJWSSignatureWithKey(signatures[0], header, encodedPayload, verificationKey); err != nil {
+		return list, fmt.Errorf("Error while verifying revocation list signature: %v", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return list, fmt.Errorf("Error while decoding revocation list: %v", err)
+	}
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return list, fmt.Errorf("Error while parsing revocation list: %v", err)
+	}
+	return list, nil
+}
+
+func (f *Fetcher) fetchRevocationList(listURL string) error {
+	var list revocationList
+	if err := f.getJSON(context.Background(), listURL, &list); err != nil {
+		return fmt.Errorf("Error while fetching revocation list: %v", err)
+	}
+	f.setRemoteRevokedKeys(listURL, list.Revoked)
+	return nil
+}
+
+// setRemoteRevokedKeys replaces the keys of the revocation list of the URL
+func (f *Fetcher) setRemoteRevokedKeys(listURL string, keys []RevokedKey) {
+	remote := make(map[RevokedKey]bool, len(keys))
+	for _, key := range keys {
+		remote[key] = true
+	}
+
+	r := f.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(remote) == 0 {
+		delete(r.remote, listURL)
+		return
+	}
+	r.remote[listURL] = remote
+}
+
+// isKeyRevoked reports whether the key is revoked for the issuer or for all issuers
+func (f *Fetcher) isKeyRevoked(issuer, keyID string) bool {
+	r := f.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range []RevokedKey{{Issuer: issuer, KeyID: keyID}, {KeyID: keyID}} {
+		if r.keys[key] {
+			return true
+		}
+		for _, remote := range r.remote {
+			if remote[key] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isKeyRevokedForIssuers reports whether the key of a key set of the issuers is revoked. The key set of no known issuer,
+// e.g. of a JWKs URL fetched with FromJWKsURL, matches the revocations of the key for any issuer, since tokens can claim any iss
+func (f *Fetcher) isKeyRevokedForIssuers(issuers []string, keyID string) bool {
+	if len(issuers) == 0 {
+		return f.isKeyRevokedForAnyIssuer(keyID)
+	}
+	for _, issuer := range issuers {
+		if f.isKeyRevoked(issuer, keyID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) isKeyRevokedForAnyIssuer(keyID string) bool {
+	r := f.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for key := range r.keys {
+		if key.KeyID == keyID {
+			return true
+		}
+	}
+	for _, remote := range r.remote {
+		for key := range remote {
+			if key.KeyID == keyID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keySetIssuers returns the issuers whose keys the entry of cacheKey holds: the issuer of issuer cache entries, otherwise the
+// providers and cached issuers using its JWKs or discover URL. The token's iss claim isn't trusted, as it's signed by the key checked
+func (f *Fetcher) keySetIssuers(cacheKey string, cache *entryCache, entry *keySetEntry) []string {
+	if cache == f.issuerCache {
+		return []string{cacheKey}
+	}
+	urls := map[string]bool{cacheKey: true}
+	if entry.jwksURL != "" {
+		urls[entry.jwksURL] = true
+	}
+	if entry.discoverURL != "" {
+		urls[entry.discoverURL] = true
+	}
+	var issuers []string
+	for _, jwkProvider := range f.Providers() {
+		if jwkProvider.Issuer != "" && (urls[jwkProvider.JWKURL] || urls[jwkProvider.DiscoverURL]) {
+			issuers = append(issuers, jwkProvider.Issuer)
+		}
+	}
+	for issuer, issuerEntry := range f.issuerCache.all() {
+		if issuerEntry != nil && (urls[issuerEntry.jwksURL] || urls[issuerEntry.discoverURL]) {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers
+}
@@ -0,0 +1,276 @@
+package jwkfetch
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestRevokeKey(t *testing.T) {
+	jwksURL := "https://revocation.example.com/jwks"
+	untiedJWKsURL := "https://revocation.example.com/untied/jwks"
+	keySet, _ := jwk.ParseString(jwkResponse)
+	defaultFetcher.jwksCache.set(jwksURL, &keySetEntry{keySet: keySet, jwksURL: jwksURL})
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+	defaultFetcher.jwksCache.set(untiedJWKsURL, &keySetEntry{keySet: keySet, jwksURL: untiedJWKsURL})
+	defer defaultFetcher.jwksCache.delete(untiedJWKsURL)
+	defer SetRevokedKeys(nil)
+
+	issuer := fmt.Sprintf("http://%s", httptestServerURL)
+	defaultFetcher.setProviders([]JWKProvider{{Issuer: issuer, JWKURL: jwksURL}})
+	defer defaultFetcher.setProviders(nil)
+	keyID := "512fe2ae0e60bd03084b12885b41423f"
+
+	tests := []struct {
+		name        string
+		jwksURL     string
+		tokenIssuer string
+		revoke      []RevokedKey
+		wantErr     error
+	}{
+		{
+			name:    "Key is not revoked",
+			jwksURL: jwksURL,
+			revoke:  nil,
+			wantErr: nil,
+		},
+		{
+			name:    "Key is revoked for the issuer",
+			jwksURL: jwksURL,
+			revoke:  []RevokedKey{{Issuer: issuer, KeyID: keyID}},
+			wantErr: ErrKeyRevoked,
+		},
+		{
+			name:    "Key is revoked for all issuers",
+			jwksURL: jwksURL,
+			revoke:  []RevokedKey{{KeyID: keyID}},
+			wantErr: ErrKeyRevoked,
+		},
+		{
+			name:    "Key is revoked for another issuer",
+			jwksURL: jwksURL,
+			revoke:  []RevokedKey{{Issuer: "https://other.example.com", KeyID: keyID}},
+			wantErr: nil,
+		},
+		{
+			name:        "Revoked key signs a token of another issuer",
+			jwksURL:     jwksURL,
+			tokenIssuer: "https://other.example.com",
+			revoke:      []RevokedKey{{Issuer: issuer, KeyID: keyID}},
+			wantErr:     ErrKeyRevoked,
+		},
+		{
+			name:        "Revoked key of a key set of no issuer signs a token of another issuer",
+			jwksURL:     untiedJWKsURL,
+			tokenIssuer: "https://other.example.com",
+			revoke:      []RevokedKey{{Issuer: issuer, KeyID: keyID}},
+			wantErr:     ErrKeyRevoked,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetRevokedKeys(nil)
+			for _, key := range tt.revoke {
+				RevokeKey(key.Issuer, key.KeyID)
+			}
+			token := mockToken()
+			if tt.tokenIssuer != "" {
+				token.Claims = jwt.MapClaims{"iss": tt.tokenIssuer}
+			}
+			if _, err := FromJWKsURL(tt.jwksURL)(token); err != tt.wantErr {
+				t.Errorf("FromJWKsURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	RevokeKey(issuer, keyID)
+	UnrevokeKey(issuer, keyID)
+	if _, err := FromJWKsURL(jwksURL)(mockToken()); err != nil {
+		t.Errorf("FromJWKsURL() after UnrevokeKey() error = %v", err)
+	}
+}
+
+func TestPollRevocationList(t *testing.T) {
+	revoked := `{"revoked": [{"iss": "https://first.example.com", "kid": "first-key"}]}`
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/revoked" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, revoked)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	listURL := fmt.Sprintf("http://%s/revoked", httptestServerURL)
+	defer defaultFetcher.setRemoteRevokedKeys(listURL, nil)
+
+	if _, err := PollRevocationList(fmt.Sprintf("http://%s/missing", httptestServerURL), time.Second); err == nil {
+		t.Errorf("PollRevocationList() of missing list error = nil, want error")
+	}
+
+	stop, err := PollRevocationList(listURL, time.Second)
+	if err != nil {
+		t.Fatalf("PollRevocationList() error = %v", err)
+	}
+	defer stop()
+
+	want := []RevokedKey{{Issuer: "https://first.example.com", KeyID: "first-key"}}
+	if got := RevokedKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RevokedKeys() = %v, want %v", got, want)
+	}
+	if !defaultFetcher.isKeyRevoked("https://first.example.com", "first-key") {
+		t.Errorf("Key of the remote revocation list is not revoked")
+	}
+}
+
+func TestPollSignedRevocationList(t *testing.T) {
+	privateKey, _ := newTestKeySet(t, "security-team-key")
+	otherKey, _ := newTestKeySet(t, "other-key")
+	signList := func(signingKey *rsa.PrivateKey, list string) string {
+		protected, signature := signJWS(t, signingKey, `{"alg":"RS256"}`, []byte(list))
+		return fmt.Sprintf("%s.%s.%s", protected, base64.RawURLEncoding.EncodeToString([]byte(list)), signature)
+	}
+
+	document := signList(privateKey, `{"iat": 1700000100, "revoked": [{"iss": "https://first.example.com", "kid": "first-key"}]}`)
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, document)
+	}))
+	defer server.Close()
+	listURL := fmt.Sprintf("http://%s/revoked.jws", httptestServerURL)
+	defer defaultFetcher.setRemoteRevokedKeys(listURL, nil)
+
+	if _, err := PollSignedRevocationList(listURL, &otherKey.PublicKey, time.Second); err == nil {
+		t.Errorf("PollSignedRevocationList() with wrong verification key error = nil, want error")
+	}
+
+	stop, err := PollSignedRevocationList(listURL, &privateKey.PublicKey, time.Hour)
+	if err != nil {
+		t.Fatalf("PollSignedRevocationList() error = %v", err)
+	}
+	stop()
+	if !defaultFetcher.isKeyRevoked("https://first.example.com", "first-key") {
+		t.Errorf("Key of the signed revocation list is not revoked")
+	}
+
+	poller := &signedRevocationListPoller{fetcher: defaultFetcher, listURL: listURL, verificationKey: &privateKey.PublicKey}
+	if err := poller.fetch(); err != nil {
+		t.Fatalf("signedRevocationListPoller.fetch() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		document string
+		wantErr  bool
+	}{
+		{
+			name:     "Newer list",
+			document: signList(privateKey, `{"iat": 1700000200, "revoked": []}`),
+			wantErr:  false,
+		},
+		{
+			name:     "Rollback to older list",
+			document: signList(privateKey, `{"iat": 1700000100, "revoked": []}`),
+			wantErr:  true,
+		},
+		{
+			name:     "List without iat",
+			document: signList(privateKey, `{"revoked": []}`),
+			wantErr:  true,
+		},
+		{
+			name:     "List signed with another key",
+			document: signList(otherKey, `{"iat": 1700000300, "revoked": []}`),
+			wantErr:  true,
+		},
+		{
+			name:     "Unsigned list",
+			document: `{"iat": 1700000300, "revoked": []}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document = tt.document
+			if err := poller.fetch(); (err != nil) != tt.wantErr {
+				t.Errorf("signedRevocationListPoller.fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevocationListsArePolledTogether(t *testing.T) {
+	first := RevokedKey{Issuer: "https://first.example.com", KeyID: "first-key"}
+	second := RevokedKey{Issuer: "https://second.example.com", KeyID: "second-key"}
+	defaultFetcher.setRemoteRevokedKeys("https://security.example.com/revoked.json", []RevokedKey{first})
+	defaultFetcher.setRemoteRevokedKeys("https://security.example.com/revoked.jws", []RevokedKey{first, second})
+	defer defaultFetcher.setRemoteRevokedKeys("https://security.example.com/revoked.jws", nil)
+
+	if got, want := RevokedKeys(), []RevokedKey{first, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RevokedKeys() = %v, want %v", got, want)
+	}
+
+	defaultFetcher.setRemoteRevokedKeys("https://security.example.com/revoked.json", nil)
+	if !defaultFetcher.isKeyRevoked(first.Issuer, first.KeyID) || !defaultFetcher.isKeyRevoked(second.Issuer, second.KeyID) {
+		t.Errorf("Keys of the signed revocation list aren't revoked after the other list changed")
+	}
+	defaultFetcher.setRemoteRevokedKeys("https://security.example.com/revoked.jws", []RevokedKey{second})
+	if defaultFetcher.isKeyRevoked(first.Issuer, first.KeyID) {
+		t.Errorf("Key removed from both revocation lists is revoked")
+	}
+}
+
+func TestRevocationListFailure(t *testing.T) {
+	var failing int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"revoked": [{"iss": "https://first.example.com", "kid": "first-key"}]}`)
+	}))
+	defer server.Close()
+	listURL := fmt.Sprintf("http://%s/revoked", httptestServerURL)
+	defer defaultFetcher.setRemoteRevokedKeys(listURL, nil)
+
+	failures := make(chan RevocationListFailure, 10)
+	SetHooks(Hooks{OnRevocationListFailure: func(failure RevocationListFailure) {
+		select {
+		case failures <- failure:
+		default:
+		}
+	}})
+	defer SetHooks(Hooks{})
+
+	stop, err := PollRevocationList(listURL, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("PollRevocationList() error = %v", err)
+	}
+	defer stop()
+	atomic.StoreInt32(&failing, 1)
+
+	for want := 1; want <= 2; want++ {
+		select {
+		case failure := <-failures:
+			if failure.ListURL != listURL || failure.ConsecutiveFailures != want || failure.Err == nil {
+				t.Errorf("OnRevocationListFailure() = %+v, want failure %d of %s", failure, want, listURL)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("OnRevocationListFailure() wasn't called")
+		}
+	}
+	if !defaultFetcher.isKeyRevoked("https://first.example.com", "first-key") {
+		t.Errorf("Key of the last fetched revocation list isn't revoked after the poll failed")
+	}
+}
@@ -0,0 +1,184 @@
+package jwkfetch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// RotationAnomalyPolicy sets when the key set changes of a provider are anomalous
+type RotationAnomalyPolicy struct {
+	// Window is the rolling window rotations are counted in. Defaults to 24 hours
+	Window time.Duration
+	// Factor is how many times the provider's historical rotations per window the current window must exceed. Defaults to 5
+	Factor float64
+	// MinRotations is the least rotations within the window that are anomalous, so new providers without history aren't reported
+	// for their first rotations. Defaults to 3
+	MinRotations int
+}
+
+// RotationStats describes how often the keys of a provider change
+type RotationStats struct {
+	// Rotations counts the key set changes within the policy's window
+	Rotations int
+	// Baseline is the average key set changes per window before the current window
+	Baseline     float64
+	LastRotation time.Time
+}
+
+// RotationAnomaly is reported to the OnRotationAnomaly hook when the keys of a provider change far more often than they used to,
+// e.g. because the issuer was compromised or is misconfigured
+type RotationAnomaly struct {
+	Issuer string
+	RotationStats
+	// AddedKeyIDs and RemovedKeyIDs are the changes of the rotation that was found anomalous
+	AddedKeyIDs   []string
+	RemovedKeyIDs []string
+}
+
+// rotationHistoryWindows bounds the windows of rotations kept for the baseline
+const rotationHistoryWindows = 30
+
+// rotationTracker holds the key ids last fetched for a provider and the times they changed
+type rotationTracker struct {
+	keyIDs    map[string]bool
+	firstSeen time.Time
+	rotations []time.Time
+}
+
+// SetRotationAnomalyPolicy is Fetcher.SetRotationAnomalyPolicy of the default fetcher
+func SetRotationAnomalyPolicy(policy RotationAnomalyPolicy) {
+	defaultFetcher.SetRotationAnomalyPolicy(policy)
+}
+
+// SetRotationAnomalyPolicy replaces the policy of the fetcher's OnRotationAnomaly hook. Zero fields keep their defaults
+func (f *Fetcher) SetRotationAnomalyPolicy(policy RotationAnomalyPolicy) {
+	if policy.Window <= 0 {
+		policy.Window = 24 * time.Hour
+	}
+	if policy.Factor <= 0 {
+		policy.Factor = 5
+	}
+	if policy.MinRotations <= 0 {
+		policy.MinRotations = 3
+	}
+	f.policyMu.Lock()
+	defer f.policyMu.Unlock()
+	f.rotationPolicy = policy
+}
+
+func (f *Fetcher) currentRotationPolicy() RotationAnomalyPolicy {
+	f.policyMu.RLock()
+	defer f.policyMu.RUnlock()
+	return f.rotationPolicy
+}
+
+// recordKeySetRotation compares the fetched keys of the issuer with the previous ones, and reports to OnRotationAnomaly
+// when they changed far more often within the window than they used to
+func (f *Fetcher) recordKeySetRotation(issuer string, keySet *jwk.Set) {
+	keyIDs := make(map[string]bool)
+	for _, key := range keySet.Keys {
+		if key.KeyID() != "" {
+			keyIDs[key.KeyID()] = true
+		}
+	}
+	now := clockNow()
+	policy := f.currentRotationPolicy()
+
+	f.statsMu.Lock()
+	tracker, ok := f.rotationTrackers[issuer]
+	if !ok {
+		if len(f.rotationTrackers) < maxEndpoints {
+			f.rotationTrackers[issuer] = &rotationTracker{keyIDs: keyIDs, firstSeen: now}
+		}
+		f.statsMu.Unlock()
+		return
+	}
+	added, removed := diffKeyIDs(tracker.keyIDs, keyIDs)
+	if len(added) == 0 && len(removed) == 0 {
+		f.statsMu.Unlock()
+		return
+	}
+	tracker.keyIDs = keyIDs
+	tracker.rotations = append(tracker.rotations, now)
+	tracker.trim(now, policy.Window)
+	stats := tracker.stats(now, policy.Window)
+	f.statsMu.Unlock()
+
+	if stats.Rotations < policy.MinRotations || float64(stats.Rotations) <= policy.Factor*stats.Baseline {
+		return
+	}
+	if onRotationAnomaly := f.currentHooks().OnRotationAnomaly; onRotationAnomaly != nil {
+		event := RotationAnomaly{Issuer: issuer, RotationStats: stats, AddedKeyIDs: added, RemovedKeyIDs: removed}
+		f.deliverHook(issuer, func() { onRotationAnomaly(event) })
+	}
+}
+
+// trim drops the rotations older than the history kept for the baseline
+func (t *rotationTracker) trim(now time.Time, window time.Duration) {
+	historyStart := now.Add(-rotationHistoryWindows * window)
+	if t.firstSeen.Before(historyStart) {
+		t.firstSeen = historyStart
+	}
+	for len(t.rotations) > 0 && t.rotations[0].Before(historyStart) {
+		t.rotations = t.rotations[1:]
+	}
+}
+
+// stats counts the rotations of the current window and averages the ones of the whole windows before it
+func (t *rotationTracker) stats(now time.Time, window time.Duration) RotationStats {
+	var stats RotationStats
+	windowStart := now.Add(-window)
+	previous := 0
+	for _, rotatedAt := range t.rotations {
+		if rotatedAt.After(windowStart) {
+			stats.Rotations++
+		} else {
+			previous++
+		}
+	}
+	if windows := int(windowStart.Sub(t.firstSeen) / window); windows > 0 {
+		stats.Baseline = float64(previous) / float64(windows)
+	}
+	if len(t.rotations) > 0 {
+		stats.LastRotation = t.rotations[len(t.rotations)-1]
+	}
+	return stats
+}
+
+func (f *Fetcher) getRotationStats(issuer string) RotationStats {
+	window := f.currentRotationPolicy().Window
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	tracker, ok := f.rotationTrackers[issuer]
+	if !ok {
+		return RotationStats{}
+	}
+	now := clockNow()
+	tracker.trim(now, window)
+	return tracker.stats(now, window)
+}
+
+func (f *Fetcher) forgetRotations(issuer string) {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	delete(f.rotationTrackers, issuer)
+}
+
+// diffKeyIDs returns the sorted key ids added to and removed from the previous ones
+func diffKeyIDs(previous, current map[string]bool) (added, removed []string) {
+	for keyID := range current {
+		if !previous[keyID] {
+			added = append(added, keyID)
+		}
+	}
+	for keyID := range previous {
+		if !current[keyID] {
+			removed = append(removed, keyID)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,90 @@
+package jwkfetch
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestRecordKeySetRotation(t *testing.T) {
+	defer func() { clockNow = time.Now }()
+	SetRotationAnomalyPolicy(RotationAnomalyPolicy{Window: time.Hour, Factor: 3, MinRotations: 3})
+	defer SetRotationAnomalyPolicy(RotationAnomalyPolicy{})
+	defer defaultFetcher.forgetRotations("rotating")
+
+	var anomalies []RotationAnomaly
+	SetHooks(Hooks{OnRotationAnomaly: func(event RotationAnomaly) {
+		anomalies = append(anomalies, event)
+	}})
+	defer SetHooks(Hooks{})
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keySet := func(keyIDs ...string) *jwk.Set {
+		set := &jwk.Set{}
+		for _, keyID := range keyIDs {
+			key, _ := jwk.New(&privateKey.PublicKey)
+			key.Set(jwk.KeyIDKey, keyID)
+			set.Keys = append(set.Keys, key)
+		}
+		return set
+	}
+
+	tests := []struct {
+		name          string
+		offset        time.Duration
+		keyIDs        []string
+		wantRotations int
+		wantAnomaly   *RotationAnomaly
+	}{
+		{name: "First fetch", keyIDs: []string{"a"}},
+		{name: "Rotation every 2 hours", offset: 2 * time.Hour, keyIDs: []string{"a", "b"}, wantRotations: 1},
+		{name: "Old key removed", offset: 4 * time.Hour, keyIDs: []string{"b"}, wantRotations: 1},
+		{name: "Next rotation", offset: 6 * time.Hour, keyIDs: []string{"c"}, wantRotations: 1},
+		{name: "Same keys", offset: 7 * time.Hour, keyIDs: []string{"c"}},
+		{name: "Rotation after 2 hours", offset: 8 * time.Hour, keyIDs: []string{"d"}, wantRotations: 1},
+		{name: "Below MinRotations", offset: 8*time.Hour + 20*time.Minute, keyIDs: []string{"e"}, wantRotations: 2},
+		{
+			name:          "Anomalous rotation",
+			offset:        8*time.Hour + 30*time.Minute,
+			keyIDs:        []string{"f"},
+			wantRotations: 3,
+			wantAnomaly:   &RotationAnomaly{Issuer: "rotating", AddedKeyIDs: []string{"f"}, RemovedKeyIDs: []string{"e"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anomalies = nil
+			clockNow = fakeClock(tt.offset)
+			defaultFetcher.recordKeySetRotation("rotating", keySet(tt.keyIDs...))
+
+			stats := defaultFetcher.getRotationStats("rotating")
+			if stats.Rotations != tt.wantRotations {
+				t.Errorf("getRotationStats() = %+v, want %d rotations", stats, tt.wantRotations)
+			}
+			if tt.wantAnomaly == nil {
+				if len(anomalies) > 0 {
+					t.Errorf("OnRotationAnomaly() events = %+v, want none", anomalies)
+				}
+				return
+			}
+			if len(anomalies) != 1 {
+				t.Fatalf("OnRotationAnomaly() events = %+v, want one", anomalies)
+			}
+			got := anomalies[0]
+			if got.Issuer != tt.wantAnomaly.Issuer || !reflect.DeepEqual(got.AddedKeyIDs, tt.wantAnomaly.AddedKeyIDs) ||
+				!reflect.DeepEqual(got.RemovedKeyIDs, tt.wantAnomaly.RemovedKeyIDs) {
+				t.Errorf("OnRotationAnomaly() event = %+v, want %+v", got, *tt.wantAnomaly)
+			}
+			if got.Rotations != tt.wantRotations || got.Baseline <= 0 || got.Baseline*3 >= float64(got.Rotations) {
+				t.Errorf("OnRotationAnomaly() event = %+v, want %d rotations over a lower baseline", got, tt.wantRotations)
+			}
+		})
+	}
+}
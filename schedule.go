@@ -0,0 +1,44 @@
+package jwkfetch
+
+import (
+	"sync"
+	"time"
+)
+
+// schedule runs a job at a fixed interval until stopped
+type schedule struct {
+	interval time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// every runs the job every interval, recovering its panics and reporting them to the fetcher's OnPanic hook
+func (f *Fetcher) every(interval time.Duration, job func()) *schedule {
+	s := &schedule{interval: interval, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.runJob(job)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (f *Fetcher) runJob(job func()) {
+	var err error
+	defer f.recoverPanic(&err)
+	job()
+}
+
+// Stop stops the schedule. A running job isn't interrupted
+func (s *schedule) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
@@ -0,0 +1,33 @@
+package jwkfetch
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	var runs int32
+	s := defaultFetcher.every(10*time.Millisecond, func() {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			panic("first run")
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&runs); got < 3 {
+		t.Fatalf("Job ran %d times, want it to keep running after a panic", got)
+	}
+
+	s.Stop()
+	s.Stop()
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt32(&runs)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != stopped {
+		t.Errorf("Job ran %d times after Stop()", got-stopped)
+	}
+}
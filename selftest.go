@@ -0,0 +1,228 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// SelfTestCheck is the outcome of a check of SelfTest: "discovery", "keys", "algorithms" or "sample_token". Err is nil when it passed
+type SelfTestCheck struct {
+	Name string
+	// Skipped checks don't apply to the provider, e.g. discovery of providers with JWKURL
+	Skipped bool
+	Err     error
+}
+
+// SelfTestResult is the outcome of the checks of a provider
+type SelfTestResult struct {
+	// Issuer is the provider's Issuer, or its DiscoverURL or JWKURL when it has no Issuer
+	Issuer   string
+	KeyCount int
+	Checks   []SelfTestCheck
+}
+
+// Passed reports whether none of the provider's checks failed
+func (r SelfTestResult) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// SelfTestReport is the outcome of SelfTest for every provider
+type SelfTestReport struct {
+	Results []SelfTestResult
+}
+
+// Passed reports whether all providers passed
+func (r SelfTestReport) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes a line per check, e.g. for the logs of a deployment pipeline
+func (r SelfTestReport) WriteText(w io.Writer) error {
+	for _, result := range r.Results {
+		for _, check := range result.Checks {
+			var line string
+			switch {
+			case check.Skipped:
+				line = fmt.Sprintf("SKIP %s %s", result.Issuer, check.Name)
+			case check.Err != nil:
+				line = fmt.Sprintf("FAIL %s %s: %v", result.Issuer, check.Name, check.Err)
+			case check.Name == "keys":
+				line = fmt.Sprintf("PASS %s %s: %d keys", result.Issuer, check.Name, result.KeyCount)
+			default:
+				line = fmt.Sprintf("PASS %s %s", result.Issuer, check.Name)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SelfTest is Fetcher.SelfTest of the default fetcher
+func SelfTest(ctx context.Context, sampleTokens ...string) SelfTestReport {
+	return defaultFetcher.SelfTest(ctx, sampleTokens...)
+}
+
+// SelfTest checks every registered provider without using or changing the cached keys: it fetches the discovery document
+// and the keys, checks that the accepted algorithms are supported and match the keys, and verifies the signature of the sample
+// tokens of the provider's issuer. The claims of sample tokens aren't validated, so expired samples can be used.
+// Sample tokens of issuers without provider fail, e.g. to run before rollout in deployment pipelines
+func (f *Fetcher) SelfTest(ctx context.Context, sampleTokens ...string) SelfTestReport {
+	// the checks fetch with a fetcher of their own, so the cached keys of the fetcher aren't used or replaced
+	tester := newFetcher()
+	defer tester.Close()
+	tester.options = f.currentOptions()
+	providers := f.Providers()
+	tester.setProviders(providers)
+	for _, jwkProvider := range providers {
+		tester.registerClient(jwkProvider)
+	}
+
+	samples := make(map[string][]string)
+	var report SelfTestReport
+	for _, sampleToken := range sampleTokens {
+		issuer, err := sampleTokenIssuer(sampleToken)
+		if err == nil {
+			if _, ok := tester.findProvider(issuer); !ok {
+				err = fmt.Errorf("No provider of issuer %s", issuer)
+			}
+		}
+		if err != nil {
+			report.Results = append(report.Results, SelfTestResult{Issuer: issuer, Checks: []SelfTestCheck{{Name: "sample_token", Err: err}}})
+			continue
+		}
+		samples[issuer] = append(samples[issuer], sampleToken)
+	}
+
+	for _, jwkProvider := range providers {
+		report.Results = append(report.Results, tester.selfTestProvider(ctx, jwkProvider, samples[jwkProvider.Issuer]))
+	}
+	return report
+}
+
+func (f *Fetcher) selfTestProvider(ctx context.Context, jwkProvider JWKProvider, samples []string) SelfTestResult {
+	result := SelfTestResult{Issuer: providerKey(jwkProvider)}
+	result.Checks = append(result.Checks, f.selfTestDiscovery(ctx, jwkProvider))
+
+	entry, err := f.cacheProviderEntry(ctx, jwkProvider)
+	if err == nil && (entry == nil || entry.keySet == nil) {
+		err = errors.New("Provider must have Issuer, DiscoverURL or JWKURL")
+	}
+	if err != nil {
+		result.Checks = append(result.Checks, SelfTestCheck{Name: "keys", Err: err})
+		return result
+	}
+	result.KeyCount = len(entry.keySet.Keys)
+	result.Checks = append(result.Checks,
+		SelfTestCheck{Name: "keys"},
+		SelfTestCheck{Name: "algorithms", Err: checkAlgorithmConsistency(jwkProvider, entry)})
+
+	if len(samples) == 0 {
+		result.Checks = append(result.Checks, SelfTestCheck{Name: "sample_token", Skipped: true})
+	}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	for _, sample := range samples {
+		_, err := parser.Parse(sample, f.FromIssuerClaimCtx(ctx))
+		result.Checks = append(result.Checks, SelfTestCheck{Name: "sample_token", Err: err})
+	}
+	return result
+}
+
+// selfTestDiscovery fetches the discovery document of providers without JWKURL, RegionalJWKURLs, JWKURLResolver or fixed key set and checks its issuer and jwks_uri
+func (f *Fetcher) selfTestDiscovery(ctx context.Context, jwkProvider JWKProvider) SelfTestCheck {
+	check := SelfTestCheck{Name: "discovery"}
+	if jwkProvider.JWKURL != "" || len(jwkProvider.RegionalJWKURLs) > 0 || jwkProvider.JWKURLResolver != nil || len(jwkProvider.InlineJWKS) > 0 || jwkProvider.JWKSEnv != "" {
+		check.Skipped = true
+		return check
+	}
+	discoverURL := jwkProvider.DiscoverURL
+	if discoverURL == "" {
+		discoverURL, check.Err = getDiscoverURL(jwkProvider.Issuer)
+		if check.Err != nil {
+			return check
+		}
+	}
+	document, err := f.getDiscoveryDocument(ctx, discoverURL)
+	switch {
+	case err != nil:
+		check.Err = err
+	case jwkProvider.Issuer != "" && document.Issuer != "" && document.Issuer != jwkProvider.Issuer:
+		check.Err = fmt.Errorf("Openid connect configuration issuer %q doesn't match %q", document.Issuer, jwkProvider.Issuer)
+	default:
+		check.Err = f.checkJWKsURI(discoverURL, document.JWKsURI)
+	}
+	return check
+}
+
+// checkAlgorithmConsistency checks that the accepted algorithms are supported, that the signing keys' algorithms are accepted and,
+// for providers with Algorithms, that every accepted algorithm has a key of its type
+func checkAlgorithmConsistency(jwkProvider JWKProvider, entry *keySetEntry) error {
+	policy := newAlgorithmPolicy(&jwkProvider, entry)
+	var problems []string
+	if len(policy.Unsupported) > 0 {
+		problems = append(problems, fmt.Sprintf("unsupported algorithms %s are accepted", strings.Join(policy.Unsupported, ", ")))
+	}
+	accepted := make(map[string]bool)
+	for _, alg := range policy.Accepted {
+		accepted[alg] = true
+	}
+	keyTypes := make(map[string]bool)
+	for _, key := range entry.keySet.Keys {
+		if key.KeyUsage() == string(jwk.ForEncryption) {
+			continue
+		}
+		keyTypes[string(key.KeyType())] = true
+		if alg := key.Algorithm(); alg != "" && !accepted[alg] {
+			problems = append(problems, fmt.Sprintf("key %s has algorithm %s, which isn't accepted", key.KeyID(), alg))
+		}
+	}
+	if policy.Source == ProviderAlgorithms {
+		for _, alg := range policy.Accepted {
+			if keyType := algorithmKeyType(alg); keyType != "" && !keyTypes[keyType] {
+				problems = append(problems, fmt.Sprintf("no key can verify algorithm %s", alg))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// algorithmKeyType is the kty of the keys verifying the algorithm, empty for the HMAC algorithms
+func algorithmKeyType(alg string) string {
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return "RSA"
+	case strings.HasPrefix(alg, "ES"):
+		return "EC"
+	case alg == "EdDSA":
+		return "OKP"
+	}
+	return ""
+}
+
+func sampleTokenIssuer(sampleToken string) (string, error) {
+	token, _, err := new(jwt.Parser).ParseUnverified(sampleToken, jwt.MapClaims{})
+	if err != nil {
+		return "", fmt.Errorf("Error while parsing sample token: %w", err)
+	}
+	return getIssuer(token)
+}
@@ -0,0 +1,81 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestSelfTest(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "self-test-key")
+	otherKey, _ := newTestKeySet(t, "self-test-key")
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration"):
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, server.URL, server.URL+"/jwks")
+		case r.URL.Path == "/jwks":
+			io.WriteString(w, keySet)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	claims := jwt.MapClaims{"iss": server.URL, "exp": time.Now().Add(-time.Hour).Unix()}
+	sample := signTestToken(t, privateKey, "self-test-key", claims)
+	forged := signTestToken(t, otherKey, "self-test-key", claims)
+	unknownIssuer := signTestToken(t, privateKey, "self-test-key", jwt.MapClaims{"iss": "https://unknown.example.com"})
+	tests := []struct {
+		name         string
+		providers    []JWKProvider
+		samples      []string
+		wantPassed   bool
+		wantFailures []string
+	}{
+		{name: "Discovered provider with expired sample", providers: []JWKProvider{{Issuer: server.URL}}, samples: []string{sample}, wantPassed: true},
+		{name: "JWKs URL provider", providers: []JWKProvider{{Issuer: server.URL, JWKURL: server.URL + "/jwks"}}, wantPassed: true},
+		{name: "Issuer mismatch", providers: []JWKProvider{{Issuer: server.URL + "/tenant"}}, wantFailures: []string{"discovery"}},
+		{name: "Unreachable keys", providers: []JWKProvider{{Issuer: server.URL, JWKURL: server.URL + "/missing"}}, wantFailures: []string{"keys"}},
+		{name: "Algorithms without keys", providers: []JWKProvider{{Issuer: server.URL, Algorithms: []string{"ES256"}}}, wantFailures: []string{"algorithms"}},
+		{name: "Forged sample", providers: []JWKProvider{{Issuer: server.URL}}, samples: []string{forged}, wantFailures: []string{"sample_token"}},
+		{name: "Sample of unknown issuer", providers: []JWKProvider{{Issuer: server.URL}}, samples: []string{unknownIssuer}, wantFailures: []string{"sample_token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFetcher()
+			defer f.Close()
+			f.setProviders(tt.providers)
+
+			report := f.SelfTest(context.Background(), tt.samples...)
+			if report.Passed() != tt.wantPassed {
+				var buf bytes.Buffer
+				report.WriteText(&buf)
+				t.Errorf("SelfTest() passed = %v, want %v:\n%s", report.Passed(), tt.wantPassed, buf.String())
+			}
+			var failures []string
+			for _, result := range report.Results {
+				for _, check := range result.Checks {
+					if check.Err != nil {
+						failures = append(failures, check.Name)
+					}
+				}
+			}
+			if strings.Join(failures, ",") != strings.Join(tt.wantFailures, ",") {
+				t.Errorf("SelfTest() failed checks %v, want %v", failures, tt.wantFailures)
+			}
+			if f.issuerCache.len() != 0 || f.jwksCache.len() != 0 {
+				t.Errorf("SelfTest() cached keys in the fetcher")
+			}
+		})
+	}
+}
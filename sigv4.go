@@ -0,0 +1,181 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+// AWSCredentials are AWS credentials used to sign requests
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SigV4Transport signs requests with AWS Signature Version 4, e.g. for JWKs endpoints exposed via API Gateway with IAM authorization
+type SigV4Transport struct {
+	// Region is the AWS region of the endpoint, e.g. us-east-1
+	Region string
+	// Service is the signing name of the AWS service, e.g. execute-api
+	Service string
+	// Credentials returns the credentials to sign with. Use it to plug in the credentials provider of AWS SDK
+	Credentials func(context.Context) (AWSCredentials, error)
+	// Base is the transport that sends the signed request. http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	now func() time.Time
+}
+
+// AWSCredentialsFromEnv reads AWS credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func AWSCredentialsFromEnv(ctx context.Context) (AWSCredentials, error) {
+	credentials := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return AWSCredentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are not set")
+	}
+	return credentials, nil
+}
+
+// RoundTrip signs a copy of the request and sends it with the base transport
+func (t *SigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentialsFn := t.Credentials
+	if credentialsFn == nil {
+		credentialsFn = AWSCredentialsFromEnv
+	}
+	credentials, err := credentialsFn(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("Error while getting AWS credentials: %v", err)
+	}
+
+	signedReq := req.Clone(req.Context())
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		signedReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	t.sign(signedReq, body, credentials, now().UTC())
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signedReq)
+}
+
+func (t *SigV4Transport) sign(req *http.Request, body []byte, credentials AWSCredentials, signTime time.Time) {
+	amzDate := signTime.Format("20060102T150405Z")
+	dateStamp := signTime.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "x-amz-date" || name == "x-amz-security-token" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Escape(req.URL.EscapedPath(), false),
+		sigV4CanonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, t.Region, t.Service)
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), dateStamp)
+	signingKey = hmacSHA256(signingKey, t.Region)
+	signingKey = hmacSHA256(signingKey, t.Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sigV4CanonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, sigV4Escape(key, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// sigV4Escape URI-encodes every byte except the unreserved characters as required by SigV4
+func sigV4Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4Transport_sign(t *testing.T) {
+	credentials := AWSCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signTime := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	transport := &SigV4Transport{Region: "us-east-1", Service: "service"}
+
+	type args struct {
+		url string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "get-vanilla",
+			args: args{
+				url: "https://example.amazonaws.com/",
+			},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name: "get-vanilla-query-order-key-case",
+			args: args{
+				url: "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			},
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.args.url, nil)
+			transport.sign(req, nil, credentials, signTime)
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("sign() Authorization = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigV4Transport(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			r.Header.Get("X-Amz-Security-Token") != "session-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/iam/jwks") {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/iam/jwks", httptestServerURL)
+	defaultFetcher.registerClient(JWKProvider{
+		JWKURL: jwksURL,
+		Transport: &SigV4Transport{
+			Region:  "us-east-1",
+			Service: "execute-api",
+			Credentials: func(ctx context.Context) (AWSCredentials, error) {
+				return AWSCredentials{
+					AccessKeyID:     "AKIDEXAMPLE",
+					SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+					SessionToken:    "session-token",
+				}, nil
+			},
+		},
+	})
+	defer defaultFetcher.deleteClient(jwksURL)
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+
+	keyFunc := FromJWKsURL(jwksURL)
+	if _, err := keyFunc(mockToken()); err != nil {
+		t.Errorf("FromJWKsURL() with SigV4Transport error = %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrSnapshotEncrypted is returned when reading an encrypted snapshot without encryption
+var ErrSnapshotEncrypted = errors.New("Snapshot is encrypted")
+
+// ErrSnapshotNotEncrypted is returned when reading a plaintext snapshot with encryption, so a tampered or downgraded snapshot isn't trusted
+var ErrSnapshotNotEncrypted = errors.New("Snapshot is not encrypted")
+
+const snapshotFormatVersion = 1
+
+// snapshotAdditionalData binds the encrypted snapshots to their format
+var snapshotAdditionalData = []byte("jwkfetch snapshot v1")
+
+// SnapshotEncryption encrypts the cache snapshots at rest
+type SnapshotEncryption interface {
+	Seal(ctx context.Context, plaintext []byte) ([]byte, error)
+	Open(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// KeyWrapper wraps and unwraps data keys with a key held elsewhere, e.g. by a KMS
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// CachePersistence configures writing the cached keys to a snapshot file, so restarted processes start with the keys they had
+type CachePersistence struct {
+	// Path is the snapshot file. Empty disables persistence
+	Path string
+	// Interval is the time between writes of the snapshot. Defaults to 5 minutes
+	Interval time.Duration
+	// Encryption encrypts the snapshot. Nil writes it as plaintext JSON
+	Encryption SnapshotEncryption
+	// HistoryDir retains a copy of every written snapshot in the directory, named by the time it was saved, for VerifyAt.
+	// Empty keeps only the latest snapshot
+	HistoryDir string
+	// HistoryMaxCount, HistoryMaxAge and HistoryMaxBytes bound the snapshots retained in HistoryDir. The oldest snapshots beyond
+	// any of them are pruned after every write, but the latest snapshot is always kept. Zero means no bound
+	HistoryMaxCount int
+	HistoryMaxAge   time.Duration
+	HistoryMaxBytes int64
+}
+
+// snapshot is the content of a snapshot file
+type snapshot struct {
+	SavedAt time.Time       `json:"saved_at"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Cache        string          `json:"cache"`
+	Key          string          `json:"key"`
+	KeySet       json.RawMessage `json:"key_set"`
+	JWKsURL      string          `json:"jwks_url,omitempty"`
+	DiscoverURL  string          `json:"discover_url,omitempty"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	Algorithms   []string        `json:"algorithms,omitempty"`
+	TokenTypes   []string        `json:"token_types,omitempty"`
+	Header       http.Header     `json:"header,omitempty"`
+	Source       string          `json:"source,omitempty"`
+	PeerSPKIHash string          `json:"peer_spki_hash,omitempty"`
+}
+
+// snapshotFile holds either the plaintext or the encrypted snapshot
+type snapshotFile struct {
+	Version   int       `json:"version"`
+	Snapshot  *snapshot `json:"snapshot,omitempty"`
+	Encrypted []byte    `json:"encrypted,omitempty"`
+}
+
+// snapshotCaches are the caches written to snapshots, by their name in the snapshot
+func (f *Fetcher) snapshotCaches() map[string]*entryCache {
+	return map[string]*entryCache{
+		"issuer":    f.issuerCache,
+		"discover":  f.discoverURLsCache,
+		"jwks":      f.jwksCache,
+		"vc_issuer": f.vcIssuerCache,
+		"did":       f.didCache,
+	}
+}
+
+// SetCachePersistence is Fetcher.SetCachePersistence of the default fetcher
+func SetCachePersistence(ctx context.Context, p CachePersistence) error {
+	return defaultFetcher.SetCachePersistence(ctx, p)
+}
+
+// SetCachePersistence reads the cached keys of the fetcher from the snapshot file, if it exists, and writes them to it every interval.
+// Keys read from the snapshot are used until refreshed as usual, and never replace keys already fetched
+func (f *Fetcher) SetCachePersistence(ctx context.Context, p CachePersistence) error {
+	if p.Interval <= 0 {
+		p.Interval = 5 * time.Minute
+	}
+
+	f.persistenceMu.Lock()
+	defer f.persistenceMu.Unlock()
+	if f.persistenceScheduler != nil {
+		f.persistenceScheduler.Stop()
+		f.persistenceScheduler = nil
+	}
+	f.persistence = CachePersistence{}
+	if p.Path == "" {
+		return nil
+	}
+
+	file, err := os.Open(p.Path)
+	switch {
+	case err == nil:
+		err = f.ReadSnapshot(ctx, file, p.Encryption)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("Error while reading snapshot %s: %w", p.Path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if p.HistoryDir != "" {
+		if err := os.MkdirAll(p.HistoryDir, 0700); err != nil {
+			return err
+		}
+	}
+
+	f.persistence = p
+	f.persistenceScheduler = f.every(p.Interval, func() {
+		f.PersistCache(context.Background())
+	})
+	return nil
+}
+
+func (f *Fetcher) currentPersistence() CachePersistence {
+	f.persistenceMu.Lock()
+	defer f.persistenceMu.Unlock()
+	return f.persistence
+}
+
+// PersistCache is Fetcher.PersistCache of the default fetcher
+func PersistCache(ctx context.Context) error {
+	return defaultFetcher.PersistCache(ctx)
+}
+
+// PersistCache writes the cached keys of the fetcher to the snapshot file of SetCachePersistence now, e.g. before shutting down
+func (f *Fetcher) PersistCache(ctx context.Context) error {
+	p := f.currentPersistence()
+	if p.Path == "" {
+		return errors.New("Cache persistence is not enabled")
+	}
+	if f.InForensicMode() {
+		return fmt.Errorf("%w: snapshots aren't written", ErrForensicMode)
+	}
+
+	var buf bytes.Buffer
+	savedAt := clockNow()
+	if err := f.writeSnapshot(ctx, &buf, p.Encryption, savedAt); err != nil {
+		return err
+	}
+	if err := writeSnapshotFile(p.Path, buf.Bytes()); err != nil {
+		return err
+	}
+	if p.HistoryDir == "" {
+		return nil
+	}
+	if err := writeSnapshotFile(filepath.Join(p.HistoryDir, historySnapshotName(savedAt)), buf.Bytes()); err != nil {
+		return err
+	}
+	return pruneSnapshotHistory(p)
+}
+
+// writeSnapshotFile replaces the snapshot file atomically, so readers never see a partial snapshot
+func writeSnapshotFile(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// WriteSnapshot is Fetcher.WriteSnapshot of the default fetcher
+func WriteSnapshot(ctx context.Context, w io.Writer, encryption SnapshotEncryption) error {
+	return defaultFetcher.WriteSnapshot(ctx, w, encryption)
+}
+
+// WriteSnapshot writes the cached keys of the fetcher, encrypted with the encryption unless nil
+func (f *Fetcher) WriteSnapshot(ctx context.Context, w io.Writer, encryption SnapshotEncryption) error {
+	return f.writeSnapshot(ctx, w, encryption, clockNow())
+}
+
+func (f *Fetcher) writeSnapshot(ctx context.Context, w io.Writer, encryption SnapshotEncryption, savedAt time.Time) error {
+	s := &snapshot{SavedAt: savedAt}
+	for cacheName, cache := range f.snapshotCaches() {
+		for cacheKey, entry := range cache.all() {
+			if entry == nil || entry.keySet == nil {
+				continue
+			}
+			keySet, err := json.Marshal(entry.keySet)
+			if err != nil {
+				return fmt.Errorf("Error while writing snapshot: %w", err)
+			}
+			s.Entries = append(s.Entries, snapshotEntry{
+				Cache:        cacheName,
+				Key:          cacheKey,
+				KeySet:       keySet,
+				JWKsURL:      entry.jwksURL,
+				DiscoverURL:  entry.discoverURL,
+				FetchedAt:    entry.fetchedAt,
+				Algorithms:   entry.algorithms,
+				TokenTypes:   entry.tokenTypes,
+				Header:       entry.header,
+				Source:       entry.source,
+				PeerSPKIHash: entry.peerSPKIHash,
+			})
+		}
+	}
+
+	file := snapshotFile{Version: snapshotFormatVersion, Snapshot: s}
+	if encryption != nil {
+		plaintext, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("Error while writing snapshot: %w", err)
+		}
+		ciphertext, err := encryption.Seal(ctx, plaintext)
+		if err != nil {
+			return fmt.Errorf("Error while encrypting snapshot: %w", err)
+		}
+		file = snapshotFile{Version: snapshotFormatVersion, Encrypted: ciphertext}
+	}
+	return json.NewEncoder(w).Encode(file)
+}
+
+// ReadSnapshot is Fetcher.ReadSnapshot of the default fetcher
+func ReadSnapshot(ctx context.Context, r io.Reader, encryption SnapshotEncryption) error {
+	return defaultFetcher.ReadSnapshot(ctx, r, encryption)
+}
+
+// ReadSnapshot caches in the fetcher the keys of a snapshot written by WriteSnapshot with the same encryption
+func (f *Fetcher) ReadSnapshot(ctx context.Context, r io.Reader, encryption SnapshotEncryption) error {
+	s, err := readSnapshot(ctx, r, encryption)
+	if err != nil {
+		return err
+	}
+	entries, err := f.parseSnapshotEntries(s)
+	if err != nil {
+		return err
+	}
+	f.restoreSnapshotEntries(s, entries)
+	return nil
+}
+
+// readSnapshot decodes and decrypts a snapshot file
+func readSnapshot(ctx context.Context, r io.Reader, encryption SnapshotEncryption) (*snapshot, error) {
+	var file snapshotFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("Error while parsing snapshot: %w", err)
+	}
+	if file.Version != snapshotFormatVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", file.Version)
+	}
+
+	s := file.Snapshot
+	switch {
+	case encryption == nil && file.Encrypted != nil:
+		return nil, ErrSnapshotEncrypted
+	case encryption != nil && file.Encrypted == nil:
+		return nil, ErrSnapshotNotEncrypted
+	case encryption != nil:
+		plaintext, err := encryption.Open(ctx, file.Encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("Error while decrypting snapshot: %w", err)
+		}
+		s = &snapshot{}
+		if err := json.Unmarshal(plaintext, s); err != nil {
+			return nil, fmt.Errorf("Error while parsing snapshot: %w", err)
+		}
+	case s == nil:
+		return nil, errors.New("Error while parsing snapshot: missing 'snapshot' parameter")
+	}
+	return s, nil
+}
+
+// parseSnapshotEntries parses all entries of the snapshot before any is cached, so a bad snapshot isn't partially restored
+func (f *Fetcher) parseSnapshotEntries(s *snapshot) ([]*keySetEntry, error) {
+	caches := f.snapshotCaches()
+	entries := make([]*keySetEntry, len(s.Entries))
+	for i, saved := range s.Entries {
+		if _, ok := caches[saved.Cache]; !ok {
+			return nil, fmt.Errorf("Error while parsing snapshot: unknown cache %q", saved.Cache)
+		}
+		keySet, _, err := f.parseKeySet(bytes.NewReader(saved.KeySet))
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing snapshot keys of %s: %w", saved.Key, err)
+		}
+		entries[i] = &keySetEntry{
+			keySet:       keySet,
+			index:        newKeyIndex(keySet),
+			jwksURL:      saved.JWKsURL,
+			discoverURL:  saved.DiscoverURL,
+			fetchedAt:    saved.FetchedAt,
+			algorithms:   saved.Algorithms,
+			tokenTypes:   saved.TokenTypes,
+			header:       saved.Header,
+			source:       saved.Source,
+			peerSPKIHash: saved.PeerSPKIHash,
+		}
+	}
+	return entries, nil
+}
+
+func (f *Fetcher) restoreSnapshotEntries(s *snapshot, entries []*keySetEntry) {
+	caches := f.snapshotCaches()
+	for i, saved := range s.Entries {
+		// restored entries have the zero version, older than any fetched entry
+		caches[saved.Cache].store(saved.Key, entries[i])
+	}
+}
+
+type aeadEncryption struct {
+	aead cipher.AEAD
+}
+
+// AEADEncryption encrypts snapshots with the AEAD, e.g. AES-GCM with a key of the caller's secret store. Every snapshot gets a random nonce
+func AEADEncryption(aead cipher.AEAD) SnapshotEncryption {
+	return aeadEncryption{aead: aead}
+}
+
+func (e aeadEncryption) Seal(ctx context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(plaintext)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, plaintext, snapshotAdditionalData), nil
+}
+
+func (e aeadEncryption) Open(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < e.aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:e.aead.NonceSize()], ciphertext[e.aead.NonceSize():]
+	return e.aead.Open(nil, nonce, sealed, snapshotAdditionalData)
+}
+
+type envelopeEncryption struct {
+	keyWrapper KeyWrapper
+}
+
+// EnvelopeEncryption encrypts every snapshot with a new AES-256-GCM data key, stored in the snapshot wrapped by the key wrapper
+func EnvelopeEncryption(keyWrapper KeyWrapper) SnapshotEncryption {
+	return envelopeEncryption{keyWrapper: keyWrapper}
+}
+
+// Seal writes the length of the wrapped data key, the wrapped data key and the snapshot sealed with it
+func (e envelopeEncryption) Seal(ctx context.Context, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.keyWrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error while wrapping data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := AEADEncryption(aead).Seal(ctx, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := binary.BigEndian.AppendUint32(nil, uint32(len(wrapped)))
+	ciphertext = append(ciphertext, wrapped...)
+	return append(ciphertext, sealed...), nil
+}
+
+func (e envelopeEncryption) Open(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 4 || uint64(len(ciphertext)-4) < uint64(binary.BigEndian.Uint32(ciphertext)) {
+		return nil, errors.New("ciphertext is too short")
+	}
+	wrappedLen := binary.BigEndian.Uint32(ciphertext)
+	wrapped, sealed := ciphertext[4:4+wrappedLen], ciphertext[4+wrappedLen:]
+	dataKey, err := e.keyWrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("Error while unwrapping data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return AEADEncryption(aead).Open(ctx, sealed)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
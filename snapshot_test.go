@@ -0,0 +1,141 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// xorKeyWrapper is a stand-in for a KMS, wrapping data keys by XOR with its key
+type xorKeyWrapper struct {
+	key byte
+}
+
+func (w xorKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	wrapped := make([]byte, len(key))
+	for i := range key {
+		wrapped[i] = key[i] ^ w.key
+	}
+	return wrapped, nil
+}
+
+func (w xorKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return w.WrapKey(ctx, wrapped)
+}
+
+func testAEAD(t *testing.T, key byte) cipher.AEAD {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestSnapshot(t *testing.T) {
+	const keyID = "512fe2ae0e60bd03084b12885b41423f"
+	tests := []struct {
+		name       string
+		write      SnapshotEncryption
+		read       SnapshotEncryption
+		wantErr    error
+		wantFailed bool
+	}{
+		{name: "Plaintext"},
+		{name: "AEAD", write: AEADEncryption(testAEAD(t, 1)), read: AEADEncryption(testAEAD(t, 1))},
+		{name: "Envelope", write: EnvelopeEncryption(xorKeyWrapper{key: 7}), read: EnvelopeEncryption(xorKeyWrapper{key: 7})},
+		{name: "Wrong AEAD key", write: AEADEncryption(testAEAD(t, 1)), read: AEADEncryption(testAEAD(t, 2)), wantFailed: true},
+		{name: "Wrong wrapping key", write: EnvelopeEncryption(xorKeyWrapper{key: 7}), read: EnvelopeEncryption(xorKeyWrapper{key: 8}), wantFailed: true},
+		{name: "Encrypted without encryption", write: AEADEncryption(testAEAD(t, 1)), wantErr: ErrSnapshotEncrypted},
+		{name: "Plaintext with encryption", read: AEADEncryption(testAEAD(t, 1)), wantErr: ErrSnapshotNotEncrypted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer InvalidateAll()
+			keySet, _, err := defaultFetcher.parseKeySet(strings.NewReader(jwkResponse))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defaultFetcher.jwksCache.set("https://example.com/jwks", &keySetEntry{keySet: keySet, index: newKeyIndex(keySet), jwksURL: "https://example.com/jwks", source: "inline"})
+
+			var buf bytes.Buffer
+			if err := WriteSnapshot(context.Background(), &buf, tt.write); err != nil {
+				t.Fatalf("WriteSnapshot() error = %v", err)
+			}
+			if tt.write != nil && strings.Contains(buf.String(), keyID) {
+				t.Errorf("WriteSnapshot() = %s, want the keys encrypted", buf.String())
+			}
+			InvalidateAll()
+
+			err = ReadSnapshot(context.Background(), &buf, tt.read)
+			if tt.wantErr != nil || tt.wantFailed {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Errorf("ReadSnapshot() error = %v, want %v", err, tt.wantErr)
+				}
+				if defaultFetcher.jwksCache.len() != 0 {
+					t.Errorf("ReadSnapshot() cached %d entries of a rejected snapshot", defaultFetcher.jwksCache.len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadSnapshot() error = %v", err)
+			}
+			entry := defaultFetcher.jwksCache.get("https://example.com/jwks")
+			if entry == nil || !hasKey(entry, keyID) || entry.source != "inline" {
+				t.Errorf("ReadSnapshot() cached %+v, want the written entry", entry)
+			}
+		})
+	}
+}
+
+func TestSetCachePersistence(t *testing.T) {
+	defer InvalidateAll()
+	defer SetCachePersistence(context.Background(), CachePersistence{})
+	path := filepath.Join(t.TempDir(), "keys.snapshot")
+	encryption := EnvelopeEncryption(xorKeyWrapper{key: 3})
+
+	keySet, _, err := defaultFetcher.parseKeySet(strings.NewReader(jwkResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistCache(context.Background()); err == nil {
+		t.Errorf("PersistCache() without persistence error = nil, want error")
+	}
+	if err := SetCachePersistence(context.Background(), CachePersistence{Path: path, Encryption: encryption}); err != nil {
+		t.Fatalf("SetCachePersistence() of a missing file error = %v", err)
+	}
+	defaultFetcher.issuerCache.set("https://example.com", &keySetEntry{keySet: keySet, index: newKeyIndex(keySet)})
+	if err := PersistCache(context.Background()); err != nil {
+		t.Fatalf("PersistCache() error = %v", err)
+	}
+
+	InvalidateAll()
+	if err := SetCachePersistence(context.Background(), CachePersistence{Path: path, Encryption: encryption}); err != nil {
+		t.Fatalf("SetCachePersistence() error = %v", err)
+	}
+	if defaultFetcher.issuerCache.get("https://example.com") == nil {
+		t.Errorf("SetCachePersistence() didn't restore the persisted keys")
+	}
+
+	InvalidateAll()
+	if err := SetCachePersistence(context.Background(), CachePersistence{Path: path}); !errors.Is(err, ErrSnapshotEncrypted) {
+		t.Errorf("SetCachePersistence() without encryption error = %v, want %v", err, ErrSnapshotEncrypted)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("SetCachePersistence() removed the snapshot: %v", err)
+	}
+}
+
+func hasKey(entry *keySetEntry, keyID string) bool {
+	_, err := entry.index.lookupKey(keyID)
+	return err == nil
+}
@@ -0,0 +1,166 @@
+package jwkfetch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// KeySetSource fetches the key set of a JWKs URL, e.g. over HTTP, from a sidecar or from a message bus
+type KeySetSource interface {
+	// FetchKeySet returns the key set of the URL together with the response headers, nil if the source has no headers
+	FetchKeySet(ctx context.Context, jwksURL string) (*jwk.Set, http.Header, error)
+}
+
+// HTTPKeySetSource fetches key sets over HTTP with the providers' transports. It is the default KeySetSource
+type HTTPKeySetSource struct{}
+
+// FetchKeySet fetches the key set from the URL
+func (HTTPKeySetSource) FetchKeySet(ctx context.Context, jwksURL string) (*jwk.Set, http.Header, error) {
+	if err := checkHost(ctx, jwksURL); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error while fetching jwks: %v", err)
+	}
+	if validators, ok := ctx.Value(validatorsKey{}).(http.Header); ok {
+		if etag := validators.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := validators.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	resp, err := httpClientFor(ctx, jwksURL).Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error while fetching jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.Header, fmt.Errorf("Error while fetching jwks: unexpected status code %d", resp.StatusCode)
+	}
+	f := fetcherFrom(ctx)
+	keySet, malformed, err := f.parseKeySet(resp.Body)
+	f.reportMalformedKeys(jwksURL, malformed)
+	if err != nil {
+		return nil, resp.Header, fmt.Errorf("Error while fetching jwks: %w", err)
+	}
+	return keySet, resp.Header, nil
+}
+
+// errNotModified is returned by HTTPKeySetSource for conditional fetches whose key set didn't change
+var errNotModified = errors.New("Key set is not modified")
+
+type validatorsKey struct{}
+
+// withValidators returns a context whose HTTPKeySetSource fetches are conditional on the ETag and Last-Modified of the header
+func withValidators(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, validatorsKey{}, header)
+}
+
+// SetKeySetSource is Fetcher.SetKeySetSource of the default fetcher
+func SetKeySetSource(source KeySetSource) {
+	defaultFetcher.SetKeySetSource(source)
+}
+
+// SetKeySetSource replaces the source of all key sets the fetcher fetches. Discovery documents are still fetched over HTTP. Nil restores HTTPKeySetSource
+func (f *Fetcher) SetKeySetSource(source KeySetSource) {
+	if source == nil {
+		source = HTTPKeySetSource{}
+	}
+	f.transportMu.Lock()
+	defer f.transportMu.Unlock()
+	f.keySetSource = source
+}
+
+func (f *Fetcher) currentKeySetSource() KeySetSource {
+	f.transportMu.RLock()
+	defer f.transportMu.RUnlock()
+	return f.keySetSource
+}
+
+// fetchKeySet fetches the key set from the current source into an entry, marking its errors as fetch errors.
+// The SPKI hash of the TLS peer is traced from the connections of the fetch, so it is known for any source fetching over HTTP with the context.
+// Key sets of responses with ETag or Last-Modified are fetched again conditionally, keeping the previous key set when it isn't modified
+func (f *Fetcher) fetchKeySet(ctx context.Context, jwksURL string) (*keySetEntry, error) {
+	ctx = withFetcher(ctx, f)
+	if err := checkForensicMode(ctx, jwksURL); err != nil {
+		return nil, err
+	}
+	version := nextEntryVersion()
+	var peerSPKIHash string
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if conn, ok := info.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+				if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+					peerSPKIHash = spkiHash(certs[0])
+				}
+			}
+		},
+	})
+	validated := f.validatedCache.get(jwksURL)
+	if validated != nil {
+		ctx = withValidators(ctx, validated.header)
+	}
+	keySet, header, err := f.currentKeySetSource().FetchKeySet(ctx, jwksURL)
+	if errors.Is(err, errNotModified) && validated != nil {
+		keySet, header, err = validated.keySet, revalidatedHeader(validated.header, header), nil
+		if peerSPKIHash == "" {
+			peerSPKIHash = validated.peerSPKIHash
+		}
+	}
+	if err != nil {
+		return nil, &fetchError{err: err}
+	}
+	entry := &keySetEntry{
+		keySet:       keySet,
+		index:        newKeyIndex(keySet),
+		jwksURL:      jwksURL,
+		fetchedAt:    clockNow(),
+		header:       cachingHeader(header),
+		peerSPKIHash: peerSPKIHash,
+		version:      version,
+	}
+	if entry.header.Get("ETag") != "" || entry.header.Get("Last-Modified") != "" {
+		f.validatedCache.store(jwksURL, entry)
+	} else {
+		f.validatedCache.delete(jwksURL)
+	}
+	return entry, nil
+}
+
+// revalidatedHeader is the header of a cached response updated with the caching headers of its not modified response, as in RFC 9111
+func revalidatedHeader(cached, notModified http.Header) http.Header {
+	header := cached.Clone()
+	// the age of the cached response is the one of the not modified response
+	header.Del("Age")
+	for _, name := range cachingHeaders {
+		if values := notModified.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return header
+}
+
+// cachingHeaders are the response headers kept with cached key sets
+var cachingHeaders = []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date", "Age"}
+
+func cachingHeader(header http.Header) http.Header {
+	kept := make(http.Header)
+	for _, name := range cachingHeaders {
+		for _, value := range header.Values(name) {
+			kept.Add(name, value)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,129 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+type fakeKeySetSource struct {
+	keySets map[string]string
+	fetched []string
+}
+
+func (s *fakeKeySetSource) FetchKeySet(ctx context.Context, jwksURL string) (*jwk.Set, http.Header, error) {
+	s.fetched = append(s.fetched, jwksURL)
+	keySet, ok := s.keySets[jwksURL]
+	if !ok {
+		return nil, nil, errors.New("Key set not published")
+	}
+	parsed, err := jwk.ParseString(keySet)
+	return parsed, nil, err
+}
+
+func TestSetKeySetSource(t *testing.T) {
+	source := &fakeKeySetSource{keySets: map[string]string{"bus://keys/published": jwkResponse}}
+	SetKeySetSource(source)
+	defer SetKeySetSource(nil)
+
+	tests := []struct {
+		name    string
+		jwksURL string
+		wantErr bool
+	}{
+		{name: "Published", jwksURL: "bus://keys/published"},
+		{name: "Not published", jwksURL: "bus://keys/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer defaultFetcher.jwksCache.delete(tt.jwksURL)
+			_, err := FromJWKsURL(tt.jwksURL)(mockToken())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromJWKsURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(source.fetched) == 0 || source.fetched[len(source.fetched)-1] != tt.jwksURL {
+				t.Errorf("Key set source fetched %v, want %s", source.fetched, tt.jwksURL)
+			}
+		})
+	}
+
+	SetKeySetSource(nil)
+	if _, ok := defaultFetcher.currentKeySetSource().(HTTPKeySetSource); !ok {
+		t.Errorf("SetKeySetSource(nil) didn't restore HTTPKeySetSource")
+	}
+}
+
+func TestConditionalFetch(t *testing.T) {
+	_, firstKeySet := newTestKeySet(t, "first-key")
+	_, secondKeySet := newTestKeySet(t, "second-key")
+	var mu sync.Mutex
+	var keySet, etag, lastModified, gotIfNoneMatch, gotIfModifiedSince string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gotIfNoneMatch, gotIfModifiedSince = r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if lastModified != "" {
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		w.Header().Set("Cache-Control", "max-age=600")
+		if (etag != "" && gotIfNoneMatch == etag) || (etag == "" && lastModified != "" && gotIfModifiedSince == lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name                string
+		keySet              string
+		etag                string
+		lastModified        string
+		wantIfNoneMatch     string
+		wantIfModifiedSince string
+		wantKeyID           string
+	}{
+		{name: "First fetch", keySet: firstKeySet, etag: `"v1"`, wantKeyID: "first-key"},
+		{name: "Not modified keeps the key set", keySet: secondKeySet, etag: `"v1"`, wantIfNoneMatch: `"v1"`, wantKeyID: "first-key"},
+		{name: "Modified", keySet: secondKeySet, etag: `"v2"`, wantIfNoneMatch: `"v1"`, wantKeyID: "second-key"},
+		{name: "Last modified", keySet: firstKeySet, lastModified: modified, wantIfNoneMatch: `"v2"`, wantKeyID: "first-key"},
+		{name: "Not modified since", keySet: secondKeySet, lastModified: modified, wantIfModifiedSince: modified, wantKeyID: "first-key"},
+		{name: "No validators", keySet: secondKeySet, wantIfModifiedSince: modified, wantKeyID: "second-key"},
+		{name: "Unconditional without validators", keySet: firstKeySet, wantKeyID: "first-key"},
+	}
+	f := newFetcher()
+	defer f.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			keySet, etag, lastModified = tt.keySet, tt.etag, tt.lastModified
+			mu.Unlock()
+			entry, err := f.fetchKeySet(context.Background(), server.URL+"/jwks")
+			if err != nil {
+				t.Fatalf("fetchKeySet() error = %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if gotIfNoneMatch != tt.wantIfNoneMatch || gotIfModifiedSince != tt.wantIfModifiedSince {
+				t.Errorf("If-None-Match = %q, If-Modified-Since = %q, want %q, %q", gotIfNoneMatch, gotIfModifiedSince, tt.wantIfNoneMatch, tt.wantIfModifiedSince)
+			}
+			if len(entry.keySet.Keys) != 1 || entry.keySet.Keys[0].KeyID() != tt.wantKeyID {
+				t.Errorf("fetchKeySet() keys = %v, want %s", entry.keySet.Keys, tt.wantKeyID)
+			}
+			if got := entry.header.Get("Cache-Control"); got != "max-age=600" {
+				t.Errorf("fetchKeySet() Cache-Control = %q, want %q", got, "max-age=600")
+			}
+		})
+	}
+}
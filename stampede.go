@@ -0,0 +1,102 @@
+package jwkfetch
+
+import (
+	"sort"
+	"time"
+)
+
+// StampedeStats describes the concurrent refreshes forced by unknown kids for a cached issuer or URL
+type StampedeStats struct {
+	Key string
+	// ForcedRefreshes counts the refreshes forced by unknown kids
+	ForcedRefreshes uint64
+	// Waiters counts the forced refreshes that started while another one of the key was in flight, and so shared its fetch
+	Waiters uint64
+	// MaxWaiters is the most waiters of the key at once
+	MaxWaiters int
+	// WaitTotal and WaitMax are the time the waiters took until their refresh returned
+	WaitTotal time.Duration
+	WaitMax   time.Duration
+}
+
+type stampedeRecorder struct {
+	stats    StampedeStats
+	inFlight int
+}
+
+// SetStampedeDebug is Fetcher.SetStampedeDebug of the default fetcher
+func SetStampedeDebug(enabled bool) {
+	defaultFetcher.SetStampedeDebug(enabled)
+}
+
+// SetStampedeDebug records the concurrency of the refreshes forced by unknown kids of the fetcher, reported by StampedeReport,
+// e.g. to tune TTLs. Enabling it resets the recorded stats
+func (f *Fetcher) SetStampedeDebug(enabled bool) {
+	f.stampedeMu.Lock()
+	defer f.stampedeMu.Unlock()
+	f.stampedeRecorders = make(map[string]*stampedeRecorder)
+	f.stampedeDebug = enabled
+}
+
+// StampedeReport is Fetcher.StampedeReport of the default fetcher
+func StampedeReport() []StampedeStats {
+	return defaultFetcher.StampedeReport()
+}
+
+// StampedeReport returns the stats recorded since SetStampedeDebug, sorted from the most to the least waiters
+func (f *Fetcher) StampedeReport() []StampedeStats {
+	f.stampedeMu.Lock()
+	report := make([]StampedeStats, 0, len(f.stampedeRecorders))
+	for _, recorder := range f.stampedeRecorders {
+		report = append(report, recorder.stats)
+	}
+	f.stampedeMu.Unlock()
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Waiters != report[j].Waiters {
+			return report[i].Waiters > report[j].Waiters
+		}
+		return report[i].Key < report[j].Key
+	})
+	return report
+}
+
+// beginForcedRefresh records the start of a refresh forced by an unknown kid and returns the func recording its end
+func (f *Fetcher) beginForcedRefresh(key string) func() {
+	f.stampedeMu.Lock()
+	defer f.stampedeMu.Unlock()
+	if !f.stampedeDebug {
+		return func() {}
+	}
+	recorder, ok := f.stampedeRecorders[key]
+	if !ok {
+		if len(f.stampedeRecorders) >= maxEndpoints {
+			return func() {}
+		}
+		recorder = &stampedeRecorder{stats: StampedeStats{Key: key}}
+		f.stampedeRecorders[key] = recorder
+	}
+	recorder.stats.ForcedRefreshes++
+	waiting := recorder.inFlight > 0
+	recorder.inFlight++
+	if waiting {
+		recorder.stats.Waiters++
+		if recorder.inFlight-1 > recorder.stats.MaxWaiters {
+			recorder.stats.MaxWaiters = recorder.inFlight - 1
+		}
+	}
+
+	start := time.Now()
+	return func() {
+		wait := time.Since(start)
+		f.stampedeMu.Lock()
+		defer f.stampedeMu.Unlock()
+		recorder.inFlight--
+		if waiting {
+			recorder.stats.WaitTotal += wait
+			if wait > recorder.stats.WaitMax {
+				recorder.stats.WaitMax = wait
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package jwkfetch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStampedeReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		want        []StampedeStats
+		wantWaitMin time.Duration
+	}{
+		{name: "Disabled", enabled: false, want: []StampedeStats{}},
+		{name: "Enabled", enabled: true, want: []StampedeStats{
+			{Key: "https://a.example.com", ForcedRefreshes: 3, Waiters: 2, MaxWaiters: 2},
+			{Key: "https://b.example.com", ForcedRefreshes: 2, Waiters: 0, MaxWaiters: 0},
+		}, wantWaitMin: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStampedeDebug(tt.enabled)
+			defer SetStampedeDebug(false)
+
+			first := defaultFetcher.beginForcedRefresh("https://a.example.com")
+			second := defaultFetcher.beginForcedRefresh("https://a.example.com")
+			third := defaultFetcher.beginForcedRefresh("https://a.example.com")
+			time.Sleep(10 * time.Millisecond)
+			third()
+			second()
+			first()
+			defaultFetcher.beginForcedRefresh("https://b.example.com")()
+			defaultFetcher.beginForcedRefresh("https://b.example.com")()
+
+			got := StampedeReport()
+			if len(got) != len(tt.want) {
+				t.Fatalf("StampedeReport() = %+v, want %+v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].Key != want.Key || got[i].ForcedRefreshes != want.ForcedRefreshes || got[i].Waiters != want.Waiters || got[i].MaxWaiters != want.MaxWaiters {
+					t.Errorf("StampedeReport()[%d] = %+v, want %+v", i, got[i], want)
+				}
+			}
+			if tt.enabled && (got[0].WaitTotal < 2*tt.wantWaitMin || got[0].WaitMax < tt.wantWaitMin || got[1].WaitTotal != 0) {
+				t.Errorf("StampedeReport() waits = %v, %v, %v, want waits of the waiters only", got[0].WaitTotal, got[0].WaitMax, got[1].WaitTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package jwkfetch
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// ProviderStats describes the cached key set of a provider
+type ProviderStats struct {
+	Issuer    string
+	FetchedAt time.Time
+	// MaxStaleRemaining is the time left until the cached key set exceeds the provider's MaxStale, negative once exceeded.
+	// Zero when the provider has no MaxStale or no cached key set
+	MaxStaleRemaining time.Duration
+	// ApproxBytes approximates the memory used by the provider's cached keys
+	ApproxBytes uint64
+	// CachingHeader holds the Cache-Control, Expires, ETag, Last-Modified, Date and Age headers of the response of the cached key set
+	CachingHeader http.Header
+	// RefreshFailures counts the consecutive failures of the provider's scheduled refresh
+	RefreshFailures int
+	// Degraded is true once RefreshFailures reaches the provider's RefreshEscalation.FailureThreshold
+	Degraded bool
+	// RefreshInterval is the interval of the provider's scheduled refresh, as adapted by its AdaptiveRefresh. Zero when it has none
+	RefreshInterval time.Duration
+	// Migration counts the keys resolved from each source while the provider has a Migration, nil otherwise
+	Migration *MigrationStats
+	// Rotation describes how often the provider's keys changed
+	Rotation RotationStats
+	// Provenance describes where the provider's cached key set came from
+	Provenance KeyProvenance
+}
+
+// Stats is Fetcher.Stats of the default fetcher
+func Stats() []ProviderStats {
+	return defaultFetcher.Stats()
+}
+
+// Stats returns the stats of the configured providers
+func (f *Fetcher) Stats() []ProviderStats {
+	providers := f.Providers()
+	stats := make([]ProviderStats, 0, len(providers))
+	for _, jwkProvider := range providers {
+		providerStats := ProviderStats{
+			Issuer:          jwkProvider.Issuer,
+			RefreshInterval: f.scheduledRefreshInterval(jwkProvider),
+			Rotation:        f.getRotationStats(jwkProvider.Issuer),
+		}
+		providerStats.RefreshFailures, providerStats.Degraded = f.refreshDegraded(jwkProvider)
+		if jwkProvider.Migration != nil {
+			providerStats.Migration = f.getMigrationStats(jwkProvider.Issuer)
+		}
+		if entry := f.issuerCache.get(jwkProvider.Issuer); entry != nil {
+			providerStats.FetchedAt = entry.fetchedAt
+			providerStats.ApproxBytes = entry.approxBytes()
+			providerStats.CachingHeader = entry.header.Clone()
+			providerStats.Provenance = entry.provenance()
+			if jwkProvider.MaxStale > 0 {
+				providerStats.MaxStaleRemaining = jwkProvider.MaxStale - elapsedSince(entry.fetchedAt)
+			}
+		}
+		stats = append(stats, providerStats)
+	}
+	return stats
+}
+
+// maxLatencySamples bounds the latency samples kept per endpoint for percentiles
+const maxLatencySamples = 1000
+
+// maxEndpoints bounds the recorded endpoints, since endpoints may be derived from token claims
+const maxEndpoints = 1000
+
+// EndpointStats describes the fetches of an endpoint
+type EndpointStats struct {
+	URL      string
+	Requests uint64
+	// Errors counts requests that failed without a response
+	Errors      uint64
+	StatusCodes map[int]uint64
+	// Latency percentiles until the response headers, over the last 1000 requests
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+	// Bytes is the total size of the read response bodies and LastBytes the size of the last one
+	Bytes     uint64
+	LastBytes uint64
+	// MalformedKeys counts the keys of fetched key sets skipped because they couldn't be parsed
+	MalformedKeys uint64
+}
+
+type endpointRecorder struct {
+	stats     EndpointStats
+	latencies []time.Duration
+	next      int
+}
+
+var endpointStatsMu sync.Mutex
+var endpointRecorders map[string]*endpointRecorder = make(map[string]*endpointRecorder)
+
+// FetchStats returns the stats of the fetched endpoints sorted by URL
+func FetchStats() []EndpointStats {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+
+	stats := make([]EndpointStats, 0, len(endpointRecorders))
+	for _, recorder := range endpointRecorders {
+		endpointStats := recorder.stats
+		endpointStats.StatusCodes = make(map[int]uint64, len(recorder.stats.StatusCodes))
+		for code, count := range recorder.stats.StatusCodes {
+			endpointStats.StatusCodes[code] = count
+		}
+		sorted := append([]time.Duration(nil), recorder.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		endpointStats.LatencyP50 = percentile(sorted, 50)
+		endpointStats.LatencyP90 = percentile(sorted, 90)
+		endpointStats.LatencyP99 = percentile(sorted, 99)
+		stats = append(stats, endpointStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
+
+// endpointLatency is the median latency of the endpoint's recorded fetches, false when it wasn't fetched
+func endpointLatency(endpoint string) (time.Duration, bool) {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+	recorder, ok := endpointRecorders[endpoint]
+	if !ok || len(recorder.latencies) == 0 {
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), recorder.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, 50), true
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func endpointRecorderFor(endpoint string) *endpointRecorder {
+	recorder, ok := endpointRecorders[endpoint]
+	if !ok && len(endpointRecorders) >= maxEndpoints {
+		return &endpointRecorder{stats: EndpointStats{StatusCodes: make(map[int]uint64)}}
+	}
+	if !ok {
+		recorder = &endpointRecorder{stats: EndpointStats{URL: endpoint, StatusCodes: make(map[int]uint64)}}
+		endpointRecorders[endpoint] = recorder
+	}
+	return recorder
+}
+
+func recordFetch(endpoint string, latency time.Duration, resp *http.Response, err error) {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+
+	recorder := endpointRecorderFor(endpoint)
+	recorder.stats.Requests++
+	if err != nil {
+		recorder.stats.Errors++
+		return
+	}
+	recorder.stats.StatusCodes[resp.StatusCode]++
+	if len(recorder.latencies) < maxLatencySamples {
+		recorder.latencies = append(recorder.latencies, latency)
+	} else {
+		recorder.latencies[recorder.next] = latency
+		recorder.next = (recorder.next + 1) % maxLatencySamples
+	}
+}
+
+func recordFetchBytes(endpoint string, bytes uint64) {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+
+	recorder := endpointRecorderFor(endpoint)
+	recorder.stats.Bytes += bytes
+	recorder.stats.LastBytes = bytes
+}
+
+func recordMalformedKeys(endpoint string, keys uint64) {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+
+	recorder := endpointRecorderFor(endpoint)
+	recorder.stats.MalformedKeys += keys
+}
+
+// countingBody records the size of a response body when it is closed
+type countingBody struct {
+	io.ReadCloser
+	endpoint string
+	bytes    uint64
+	once     sync.Once
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += uint64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(func() {
+		recordFetchBytes(b.endpoint, b.bytes)
+	})
+	return b.ReadCloser.Close()
+}
+
+// statsTransport records the stats of the requests of its base transport
+type statsTransport struct {
+	base http.RoundTripper
+}
+
+func (t statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := req.URL.String()
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	recordFetch(endpoint, time.Since(start), resp, err)
+	recordCallerFetch(req.Context())
+	if err == nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, endpoint: endpoint}
+	}
+	return resp, err
+}
+
+// CacheMemoryStats approximates the memory used by the cached key sets
+type CacheMemoryStats struct {
+	// Entries counts the cache entries of all cache layers
+	Entries int
+	// KeySets and Keys count the distinct cached key sets and their keys, since entries of different layers share key sets
+	KeySets int
+	Keys    int
+	// ApproxBytes approximates the memory used by the keys with the size of their JSON
+	ApproxBytes uint64
+}
+
+// CacheMemory is Fetcher.CacheMemory of the default fetcher
+func CacheMemory() CacheMemoryStats {
+	return defaultFetcher.CacheMemory()
+}
+
+// CacheMemory returns the approximate memory used by the cached key sets
+func (f *Fetcher) CacheMemory() CacheMemoryStats {
+	var stats CacheMemoryStats
+	seen := make(map[*jwk.Set]bool)
+	for _, cache := range f.allCaches() {
+		for _, entry := range cache.all() {
+			if entry == nil {
+				continue
+			}
+			stats.Entries++
+			if entry.keySet == nil || seen[entry.keySet] {
+				continue
+			}
+			seen[entry.keySet] = true
+			stats.KeySets++
+			stats.Keys += len(entry.keySet.Keys)
+			stats.ApproxBytes += entry.approxBytes()
+		}
+	}
+	return stats
+}
+
+// AccessStats describes the key lookups of a cached issuer, or of a discover or JWKs URL for keyfuncs of a fixed URL
+type AccessStats struct {
+	Key string
+	// Configured is true when Key is the Issuer, DiscoverURL or JWKURL of a registered provider
+	Configured bool
+	// Accesses counts the keys looked up for tokens, before their signature is verified
+	Accesses   uint64
+	LastAccess time.Time
+}
+
+// AccessReport is Fetcher.AccessReport of the default fetcher
+func AccessReport() []AccessStats {
+	return defaultFetcher.AccessReport()
+}
+
+// AccessReport returns the access stats of the cached issuers and of the registered providers, including the ones never accessed,
+// sorted from the most to the least accessed, so operators can find providers that receive no traffic
+func (f *Fetcher) AccessReport() []AccessStats {
+	configured := make(map[string]bool)
+	for _, jwkProvider := range f.Providers() {
+		configured[providerKey(jwkProvider)] = true
+	}
+
+	f.statsMu.Lock()
+	report := make([]AccessStats, 0, len(f.accessStats)+len(configured))
+	for key, stats := range f.accessStats {
+		access := *stats
+		access.Configured = configured[key]
+		delete(configured, key)
+		report = append(report, access)
+	}
+	f.statsMu.Unlock()
+	for key := range configured {
+		report = append(report, AccessStats{Key: key, Configured: true})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Accesses != report[j].Accesses {
+			return report[i].Accesses > report[j].Accesses
+		}
+		return report[i].Key < report[j].Key
+	})
+	return report
+}
+
+func (f *Fetcher) recordAccess(key string) {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	stats, ok := f.accessStats[key]
+	if !ok {
+		if len(f.accessStats) >= maxEndpoints {
+			return
+		}
+		stats = &AccessStats{Key: key}
+		f.accessStats[key] = stats
+	}
+	stats.Accesses++
+	stats.LastAccess = time.Now()
+}
+
+func (f *Fetcher) forgetAccess(key string) {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	delete(f.accessStats, key)
+}
@@ -0,0 +1,235 @@
+package jwkfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestStats(t *testing.T) {
+	withMaxStale := JWKProvider{Issuer: fmt.Sprintf("http://%s/stats", httptestServerURL), MaxStale: time.Hour}
+	withoutMaxStale := JWKProvider{Issuer: fmt.Sprintf("http://%s/stats-no-bound", httptestServerURL)}
+	notCached := JWKProvider{Issuer: fmt.Sprintf("http://%s/stats-not-cached", httptestServerURL), MaxStale: time.Hour}
+	defaultFetcher.setProviders([]JWKProvider{withMaxStale, withoutMaxStale, notCached})
+	defer defaultFetcher.setProviders(nil)
+
+	fetchedAt := time.Now().Add(-20 * time.Minute)
+	defaultFetcher.issuerCache.set(withMaxStale.Issuer, &keySetEntry{fetchedAt: fetchedAt})
+	defaultFetcher.issuerCache.set(withoutMaxStale.Issuer, &keySetEntry{fetchedAt: fetchedAt})
+	defer defaultFetcher.issuerCache.delete(withMaxStale.Issuer)
+	defer defaultFetcher.issuerCache.delete(withoutMaxStale.Issuer)
+
+	stats := Stats()
+	if len(stats) != 3 {
+		t.Fatalf("Stats() returned %d providers, want 3", len(stats))
+	}
+	tests := []struct {
+		name          string
+		stats         ProviderStats
+		wantFetchedAt time.Time
+		wantMin       time.Duration
+		wantMax       time.Duration
+	}{
+		{name: "With MaxStale", stats: stats[0], wantFetchedAt: fetchedAt, wantMin: 39 * time.Minute, wantMax: 40 * time.Minute},
+		{name: "Without MaxStale", stats: stats[1], wantFetchedAt: fetchedAt},
+		{name: "Not cached", stats: stats[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.stats.FetchedAt.Equal(tt.wantFetchedAt) {
+				t.Errorf("FetchedAt = %v, want %v", tt.stats.FetchedAt, tt.wantFetchedAt)
+			}
+			if got := tt.stats.MaxStaleRemaining; got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("MaxStaleRemaining = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestFetchStats(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fetch-stats/jwks" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	jwksURL := fmt.Sprintf("http://%s/fetch-stats/jwks", httptestServerURL)
+	missingURL := fmt.Sprintf("http://%s/fetch-stats/missing", httptestServerURL)
+	defer delete(endpointRecorders, jwksURL)
+	defer delete(endpointRecorders, missingURL)
+	for i := 0; i < 2; i++ {
+		if _, err := defaultFetcher.getKeySet(context.Background(), jwksURL); err != nil {
+			t.Fatalf("getKeySet() error = %v", err)
+		}
+	}
+	defaultFetcher.getKeySet(context.Background(), missingURL)
+
+	got := make(map[string]EndpointStats)
+	for _, endpointStats := range FetchStats() {
+		got[endpointStats.URL] = endpointStats
+	}
+	tests := []struct {
+		name        string
+		url         string
+		wantCodes   map[int]uint64
+		wantBytes   uint64
+		wantLatency bool
+	}{
+		{name: "Key set", url: jwksURL, wantCodes: map[int]uint64{http.StatusOK: 2}, wantBytes: 2 * uint64(len(jwkResponse)), wantLatency: true},
+		{name: "Missing", url: missingURL, wantCodes: map[int]uint64{http.StatusNotFound: 1}, wantLatency: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpointStats, ok := got[tt.url]
+			if !ok {
+				t.Fatalf("FetchStats() doesn't have %s", tt.url)
+			}
+			if !reflect.DeepEqual(endpointStats.StatusCodes, tt.wantCodes) {
+				t.Errorf("StatusCodes = %v, want %v", endpointStats.StatusCodes, tt.wantCodes)
+			}
+			if endpointStats.Bytes != tt.wantBytes {
+				t.Errorf("Bytes = %v, want %v", endpointStats.Bytes, tt.wantBytes)
+			}
+			if (endpointStats.LatencyP99 > 0) != tt.wantLatency || endpointStats.LatencyP50 > endpointStats.LatencyP99 {
+				t.Errorf("LatencyP50 = %v, LatencyP99 = %v", endpointStats.LatencyP50, endpointStats.LatencyP99)
+			}
+		})
+	}
+}
+
+func Test_percentile(t *testing.T) {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		p       int
+		want    time.Duration
+	}{
+		{name: "No samples", p: 50, want: 0},
+		{name: "Median", samples: samples, p: 50, want: 50 * time.Millisecond},
+		{name: "P99", samples: samples, p: 99, want: 99 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.samples, tt.p); got != tt.want {
+				t.Errorf("percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheMemory(t *testing.T) {
+	saved := []*entryCache{defaultFetcher.issuerCache, defaultFetcher.jwksCache, defaultFetcher.discoverURLsCache, defaultFetcher.didCache, defaultFetcher.vcIssuerCache}
+	defer func() {
+		defaultFetcher.issuerCache, defaultFetcher.jwksCache, defaultFetcher.discoverURLsCache, defaultFetcher.didCache, defaultFetcher.vcIssuerCache = saved[0], saved[1], saved[2], saved[3], saved[4]
+	}()
+	keySet, _ := jwk.ParseString(jwkResponse)
+	otherKeySet, _ := jwk.ParseString(cachedSet)
+	entry := &keySetEntry{keySet: keySet, index: newKeyIndex(keySet)}
+	defaultFetcher.issuerCache = &entryCache{entries: map[string]*keySetEntry{"issuer": entry}}
+	defaultFetcher.jwksCache = &entryCache{entries: map[string]*keySetEntry{"jwks": entry, "other": {keySet: otherKeySet}}}
+	defaultFetcher.discoverURLsCache = &entryCache{entries: map[string]*keySetEntry{"discover": {keySet: keySet, index: entry.index}}}
+	defaultFetcher.didCache = newEntryCache()
+	defaultFetcher.vcIssuerCache = newEntryCache()
+
+	got := CacheMemory()
+	if got.Entries != 4 || got.KeySets != 2 || got.Keys != 3 {
+		t.Errorf("CacheMemory() = %+v, want 4 entries, 2 key sets and 3 keys", got)
+	}
+	if want := entry.approxBytes() + newKeyIndex(otherKeySet).approxBytes; got.ApproxBytes != want || want == 0 {
+		t.Errorf("CacheMemory() ApproxBytes = %d, want %d", got.ApproxBytes, want)
+	}
+}
+
+func TestAccessReport(t *testing.T) {
+	keySet, _ := jwk.ParseString(jwkResponse)
+	hot := "https://hot.example.com"
+	warm := "https://warm.example.com"
+	cold := JWKProvider{Issuer: "https://cold.example.com"}
+	f := newFetcher()
+	f.setProviders([]JWKProvider{{Issuer: hot}, cold})
+
+	cache := &entryCache{entries: map[string]*keySetEntry{
+		hot:  {keySet: keySet},
+		warm: {keySet: keySet},
+	}}
+	retrieveFn := func(ctx context.Context, cacheKey string) (*keySetEntry, error) {
+		return cache.get(cacheKey), nil
+	}
+	for _, cacheKey := range []string{hot, hot, warm} {
+		if _, err := f.retrieveKey(context.Background(), mockToken(), cacheKey, cache, retrieveFn); err != nil {
+			t.Fatalf("retrieveKey() error = %v", err)
+		}
+	}
+	for _, access := range AccessReport() {
+		if access.Key == hot || access.Key == warm {
+			t.Errorf("AccessReport() of the default fetcher has %+v of another fetcher", access)
+		}
+	}
+
+	want := []AccessStats{
+		{Key: hot, Configured: true, Accesses: 2},
+		{Key: warm, Accesses: 1},
+		{Key: cold.Issuer, Configured: true},
+	}
+	got := f.AccessReport()
+	if len(got) != len(want) {
+		t.Fatalf("AccessReport() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Key != want[i].Key || got[i].Configured != want[i].Configured || got[i].Accesses != want[i].Accesses {
+			t.Errorf("AccessReport()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+		if got[i].LastAccess.IsZero() != (want[i].Accesses == 0) {
+			t.Errorf("AccessReport()[%d].LastAccess = %v", i, got[i].LastAccess)
+		}
+	}
+}
+
+func TestStatsCachingHeader(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/caching/.well-known/openid-configuration":
+			io.WriteString(w, fmt.Sprintf(`{"jwks_uri": "http://%s/caching/jwks"}`, httptestServerURL))
+		case "/caching/jwks":
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Age", "120")
+			w.Header().Set("X-Request-Id", "ignored")
+			io.WriteString(w, jwkResponse)
+		}
+	}))
+	defer server.Close()
+
+	jwkProvider := JWKProvider{Issuer: fmt.Sprintf("http://%s/caching", httptestServerURL)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+	if err := defaultFetcher.cacheProvider(context.Background(), jwkProvider); err != nil {
+		t.Fatalf("cacheProvider() error = %v", err)
+	}
+
+	header := Stats()[0].CachingHeader
+	for name, want := range map[string]string{"Cache-Control": "public, max-age=3600", "Etag": `"v1"`, "Age": "120", "X-Request-Id": ""} {
+		if got := header.Get(name); got != want {
+			t.Errorf("CachingHeader %s = %q, want %q", name, got, want)
+		}
+	}
+	if header.Get("Date") == "" {
+		t.Errorf("CachingHeader doesn't have Date")
+	}
+}
@@ -0,0 +1,278 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// The stress tests run hundreds of goroutines over the package state shared between requests and background refreshes.
+// They are meant to be run with -race, which reports the unsynchronized accesses even when the results look right
+const stressGoroutines = 200
+
+// stress runs fn on stressGoroutines goroutines started together and waits for them
+func stress(fn func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < stressGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestStressRevocation(t *testing.T) {
+	defer SetRevokedKeys(nil)
+
+	stress(func(i int) {
+		keyID := fmt.Sprintf("kid-%d", i%10)
+		switch i % 4 {
+		case 0:
+			RevokeKey("issuer", keyID)
+		case 1:
+			UnrevokeKey("issuer", keyID)
+		case 2:
+			defaultFetcher.isKeyRevoked("issuer", keyID)
+		default:
+			RevokedKeys()
+		}
+	})
+
+	SetRevokedKeys(nil)
+	stress(func(i int) {
+		RevokeKey("issuer", fmt.Sprintf("kid-%d", i))
+	})
+	if got := len(RevokedKeys()); got != stressGoroutines {
+		t.Errorf("len(RevokedKeys()) = %d, want %d", got, stressGoroutines)
+	}
+}
+
+func TestStressReplayCache(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	var unused int32
+	stress(func(i int) {
+		ok, err := cache.Use(context.Background(), "issuer jti", time.Minute)
+		if err != nil {
+			t.Errorf("Use() error = %v", err)
+		}
+		if ok {
+			atomic.AddInt32(&unused, 1)
+		}
+	})
+	if unused != 1 {
+		t.Errorf("Use() returned true %d times, want once", unused)
+	}
+}
+
+func TestStressRefreshQuota(t *testing.T) {
+	SetRefreshQuota(RefreshQuota{Refreshes: 5, Interval: time.Hour})
+	defer SetRefreshQuota(RefreshQuota{})
+
+	var allowed [2]int32
+	stress(func(i int) {
+		if defaultFetcher.allowForcedRefresh(WithCaller(context.Background(), fmt.Sprintf("caller-%d", i%2))) {
+			atomic.AddInt32(&allowed[i%2], 1)
+		}
+	})
+	for caller, got := range allowed {
+		if got != 5 {
+			t.Errorf("caller-%d allowed %d refreshes, want 5", caller, got)
+		}
+	}
+}
+
+func TestStressCallerStats(t *testing.T) {
+	callerStatsMu.Lock()
+	callerStats = make(map[string]*CallerStats)
+	callerStatsMu.Unlock()
+
+	stress(func(i int) {
+		ctx := WithCaller(context.Background(), "stress")
+		recordCallerFetch(ctx)
+		recordCallerKeyMiss(ctx)
+		CallerReport()
+	})
+
+	for _, stats := range CallerReport() {
+		if stats.Caller != "stress" {
+			continue
+		}
+		if stats.Fetches != stressGoroutines || stats.KeyMisses != stressGoroutines {
+			t.Errorf("CallerReport() = %+v, want %d fetches and key misses", stats, stressGoroutines)
+		}
+		return
+	}
+	t.Errorf("CallerReport() doesn't have caller stress")
+}
+
+func TestStressStampede(t *testing.T) {
+	SetStampedeDebug(true)
+	defer SetStampedeDebug(false)
+
+	stress(func(i int) {
+		done := defaultFetcher.beginForcedRefresh("stress")
+		StampedeReport()
+		done()
+	})
+
+	report := StampedeReport()
+	if len(report) != 1 {
+		t.Fatalf("StampedeReport() = %+v, want the stress key", report)
+	}
+	if report[0].ForcedRefreshes != stressGoroutines || report[0].Waiters >= stressGoroutines {
+		t.Errorf("StampedeReport() = %+v, want %d forced refreshes", report[0], stressGoroutines)
+	}
+}
+
+func TestStressHooks(t *testing.T) {
+	defer SetHooks(Hooks{})
+
+	var degraded int32
+	stress(func(i int) {
+		if i%2 == 0 {
+			SetHooks(Hooks{OnProviderDegraded: func(ProviderDegraded) { atomic.AddInt32(&degraded, 1) }})
+			return
+		}
+		if onProviderDegraded := defaultFetcher.currentHooks().OnProviderDegraded; onProviderDegraded != nil {
+			onProviderDegraded(ProviderDegraded{Issuer: "issuer"})
+		}
+	})
+	if degraded > stressGoroutines/2 {
+		t.Errorf("OnProviderDegraded called %d times, want at most %d", degraded, stressGoroutines/2)
+	}
+}
+
+func TestStressAllowedHosts(t *testing.T) {
+	defer SetAllowedHosts()
+
+	stress(func(i int) {
+		if i%10 == 0 {
+			if err := SetAllowedHosts("*.example.com"); err != nil {
+				t.Errorf("SetAllowedHosts() error = %v", err)
+			}
+			return
+		}
+		if err := checkHost(context.Background(), "https://login.example.com/jwks"); err != nil {
+			t.Errorf("checkHost() error = %v", err)
+		}
+	})
+}
+
+func TestStressKeyResolution(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/jwks" {
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, "http://"+r.Host, "http://"+r.Host+"/jwks")
+	}))
+	defer server.Close()
+	defer InvalidateAll()
+	issuer := server.URL
+	jwksURL := server.URL + "/jwks"
+
+	token := mockToken()
+	token.Claims = jwt.MapClaims{"iss": issuer}
+	unknownJWS := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"unknown"}`)) + ".e30.c2lnbmF0dXJl"
+	stress(func(i int) {
+		var err error
+		switch i % 10 {
+		case 0:
+			Invalidate(issuer)
+		case 1:
+			InvalidateAll()
+		case 2:
+			// an unknown kid forces a refresh of the issuer's key set
+			_, err = VerifyJWS(context.Background(), issuer, []byte(unknownJWS), nil)
+			if errors.Is(err, ErrKeyNotFound) {
+				err = nil
+			}
+		case 3:
+			defaultFetcher.evictIdleEntries(time.Now())
+			CacheMemory()
+			Stats()
+		case 4:
+			_, err = FromJWKsURL(jwksURL)(token)
+		case 5:
+			_, err = FromDiscoverURL(issuer + "/.well-known/openid-configuration")(token)
+		default:
+			_, err = FromIssuerClaim()(token)
+		}
+		if err != nil {
+			t.Errorf("keyfunc error = %v", err)
+		}
+	})
+
+	if _, err := FromIssuerClaim()(token); err != nil {
+		t.Errorf("FromIssuerClaim() after stress error = %v", err)
+	}
+}
+
+func TestStressProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, jwkResponse)
+	}))
+	defer server.Close()
+
+	const issuers = 5
+	providers := make([]JWKProvider, issuers)
+	for i := range providers {
+		providers[i] = JWKProvider{Issuer: fmt.Sprintf("%s/issuer-%d", server.URL, i), JWKURL: fmt.Sprintf("%s/issuer-%d/jwks", server.URL, i)}
+	}
+	f, err := New(providers)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	stress(func(i int) {
+		jwkProvider := providers[i%issuers]
+		switch i % 10 {
+		case 0:
+			f.AddProvider(jwkProvider)
+		case 1:
+			f.RemoveProvider(jwkProvider.Issuer)
+		case 2:
+			jwkProvider.RefreshInterval = time.Hour
+			f.UpdateProvider(jwkProvider)
+		case 3:
+			f.Providers()
+			f.refreshCaches()
+		default:
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			if _, err := f.FromIssuerClaim()(token); err != nil && !errors.Is(err, ErrIssuerNotAllowed) {
+				t.Errorf("FromIssuerClaim() error = %v", err)
+			}
+		}
+	})
+
+	for _, jwkProvider := range providers {
+		f.RemoveProvider(jwkProvider.Issuer)
+		if err := f.AddProvider(jwkProvider); err != nil {
+			t.Errorf("AddProvider() after stress error = %v", err)
+		}
+		token := mockToken()
+		token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+		if _, err := f.FromIssuerClaim()(token); err != nil {
+			t.Errorf("FromIssuerClaim() after stress error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package jwkfetch
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// TokenTooLargeError is returned for tokens exceeding the TokenLimits. ParseAndVerify, VerifyBatch and the middleware return it before
+// the token is parsed, while the keyfuncs only get tokens already parsed by jwt-go and return it before their keys are resolved
+type TokenTooLargeError struct {
+	// Part is "token" when the whole token exceeds MaxTokenBytes and "header" when its decoded header exceeds MaxHeaderBytes
+	Part  string
+	Size  int
+	Limit int
+}
+
+func (e *TokenTooLargeError) Error() string {
+	return fmt.Sprintf("Token %s of %d bytes exceeds %d bytes", e.Part, e.Size, e.Limit)
+}
+
+// TokenLimits bound the tokens processed by the keyfuncs, ParseAndVerify and the middleware
+type TokenLimits struct {
+	// MaxTokenBytes is the maximal length of a token. Zero means no bound
+	MaxTokenBytes int `json:"max_token_bytes"`
+	// MaxHeaderBytes is the maximal size of a token's decoded header. Zero means no bound
+	MaxHeaderBytes int `json:"max_header_bytes"`
+}
+
+// SetTokenLimits is Fetcher.SetTokenLimits of the default fetcher
+func SetTokenLimits(limits TokenLimits) {
+	defaultFetcher.SetTokenLimits(limits)
+}
+
+// SetTokenLimits sets the limits of the tokens the fetcher processes, bounding the work spent on garbage tokens hitting public endpoints.
+// Defaults to 64KB tokens with headers of at most 8KB
+func (f *Fetcher) SetTokenLimits(limits TokenLimits) {
+	f.limitsMu.Lock()
+	defer f.limitsMu.Unlock()
+	f.tokenLimits = limits
+}
+
+func (f *Fetcher) currentTokenLimits() TokenLimits {
+	f.limitsMu.RLock()
+	defer f.limitsMu.RUnlock()
+	return f.tokenLimits
+}
+
+// checkTokenSize returns a *TokenTooLargeError for tokens exceeding the TokenLimits. The size of the header is computed from
+// the length of its encoding, so nothing is decoded
+func (f *Fetcher) checkTokenSize(tokenString string) error {
+	if err := f.checkTokenLength(len(tokenString)); err != nil {
+		return err
+	}
+	header := tokenString
+	if i := strings.IndexByte(tokenString, '.'); i >= 0 {
+		header = tokenString[:i]
+	}
+	return f.checkHeaderSize(header)
+}
+
+func (f *Fetcher) checkTokenLength(length int) error {
+	if limit := f.currentTokenLimits().MaxTokenBytes; limit > 0 && length > limit {
+		return &TokenTooLargeError{Part: "token", Size: length, Limit: limit}
+	}
+	return nil
+}
+
+// checkHeaderSize checks the decoded size of a base64url encoded header
+func (f *Fetcher) checkHeaderSize(encodedHeader string) error {
+	limit := f.currentTokenLimits().MaxHeaderBytes
+	if size := base64.RawURLEncoding.DecodedLen(len(strings.TrimRight(encodedHeader, "="))); limit > 0 && size > limit {
+		return &TokenTooLargeError{Part: "header", Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// checkParsedTokenSize checks the raw token of tokens jwt-go already parsed, so it only prevents resolving their keys.
+// Tokens built without parsing have no raw token
+func (f *Fetcher) checkParsedTokenSize(token *jwt.Token) error {
+	if token.Raw == "" {
+		return nil
+	}
+	return f.checkTokenSize(token.Raw)
+}
@@ -0,0 +1,74 @@
+package jwkfetch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestTokenLimits(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	header := encode(`{"alg":"RS256","kid":"key"}`)
+	payload := encode(`{"iss":"https://login.example.com"}`)
+	largeHeader := encode(`{"alg":"RS256","kid":"` + strings.Repeat("k", 200) + `"}`)
+	tests := []struct {
+		name     string
+		limits   TokenLimits
+		token    string
+		wantPart string
+	}{
+		{name: "Within limits", limits: TokenLimits{MaxTokenBytes: 200, MaxHeaderBytes: 100}, token: header + "." + payload + ".sig"},
+		{name: "Token too long", limits: TokenLimits{MaxTokenBytes: 50}, token: header + "." + payload + ".sig", wantPart: "token"},
+		{name: "Header too large", limits: TokenLimits{MaxHeaderBytes: 100}, token: largeHeader + "." + payload + ".sig", wantPart: "header"},
+		{name: "Header without segments", limits: TokenLimits{MaxHeaderBytes: 100}, token: largeHeader, wantPart: "header"},
+		{name: "No limits", limits: TokenLimits{}, token: largeHeader + "." + strings.Repeat("a", 1<<20) + ".sig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetTokenLimits(tt.limits)
+			defer SetTokenLimits(TokenLimits{MaxTokenBytes: 64 << 10, MaxHeaderBytes: 8 << 10})
+
+			err := defaultFetcher.checkTokenSize(tt.token)
+			var tooLarge *TokenTooLargeError
+			if errors.As(err, &tooLarge) != (tt.wantPart != "") || (tooLarge != nil && tooLarge.Part != tt.wantPart) {
+				t.Fatalf("checkTokenSize() error = %v, want part %q", err, tt.wantPart)
+			}
+
+			_, err = ParseAndVerify(context.Background(), tt.token)
+			if tt.wantPart != "" && !errors.As(err, &tooLarge) {
+				t.Errorf("ParseAndVerify() error = %v, want *TokenTooLargeError", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+			NewMiddleware()(http.NotFoundHandler()).ServeHTTP(recorder, request)
+			if tt.wantPart != "" && !strings.Contains(recorder.Header().Get("WWW-Authenticate"), "invalid_request") {
+				t.Errorf("Middleware WWW-Authenticate = %q, want invalid_request", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestTokenLimitsOfKeyfuncs(t *testing.T) {
+	SetTokenLimits(TokenLimits{MaxTokenBytes: 100})
+	defer SetTokenLimits(TokenLimits{MaxTokenBytes: 64 << 10, MaxHeaderBytes: 8 << 10})
+
+	token := &jwt.Token{Raw: strings.Repeat("a", 101), Header: map[string]interface{}{"kid": "key"}, Claims: jwt.MapClaims{"iss": "https://login.example.com"}}
+	_, err := FromIssuerClaim()(token)
+	var tooLarge *TokenTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Part != "token" {
+		t.Errorf("FromIssuerClaim() error = %v, want *TokenTooLargeError of the token", err)
+	}
+	if got := RejectionReasonOf(err); got != RejectionTokenTooLarge {
+		t.Errorf("RejectionReasonOf() = %q, want %q", got, RejectionTokenTooLarge)
+	}
+}
@@ -0,0 +1,43 @@
+package jwkfetch
+
+import (
+	"context"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// MapClaimValues adds the values mapped from the values of the from claim to the to claim, e.g. internal roles of IdP group ids.
+// Unmapped values are skipped
+func MapClaimValues(from string, to string, mapping map[string][]string) ClaimsTransformer {
+	return func(ctx context.Context, claims jwt.MapClaims) error {
+		mapped := make(map[string]bool)
+		var values []interface{}
+		for _, value := range orderedClaimValues(claims, to) {
+			mapped[value] = true
+			values = append(values, value)
+		}
+		for _, value := range orderedClaimValues(claims, from) {
+			for _, mappedValue := range mapping[value] {
+				if !mapped[mappedValue] {
+					mapped[mappedValue] = true
+					values = append(values, mappedValue)
+				}
+			}
+		}
+		if len(values) > 0 {
+			claims[to] = values
+		}
+		return nil
+	}
+}
+
+// LowercaseClaim lowercases a string claim, e.g. email
+func LowercaseClaim(name string) ClaimsTransformer {
+	return func(ctx context.Context, claims jwt.MapClaims) error {
+		if value, ok := claims[name].(string); ok {
+			claims[name] = strings.ToLower(value)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestMapClaimValues(t *testing.T) {
+	mapping := map[string][]string{"group-1": {"admin"}, "group-2": {"viewer", "admin"}}
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   interface{}
+	}{
+		{name: "Mapped groups", claims: jwt.MapClaims{"groups": []interface{}{"group-1", "group-2", "group-3"}}, want: []interface{}{"admin", "viewer"}},
+		{name: "Existing roles", claims: jwt.MapClaims{"groups": "group-2", "roles": []interface{}{"owner"}}, want: []interface{}{"owner", "viewer", "admin"}},
+		{name: "No mapped groups", claims: jwt.MapClaims{"groups": []interface{}{"group-3"}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MapClaimValues("groups", "roles", mapping)(context.Background(), tt.claims)
+			if got := tt.claims["roles"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("roles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithClaimsTransformers(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "transform-key")
+	jwkProvider := JWKProvider{Issuer: "https://issuer.example.com", InlineJWKS: []byte(keySet)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	tokenString := signTestToken(t, privateKey, "transform-key", jwt.MapClaims{
+		"iss":    jwkProvider.Issuer,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "Jane.Doe@Example.com",
+		"groups": []string{"group-1"},
+	})
+	failing := func(ctx context.Context, claims jwt.MapClaims) error {
+		return errors.New("Directory is unavailable")
+	}
+
+	tests := []struct {
+		name         string
+		transformers []ClaimsTransformer
+		wantStatus   int
+		wantEmail    string
+	}{
+		{name: "Roles mapped before requirements", transformers: []ClaimsTransformer{MapClaimValues("groups", "roles", map[string][]string{"group-1": {"admin"}}), LowercaseClaim("email")}, wantStatus: http.StatusOK, wantEmail: "jane.doe@example.com"},
+		{name: "No transformers", wantStatus: http.StatusForbidden},
+		{name: "Failing transformer", transformers: []ClaimsTransformer{failing}, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var email interface{}
+			handler := NewMiddleware(WithClaimsTransformers(tt.transformers...), RequireRoles("admin"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ := ClaimsFromContext(r.Context())
+				email = claims["email"]
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tokenString)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && email != tt.wantEmail {
+				t.Errorf("email = %v, want %v", email, tt.wantEmail)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"time"
+)
+
+// ConnectionPool tunes the connections of the transport fetching keys of providers without Transport
+type ConnectionPool struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept per host, 2 when zero.
+	// Raise it when many providers share a host to avoid reconnecting
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer than the timeout, 90 seconds when zero
+	IdleConnTimeout time.Duration
+	// ForceAttemptHTTP2 attempts HTTP/2 over TLS. False disables HTTP/2
+	ForceAttemptHTTP2 bool
+}
+
+// SetConnectionPool is Fetcher.SetConnectionPool of the default fetcher
+func SetConnectionPool(pool ConnectionPool) {
+	defaultFetcher.SetConnectionPool(pool)
+}
+
+// SetConnectionPool replaces the transport fetching keys of the fetcher's providers without Transport with one tuned by the pool.
+// By default keys are fetched with http.DefaultTransport. Should be called before Init
+func (f *Fetcher) SetConnectionPool(pool ConnectionPool) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	if pool.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	transport.ForceAttemptHTTP2 = pool.ForceAttemptHTTP2
+
+	f.transportMu.Lock()
+	previous := f.fetchTransport
+	f.fetchTransport = transport
+	f.transportMu.Unlock()
+	closeIdleConnections(previous)
+}
+
+// SetTransport is Fetcher.SetTransport of the default fetcher
+func SetTransport(transport http.RoundTripper) {
+	defaultFetcher.SetTransport(transport)
+}
+
+// SetTransport replaces the transport fetching the fetcher's discovery documents and the keys of its providers without Transport,
+// e.g. with a transport calling the host's fetch API in WASM runtimes without sockets such as wasip1. Nil restores http.DefaultTransport
+func (f *Fetcher) SetTransport(transport http.RoundTripper) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	f.transportMu.Lock()
+	defer f.transportMu.Unlock()
+	f.fetchTransport = transport
+}
+
+func (f *Fetcher) currentFetchTransport() http.RoundTripper {
+	f.transportMu.RLock()
+	defer f.transportMu.RUnlock()
+	return f.fetchTransport
+}
+
+// closeIdleConnections closes the idle connections of the transport of SetConnectionPool, so closed fetchers don't keep them open
+func (f *Fetcher) closeIdleConnections() {
+	closeIdleConnections(f.currentFetchTransport())
+}
+
+func closeIdleConnections(transport http.RoundTripper) {
+	if transport, ok := transport.(*http.Transport); ok && transport != http.DefaultTransport {
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,109 @@
+package jwkfetch
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetConnectionPool(t *testing.T) {
+	defer SetTransport(nil)
+
+	tests := []struct {
+		name                    string
+		pool                    ConnectionPool
+		wantMaxIdleConnsPerHost int
+		wantIdleConnTimeout     time.Duration
+		wantForceAttemptHTTP2   bool
+	}{
+		{
+			name:                "Defaults",
+			wantIdleConnTimeout: http.DefaultTransport.(*http.Transport).IdleConnTimeout,
+		},
+		{
+			name:                    "Tuned",
+			pool:                    ConnectionPool{MaxIdleConnsPerHost: 50, IdleConnTimeout: 5 * time.Minute, ForceAttemptHTTP2: true},
+			wantMaxIdleConnsPerHost: 50,
+			wantIdleConnTimeout:     5 * time.Minute,
+			wantForceAttemptHTTP2:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetConnectionPool(tt.pool)
+			transport, ok := defaultFetcher.currentFetchTransport().(*http.Transport)
+			if !ok {
+				t.Fatalf("currentFetchTransport() = %T, want *http.Transport", defaultFetcher.currentFetchTransport())
+			}
+			if transport.MaxIdleConnsPerHost != tt.wantMaxIdleConnsPerHost {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, tt.wantMaxIdleConnsPerHost)
+			}
+			if transport.IdleConnTimeout != tt.wantIdleConnTimeout {
+				t.Errorf("IdleConnTimeout = %v, want %v", transport.IdleConnTimeout, tt.wantIdleConnTimeout)
+			}
+			if transport.ForceAttemptHTTP2 != tt.wantForceAttemptHTTP2 {
+				t.Errorf("ForceAttemptHTTP2 = %v, want %v", transport.ForceAttemptHTTP2, tt.wantForceAttemptHTTP2)
+			}
+		})
+	}
+}
+
+func TestConnectionPoolReusesConnections(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, jwkResponse)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	defer SetTransport(nil)
+
+	SetConnectionPool(ConnectionPool{MaxIdleConnsPerHost: 10})
+	for _, path := range []string{"/first/jwks", "/second/jwks", "/third/jwks"} {
+		if _, err := defaultFetcher.getKeySet(context.Background(), server.URL+path); err != nil {
+			t.Fatalf("getKeySet() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&connections); got != 1 {
+		t.Errorf("Fetches of one host opened %d connections, want 1", got)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSetTransport(t *testing.T) {
+	var fetched []string
+	SetTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetched = append(fetched, req.URL.String())
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(jwkResponse)), Request: req}, nil
+	}))
+	defer SetTransport(nil)
+
+	jwksURL := "https://host-fetch.example.com/jwks"
+	defer defaultFetcher.jwksCache.delete(jwksURL)
+	if _, err := FromJWKsURL(jwksURL)(mockToken()); err != nil {
+		t.Fatalf("FromJWKsURL() error = %v", err)
+	}
+	if len(fetched) != 1 || fetched[0] != jwksURL {
+		t.Errorf("Transport fetched %v, want %s", fetched, jwksURL)
+	}
+
+	SetTransport(nil)
+	if defaultFetcher.currentFetchTransport() != http.DefaultTransport {
+		t.Errorf("SetTransport(nil) didn't restore http.DefaultTransport")
+	}
+}
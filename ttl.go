@@ -0,0 +1,84 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerTTL is the remaining lifetime of a response by its Cache-Control max-age, or else its Expires relative to its Date,
+// less its Age, zero for no-store, no-cache and invalid Expires. False when the response has no Cache-Control or Expires lifetime
+func headerTTL(header http.Header) (time.Duration, bool) {
+	var maxAge time.Duration
+	found := false
+	for _, directive := range strings.Split(strings.Join(header.Values("Cache-Control"), ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0, true
+		case "max-age":
+			seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			maxAge = time.Duration(seconds) * time.Second
+			found = true
+		}
+	}
+	if !found {
+		if maxAge, found = expiresTTL(header); !found {
+			return 0, false
+		}
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		maxAge -= time.Duration(age) * time.Second
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	return maxAge, true
+}
+
+// expiresTTL is the lifetime of a response by its Expires relative to its Date, or to now when it has none.
+// Invalid Expires, e.g. "0", are in the past as in RFC 9111. False when the response has no Expires
+func expiresTTL(header http.Header) (time.Duration, bool) {
+	value := header.Get("Expires")
+	if value == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		return 0, true
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = clockNow()
+	}
+	return expires.Sub(date), true
+}
+
+// entryTTL is how long the provider's cached key set may be used before it is fetched again: the lifetime of its response
+// when it has caching headers, CacheTTL otherwise, clamped to MinTTL and MaxTTL. False when the key set doesn't expire
+func (jwkProvider JWKProvider) entryTTL(entry *keySetEntry) (time.Duration, bool) {
+	if jwkProvider.CacheTTL <= 0 && jwkProvider.MinTTL <= 0 && jwkProvider.MaxTTL <= 0 {
+		return 0, false
+	}
+	ttl, ok := headerTTL(entry.header)
+	if !ok {
+		if jwkProvider.CacheTTL <= 0 && jwkProvider.MaxTTL <= 0 {
+			return 0, false
+		}
+		ttl = jwkProvider.CacheTTL
+		if ttl <= 0 {
+			ttl = jwkProvider.MaxTTL
+		}
+	}
+	if jwkProvider.MaxTTL > 0 && ttl > jwkProvider.MaxTTL {
+		ttl = jwkProvider.MaxTTL
+	}
+	if ttl < jwkProvider.MinTTL {
+		ttl = jwkProvider.MinTTL
+	}
+	return ttl, true
+}
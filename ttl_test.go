@@ -0,0 +1,122 @@
+package jwkfetch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func Test_headerTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "No header", header: http.Header{}, wantOk: false},
+		{name: "Public", header: http.Header{"Cache-Control": {"public"}}, wantOk: false},
+		{name: "Max age", header: http.Header{"Cache-Control": {"public, max-age=3600"}}, want: time.Hour, wantOk: true},
+		{name: "Max age less age", header: http.Header{"Cache-Control": {"max-age=3600"}, "Age": {"600"}}, want: 50 * time.Minute, wantOk: true},
+		{name: "Older than max age", header: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"600"}}, want: 0, wantOk: true},
+		{name: "No store", header: http.Header{"Cache-Control": {"no-store"}}, want: 0, wantOk: true},
+		{name: "No cache with max age", header: http.Header{"Cache-Control": {"max-age=3600", "no-cache"}}, want: 0, wantOk: true},
+		{name: "Invalid max age", header: http.Header{"Cache-Control": {"max-age=soon"}}, wantOk: false},
+		{name: "Expires", header: http.Header{"Date": {"Mon, 02 Jan 2006 15:04:05 GMT"}, "Expires": {"Mon, 02 Jan 2006 16:04:05 GMT"}}, want: time.Hour, wantOk: true},
+		{name: "Expires less age", header: http.Header{"Date": {"Mon, 02 Jan 2006 15:04:05 GMT"}, "Expires": {"Mon, 02 Jan 2006 16:04:05 GMT"}, "Age": {"600"}}, want: 50 * time.Minute, wantOk: true},
+		{name: "Max age overrides expires", header: http.Header{"Cache-Control": {"max-age=60"}, "Date": {"Mon, 02 Jan 2006 15:04:05 GMT"}, "Expires": {"Mon, 02 Jan 2006 16:04:05 GMT"}}, want: time.Minute, wantOk: true},
+		{name: "Invalid expires", header: http.Header{"Expires": {"0"}}, want: 0, wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := headerTTL(tt.header)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("headerTTL() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestJWKProvider_entryTTL(t *testing.T) {
+	noStore := http.Header{"Cache-Control": {"no-store"}}
+	week := http.Header{"Cache-Control": {"max-age=604800"}}
+	tests := []struct {
+		name        string
+		jwkProvider JWKProvider
+		header      http.Header
+		want        time.Duration
+		wantOk      bool
+	}{
+		{name: "No TTL", jwkProvider: JWKProvider{}, header: week, wantOk: false},
+		{name: "Cache TTL without header", jwkProvider: JWKProvider{CacheTTL: time.Hour}, header: nil, want: time.Hour, wantOk: true},
+		{name: "Header overrides cache TTL", jwkProvider: JWKProvider{CacheTTL: time.Hour}, header: week, want: 7 * 24 * time.Hour, wantOk: true},
+		{name: "No store", jwkProvider: JWKProvider{CacheTTL: time.Hour}, header: noStore, want: 0, wantOk: true},
+		{name: "No store clamped to min TTL", jwkProvider: JWKProvider{MinTTL: 10 * time.Minute}, header: noStore, want: 10 * time.Minute, wantOk: true},
+		{name: "Max age clamped to max TTL", jwkProvider: JWKProvider{MaxTTL: 24 * time.Hour}, header: week, want: 24 * time.Hour, wantOk: true},
+		{name: "Max TTL without header", jwkProvider: JWKProvider{MaxTTL: 24 * time.Hour}, header: nil, want: 24 * time.Hour, wantOk: true},
+		{name: "Min TTL without header", jwkProvider: JWKProvider{MinTTL: 10 * time.Minute}, header: nil, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.jwkProvider.entryTTL(&keySetEntry{header: tt.header})
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("entryTTL() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMinTTLOverridesNoStore(t *testing.T) {
+	var jwksRequests int32
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nostore/jwks" {
+			atomic.AddInt32(&jwksRequests, 1)
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, jwkResponse)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		minTTL time.Duration
+		want   int32
+	}{
+		{name: "No store honored", minTTL: 0, want: 3},
+		{name: "No store clamped", minTTL: time.Hour, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&jwksRequests, 0)
+			jwkProvider := JWKProvider{
+				Issuer:   fmt.Sprintf("http://%s/nostore", httptestServerURL),
+				JWKURL:   fmt.Sprintf("http://%s/nostore/jwks", httptestServerURL),
+				CacheTTL: time.Hour,
+				MinTTL:   tt.minTTL,
+			}
+			defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+			defer defaultFetcher.setProviders(nil)
+			defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+			token := mockToken()
+			token.Claims = jwt.MapClaims{"iss": jwkProvider.Issuer}
+			keyFunc := FromIssuerClaim()
+			for i := 0; i < 3; i++ {
+				if _, err := keyFunc(token); err != nil {
+					t.Fatalf("FromIssuerClaim() error = %v", err)
+				}
+			}
+			if got := atomic.LoadInt32(&jwksRequests); got != tt.want {
+				t.Errorf("JWKs requests = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
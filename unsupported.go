@@ -0,0 +1,77 @@
+package jwkfetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrUnsupportedKey is returned for key sets with keys of an unsupported key type or algorithm when the policy is RejectUnsupportedKeys
+var ErrUnsupportedKey = errors.New("Key type or algorithm is not supported")
+
+// UnsupportedKeyPolicy is what happens to keys of a key type or signing algorithm the package doesn't support, e.g. OKP keys of new curves
+type UnsupportedKeyPolicy int32
+
+const (
+	// ReportUnsupportedKeys skips the keys and reports them to the OnMalformedKey hook and FetchStats like malformed keys. It is the default
+	ReportUnsupportedKeys UnsupportedKeyPolicy = iota
+	// SkipUnsupportedKeys skips the keys silently
+	SkipUnsupportedKeys
+	// RejectUnsupportedKeys fails their whole key set with ErrUnsupportedKey
+	RejectUnsupportedKeys
+)
+
+// supportedKeyTypes are the kty values keys are parsed for
+var supportedKeyTypes = map[string]bool{"RSA": true, "EC": true, "oct": true}
+
+// SetUnsupportedKeyPolicy is Fetcher.SetUnsupportedKeyPolicy of the default fetcher
+func SetUnsupportedKeyPolicy(policy UnsupportedKeyPolicy) {
+	defaultFetcher.SetUnsupportedKeyPolicy(policy)
+}
+
+// SetUnsupportedKeyPolicy sets what happens to the fetcher's keys of a key type or signing algorithm the package doesn't support.
+// The supported keys of the key set stay usable unless the policy is RejectUnsupportedKeys
+func (f *Fetcher) SetUnsupportedKeyPolicy(policy UnsupportedKeyPolicy) {
+	f.policyMu.Lock()
+	defer f.policyMu.Unlock()
+	f.unsupportedKeyPolicy = policy
+}
+
+func (f *Fetcher) currentUnsupportedKeyPolicy() UnsupportedKeyPolicy {
+	f.policyMu.RLock()
+	defer f.policyMu.RUnlock()
+	return f.unsupportedKeyPolicy
+}
+
+// rawKeyHeader holds the fields of an unparsed key identifying it and its support
+type rawKeyHeader struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+func parseRawKeyHeader(raw json.RawMessage) (rawKeyHeader, bool) {
+	var header rawKeyHeader
+	err := json.Unmarshal(raw, &header)
+	return header, err == nil
+}
+
+// checkKeySupport returns an ErrUnsupportedKey for keys of an unsupported key type, or of a signing algorithm without verifier.
+// The algorithms of encryption keys aren't checked since they are never used to verify
+func checkKeySupport(header rawKeyHeader) error {
+	if !supportedKeyTypes[header.KeyType] {
+		return fmt.Errorf("%w: kty %q", ErrUnsupportedKey, header.KeyType)
+	}
+	if header.Algorithm != "" && header.Use != "enc" && !supportedAlgorithm(header.Algorithm) {
+		return fmt.Errorf("%w: alg %q", ErrUnsupportedKey, header.Algorithm)
+	}
+	return nil
+}
+
+// supportedAlgorithm checks a token signing algorithm has a verifier compiled in
+func supportedAlgorithm(alg string) bool {
+	return alg != "none" && jwt.GetSigningMethod(alg) != nil
+}
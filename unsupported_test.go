@@ -0,0 +1,54 @@
+package jwkfetch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUnsupportedKeyPolicy(t *testing.T) {
+	defer SetUnsupportedKeyPolicy(ReportUnsupportedKeys)
+
+	okpKey := `{"kty":"OKP","kid":"okp","crv":"Ed448","x":"AA"}`
+	futureKey := `{"kty":"oct","kid":"future","alg":"FUTURE512","k":"c2VjcmV0"}`
+	encryptionKey := `{"kty":"oct","kid":"enc","alg":"A256KW","use":"enc","k":"c2VjcmV0"}`
+	validKey := `{"kty":"oct","kid":"valid","alg":"HS256","k":"c2VjcmV0"}`
+	mixed := `{"keys": [` + strings.Join([]string{okpKey, futureKey, encryptionKey, validKey}, ",") + `]}`
+	onlyUnsupported := `{"keys": [` + okpKey + `]}`
+
+	tests := []struct {
+		name          string
+		policy        UnsupportedKeyPolicy
+		document      string
+		wantKeys      int
+		wantMalformed int
+		wantErr       error
+	}{
+		{name: "Reported", policy: ReportUnsupportedKeys, document: mixed, wantKeys: 2, wantMalformed: 2},
+		{name: "Skipped", policy: SkipUnsupportedKeys, document: mixed, wantKeys: 2},
+		{name: "Rejected", policy: RejectUnsupportedKeys, document: mixed, wantErr: ErrUnsupportedKey},
+		{name: "Only unsupported keys reported", policy: ReportUnsupportedKeys, document: onlyUnsupported, wantMalformed: 1, wantErr: ErrNoUsableKeys},
+		{name: "Only unsupported keys skipped", policy: SkipUnsupportedKeys, document: onlyUnsupported, wantErr: ErrNoUsableKeys},
+		{name: "Supported keys", policy: RejectUnsupportedKeys, document: `{"keys": [` + encryptionKey + `,` + validKey + `]}`, wantKeys: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetUnsupportedKeyPolicy(tt.policy)
+			keySet, malformed, err := defaultFetcher.parseKeySet(strings.NewReader(tt.document))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseKeySet() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(keySet.Keys) != tt.wantKeys {
+				t.Errorf("parseKeySet() returned %d keys, want %d", len(keySet.Keys), tt.wantKeys)
+			}
+			if len(malformed) != tt.wantMalformed {
+				t.Fatalf("parseKeySet() malformed keys = %+v, want %d", malformed, tt.wantMalformed)
+			}
+			for _, key := range malformed {
+				if !key.Unsupported || !errors.Is(key.Err, ErrUnsupportedKey) {
+					t.Errorf("parseKeySet() malformed key = %+v, want unsupported", key)
+				}
+			}
+		})
+	}
+}
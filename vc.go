@@ -0,0 +1,113 @@
+package jwkfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const vcIssuerWellKnownPath = "/.well-known/jwt-vc-issuer"
+
+// vcIssuerMetadata is the JWT VC issuer metadata document. It carries either jwks_uri or inline jwks
+type vcIssuerMetadata struct {
+	Issuer  string          `json:"issuer"`
+	JWKsURI string          `json:"jwks_uri"`
+	JWKs    json.RawMessage `json:"jwks"`
+}
+
+// FromVCIssuerClaim is Fetcher.FromVCIssuerClaim of the default fetcher
+func FromVCIssuerClaim() func(*jwt.Token) (interface{}, error) {
+	return defaultFetcher.FromVCIssuerClaim()
+}
+
+// FromVCIssuerClaim extracts issuer from SD-JWT VC token and fetches its keys from the JWT VC issuer metadata found at <iss host>/.well-known/jwt-vc-issuer<iss path>
+func (f *Fetcher) FromVCIssuerClaim() func(*jwt.Token) (interface{}, error) {
+	return f.safeKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		issuer, err := getIssuer(token)
+		if err != nil {
+			return nil, err
+		}
+		return f.retrieveKey(context.Background(), token, issuer, f.vcIssuerCache, f.getKeySetFromVCIssuerCache)
+	})
+}
+
+func (f *Fetcher) getKeySetFromVCIssuerCache(ctx context.Context, issuer string) (*keySetEntry, error) {
+	if !f.isIssuerAllowed(issuer) {
+		return nil, ErrIssuerNotAllowed
+	}
+	if entry := f.vcIssuerCache.get(issuer); entry != nil && !isStale(ctx, entry) {
+		return entry, nil
+	}
+
+	metadataURL, err := getVCIssuerMetadataURL(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	version := nextEntryVersion()
+	var metadata vcIssuerMetadata
+	if err := f.getJSON(ctx, metadataURL, &metadata); err != nil {
+		return nil, fmt.Errorf("Error while getting jwt vc issuer metadata: %w", err)
+	}
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("Jwt vc issuer metadata issuer %q doesn't match token issuer %q", metadata.Issuer, issuer)
+	}
+
+	var entry *keySetEntry
+	switch {
+	case metadata.JWKsURI != "":
+		if err := f.checkJWKsURI(metadataURL, metadata.JWKsURI); err != nil {
+			return nil, err
+		}
+		jwksEntry, err := f.getKeySetFromJWKCache(ctx, metadata.JWKsURI)
+		if err != nil {
+			return nil, err
+		}
+		entry = &keySetEntry{
+			keySet:       jwksEntry.keySet,
+			index:        jwksEntry.index,
+			jwksURL:      jwksEntry.jwksURL,
+			discoverURL:  metadataURL,
+			fetchedAt:    jwksEntry.fetchedAt,
+			header:       jwksEntry.header,
+			version:      jwksEntry.version,
+			peerSPKIHash: jwksEntry.peerSPKIHash,
+		}
+	case len(metadata.JWKs) > 0:
+		keySet, malformed, err := f.parseKeySet(bytes.NewReader(metadata.JWKs))
+		f.reportMalformedKeys("", malformed)
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing jwt vc issuer metadata jwks: %w", err)
+		}
+		entry = &keySetEntry{
+			keySet:      keySet,
+			index:       newKeyIndex(keySet),
+			discoverURL: metadataURL,
+			fetchedAt:   clockNow(),
+			version:     version,
+		}
+	default:
+		return nil, fmt.Errorf("Jwt vc issuer metadata has neither jwks_uri nor jwks")
+	}
+
+	return f.vcIssuerCache.store(issuer, entry), nil
+}
+
+// getVCIssuerMetadataURL inserts the well-known path between the host and the path of the issuer
+func getVCIssuerMetadataURL(issuer string) (string, error) {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("Error while getting jwt vc issuer metadata url from issuer claim: %v", err)
+	}
+	if issuerURL.Scheme != "https" && issuerURL.Scheme != "http" || issuerURL.Host == "" {
+		return "", fmt.Errorf("Jwt vc issuer %q is not an http(s) url", issuer)
+	}
+	issuerURL.Path = vcIssuerWellKnownPath + strings.TrimSuffix(issuerURL.Path, "/")
+	issuerURL.RawPath = ""
+	return issuerURL.String(), nil
+}
@@ -0,0 +1,196 @@
+package jwkfetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func Test_getVCIssuerMetadataURL(t *testing.T) {
+	type args struct {
+		issuer string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "https://issuer.example.com",
+			args: args{
+				issuer: "https://issuer.example.com",
+			},
+			want:    "https://issuer.example.com/.well-known/jwt-vc-issuer",
+			wantErr: false,
+		},
+		{
+			name: "https://issuer.example.com/tenant/1234",
+			args: args{
+				issuer: "https://issuer.example.com/tenant/1234",
+			},
+			want:    "https://issuer.example.com/.well-known/jwt-vc-issuer/tenant/1234",
+			wantErr: false,
+		},
+		{
+			name: "https://issuer.example.com/tenant/",
+			args: args{
+				issuer: "https://issuer.example.com/tenant/",
+			},
+			want:    "https://issuer.example.com/.well-known/jwt-vc-issuer/tenant",
+			wantErr: false,
+		},
+		{
+			name: "issuer.example.com",
+			args: args{
+				issuer: "issuer.example.com",
+			},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getVCIssuerMetadataURL(tt.args.issuer)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getVCIssuerMetadataURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getVCIssuerMetadataURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromVCIssuerClaim(t *testing.T) {
+	server := createTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/jwt-vc-issuer/jwks-uri" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"issuer": "http://%s/jwks-uri", "jwks_uri": "http://%s/jwks"}`, httptestServerURL, httptestServerURL)
+			return
+		}
+
+		if r.URL.Path == "/.well-known/jwt-vc-issuer/inline-jwks" || r.URL.Path == "/.well-known/jwt-vc-issuer/large-jwks" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"issuer": "http://%s%s", "jwks": %s}`, httptestServerURL, strings.TrimPrefix(r.URL.Path, vcIssuerWellKnownPath), jwkResponse)
+			return
+		}
+
+		if r.URL.Path == "/.well-known/jwt-vc-issuer/other-host" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"issuer": "http://%s/other-host", "jwks_uri": "http://127.0.0.1:8888/jwks"}`, httptestServerURL)
+			return
+		}
+
+		if r.URL.Path == "/.well-known/jwt-vc-issuer/wrong-issuer" {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"issuer": "https://attacker.example.com", "jwks_uri": "https://attacker.example.com/jwks"}`)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/jwks") {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, jwkResponse)
+			return
+		}
+	}))
+	defer server.Close()
+
+	vcToken := func(path string) *jwt.Token {
+		tkn := mockToken()
+		tkn.Claims = jwt.MapClaims{
+			"iss": fmt.Sprintf("http://%s/%s", httptestServerURL, path),
+		}
+		return tkn
+	}
+
+	type args struct {
+		token *jwt.Token
+	}
+	tests := []struct {
+		name      string
+		args      args
+		policy    JWKsURIPolicy
+		limits    *KeySetLimits
+		want      interface{}
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "Metadata with jwks_uri",
+			args: args{
+				token: vcToken("jwks-uri"),
+			},
+			want:    mockKey(),
+			wantErr: false,
+		},
+		{
+			name: "Metadata with inline jwks",
+			args: args{
+				token: vcToken("inline-jwks"),
+			},
+			want:    mockKey(),
+			wantErr: false,
+		},
+		{
+			name: "Metadata inline jwks exceeds the key set limits",
+			args: args{
+				token: vcToken("large-jwks"),
+			},
+			limits:    &KeySetLimits{MaxBytes: 64},
+			want:      nil,
+			wantErr:   true,
+			wantErrIs: ErrKeySetTooLarge,
+		},
+		{
+			name: "Metadata jwks_uri isn't allowed",
+			args: args{
+				token: vcToken("other-host"),
+			},
+			policy:    JWKsURIPolicy{RequireSameHost: true},
+			want:      nil,
+			wantErr:   true,
+			wantErrIs: ErrJWKsURINotAllowed,
+		},
+		{
+			name: "Metadata issuer doesn't match token issuer",
+			args: args{
+				token: vcToken("wrong-issuer"),
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetJWKsURIPolicy(tt.policy)
+			defer SetJWKsURIPolicy(JWKsURIPolicy{})
+			if tt.limits != nil {
+				defer SetKeySetLimits(defaultFetcher.currentKeySetLimits())
+				SetKeySetLimits(*tt.limits)
+			}
+
+			keyFunc := FromVCIssuerClaim()
+			got, err := keyFunc(tt.args.token)
+			if (err != nil) != tt.wantErr || (tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs)) {
+				t.Errorf("FromVCIssuerClaim() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromVCIssuerClaim() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
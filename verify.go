@@ -0,0 +1,234 @@
+package jwkfetch
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrTokenReplayed is returned for tokens whose jti was already used when replay detection is enabled
+var ErrTokenReplayed = errors.New("Token was already used")
+
+// ReplayCache remembers the jti of used tokens. Implement it over a shared store, e.g. Redis, to detect replays across instances
+type ReplayCache interface {
+	// Use records the key for the ttl and returns false when the key is already recorded
+	Use(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// MemoryReplayCache is a ReplayCache of the process memory
+type MemoryReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// expiries orders the seen keys by their expiry, so each Use drops only the expired keys
+	expiries replayExpiries
+}
+
+// NewMemoryReplayCache returns an empty MemoryReplayCache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time)}
+}
+
+// Use records the key for the ttl, dropping the expired keys
+func (c *MemoryReplayCache) Use(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for len(c.expiries) > 0 && now.After(c.expiries[0].expiresAt) {
+		delete(c.seen, heap.Pop(&c.expiries).(replayExpiry).key)
+	}
+	if _, ok := c.seen[key]; ok {
+		return false, nil
+	}
+	c.seen[key] = now.Add(ttl)
+	heap.Push(&c.expiries, replayExpiry{key: key, expiresAt: now.Add(ttl)})
+	return true, nil
+}
+
+type replayExpiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// replayExpiries is a min-heap of the expiries of the seen keys
+type replayExpiries []replayExpiry
+
+func (e replayExpiries) Len() int            { return len(e) }
+func (e replayExpiries) Less(i, j int) bool  { return e[i].expiresAt.Before(e[j].expiresAt) }
+func (e replayExpiries) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *replayExpiries) Push(x interface{}) { *e = append(*e, x.(replayExpiry)) }
+func (e *replayExpiries) Pop() interface{} {
+	old := *e
+	last := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return last
+}
+
+type verifyOptions struct {
+	replayCache ReplayCache
+	nonce       string
+	accessToken string
+	code        string
+	// batchConcurrency is the number of goroutines of VerifyBatch
+	batchConcurrency int
+	// at is the time the exp, nbf and iat claims are checked at by VerifyAt. Zero checks them at the current time
+	at time.Time
+	// discoveredIssuers resolves the keys of issuers that are neither registered nor allowed by discovering them
+	discoveredIssuers bool
+}
+
+// VerifyOption configures ParseAndVerify
+type VerifyOption func(*verifyOptions)
+
+// WithReplayDetection rejects tokens whose jti was already used with ErrTokenReplayed, e.g. for one-time-use tokens of webhooks and magic links.
+// Tokens must have jti and exp claims, and their jti is remembered in the cache for the rest of their lifetime
+func WithReplayDetection(cache ReplayCache) VerifyOption {
+	return func(o *verifyOptions) {
+		o.replayCache = cache
+	}
+}
+
+// WithDiscoveredIssuers verifies the tokens of issuers that are neither registered providers nor allowed by WithAllowedIssuers
+// with the keys of their discovery document, like FromIssuerClaim. Without it such tokens fail with ErrIssuerNotAllowed,
+// since anyone hosting an OpenID issuer could otherwise sign tokens that verify
+func WithDiscoveredIssuers() VerifyOption {
+	return func(o *verifyOptions) {
+		o.discoveredIssuers = true
+	}
+}
+
+// WithNonce requires the nonce claim of ID tokens to equal the nonce sent in the authentication request
+func WithNonce(nonce string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.nonce = nonce
+	}
+}
+
+// WithAccessTokenHash requires the at_hash claim of ID tokens to match the access token issued with them
+func WithAccessTokenHash(accessToken string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.accessToken = accessToken
+	}
+}
+
+// WithCodeHash requires the c_hash claim of ID tokens to match the authorization code issued with them
+func WithCodeHash(code string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.code = code
+	}
+}
+
+// ParseAndVerify is Fetcher.ParseAndVerify of the default fetcher
+func ParseAndVerify(ctx context.Context, tokenString string, opts ...VerifyOption) (*jwt.Token, error) {
+	return defaultFetcher.ParseAndVerify(ctx, tokenString, opts...)
+}
+
+// ParseAndVerify parses the token and verifies its signature with the key of its issuer, resolved like FromIssuerClaim does, its exp, nbf and iat claims,
+// and its aud claim when the issuer's provider has Audiences. Only tokens of registered providers and of the issuers of WithAllowedIssuers
+// are verified, others fail with ErrIssuerNotAllowed unless WithDiscoveredIssuers is set.
+// Errors of resolving the key are returned as is, so RejectionReasonOf categorizes them
+func (f *Fetcher) ParseAndVerify(ctx context.Context, tokenString string, opts ...VerifyOption) (*jwt.Token, error) {
+	var options verifyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return f.verifyToken(ctx, tokenString, func(token *jwt.Token) (interface{}, error) {
+		resolvedKey, err := f.resolveTrustedKey(ctx, token, options)
+		if err != nil {
+			return nil, err
+		}
+		return resolvedKey.Key, nil
+	}, options)
+}
+
+// resolveTrustedKey resolves the key of the token like ResolveKey, only for the issuers of registered providers and of WithAllowedIssuers
+// unless WithDiscoveredIssuers is set
+func (f *Fetcher) resolveTrustedKey(ctx context.Context, token *jwt.Token, options verifyOptions) (ResolvedKey, error) {
+	if !options.discoveredIssuers {
+		issuer, err := getIssuer(token)
+		if err != nil {
+			return ResolvedKey{}, err
+		}
+		if _, registered := f.findProvider(issuer); !registered && !f.currentOptions().allowedIssuers[issuer] {
+			return ResolvedKey{}, ErrIssuerNotAllowed
+		}
+	}
+	return f.ResolveKey(ctx, token)
+}
+
+// verifyToken verifies the token with the key of the keyfunc and checks its claims, with the audiences of the fetcher's provider of its issuer
+func (f *Fetcher) verifyToken(ctx context.Context, tokenString string, keyFunc jwt.Keyfunc, options verifyOptions) (*jwt.Token, error) {
+	if err := f.checkTokenSize(tokenString); err != nil {
+		return nil, err
+	}
+	parser := jwt.Parser{SkipClaimsValidation: !options.at.IsZero()}
+	token, err := parser.Parse(tokenString, keyFunc)
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorUnverifiable != 0 && validationErr.Inner != nil {
+			return nil, validationErr.Inner
+		}
+		return nil, err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if !options.at.IsZero() {
+		if err := checkTimeClaims(claims, options.at); err != nil {
+			return nil, err
+		}
+	}
+	issuer, _ := claims["iss"].(string)
+	if jwkProvider, ok := f.findProvider(issuer); ok {
+		if err := checkAudience(claims, jwkProvider); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkIDTokenClaims(token, options); err != nil {
+		return nil, err
+	}
+	if options.replayCache != nil {
+		if err := checkReplay(ctx, options.replayCache, token); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// checkTimeClaims checks the exp, nbf and iat claims at the time the way jwt.Parse checks them at the current time
+func checkTimeClaims(claims jwt.MapClaims, at time.Time) error {
+	switch {
+	case !claims.VerifyExpiresAt(at.Unix(), false):
+		return jwt.NewValidationError("Token is expired", jwt.ValidationErrorExpired)
+	case !claims.VerifyNotBefore(at.Unix(), false):
+		return jwt.NewValidationError("Token is not valid yet", jwt.ValidationErrorNotValidYet)
+	case !claims.VerifyIssuedAt(at.Unix(), false):
+		return jwt.NewValidationError("Token used before issued", jwt.ValidationErrorIssuedAt)
+	}
+	return nil
+}
+
+// checkReplay records the token's jti, keyed by its issuer, until the token expires
+func checkReplay(ctx context.Context, cache ReplayCache, token *jwt.Token) error {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("Token doesn't have claim jti")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("Token doesn't have claim exp")
+	}
+	issuer, _ := claims["iss"].(string)
+	ttl := time.Until(time.Unix(int64(exp), 0))
+	unused, err := cache.Use(ctx, issuer+" "+jti, ttl)
+	if err != nil {
+		return fmt.Errorf("Error while checking token replay: %w", err)
+	}
+	if !unused {
+		return ErrTokenReplayed
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package jwkfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestParseAndVerify(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "verify-key")
+	jwkProvider := JWKProvider{Issuer: "https://issuer.example.com", InlineJWKS: []byte(keySet)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			fmt.Fprintf(w, `{"jwks_uri": "http://%s/jwks"}`, r.Host)
+			return
+		}
+		io.WriteString(w, keySet)
+	}))
+	defer server.Close()
+	discoveredIssuer := server.URL
+	defer defaultFetcher.Invalidate(discoveredIssuer)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name       string
+		keyID      string
+		claims     jwt.MapClaims
+		opts       []VerifyOption
+		wantErr    bool
+		wantReason RejectionReason
+	}{
+		{name: "Valid token", keyID: "verify-key", claims: jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp}},
+		{name: "Expired token", keyID: "verify-key", claims: jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": time.Now().Add(-time.Hour).Unix()}, wantErr: true},
+		{name: "Unknown kid", keyID: "unknown", claims: jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp}, wantErr: true, wantReason: RejectionUnknownKid},
+		{name: "Unregistered issuer", keyID: "verify-key", claims: jwt.MapClaims{"iss": discoveredIssuer, "exp": exp}, wantErr: true, wantReason: RejectionUnknownIssuer},
+		{name: "Discovered issuer", keyID: "verify-key", claims: jwt.MapClaims{"iss": discoveredIssuer, "exp": exp}, opts: []VerifyOption{WithDiscoveredIssuers()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenString := signTestToken(t, privateKey, tt.keyID, tt.claims)
+			token, err := ParseAndVerify(context.Background(), tokenString, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAndVerify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reason := RejectionReasonOf(err); reason != tt.wantReason {
+				t.Errorf("RejectionReasonOf() = %q, want %q", reason, tt.wantReason)
+			}
+			if err == nil && !token.Valid {
+				t.Errorf("ParseAndVerify() token isn't valid")
+			}
+		})
+	}
+}
+
+func TestWithReplayDetection(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "replay-key")
+	jwkProvider := JWKProvider{Issuer: "https://issuer.example.com", InlineJWKS: []byte(keySet)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	cache := NewMemoryReplayCache()
+	exp := time.Now().Add(time.Hour).Unix()
+	oneTimeToken := signTestToken(t, privateKey, "replay-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp, "jti": "link-1"})
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     error
+	}{
+		{name: "First use", tokenString: oneTimeToken},
+		{name: "Replay", tokenString: oneTimeToken, wantErr: ErrTokenReplayed},
+		{name: "Other jti", tokenString: signTestToken(t, privateKey, "replay-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp, "jti": "link-2"})},
+		{name: "Missing jti", tokenString: signTestToken(t, privateKey, "replay-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp}), wantErr: errors.New("Token doesn't have claim jti")},
+		{name: "Missing exp", tokenString: signTestToken(t, privateKey, "replay-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "jti": "link-3"}), wantErr: errors.New("Token doesn't have claim exp")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAndVerify(context.Background(), tt.tokenString, WithReplayDetection(cache))
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Errorf("ParseAndVerify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	ctx := context.Background()
+	if unused, _ := cache.Use(ctx, "jti", -time.Second); !unused {
+		t.Fatal("Use() = false, want true for a new key")
+	}
+	if unused, _ := cache.Use(ctx, "jti", time.Minute); !unused {
+		t.Error("Use() = false, want true after the key expired")
+	}
+	if unused, _ := cache.Use(ctx, "jti", time.Minute); unused {
+		t.Error("Use() = true, want false for a used key")
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Use(ctx, fmt.Sprintf("expired-%d", i), -time.Second)
+	}
+	cache.Use(ctx, "other-jti", time.Minute)
+	if len(cache.seen) != 2 || len(cache.expiries) != 2 {
+		t.Errorf("MemoryReplayCache has %d keys and %d expiries, want the 2 unexpired", len(cache.seen), len(cache.expiries))
+	}
+}
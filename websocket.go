@@ -0,0 +1,71 @@
+package jwkfetch
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// webSocketBearerProtocol is the subprotocol followed by the token in Sec-WebSocket-Protocol, since browsers can't set headers on WebSocket handshakes
+const webSocketBearerProtocol = "bearer"
+
+// WebSocketAuth is an authenticated WebSocket handshake
+type WebSocketAuth struct {
+	Token  *jwt.Token
+	Claims jwt.MapClaims
+	// Subprotocol must be selected in the handshake response when the token was sent in Sec-WebSocket-Protocol, or browsers close the connection
+	Subprotocol string
+	// ExpiresAt is the exp of the token, zero when it has none. Close the connection by then, since the token isn't verified again
+	ExpiresAt time.Time
+}
+
+// WebSocketProtocolExtractor takes the token following the "bearer" subprotocol in Sec-WebSocket-Protocol, e.g. "bearer, eyJhbGciOi..."
+func WebSocketProtocolExtractor(r *http.Request) string {
+	var protocols []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	for i := 0; i < len(protocols)-1; i++ {
+		if strings.EqualFold(protocols[i], webSocketBearerProtocol) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// AuthenticateWebSocket is Fetcher.AuthenticateWebSocket of the default fetcher
+func AuthenticateWebSocket(r *http.Request, opts ...MiddlewareOption) (*WebSocketAuth, error) {
+	return defaultFetcher.AuthenticateWebSocket(r, opts...)
+}
+
+// AuthenticateWebSocket authenticates a WebSocket handshake with the same options as NewMiddleware, before upgrading the connection.
+// The token is taken from Sec-WebSocket-Protocol or the Authorization header unless WithTokenExtractors is set, e.g. with QueryExtractor.
+// Rejected handshakes return an *AuthError with the status to respond with
+func (f *Fetcher) AuthenticateWebSocket(r *http.Request, opts ...MiddlewareOption) (*WebSocketAuth, error) {
+	var options middlewareOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if len(options.extractors) == 0 {
+		options.extractors = []TokenExtractor{WebSocketProtocolExtractor, AuthorizationHeaderExtractor}
+	}
+
+	tokenString := extractToken(r, options.extractors)
+	token, authErr := f.authenticate(r, tokenString, options)
+	if authErr != nil {
+		return nil, authErr
+	}
+	auth := &WebSocketAuth{Token: token}
+	auth.Claims, _ = token.Claims.(jwt.MapClaims)
+	if exp, ok := auth.Claims["exp"].(float64); ok {
+		auth.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if tokenString != "" && WebSocketProtocolExtractor(r) == tokenString {
+		auth.Subprotocol = webSocketBearerProtocol
+	}
+	return auth, nil
+}
@@ -0,0 +1,59 @@
+package jwkfetch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestAuthenticateWebSocket(t *testing.T) {
+	privateKey, keySet := newTestKeySet(t, "websocket-key")
+	jwkProvider := JWKProvider{Issuer: "https://issuer.example.com", InlineJWKS: []byte(keySet)}
+	defaultFetcher.setProviders([]JWKProvider{jwkProvider})
+	defer defaultFetcher.setProviders(nil)
+	defer defaultFetcher.purgeProvider(jwkProvider, nil)
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	tokenString := signTestToken(t, privateKey, "websocket-key", jwt.MapClaims{"iss": jwkProvider.Issuer, "exp": exp.Unix(), "scope": "chat"})
+
+	tests := []struct {
+		name            string
+		setup           func(r *http.Request)
+		opts            []MiddlewareOption
+		wantSubprotocol string
+		wantStatus      int
+	}{
+		{name: "Protocol header", setup: func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "bearer, "+tokenString) }, wantSubprotocol: "bearer"},
+		{name: "Authorization header", setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tokenString) }},
+		{name: "Query not opted in", setup: func(r *http.Request) { r.URL.RawQuery = "access_token=" + tokenString }, wantStatus: http.StatusUnauthorized},
+		{name: "Query", setup: func(r *http.Request) { r.URL.RawQuery = "access_token=" + tokenString }, opts: []MiddlewareOption{WithTokenExtractors(QueryExtractor("access_token"))}},
+		{name: "Missing scope", setup: func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "bearer, "+tokenString) }, opts: []MiddlewareOption{RequireScopes("admin")}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			tt.setup(req)
+			auth, err := AuthenticateWebSocket(req, tt.opts...)
+			if tt.wantStatus != 0 {
+				var authErr *AuthError
+				if !errors.As(err, &authErr) || authErr.StatusCode != tt.wantStatus {
+					t.Fatalf("AuthenticateWebSocket() error = %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateWebSocket() error = %v", err)
+			}
+			if auth.Subprotocol != tt.wantSubprotocol {
+				t.Errorf("Subprotocol = %q, want %q", auth.Subprotocol, tt.wantSubprotocol)
+			}
+			if !auth.ExpiresAt.Equal(exp) || auth.Claims["scope"] != "chat" {
+				t.Errorf("AuthenticateWebSocket() = %+v, want exp %v and the token claims", auth, exp)
+			}
+		})
+	}
+}